@@ -0,0 +1,115 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// regBuiltinAPI registers api routes of mosdns itself.
+func (m *Mosdns) regBuiltinAPI() {
+	m.httpMux.HandleFunc("GET /plugins", m.handleListPlugins)
+	m.httpMux.Handle("/plugins/", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		m.current.Load().pluginMux.ServeHTTP(w, req)
+	}))
+	m.httpMux.HandleFunc("POST /reload", m.handleReload)
+	m.httpMux.Handle("GET /metrics", promhttp.HandlerFor(prometheus.Gatherers{
+		m.metricsReg,
+		prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return m.current.Load().pluginReg.Gather()
+		}),
+	}, promhttp.HandlerOpts{}))
+	// zap.AtomicLevel serves GET and PUT with json body {"level":"debug"}.
+	m.httpMux.Handle("/log/level", m.logLevel)
+
+	m.httpMux.HandleFunc("/debug/pprof/", pprof.Index)
+	m.httpMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	m.httpMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	m.httpMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	m.httpMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+func (m *Mosdns) handleReload(w http.ResponseWriter, _ *http.Request) {
+	if err := m.Reload(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type pluginInfo struct {
+	Tag  string `json:"tag"`
+	Type string `json:"type"`
+}
+
+func (m *Mosdns) handleListPlugins(w http.ResponseWriter, _ *http.Request) {
+	g := m.current.Load()
+	ps := make([]pluginInfo, 0, len(g.plugins))
+	for tag := range g.plugins {
+		typ := g.pluginTypes[tag]
+		if len(typ) == 0 {
+			typ = "preset"
+		}
+		ps = append(ps, pluginInfo{Tag: tag, Type: typ})
+	}
+	slices.SortFunc(ps, func(a, b pluginInfo) int { return strings.Compare(a.Tag, b.Tag) })
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(ps)
+}
+
+// withAuth wraps h with the authentication configured in cfg.
+// It returns h if no authentication is configured.
+func withAuth(cfg APIConfig, h http.Handler) http.Handler {
+	basic := len(cfg.Username) > 0 || len(cfg.Password) > 0
+	if len(cfg.Token) == 0 && !basic {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if len(cfg.Token) > 0 {
+			token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+			if ok && secureEqual(token, cfg.Token) {
+				h.ServeHTTP(w, req)
+				return
+			}
+		}
+		if basic {
+			u, p, ok := req.BasicAuth()
+			if ok && secureEqual(u, cfg.Username) && secureEqual(p, cfg.Password) {
+				h.ServeHTTP(w, req)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="mosdns"`)
+		}
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_withAuth(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})
+	h := withAuth(APIConfig{Token: "tk", Username: "u", Password: "p"}, ok)
+
+	tests := []struct {
+		name  string
+		setup func(req *http.Request)
+		want  int
+	}{
+		{"no auth", func(req *http.Request) {}, http.StatusUnauthorized},
+		{"token", func(req *http.Request) { req.Header.Set("Authorization", "Bearer tk") }, http.StatusOK},
+		{"bad token", func(req *http.Request) { req.Header.Set("Authorization", "Bearer x") }, http.StatusUnauthorized},
+		{"basic", func(req *http.Request) { req.SetBasicAuth("u", "p") }, http.StatusOK},
+		{"bad basic", func(req *http.Request) { req.SetBasicAuth("u", "x") }, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/plugins", nil)
+		tt.setup(req)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: want %d, got %d", tt.name, tt.want, w.Code)
+		}
+	}
+}
+
+func TestMosdns_handleListPlugins(t *testing.T) {
+	m := NewTestMosdnsWithPlugins(map[string]any{"b": nil, "a": nil})
+	m.pluginTypes["b"] = "cache"
+	w := httptest.NewRecorder()
+	m.handleListPlugins(w, httptest.NewRequest(http.MethodGet, "/plugins", nil))
+	want := `[{"tag":"a","type":"preset"},{"tag":"b","type":"cache"}]` + "\n"
+	if w.Body.String() != want {
+		t.Fatalf("want %s, got %s", want, w.Body.String())
+	}
+}
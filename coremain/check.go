@@ -0,0 +1,102 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/pkg/safe_close"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func init() {
+	sf := new(serverFlags)
+	checkCmd := &cobra.Command{
+		Use:   "check [-c config_file] [-d working_dir]",
+		Short: "Check the config and its plugins without starting servers.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(sf.dir) > 0 {
+				if err := os.Chdir(sf.dir); err != nil {
+					return fmt.Errorf("failed to change the current working directory, %w", err)
+				}
+			}
+			errs := Check(sf.c)
+			for _, err := range errs {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			if len(errs) > 0 {
+				return fmt.Errorf("%d error(s) found", len(errs))
+			}
+			fmt.Println("config is ok")
+			return nil
+		},
+		DisableFlagsInUseLine: true,
+		SilenceUsage:          true,
+	}
+	rootCmd.AddCommand(checkCmd)
+	fs := checkCmd.Flags()
+	fs.StringVarP(&sf.c, "config", "c", "", "config file")
+	fs.StringVarP(&sf.dir, "dir", "d", "", "working dir")
+}
+
+// Check loads the config file, its includes and all plugins in check mode,
+// and returns all errors found. Plugins are closed before Check returns.
+// See BP.CheckOnly.
+func Check(file string) []error {
+	cfg, path, err := loadConfig(file)
+	if err != nil {
+		return []error{err}
+	}
+
+	var errs []error
+	if _, err := zapcore.ParseLevel(cfg.Log.Level); err != nil {
+		errs = append(errs, fmt.Errorf("%s: invalid log level, %w", path, err))
+	}
+
+	m := newGraph(mlog.Nop(), &shared{
+		httpMux:    http.NewServeMux(),
+		metricsReg: newMetricsReg(),
+		logLevel:   zap.NewAtomicLevel(),
+		sc:         safe_close.NewSafeClose(),
+		cfgFile:    path,
+	})
+	m.checkOnly = true
+	m.current.Store(m)
+	if err := m.loadGraph(cfg); err != nil {
+		m.checkErrs = append(m.checkErrs, fmt.Errorf("%s: %w", path, err))
+	}
+	m.closePlugins(nil)
+	return append(errs, m.checkErrs...)
+}
+
+// checkErr records err of the config file in check mode and returns nil.
+// Otherwise, it returns err.
+func (m *Mosdns) checkErr(file string, err error) error {
+	if !m.checkOnly {
+		return err
+	}
+	m.checkErrs = append(m.checkErrs, fmt.Errorf("%s: %w", file, err))
+	return nil
+}
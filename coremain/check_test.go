@@ -0,0 +1,80 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, s string) string {
+		t.Helper()
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	sub := write("sub.yaml", `
+plugins:
+  - tag: sub_fail
+    type: reload_test
+    args:
+      fail: true
+`)
+	root := write("config.yaml", `
+include: ["`+sub+`", "`+filepath.Join(dir, "missing.yaml")+`"]
+plugins:
+  - tag: ok
+    type: reload_test
+  - tag: unknown_type
+    type: no_such_type
+  - tag: unknown_arg
+    type: reload_test
+    args:
+      no_such_arg: 1
+`)
+
+	errs := Check(root)
+	// Config errors come first, then errors from initialization.
+	wants := []string{
+		root + ": failed to read config from",
+		root + ": failed to init plugin #1 unknown_type",
+		root + ": failed to init plugin #2 unknown_arg",
+		sub + ": failed to init plugin #0 sub_fail",
+	}
+	if len(errs) != len(wants) {
+		t.Fatalf("want %d errors, got %d: %v", len(wants), len(errs), errs)
+	}
+	for i, want := range wants {
+		if !strings.HasPrefix(errs[i].Error(), want) {
+			t.Errorf("error #%d: want prefix %q, got %q", i, want, errs[i])
+		}
+	}
+
+	if errs := Check(write("ok.yaml", "plugins: [{tag: ok, type: reload_test}]")); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+}
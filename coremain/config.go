@@ -27,6 +27,22 @@ type Config struct {
 	Log     mlog.LogConfig `yaml:"log"`
 	Include []string       `yaml:"include"`
 	Plugins []PluginConfig `yaml:"plugins"`
+	API     APIConfig      `yaml:"api"`
+}
+
+// APIConfig configures the http api server.
+type APIConfig struct {
+	// HTTP is the listen address of the api server. The server is
+	// disabled if it is empty.
+	HTTP string `yaml:"http"`
+
+	// Token enables bearer token authentication. Requests must have
+	// the header "Authorization: Bearer <token>".
+	Token string `yaml:"token"`
+	// Username and Password enable http basic authentication.
+	// If both Token and basic authentication are set, either is accepted.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
 }
 
 // PluginConfig represents a plugin config
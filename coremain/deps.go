@@ -0,0 +1,137 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+)
+
+// pluginNode is a plugin from the config that is waiting to be initialized.
+type pluginNode struct {
+	c    PluginConfig
+	file string // config file that declares this plugin
+	idx  int    // index in the file
+
+	typeInfo PluginTypeInfo
+	args     any
+	deps     []string
+}
+
+// pluginGraph holds plugins from the config and its includes.
+type pluginGraph struct {
+	nodes []*pluginNode // in config order
+	tags  map[string]*pluginNode
+}
+
+// add checks c, decodes its args and adds it to g. presets are the preset
+// plugins that have been loaded.
+func (g *pluginGraph) add(c PluginConfig, file string, idx int, presets map[string]any) error {
+	if len(c.Tag) == 0 {
+		c.Tag = fmt.Sprintf("anonymouse_%s_%d", c.Type, len(presets)+len(g.nodes))
+	}
+
+	_, dup := g.tags[c.Tag]
+	if _, preset := presets[c.Tag]; dup || preset {
+		return fmt.Errorf("duplicated plugin tag %s", c.Tag)
+	}
+
+	typeInfo, ok := GetPluginType(c.Type)
+	if !ok {
+		return fmt.Errorf("plugin type %s not defined", c.Type)
+	}
+
+	args := typeInfo.NewArgs()
+	if reflect.TypeOf(c.Args) == reflect.TypeOf(args) { // Same type, no need to parse.
+		args = c.Args
+	} else {
+		if err := utils.WeakDecode(c.Args, args); err != nil {
+			return fmt.Errorf("unable to decode plugin args: %w", err)
+		}
+	}
+
+	n := &pluginNode{c: c, file: file, idx: idx, typeInfo: typeInfo, args: args}
+	if d, ok := args.(ArgsWithDeps); ok {
+		n.deps = d.Deps()
+	}
+	if g.tags == nil {
+		g.tags = make(map[string]*pluginNode)
+	}
+	g.nodes = append(g.nodes, n)
+	g.tags[c.Tag] = n
+	return nil
+}
+
+// sort returns nodes of g in an order that a plugin always comes after
+// the plugins it depends on. Otherwise, the config order is kept.
+// Unknown dependencies are ignored. They are either preset plugins or
+// missing, which will be reported by the plugin itself.
+// onCycle is called for every dependency cycle found. If it returns an
+// error, sort stops and returns the error. Otherwise, the dependency that
+// makes the cycle is ignored.
+func (g *pluginGraph) sort(onCycle func(n *pluginNode, cycle []string) error) ([]*pluginNode, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[*pluginNode]int, len(g.nodes))
+	sorted := make([]*pluginNode, 0, len(g.nodes))
+	var path []string // tags that are being visited
+
+	var visit func(n *pluginNode) error
+	visit = func(n *pluginNode) error {
+		switch state[n] {
+		case visited:
+			return nil
+		case visiting:
+			i := slices.Index(path, n.c.Tag)
+			return onCycle(n, append(slices.Clone(path[i:]), n.c.Tag))
+		}
+		state[n] = visiting
+		path = append(path, n.c.Tag)
+		for _, dep := range n.deps {
+			if d := g.tags[dep]; d != nil {
+				if err := visit(d); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[n] = visited
+		sorted = append(sorted, n)
+		return nil
+	}
+
+	for _, n := range g.nodes {
+		if err := visit(n); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+func cycleErr(cycle []string) error {
+	return fmt.Errorf("dependency cycle %s", strings.Join(cycle, " -> "))
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+const depsTestPluginType = "deps_test"
+
+type depsTestArgs struct {
+	DepTags []string `yaml:"deps"`
+}
+
+func (a *depsTestArgs) Deps() []string {
+	return a.DepTags
+}
+
+// depsTestClosed records tags of closed depsTestPlugin.
+var depsTestClosed []string
+
+type depsTestPlugin struct {
+	tag string
+}
+
+func (p *depsTestPlugin) Close() error {
+	depsTestClosed = append(depsTestClosed, p.tag)
+	return nil
+}
+
+func init() {
+	RegNewPluginFunc(depsTestPluginType, func(bp *BP, args any) (any, error) {
+		for _, dep := range args.(*depsTestArgs).DepTags {
+			if bp.M().GetPlugin(dep) == nil {
+				return nil, errMissingDep(dep)
+			}
+		}
+		return &depsTestPlugin{tag: bp.Tag()}, nil
+	}, func() any { return new(depsTestArgs) })
+}
+
+type errMissingDep string
+
+func (e errMissingDep) Error() string {
+	return "missing dependency " + string(e)
+}
+
+func depsTestCfg(deps map[string][]string, tags ...string) *Config {
+	cfg := new(Config)
+	for _, tag := range tags {
+		cfg.Plugins = append(cfg.Plugins, PluginConfig{
+			Tag:  tag,
+			Type: depsTestPluginType,
+			Args: &depsTestArgs{DepTags: deps[tag]},
+		})
+	}
+	return cfg
+}
+
+func TestMosdns_loadGraph_order(t *testing.T) {
+	// server -> seq -> (set, forward), set -> sub_set
+	cfg := depsTestCfg(map[string][]string{
+		"server": {"seq"},
+		"seq":    {"set", "forward", "preset"},
+		"set":    {"sub_set"},
+	}, "server", "seq", "set", "forward", "sub_set", "other")
+
+	m := NewTestMosdnsWithPlugins(map[string]any{"preset": 1})
+	if err := m.loadGraph(cfg); err != nil {
+		t.Fatal(err)
+	}
+	wantInit := []string{"preset", "sub_set", "set", "forward", "seq", "server", "other"}
+	if !slices.Equal(m.order, wantInit) {
+		t.Fatalf("want init order %v, got %v", wantInit, m.order)
+	}
+
+	depsTestClosed = nil
+	m.closePlugins(nil)
+	wantClose := slices.Clone(wantInit[1:])
+	slices.Reverse(wantClose)
+	if !slices.Equal(depsTestClosed, wantClose) {
+		t.Fatalf("want close order %v, got %v", wantClose, depsTestClosed)
+	}
+}
+
+func TestMosdns_loadGraph_cycle(t *testing.T) {
+	cfg := depsTestCfg(map[string][]string{
+		"a": {"b"},
+		"b": {"c"},
+		"c": {"a"},
+	}, "a", "b", "c")
+
+	m := NewTestMosdnsWithPlugins(map[string]any{})
+	err := m.loadGraph(cfg)
+	if err == nil || !strings.Contains(err.Error(), "dependency cycle a -> b -> c -> a") {
+		t.Fatalf("want cycle error, got %v", err)
+	}
+	if len(m.plugins) != 0 {
+		t.Fatal("plugins should not be initialized")
+	}
+}
@@ -23,32 +23,95 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/IrineSistiana/mosdns/v5/mlog"
 	"github.com/IrineSistiana/mosdns/v5/pkg/safe_close"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"go.uber.org/zap"
 )
 
 type Mosdns struct {
 	logger *zap.Logger // non-nil logger.
 
-	// Plugins
-	plugins map[string]any
-	sc      *safe_close.SafeClose
+	// Plugins of this graph. A new graph is built on every reload.
+	plugins     map[string]any
+	order       []string             // tags in initialization order
+	pluginTypes map[string]string    // tag -> type, preset plugins are not included
+	pluginMux   *http.ServeMux       // plugin apis of this graph
+	pluginReg   *prometheus.Registry // plugin metrics of this graph
+
+	// Only available while this graph is being loaded.
+	prevPlugins map[string]any // plugins of the running graph, nil if not reloading
+	onCommit    []func()
+
+	// checkOnly is set by Check. Errors are collected in checkErrs
+	// instead of stopping the loading.
+	checkOnly bool
+	checkErrs []error
+
+	*shared
+}
+
+// shared holds states that are shared by all graphs.
+type shared struct {
+	httpMux    *http.ServeMux // api mux
+	metricsReg *prometheus.Registry
+	logLevel   zap.AtomicLevel
+	sc         *safe_close.SafeClose
+
+	cfgFile  string // config file for reloading, empty if unknown
+	reloadMu sync.Mutex
+	current  atomic.Pointer[Mosdns] // the running graph
 }
 
 // NewMosdns initializes a mosdns instance and its plugins.
 func NewMosdns(cfg *Config) (*Mosdns, error) {
 	// Init logger.
-	lg, err := mlog.NewLogger(cfg.Log)
+	lg, lvl, err := mlog.NewLoggerWithLevel(cfg.Log)
 	if err != nil {
 		return nil, fmt.Errorf("failed to init logger: %w", err)
 	}
 
-	m := &Mosdns{
-		logger:  lg,
-		plugins: make(map[string]any),
-		sc:      safe_close.NewSafeClose(),
+	m := newGraph(lg, &shared{
+		httpMux:    http.NewServeMux(),
+		metricsReg: newMetricsReg(),
+		logLevel:   lvl,
+		sc:         safe_close.NewSafeClose(),
+	})
+	m.current.Store(m)
+	m.regBuiltinAPI()
+
+	// Start http api server.
+	if httpAddr := cfg.API.HTTP; len(httpAddr) > 0 {
+		httpServer := &http.Server{
+			Addr:    httpAddr,
+			Handler: withAuth(cfg.API, m.httpMux),
+		}
+		m.sc.Attach(func(done func(), closeSignal <-chan struct{}) {
+			defer done()
+			errChan := make(chan error, 1)
+			go func() {
+				m.logger.Info("starting api http server", zap.String("addr", httpAddr))
+				if len(cfg.API.Token) == 0 && len(cfg.API.Username) == 0 && len(cfg.API.Password) == 0 {
+					m.logger.Warn("api http server has no authentication")
+				}
+				errChan <- httpServer.ListenAndServe()
+			}()
+			select {
+			case err := <-errChan:
+				m.sc.SendCloseSignal(fmt.Errorf("api server exited, %w", err))
+			case <-closeSignal:
+				_ = httpServer.Close()
+			}
+		})
 	}
 
 	// Load plugins.
@@ -60,28 +123,19 @@ func NewMosdns(cfg *Config) (*Mosdns, error) {
 			defer done()
 			<-closeSignal
 			m.logger.Info("starting shutdown sequences")
-			for tag, p := range m.plugins {
-				if closer, _ := p.(io.Closer); closer != nil {
-					m.logger.Info("closing plugin", zap.String("tag", tag))
-					_ = closer.Close()
-				}
-			}
+			m.reloadMu.Lock()
+			defer m.reloadMu.Unlock()
+			m.current.Load().closePlugins(nil)
 			m.logger.Info("all plugins were closed")
 		}()
 	})
 
-	// Preset plugins
-	if err := m.loadPresetPlugins(); err != nil {
-		m.sc.SendCloseSignal(err)
-		_ = m.sc.WaitClosed()
-		return nil, err
-	}
-	// Plugins from config.
-	if err := m.loadPluginsFromCfg(cfg, 0); err != nil {
+	if err := m.loadGraph(cfg); err != nil {
 		m.sc.SendCloseSignal(err)
 		_ = m.sc.WaitClosed()
 		return nil, err
 	}
+	m.commit()
 	m.logger.Info("all plugins are loaded")
 
 	return m, nil
@@ -89,11 +143,87 @@ func NewMosdns(cfg *Config) (*Mosdns, error) {
 
 // NewTestMosdnsWithPlugins returns a mosdns instance for testing.
 func NewTestMosdnsWithPlugins(p map[string]any) *Mosdns {
+	m := newGraph(mlog.Nop(), &shared{
+		httpMux:    http.NewServeMux(),
+		metricsReg: newMetricsReg(),
+		logLevel:   zap.NewAtomicLevel(),
+		sc:         safe_close.NewSafeClose(),
+	})
+	m.plugins = p
+	m.order = slices.Sorted(maps.Keys(p))
+	m.current.Store(m)
+	return m
+}
+
+// NewTestReloadGraph returns a graph with plugins p that reloads m for
+// testing. BPs of the graph see plugins of m as their PrevPlugin. Call
+// commit to run the functions registered by BP.OnCommit.
+func NewTestReloadGraph(m *Mosdns, p map[string]any) (g *Mosdns, commit func()) {
+	g = newGraph(mlog.Nop(), m.shared)
+	g.plugins = p
+	g.order = slices.Sorted(maps.Keys(p))
+	g.prevPlugins = m.plugins
+	return g, g.commit
+}
+
+// newGraph returns a Mosdns with an empty plugin graph.
+func newGraph(lg *zap.Logger, s *shared) *Mosdns {
 	return &Mosdns{
-		logger:  mlog.Nop(),
-		plugins: p,
-		sc:      safe_close.NewSafeClose(),
+		logger:      lg,
+		plugins:     make(map[string]any),
+		pluginTypes: make(map[string]string),
+		pluginMux:   http.NewServeMux(),
+		pluginReg:   prometheus.NewRegistry(),
+		shared:      s,
+	}
+}
+
+// loadGraph loads preset plugins and plugins from cfg into m.
+func (m *Mosdns) loadGraph(cfg *Config) error {
+	// Preset plugins
+	if err := m.loadPresetPlugins(); err != nil {
+		return err
 	}
+	// Plugins from config.
+	return m.loadPluginsFromCfg(cfg, m.cfgFile)
+}
+
+// commit calls functions registered by BP.OnCommit.
+func (m *Mosdns) commit() {
+	for _, f := range m.onCommit {
+		f()
+	}
+	m.onCommit = nil
+	m.prevPlugins = nil
+}
+
+// closePlugins closes plugins of m in the reverse order of initialization,
+// so servers stop before the executables they use, except plugins that are
+// kept in the graph keep.
+func (m *Mosdns) closePlugins(keep *Mosdns) {
+	for _, tag := range slices.Backward(m.order) {
+		p := m.plugins[tag]
+		if keep != nil && samePlugin(p, keep.plugins[tag]) {
+			continue
+		}
+		if closer, _ := p.(io.Closer); closer != nil {
+			m.logger.Info("closing plugin", zap.String("tag", tag))
+			_ = closer.Close()
+		}
+	}
+}
+
+// samePlugin reports whether a and b are the same plugin instance.
+func samePlugin(a, b any) bool {
+	t := reflect.TypeOf(a)
+	return t != nil && t == reflect.TypeOf(b) && t.Comparable() && a == b
+}
+
+func newMetricsReg() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	reg.MustRegister(collectors.NewGoCollector())
+	return reg
 }
 
 func (m *Mosdns) GetSafeClose() *safe_close.SafeClose {
@@ -115,6 +245,25 @@ func (m *Mosdns) GetPlugin(tag string) any {
 	return m.plugins[tag]
 }
 
+// RegPluginAPI mounts h to the api server under "/plugins/<tag>/".
+// Requests are passed to h with the prefix stripped.
+func (m *Mosdns) RegPluginAPI(tag string, h http.Handler) {
+	prefix := "/plugins/" + strings.Trim(tag, "/")
+	m.pluginMux.Handle(prefix+"/", http.StripPrefix(prefix, h))
+}
+
+// GetMetricsReg returns a prometheus.Registerer with a prefix of "mosdns_".
+// Metrics are served at "/metrics" of the api server. Metrics registered
+// to it are dropped with this plugin graph on reload.
+func (m *Mosdns) GetMetricsReg() prometheus.Registerer {
+	return prometheus.WrapRegistererWithPrefix("mosdns_", m.pluginReg)
+}
+
+// HTTPMux returns the mux of the api server.
+func (m *Mosdns) HTTPMux() *http.ServeMux {
+	return m.httpMux
+}
+
 func (m *Mosdns) loadPresetPlugins() error {
 	for tag, f := range LoadNewPersetPluginFuncs() {
 		p, err := f(NewBP(tag, m))
@@ -122,12 +271,39 @@ func (m *Mosdns) loadPresetPlugins() error {
 			return fmt.Errorf("failed to init preset plugin %s, %w", tag, err)
 		}
 		m.plugins[tag] = p
+		m.order = append(m.order, tag)
 	}
 	return nil
 }
 
-// loadPluginsFromCfg loads plugins from this config. It follows include first.
-func (m *Mosdns) loadPluginsFromCfg(cfg *Config, includeDepth int) error {
+// loadPluginsFromCfg loads plugins from this config and its includes.
+// file is the path of cfg and is only used in error messages.
+// Plugins are initialized after the plugins they depend on, see ArgsWithDeps.
+// Otherwise, plugins from includes come first.
+func (m *Mosdns) loadPluginsFromCfg(cfg *Config, file string) error {
+	g := new(pluginGraph)
+	if err := m.collectPlugins(g, cfg, file, 0); err != nil {
+		return err
+	}
+	nodes, err := g.sort(func(n *pluginNode, cycle []string) error {
+		return m.checkErr(n.file, cycleErr(cycle))
+	})
+	if err != nil {
+		return err
+	}
+	for _, n := range nodes {
+		if err := m.newPlugin(n); err != nil {
+			err = fmt.Errorf("failed to init plugin #%d %s, %w", n.idx, n.c.Tag, err)
+			if err := m.checkErr(n.file, err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// collectPlugins adds plugins from cfg to g. It follows include first.
+func (m *Mosdns) collectPlugins(g *pluginGraph, cfg *Config, file string, includeDepth int) error {
 	const maxIncludeDepth = 8
 	if includeDepth > maxIncludeDepth {
 		return errors.New("maximum include depth reached")
@@ -138,17 +314,23 @@ func (m *Mosdns) loadPluginsFromCfg(cfg *Config, includeDepth int) error {
 	for _, s := range cfg.Include {
 		subCfg, path, err := loadConfig(s)
 		if err != nil {
-			return fmt.Errorf("failed to read config from %s, %w", s, err)
+			if err := m.checkErr(file, fmt.Errorf("failed to read config from %s, %w", s, err)); err != nil {
+				return err
+			}
+			continue
 		}
 		m.logger.Info("load config", zap.String("file", path))
-		if err := m.loadPluginsFromCfg(subCfg, includeDepth); err != nil {
+		if err := m.collectPlugins(g, subCfg, path, includeDepth); err != nil {
 			return fmt.Errorf("failed to load config from %s, %w", s, err)
 		}
 	}
 
 	for i, pc := range cfg.Plugins {
-		if err := m.newPlugin(pc); err != nil {
-			return fmt.Errorf("failed to init plugin #%d %s, %w", i, pc.Tag, err)
+		if err := g.add(pc, file, i, m.plugins); err != nil {
+			err = fmt.Errorf("failed to init plugin #%d %s, %w", i, pc.Tag, err)
+			if err := m.checkErr(file, err); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -21,10 +21,10 @@ package coremain
 
 import (
 	"fmt"
-	"reflect"
+	"net/http"
 	"sync"
 
-	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
@@ -86,36 +86,24 @@ func GetPluginType(typ string) (PluginTypeInfo, bool) {
 	return info, ok
 }
 
-// newPlugin initializes a Plugin from c and adds it to mosdns.
-func (m *Mosdns) newPlugin(c PluginConfig) error {
-	if len(c.Tag) == 0 {
-		c.Tag = fmt.Sprintf("anonymouse_%s_%d", c.Type, len(m.plugins))
-	}
-
-	if _, dup := m.plugins[c.Tag]; dup {
-		return fmt.Errorf("duplicated plugin tag %s", c.Tag)
-	}
-
-	typeInfo, ok := GetPluginType(c.Type)
-	if !ok {
-		return fmt.Errorf("plugin type %s not defined", c.Type)
-	}
-
-	args := typeInfo.NewArgs()
-	if reflect.TypeOf(c.Args) == reflect.TypeOf(args) { // Same type, no need to parse.
-		args = c.Args
-	} else {
-		if err := utils.WeakDecode(c.Args, args); err != nil {
-			return fmt.Errorf("unable to decode plugin args: %w", err)
-		}
-	}
+// ArgsWithDeps can be implemented by plugin args to declare the tags of
+// other plugins that the plugin references. Those plugins are initialized
+// before it and closed after it. Empty tags and tags that are not in the
+// config are ignored.
+type ArgsWithDeps interface {
+	Deps() []string
+}
 
-	m.logger.Info("loading plugin", zap.String("tag", c.Tag), zap.String("type", c.Type))
-	p, err := typeInfo.NewPlugin(NewBP(c.Tag, m), args)
+// newPlugin initializes the plugin of n and adds it to mosdns.
+func (m *Mosdns) newPlugin(n *pluginNode) error {
+	m.logger.Info("loading plugin", zap.String("tag", n.c.Tag), zap.String("type", n.c.Type))
+	p, err := n.typeInfo.NewPlugin(NewBP(n.c.Tag, m), n.args)
 	if err != nil {
 		return fmt.Errorf("failed to init plugin: %w", err)
 	}
-	m.plugins[c.Tag] = p
+	m.plugins[n.c.Tag] = p
+	m.pluginTypes[n.c.Tag] = n.c.Type
+	m.order = append(m.order, n.c.Tag)
 	return nil
 }
 
@@ -193,3 +181,38 @@ func (p *BP) M() *Mosdns {
 func (p *BP) Tag() string {
 	return p.tag
 }
+
+// PrevPlugin returns the plugin that has the same tag in the running graph
+// while mosdns is reloading. Otherwise, it returns nil.
+// A NewPluginFunc can return it to keep the plugin, and its states, in the
+// new graph. A kept plugin will not be closed with the old graph. Changes
+// that affect the running graph should be done in OnCommit, because the
+// reload may fail.
+func (p *BP) PrevPlugin() any {
+	return p.m.prevPlugins[p.tag]
+}
+
+// OnCommit registers f that will be called after all plugins of the graph
+// were loaded. If the graph failed to load, f will not be called.
+func (p *BP) OnCommit(f func()) {
+	p.m.onCommit = append(p.m.onCommit, f)
+}
+
+// CheckOnly reports whether mosdns is only checking the config, e.g. in
+// the "check" sub command. Plugins should still validate their args but
+// must not open sockets, write files or start servers. The plugin is
+// closed right after all plugins were loaded.
+func (p *BP) CheckOnly() bool {
+	return p.m.checkOnly
+}
+
+// RegAPI mounts h to the api server under "/plugins/<tag>/".
+func (p *BP) RegAPI(h http.Handler) {
+	p.m.RegPluginAPI(p.tag, h)
+}
+
+// MetricsReg returns a prometheus.Registerer that adds a "tag" label of
+// this plugin to metrics. See Mosdns.GetMetricsReg.
+func (p *BP) MetricsReg() prometheus.Registerer {
+	return prometheus.WrapRegistererWith(prometheus.Labels{"tag": p.tag}, p.m.GetMetricsReg())
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// reloadGracePeriod is the time that old plugins are kept after a reload,
+// so that in-flight queries can finish.
+const reloadGracePeriod = time.Second * 10
+
+// Reload reads the config file again and builds a new plugin graph.
+// The new graph replaces the running one only if all plugins were loaded,
+// otherwise the running graph is kept. Plugins of the old graph are closed
+// after a grace period. Plugins can keep their states, e.g. listening
+// sockets, across reloads. See BP.PrevPlugin.
+// Changes of the log and api sections, except the log level, require a
+// restart.
+func (m *Mosdns) Reload() error {
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+	if err := m.reload(); err != nil {
+		m.logger.Error("failed to reload, running plugins are kept", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (m *Mosdns) reload() error {
+	if len(m.cfgFile) == 0 {
+		return errors.New("config file is unknown")
+	}
+	cfg, _, err := loadConfig(m.cfgFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config, %w", err)
+	}
+	lvl, err := zapcore.ParseLevel(cfg.Log.Level)
+	if err != nil {
+		return fmt.Errorf("invalid log level: %w", err)
+	}
+
+	old := m.current.Load()
+	g := newGraph(m.logger, m.shared)
+	g.prevPlugins = old.plugins
+	if err := g.loadGraph(cfg); err != nil {
+		g.closePlugins(old)
+		return err
+	}
+	g.commit()
+	m.current.Store(g)
+	m.logLevel.SetLevel(lvl)
+	m.logger.Info("config reloaded", zap.String("file", m.cfgFile))
+
+	go func() {
+		time.Sleep(reloadGracePeriod)
+		m.reloadMu.Lock()
+		defer m.reloadMu.Unlock()
+		old.closePlugins(g)
+	}()
+	return nil
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const reloadTestPluginType = "reload_test"
+
+type reloadTestArgs struct {
+	Keep bool `yaml:"keep"`
+	Fail bool `yaml:"fail"`
+}
+
+type reloadTestPlugin struct {
+	committed bool
+	closed    bool
+}
+
+func (p *reloadTestPlugin) Close() error {
+	p.closed = true
+	return nil
+}
+
+func init() {
+	RegNewPluginFunc(reloadTestPluginType, func(bp *BP, args any) (any, error) {
+		a := args.(*reloadTestArgs)
+		if a.Fail {
+			return nil, errors.New("failed")
+		}
+		if prev, ok := bp.PrevPlugin().(*reloadTestPlugin); ok && a.Keep {
+			return prev, nil
+		}
+		p := new(reloadTestPlugin)
+		bp.OnCommit(func() { p.committed = true })
+		return p, nil
+	}, func() any { return new(reloadTestArgs) })
+}
+
+func TestMosdns_Reload(t *testing.T) {
+	cfgFile := filepath.Join(t.TempDir(), "config.yaml")
+	writeCfg := func(s string) {
+		t.Helper()
+		if err := os.WriteFile(cfgFile, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewTestMosdnsWithPlugins(map[string]any{})
+	m.cfgFile = cfgFile
+	if err := m.loadGraph(&Config{Plugins: []PluginConfig{
+		{Tag: "keep", Type: reloadTestPluginType},
+		{Tag: "renew", Type: reloadTestPluginType},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	m.commit()
+	keep := m.GetPlugin("keep").(*reloadTestPlugin)
+	renew := m.GetPlugin("renew").(*reloadTestPlugin)
+
+	// A failed reload keeps the running graph.
+	writeCfg(`
+plugins:
+  - tag: new
+    type: reload_test
+  - tag: fail
+    type: reload_test
+    args:
+      fail: true
+`)
+	if err := m.Reload(); err == nil {
+		t.Fatal("reload should fail")
+	}
+	g := m.current.Load()
+	if g != m || g.GetPlugin("new") != nil {
+		t.Fatal("running graph was replaced by a failed reload")
+	}
+	if keep.closed || renew.closed {
+		t.Fatal("running plugins were closed by a failed reload")
+	}
+
+	writeCfg(`
+plugins:
+  - tag: keep
+    type: reload_test
+    args:
+      keep: true
+  - tag: renew
+    type: reload_test
+`)
+	if err := m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	g = m.current.Load()
+	if g == m {
+		t.Fatal("running graph was not replaced")
+	}
+	if g.GetPlugin("keep") != keep {
+		t.Fatal("kept plugin was not reused")
+	}
+	p := g.GetPlugin("renew").(*reloadTestPlugin)
+	if p == renew || !p.committed {
+		t.Fatal("new plugin was not created or committed")
+	}
+
+	// Old plugins are closed after the grace period, this is what the
+	// delayed close does.
+	m.closePlugins(g)
+	if keep.closed || !renew.closed {
+		t.Fatalf("unexpected closed state, keep: %v, renew: %v", keep.closed, renew.closed)
+	}
+}
@@ -63,6 +63,14 @@ func init() {
 				m.logger.Warn("signal received", zap.Stringer("signal", sig))
 				m.sc.SendCloseSignal(nil)
 			}()
+			go func() {
+				c := make(chan os.Signal, 1)
+				signal.Notify(c, syscall.SIGHUP)
+				for sig := range c {
+					m.logger.Info("signal received, reloading", zap.Stringer("signal", sig))
+					_ = m.Reload() // error is logged by Reload
+				}
+			}()
 			return m.GetSafeClose().WaitClosed()
 		},
 		DisableFlagsInUseLine: true,
@@ -110,7 +118,12 @@ func NewServer(sf *serverFlags) (*Mosdns, error) {
 	}
 	mlog.L().Info("main config loaded", zap.String("file", fileUsed))
 
-	return NewMosdns(cfg)
+	m, err := NewMosdns(cfg)
+	if err != nil {
+		return nil, err
+	}
+	m.cfgFile = fileUsed
+	return m, nil
 }
 
 // loadConfig load a config from a file. If filePath is empty, it will
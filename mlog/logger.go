@@ -48,16 +48,23 @@ var (
 )
 
 func NewLogger(lc LogConfig) (*zap.Logger, error) {
-	lvl, err := zapcore.ParseLevel(lc.Level)
+	l, _, err := NewLoggerWithLevel(lc)
+	return l, err
+}
+
+// NewLoggerWithLevel is like NewLogger, but the level of the returned
+// logger can be changed at runtime via the returned zap.AtomicLevel.
+func NewLoggerWithLevel(lc LogConfig) (*zap.Logger, zap.AtomicLevel, error) {
+	lvl, err := zap.ParseAtomicLevel(lc.Level)
 	if err != nil {
-		return nil, fmt.Errorf("invalid log level: %w", err)
+		return nil, zap.AtomicLevel{}, fmt.Errorf("invalid log level: %w", err)
 	}
 
 	var out zapcore.WriteSyncer
 	if lf := lc.File; len(lf) > 0 {
 		f, _, err := zap.Open(lf)
 		if err != nil {
-			return nil, fmt.Errorf("open log file: %w", err)
+			return nil, zap.AtomicLevel{}, fmt.Errorf("open log file: %w", err)
 		}
 		out = zapcore.Lock(f)
 	} else {
@@ -65,9 +72,9 @@ func NewLogger(lc LogConfig) (*zap.Logger, error) {
 	}
 
 	if lc.Production {
-		return zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), out, lvl)), nil
+		return zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), out, lvl)), lvl, nil
 	}
-	return zap.New(zapcore.NewCore(zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig()), out, lvl)), nil
+	return zap.New(zapcore.NewCore(zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig()), out, lvl)), lvl, nil
 }
 
 // L is a global logger.
@@ -0,0 +1,173 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package addr_source
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/pkg/file_watcher"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// Addrs is a list of addresses split by family.
+type Addrs struct {
+	V4 []netip.Addr
+	V6 []netip.Addr
+}
+
+// Len returns the number of addresses.
+func (a *Addrs) Len() int {
+	return len(a.V4) + len(a.V6)
+}
+
+// Add parses whitespace separated addresses in s and adds them to a.
+func (a *Addrs) Add(s string) error {
+	for _, f := range strings.Fields(s) {
+		addr, err := netip.ParseAddr(f)
+		if err != nil {
+			return err
+		}
+		addr = addr.Unmap()
+		if addr.Is4() {
+			a.V4 = append(a.V4, addr)
+		} else {
+			a.V6 = append(a.V6, addr)
+		}
+	}
+	return nil
+}
+
+// LoadFromReader loads addresses from a reader. Each line may have multiple
+// addresses. "#" starts a comment.
+func (a *Addrs) LoadFromReader(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	lineCounter := 0
+	for scanner.Scan() {
+		lineCounter++
+		if err := a.Add(utils.RemoveComment(scanner.Text(), "#")); err != nil {
+			return fmt.Errorf("invalid data at line #%d: %w", lineCounter, err)
+		}
+	}
+	return scanner.Err()
+}
+
+type Opts struct {
+	// Env is the name of an environment variable that contains whitespace
+	// separated addresses. The variable must be set if Env is not empty.
+	Env string
+	// IPs are inline addresses.
+	IPs []string
+	// Files contain addresses. See Addrs.LoadFromReader.
+	Files []string
+	// DisableReload disables reloading when files are changed.
+	DisableReload bool
+	Logger        *zap.Logger
+}
+
+// Source loads addresses from an environment variable, inline texts and
+// files. Addresses are reloaded when files are changed. A failed reload
+// keeps the old addresses.
+type Source struct {
+	opts    Opts
+	addrs   atomic.Pointer[Addrs]
+	watcher *file_watcher.Watcher
+}
+
+// New creates a Source. It returns an error if no address is loaded.
+func New(opts Opts) (*Source, error) {
+	if opts.Logger == nil {
+		opts.Logger = mlog.Nop()
+	}
+	s := &Source{opts: opts}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if !opts.DisableReload && len(opts.Files) > 0 {
+		w, err := file_watcher.New(opts.Files, file_watcher.Opts{OnChange: s.reload, Logger: opts.Logger})
+		if err != nil {
+			return nil, fmt.Errorf("failed to watch files, %w", err)
+		}
+		s.watcher = w
+	}
+	return s, nil
+}
+
+func (s *Source) load() error {
+	a := new(Addrs)
+	if len(s.opts.Env) > 0 {
+		v, ok := os.LookupEnv(s.opts.Env)
+		if !ok {
+			return fmt.Errorf("env %s is not set", s.opts.Env)
+		}
+		if err := a.Add(v); err != nil {
+			return fmt.Errorf("invalid address in env %s, %w", s.opts.Env, err)
+		}
+	}
+	for i, ip := range s.opts.IPs {
+		if err := a.Add(ip); err != nil {
+			return fmt.Errorf("invalid address #%d %s, %w", i, ip, err)
+		}
+	}
+	for i, file := range s.opts.Files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read file #%d %s, %w", i, file, err)
+		}
+		if err := a.LoadFromReader(bytes.NewReader(b)); err != nil {
+			return fmt.Errorf("failed to load file #%d %s, %w", i, file, err)
+		}
+	}
+	if a.Len() == 0 {
+		return errors.New("no address")
+	}
+	s.addrs.Store(a)
+	return nil
+}
+
+func (s *Source) reload() {
+	if err := s.load(); err != nil {
+		s.opts.Logger.Error("failed to reload addresses, old addresses are kept", zap.Error(err))
+		return
+	}
+	s.opts.Logger.Info("addresses reloaded")
+}
+
+// Addrs returns the current addresses. The returned Addrs must not be
+// modified.
+func (s *Source) Addrs() *Addrs {
+	return s.addrs.Load()
+}
+
+func (s *Source) Close() error {
+	if s.watcher != nil {
+		return s.watcher.Close()
+	}
+	return nil
+}
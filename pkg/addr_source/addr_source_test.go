@@ -0,0 +1,81 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package addr_source
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSource(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "ips.txt")
+	if err := os.WriteFile(file, []byte("# comment\n192.0.2.1 2001:db8::1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ADDR_SOURCE_TEST", "192.0.2.2")
+
+	s, err := New(Opts{Env: "ADDR_SOURCE_TEST", IPs: []string{"192.0.2.3"}, Files: []string{file}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	a := s.Addrs()
+	if len(a.V4) != 3 || len(a.V6) != 1 || a.V4[0] != netip.MustParseAddr("192.0.2.2") {
+		t.Fatalf("unexpected addrs %v", a)
+	}
+
+	// A bad file keeps old addresses.
+	if err := os.WriteFile(file, []byte("bad\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if got := s.Addrs(); got != a {
+		t.Fatalf("old addrs should be kept, got %v", got)
+	}
+
+	if err := os.WriteFile(file, []byte("192.0.2.10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second * 5)
+	for s.Addrs().Len() != 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("addrs not reloaded, got %v", s.Addrs())
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
+
+func TestNew_Errors(t *testing.T) {
+	for name, opts := range map[string]Opts{
+		"unset env":  {Env: "ADDR_SOURCE_TEST_UNSET"},
+		"bad ip":     {IPs: []string{"192.0.2.256"}},
+		"no file":    {Files: []string{filepath.Join(t.TempDir(), "none")}},
+		"no address": {IPs: []string{""}},
+		"empty opts": {},
+	} {
+		if _, err := New(opts); err == nil {
+			t.Fatalf("%s: want error", name)
+		}
+	}
+}
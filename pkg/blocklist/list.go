@@ -0,0 +1,255 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package blocklist
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// List is a set of AdGuard/uBlock style DNS filter rules. It is not
+// concurrent safe for writing.
+//
+// Supported syntax: "||domain^", "|domain^", "domain", "/regexp/", "*"
+// wildcards, "@@" exceptions, hosts-style lines "ip name...", and modifiers
+// $important, $client, $dnstype and $dnsrewrite. Rules with other
+// modifiers are skipped.
+type List struct {
+	exact   map[string][]*Rule
+	domain  map[string][]*Rule
+	regexes []regexRule
+
+	rules   int
+	skipped int
+}
+
+type regexRule struct {
+	p    pattern
+	rule *Rule
+}
+
+func NewList() *List {
+	return &List{
+		exact:  make(map[string][]*Rule),
+		domain: make(map[string][]*Rule),
+	}
+}
+
+// Len returns the number of loaded rules.
+func (l *List) Len() int {
+	return l.rules
+}
+
+// Skipped returns the number of skipped unsupported rules.
+func (l *List) Skipped() int {
+	return l.skipped
+}
+
+// AddRule parses and adds a rule. source and line are recorded in the rule.
+func (l *List) AddRule(s, source string, line int) error {
+	r, ps, err := parseRule(s)
+	if err != nil {
+		if errors.Is(err, errUnsupported) {
+			l.skipped++
+			return nil
+		}
+		return err
+	}
+	if r == nil {
+		return nil
+	}
+	r.Source, r.Line = source, line
+	for _, p := range ps {
+		switch {
+		case p.regex != nil:
+			l.regexes = append(l.regexes, regexRule{p: p, rule: r})
+		case len(p.exact) > 0:
+			l.exact[p.exact] = append(l.exact[p.exact], r)
+		default:
+			l.domain[p.domain] = append(l.domain[p.domain], r)
+		}
+	}
+	l.rules++
+	return nil
+}
+
+// LoadFromReader loads rules from a reader. source is recorded in rules.
+func (l *List) LoadFromReader(r io.Reader, source string) error {
+	scanner := bufio.NewScanner(r)
+	lineCounter := 0
+	for scanner.Scan() {
+		lineCounter++
+		if err := l.AddRule(scanner.Text(), source, lineCounter); err != nil {
+			return fmt.Errorf("invalid rule at line #%d: %w", lineCounter, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// rangeRules calls f for each rule whose pattern matches name, until f
+// returns false.
+func (l *List) rangeRules(name string, f func(r *Rule) bool) {
+	name = normalize(name)
+	for _, r := range l.exact[name] {
+		if !f(r) {
+			return
+		}
+	}
+	for s := name; ; {
+		for _, r := range l.domain[s] {
+			if !f(r) {
+				return
+			}
+		}
+		i := strings.IndexByte(s, '.')
+		if i < 0 {
+			break
+		}
+		s = s[i+1:]
+	}
+	for _, rr := range l.regexes {
+		if rr.p.regex.MatchString(name) && !f(rr.rule) {
+			return
+		}
+	}
+}
+
+// Match returns rules that decide the query. It returns nil if no rule
+// matches or the query is allowed by an exception. client can be invalid
+// if it is unknown.
+//
+// Priorities, from high to low: important exceptions, important rules,
+// exceptions, $dnsrewrite rules, other blocking rules. All matched
+// $dnsrewrite rules, or hosts-style rules, are returned so that their
+// records can be combined. Otherwise, only the first matched rule is
+// returned.
+func (l *List) Match(name string, qtype uint16, client netip.Addr) []*Rule {
+	return l.match(name, func(r *Rule) bool { return r.matchQuery(qtype, client) })
+}
+
+// MatchDomain reports whether name is blocked by any rule, regardless of
+// qtype and client. $dnstype and $client of blocking rules are ignored.
+// Exceptions only apply if they have none of them.
+func (l *List) MatchDomain(name string) bool {
+	return len(l.match(name, func(r *Rule) bool {
+		return !r.Exception || (r.types == nil && r.clients == nil)
+	})) > 0
+}
+
+// match implements Match. Rules are ignored if filter returns false.
+func (l *List) match(name string, filter func(r *Rule) bool) []*Rule {
+	const (
+		block = iota
+		rewrite
+		exception
+		important
+		importantException
+	)
+	best := -1
+	var rules []*Rule
+	l.rangeRules(name, func(r *Rule) bool {
+		if !filter(r) {
+			return true
+		}
+		var p int
+		switch {
+		case r.Exception && r.Important:
+			p = importantException
+		case r.Important:
+			p = important
+		case r.Exception:
+			p = exception
+		case r.Rewrite != nil:
+			p = rewrite
+		default:
+			p = block
+		}
+		switch {
+		case p > best:
+			best = p
+			rules = append(rules[:0], r)
+		case p == best && p == rewrite,
+			p == best && p == block && len(r.Hosts) > 0 && len(rules[0].Hosts) > 0:
+			rules = append(rules, r)
+		}
+		return best != importantException
+	})
+	if best == exception || best == importantException {
+		return nil
+	}
+	return rules
+}
+
+// Response builds the response of q from rules returned by Match.
+//
+// $dnsrewrite rules answer their records of the query type, and CNAMEs.
+// Hosts-style rules answer their addresses of the query family. Other
+// rules answer NXDOMAIN.
+func Response(q *dns.Msg, rules []*Rule, ttl uint32) *dns.Msg {
+	r := new(dns.Msg)
+	r.SetReply(q)
+	r.RecursionAvailable = true
+	if len(rules) == 0 || len(q.Question) != 1 {
+		r.Rcode = dns.RcodeNameError
+		return r
+	}
+	question := q.Question[0]
+	hdr := func(rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{Name: question.Name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: ttl}
+	}
+
+	first := rules[0]
+	switch {
+	case first.Rewrite != nil:
+		r.Rcode = first.Rewrite.Rcode
+		for _, rule := range rules {
+			rr := rule.Rewrite.RR
+			if rr == nil {
+				continue
+			}
+			if t := rr.Header().Rrtype; t != question.Qtype && t != dns.TypeCNAME {
+				continue
+			}
+			rr = dns.Copy(rr)
+			*rr.Header() = hdr(rr.Header().Rrtype)
+			r.Answer = append(r.Answer, rr)
+		}
+	case len(first.Hosts) > 0:
+		for _, rule := range rules {
+			for _, addr := range rule.Hosts {
+				switch {
+				case question.Qtype == dns.TypeA && addr.Is4():
+					r.Answer = append(r.Answer, &dns.A{Hdr: hdr(dns.TypeA), A: addr.AsSlice()})
+				case question.Qtype == dns.TypeAAAA && addr.Is6():
+					r.Answer = append(r.Answer, &dns.AAAA{Hdr: hdr(dns.TypeAAAA), AAAA: addr.AsSlice()})
+				}
+			}
+		}
+	default:
+		r.Rcode = dns.RcodeNameError
+	}
+	return r
+}
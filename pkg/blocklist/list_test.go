@@ -0,0 +1,154 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package blocklist
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+const testRules = `! Title: test
+[Adblock Plus 2.0]
+||ads.example^
+@@||good.ads.example^
+||tracker.example^$important
+@@||tracker.example^
+|exact.example^
+plain.example
+/^ad[0-9]+\./
+||*.cdn-ads.example^
+||lan.example^$client=192.168.1.0/24|~192.168.1.1
+||v6only.example^$dnstype=AAAA
+||rewrite.example^$dnsrewrite=192.0.2.1
+||rewrite.example^$dnsrewrite=NOERROR;AAAA;2001:db8::1
+||cname.example^$dnsrewrite=target.example
+||refused.example^$dnsrewrite=REFUSED
+0.0.0.0 hosts.example # comment
+:: hosts.example
+||unsupported.example^$ctag=device_phone
+`
+
+func TestList(t *testing.T) {
+	l := NewList()
+	if err := l.LoadFromReader(strings.NewReader(testRules), "test.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if l.Skipped() != 1 {
+		t.Fatalf("want 1 skipped rule, got %d", l.Skipped())
+	}
+
+	lanClient := netip.MustParseAddr("192.168.1.2")
+	tests := []struct {
+		name     string
+		qtype    uint16
+		client   netip.Addr
+		wantLine int // 0 means not blocked
+	}{
+		{"ads.example.", dns.TypeA, lanClient, 3},
+		{"x.ads.example.", dns.TypeA, lanClient, 3},
+		{"good.ads.example.", dns.TypeA, lanClient, 0},
+		{"tracker.example.", dns.TypeA, lanClient, 5},
+		{"exact.example.", dns.TypeA, lanClient, 7},
+		{"sub.exact.example.", dns.TypeA, lanClient, 0},
+		{"sub.plain.example.", dns.TypeA, lanClient, 8},
+		{"ad123.example.", dns.TypeA, lanClient, 9},
+		{"img.cdn-ads.example.", dns.TypeA, lanClient, 10},
+		{"lan.example.", dns.TypeA, lanClient, 11},
+		{"lan.example.", dns.TypeA, netip.MustParseAddr("192.168.1.1"), 0},
+		{"lan.example.", dns.TypeA, netip.MustParseAddr("10.0.0.1"), 0},
+		{"v6only.example.", dns.TypeA, lanClient, 0},
+		{"v6only.example.", dns.TypeAAAA, lanClient, 12},
+		{"unsupported.example.", dns.TypeA, lanClient, 0},
+	}
+	for _, tt := range tests {
+		rules := l.Match(tt.name, tt.qtype, tt.client)
+		gotLine := 0
+		if len(rules) > 0 {
+			gotLine = rules[0].Line
+			if rules[0].Source != "test.txt" {
+				t.Fatalf("%s: unexpected source %s", tt.name, rules[0].Source)
+			}
+		}
+		if gotLine != tt.wantLine {
+			t.Fatalf("%s %s %s: want line %d, got %d", tt.name, dns.TypeToString[tt.qtype], tt.client, tt.wantLine, gotLine)
+		}
+	}
+	if !l.MatchDomain("ads.example.") || l.MatchDomain("good.ads.example.") {
+		t.Fatal("unexpected MatchDomain result")
+	}
+	// $client and $dnstype are ignored.
+	if !l.MatchDomain("lan.example.") || !l.MatchDomain("v6only.example.") {
+		t.Fatal("rules with $client or $dnstype should match domains")
+	}
+}
+
+func TestResponse(t *testing.T) {
+	l := NewList()
+	if err := l.LoadFromReader(strings.NewReader(testRules), "test.txt"); err != nil {
+		t.Fatal(err)
+	}
+	resp := func(name string, qtype uint16) *dns.Msg {
+		q := new(dns.Msg)
+		q.SetQuestion(name, qtype)
+		return Response(q, l.Match(name, qtype, netip.Addr{}), 10)
+	}
+
+	if r := resp("ads.example.", dns.TypeA); r.Rcode != dns.RcodeNameError {
+		t.Fatalf("want nxdomain, got %v", r)
+	}
+	r := resp("rewrite.example.", dns.TypeAAAA)
+	if r.Rcode != dns.RcodeSuccess || len(r.Answer) != 1 || r.Answer[0].(*dns.AAAA).AAAA.String() != "2001:db8::1" {
+		t.Fatalf("unexpected rewrite response %v", r)
+	}
+	if r.Answer[0].Header().Name != "rewrite.example." {
+		t.Fatalf("unexpected owner %s", r.Answer[0].Header().Name)
+	}
+	if r := resp("rewrite.example.", dns.TypeA); len(r.Answer) != 1 || r.Answer[0].(*dns.A).A.String() != "192.0.2.1" {
+		t.Fatalf("unexpected rewrite response %v", r)
+	}
+	if r := resp("cname.example.", dns.TypeA); len(r.Answer) != 1 || r.Answer[0].(*dns.CNAME).Target != "target.example." {
+		t.Fatalf("unexpected cname response %v", r)
+	}
+	if r := resp("refused.example.", dns.TypeA); r.Rcode != dns.RcodeRefused {
+		t.Fatalf("want refused, got %v", r)
+	}
+	if r := resp("hosts.example.", dns.TypeA); len(r.Answer) != 1 || r.Answer[0].(*dns.A).A.String() != "0.0.0.0" {
+		t.Fatalf("unexpected hosts response %v", r)
+	}
+	if r := resp("hosts.example.", dns.TypeAAAA); len(r.Answer) != 1 || r.Answer[0].(*dns.AAAA).AAAA.String() != "::" {
+		t.Fatalf("unexpected hosts response %v", r)
+	}
+}
+
+func TestList_InvalidRule(t *testing.T) {
+	for _, s := range []string{
+		"||bad domain^",
+		"||example^$dnstype=NOTATYPE",
+		"||example^$dnsrewrite=NOERROR;A;not-an-ip",
+		"/(/",
+	} {
+		if err := NewList().AddRule(s, "", 1); err == nil {
+			t.Fatalf("want error for %q", s)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package blocklist
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/netlist"
+	"github.com/miekg/dns"
+)
+
+// errUnsupported reports rules that are valid but not supported. They are
+// skipped instead of failing the whole list.
+var errUnsupported = errors.New("unsupported rule")
+
+// Rule is a parsed filter rule.
+type Rule struct {
+	// Text is the original rule.
+	Text string
+	// Source is where the rule is from, e.g. a file name.
+	Source string
+	// Line is the line number of the rule in Source, starts from 1.
+	Line int
+
+	Exception bool
+	Important bool
+	// Rewrite is the $dnsrewrite value. Nil if the rule has no $dnsrewrite.
+	Rewrite *Rewrite
+	// Hosts are addresses of a hosts-style rule.
+	Hosts []netip.Addr
+
+	clients *clientFilter
+	types   *typeFilter
+}
+
+func (r *Rule) String() string {
+	return fmt.Sprintf("%s:%d: %s", r.Source, r.Line, r.Text)
+}
+
+func (r *Rule) matchQuery(qtype uint16, client netip.Addr) bool {
+	return r.types.match(qtype) && r.clients.match(client)
+}
+
+// Rewrite is a parsed $dnsrewrite value.
+type Rewrite struct {
+	Rcode int
+	// RR is the record to answer. Its owner name is a placeholder and
+	// must be replaced. Nil if the rewrite only has a rcode.
+	RR dns.RR
+}
+
+type clientFilter struct {
+	include *netlist.List
+	exclude *netlist.List
+}
+
+func (f *clientFilter) match(addr netip.Addr) bool {
+	if f == nil {
+		return true
+	}
+	if !addr.IsValid() {
+		return f.include == nil
+	}
+	addr = addr.Unmap()
+	if f.exclude != nil && f.exclude.Contains(addr) {
+		return false
+	}
+	return f.include == nil || f.include.Contains(addr)
+}
+
+type typeFilter struct {
+	include map[uint16]struct{}
+	exclude map[uint16]struct{}
+}
+
+func (f *typeFilter) match(qtype uint16) bool {
+	if f == nil {
+		return true
+	}
+	if _, ok := f.exclude[qtype]; ok {
+		return false
+	}
+	if f.include == nil {
+		return true
+	}
+	_, ok := f.include[qtype]
+	return ok
+}
+
+// pattern is how a rule matches names. Exactly one of its fields is set.
+type pattern struct {
+	exact  string // name must equal to it
+	domain string // name is it or its subdomain
+	regex  *regexp.Regexp
+}
+
+// parseRule parses a rule line. It returns nil rules for empty lines and
+// comments. A hosts-style line may have multiple names, so it returns
+// multiple patterns that share the same rule.
+func parseRule(s string) (*Rule, []pattern, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 0 || s[0] == '!' || s[0] == '#' || s[0] == '[' {
+		return nil, nil, nil
+	}
+	if r, ps, ok, err := parseHostsRule(s); ok {
+		return r, ps, err
+	}
+
+	r := &Rule{Text: s}
+	if strings.HasPrefix(s, "@@") {
+		r.Exception = true
+		s = s[2:]
+	}
+
+	body, mods := splitModifiers(s)
+	if len(mods) > 0 {
+		for _, m := range strings.Split(mods, ",") {
+			if err := r.parseModifier(m); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	p, err := parsePattern(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, []pattern{p}, nil
+}
+
+// parseHostsRule parses "ip name [names...]". ok is false if s is not a
+// hosts-style line.
+func parseHostsRule(s string) (r *Rule, ps []pattern, ok bool, err error) {
+	s = strings.TrimSpace(strings.SplitN(s, "#", 2)[0])
+	fields := strings.Fields(s)
+	if len(fields) < 2 {
+		return nil, nil, false, nil
+	}
+	addr, err := netip.ParseAddr(fields[0])
+	if err != nil {
+		return nil, nil, false, nil
+	}
+	r = &Rule{Text: s, Hosts: []netip.Addr{addr.Unmap()}}
+	for _, name := range fields[1:] {
+		name = normalize(name)
+		if !validName(name) {
+			return nil, nil, true, fmt.Errorf("invalid name %s", name)
+		}
+		ps = append(ps, pattern{exact: name})
+	}
+	return r, ps, true, nil
+}
+
+// splitModifiers splits "pattern$modifiers".
+func splitModifiers(s string) (string, string) {
+	start := 0
+	if len(s) > 1 && s[0] == '/' {
+		if i := strings.LastIndexByte(s, '/'); i > 0 {
+			start = i
+		}
+	}
+	if i := strings.IndexByte(s[start:], '$'); i >= 0 {
+		return s[:start+i], s[start+i+1:]
+	}
+	return s, ""
+}
+
+func (r *Rule) parseModifier(m string) error {
+	name, value, _ := strings.Cut(strings.TrimSpace(m), "=")
+	switch name {
+	case "important":
+		r.Important = true
+	case "client":
+		f := new(clientFilter)
+		for _, c := range strings.Split(value, "|") {
+			c = strings.Trim(strings.TrimSpace(c), `'"`)
+			list := &f.include
+			if strings.HasPrefix(c, "~") {
+				list = &f.exclude
+				c = c[1:]
+			}
+			if *list == nil {
+				*list = netlist.NewList()
+			}
+			if err := netlist.LoadFromText(*list, c); err != nil {
+				// Client names are not supported.
+				return fmt.Errorf("%w, client %s", errUnsupported, c)
+			}
+		}
+		for _, l := range [...]*netlist.List{f.include, f.exclude} {
+			if l != nil {
+				l.Sort()
+			}
+		}
+		r.clients = f
+	case "dnstype":
+		f := new(typeFilter)
+		for _, t := range strings.Split(value, "|") {
+			set := &f.include
+			if strings.HasPrefix(t, "~") {
+				set = &f.exclude
+				t = t[1:]
+			}
+			qt, ok := dns.StringToType[strings.ToUpper(t)]
+			if !ok {
+				return fmt.Errorf("invalid dnstype %s", t)
+			}
+			if *set == nil {
+				*set = make(map[uint16]struct{})
+			}
+			(*set)[qt] = struct{}{}
+		}
+		r.types = f
+	case "dnsrewrite":
+		rw, err := parseRewrite(value)
+		if err != nil {
+			return fmt.Errorf("invalid dnsrewrite, %w", err)
+		}
+		r.Rewrite = rw
+	default:
+		return fmt.Errorf("%w, modifier %s", errUnsupported, name)
+	}
+	return nil
+}
+
+// rewriteOwner is the placeholder owner of rewrite records.
+const rewriteOwner = "rewrite.invalid."
+
+// parseRewrite parses the short form "rcode", "ip" or "name", and the full
+// form "rcode;type;value".
+func parseRewrite(s string) (*Rewrite, error) {
+	if rcode, ok := dns.StringToRcode[strings.ToUpper(s)]; ok {
+		return &Rewrite{Rcode: rcode}, nil
+	}
+	if strings.Count(s, ";") == 2 {
+		parts := strings.SplitN(s, ";", 3)
+		rcode, ok := dns.StringToRcode[strings.ToUpper(parts[0])]
+		if !ok {
+			return nil, fmt.Errorf("invalid rcode %s", parts[0])
+		}
+		rw := &Rewrite{Rcode: rcode}
+		if len(parts[1]) > 0 {
+			rr, err := dns.NewRR(fmt.Sprintf("%s 10 IN %s %s", rewriteOwner, parts[1], parts[2]))
+			if err != nil {
+				return nil, err
+			}
+			if rr == nil {
+				return nil, errors.New("empty record")
+			}
+			rw.RR = rr
+		}
+		return rw, nil
+	}
+	hdr := dns.RR_Header{Name: rewriteOwner, Class: dns.ClassINET, Ttl: 10}
+	if addr, err := netip.ParseAddr(s); err == nil {
+		if addr.Is4() {
+			hdr.Rrtype = dns.TypeA
+			return &Rewrite{RR: &dns.A{Hdr: hdr, A: addr.AsSlice()}}, nil
+		}
+		hdr.Rrtype = dns.TypeAAAA
+		return &Rewrite{RR: &dns.AAAA{Hdr: hdr, AAAA: addr.AsSlice()}}, nil
+	}
+	if _, ok := dns.IsDomainName(s); ok && len(s) > 0 {
+		hdr.Rrtype = dns.TypeCNAME
+		return &Rewrite{RR: &dns.CNAME{Hdr: hdr, Target: dns.Fqdn(s)}}, nil
+	}
+	return nil, fmt.Errorf("invalid value %s", s)
+}
+
+// parsePattern parses "||domain^", "|domain^", "domain" and "/regexp/".
+// "domain" matches the domain and its subdomains, the same as "||domain^".
+func parsePattern(s string) (pattern, error) {
+	if len(s) > 2 && s[0] == '/' && s[len(s)-1] == '/' {
+		re, err := regexp.Compile(s[1 : len(s)-1])
+		if err != nil {
+			return pattern{}, err
+		}
+		return pattern{regex: re}, nil
+	}
+
+	sub := true
+	name := s
+	switch {
+	case strings.HasPrefix(name, "||"):
+		name = name[2:]
+	case strings.HasPrefix(name, "|"):
+		name = name[1:]
+		sub = false
+	}
+	name = strings.TrimSuffix(strings.TrimSuffix(name, "|"), "^")
+	if len(name) == 0 {
+		return pattern{}, errors.New("empty pattern")
+	}
+	if strings.ContainsAny(name, "*^|/") {
+		re, err := wildcardRegexp(s)
+		if err != nil {
+			return pattern{}, err
+		}
+		return pattern{regex: re}, nil
+	}
+	name = normalize(name)
+	if !validName(name) {
+		return pattern{}, fmt.Errorf("invalid domain %s", name)
+	}
+	if sub {
+		return pattern{domain: name}, nil
+	}
+	return pattern{exact: name}, nil
+}
+
+// wildcardRegexp converts an adblock pattern with wildcards to a regexp.
+func wildcardRegexp(s string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	switch {
+	case strings.HasPrefix(s, "||"):
+		b.WriteString(`^([^.]+\.)*`)
+		s = s[2:]
+	case strings.HasPrefix(s, "|"):
+		b.WriteString(`^`)
+		s = s[1:]
+	}
+	end := strings.HasSuffix(s, "|")
+	s = strings.TrimSuffix(s, "|")
+	for _, c := range strings.ToLower(s) {
+		switch c {
+		case '*':
+			b.WriteString(`.*`)
+		case '^':
+			b.WriteString(`(\.|$)`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if end {
+		b.WriteString(`$`)
+	}
+	return regexp.Compile(b.String())
+}
+
+func validName(s string) bool {
+	_, ok := dns.IsDomainName(s)
+	return ok && !strings.ContainsAny(s, " \t")
+}
+
+// normalize returns the lower case name without the trailing dot.
+func normalize(s string) string {
+	return strings.TrimSuffix(strings.ToLower(s), ".")
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnsutils
+
+import (
+	"net/netip"
+
+	"github.com/miekg/dns"
+)
+
+const defaultEDNS0UDPSize = 1232
+
+// UpgradeEDNS0 returns the opt record of m. If m does not have one,
+// a new opt record will be appended to m.Extra.
+func UpgradeEDNS0(m *dns.Msg) *dns.OPT {
+	if opt := m.IsEdns0(); opt != nil {
+		return opt
+	}
+	m.SetEdns0(defaultEDNS0UDPSize, false)
+	return m.Extra[len(m.Extra)-1].(*dns.OPT)
+}
+
+// RemoveEDNS0 removes the opt record from m.
+func RemoveEDNS0(m *dns.Msg) {
+	for i := len(m.Extra) - 1; i >= 0; i-- {
+		if m.Extra[i].Header().Rrtype == dns.TypeOPT {
+			m.Extra = append(m.Extra[:i], m.Extra[i+1:]...)
+		}
+	}
+}
+
+// GetEDNS0Option returns the first option that has the code in opt.
+// It returns nil if opt is nil or has no such option.
+func GetEDNS0Option(opt *dns.OPT, code uint16) dns.EDNS0 {
+	if opt == nil {
+		return nil
+	}
+	for _, o := range opt.Option {
+		if o.Option() == code {
+			return o
+		}
+	}
+	return nil
+}
+
+// RemoveEDNS0Option removes all options that have the code from opt.
+// It returns the first removed option or nil if nothing was removed.
+func RemoveEDNS0Option(opt *dns.OPT, code uint16) dns.EDNS0 {
+	if opt == nil {
+		return nil
+	}
+	var removed dns.EDNS0
+	n := 0
+	for _, o := range opt.Option {
+		if o.Option() == code {
+			if removed == nil {
+				removed = o
+			}
+			continue
+		}
+		opt.Option[n] = o
+		n++
+	}
+	opt.Option = opt.Option[:n]
+	return removed
+}
+
+// GetMsgECS returns the ecs option of m, or nil if m does not have one.
+func GetMsgECS(m *dns.Msg) *dns.EDNS0_SUBNET {
+	ecs, _ := GetEDNS0Option(m.IsEdns0(), dns.EDNS0SUBNET).(*dns.EDNS0_SUBNET)
+	return ecs
+}
+
+// RemoveMsgECS removes the ecs option from m and returns it.
+// It returns nil if m does not have one.
+func RemoveMsgECS(m *dns.Msg) *dns.EDNS0_SUBNET {
+	ecs, _ := RemoveEDNS0Option(m.IsEdns0(), dns.EDNS0SUBNET).(*dns.EDNS0_SUBNET)
+	return ecs
+}
+
+// AddECS sets ecs to m. It replaces the existing ecs option if overwrite
+// is true. Otherwise, the existing one will be kept.
+// It returns true if ecs was added to m.
+func AddECS(m *dns.Msg, ecs *dns.EDNS0_SUBNET, overwrite bool) bool {
+	opt := UpgradeEDNS0(m)
+	if GetEDNS0Option(opt, dns.EDNS0SUBNET) != nil {
+		if !overwrite {
+			return false
+		}
+		RemoveEDNS0Option(opt, dns.EDNS0SUBNET)
+	}
+	opt.Option = append(opt.Option, ecs)
+	return true
+}
+
+// NewEDNS0Subnet creates a new ecs option from addr. The address will be
+// masked by mask bits. v4 in v6 addresses will be unmapped first.
+func NewEDNS0Subnet(addr netip.Addr, mask uint8) *dns.EDNS0_SUBNET {
+	addr = addr.Unmap()
+	var family uint16 = 1
+	if addr.Is6() {
+		family = 2
+	}
+	if int(mask) > addr.BitLen() {
+		mask = uint8(addr.BitLen())
+	}
+	p, _ := addr.Prefix(int(mask))
+	return &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        family,
+		SourceNetmask: mask,
+		SourceScope:   0,
+		Address:       p.Addr().AsSlice(),
+	}
+}
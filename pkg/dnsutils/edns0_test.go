@@ -0,0 +1,84 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnsutils
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestNewEDNS0Subnet(t *testing.T) {
+	tests := []struct {
+		name       string
+		addr       string
+		mask       uint8
+		wantFamily uint16
+		wantMask   uint8
+		wantAddr   string
+	}{
+		{"v4", "1.2.3.4", 24, 1, 24, "1.2.3.0"},
+		{"v4_in_v6", "::ffff:1.2.3.4", 16, 1, 16, "1.2.0.0"},
+		{"v4_mask_overflow", "1.2.3.4", 64, 1, 32, "1.2.3.4"},
+		{"v6", "2001:db8:1:2::1", 48, 2, 48, "2001:db8:1::"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ecs := NewEDNS0Subnet(netip.MustParseAddr(tt.addr), tt.mask)
+			if ecs.Family != tt.wantFamily || ecs.SourceNetmask != tt.wantMask {
+				t.Fatalf("got family %d mask %d, want %d %d", ecs.Family, ecs.SourceNetmask, tt.wantFamily, tt.wantMask)
+			}
+			addr, _ := netip.AddrFromSlice(ecs.Address)
+			if want := netip.MustParseAddr(tt.wantAddr); addr.Unmap() != want {
+				t.Fatalf("got addr %s, want %s", addr, want)
+			}
+		})
+	}
+}
+
+func TestAddRemoveECS(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("example.", dns.TypeA)
+	if GetMsgECS(m) != nil {
+		t.Fatal("unexpected ecs")
+	}
+
+	ecs1 := NewEDNS0Subnet(netip.MustParseAddr("1.2.3.4"), 24)
+	if !AddECS(m, ecs1, false) {
+		t.Fatal("ecs should be added")
+	}
+	ecs2 := NewEDNS0Subnet(netip.MustParseAddr("5.6.7.8"), 24)
+	if AddECS(m, ecs2, false) {
+		t.Fatal("ecs should not be overwritten")
+	}
+	if GetMsgECS(m) != ecs1 {
+		t.Fatal("unexpected ecs")
+	}
+	if !AddECS(m, ecs2, true) || GetMsgECS(m) != ecs2 {
+		t.Fatal("ecs should be overwritten")
+	}
+	if RemoveMsgECS(m) != ecs2 || GetMsgECS(m) != nil {
+		t.Fatal("ecs should be removed")
+	}
+	if m.IsEdns0() == nil {
+		t.Fatal("opt should be kept")
+	}
+}
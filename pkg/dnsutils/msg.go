@@ -126,3 +126,30 @@ func QclassToString(u uint16) string {
 func QtypeToString(u uint16) string {
 	return uint16Conv(u, dns.TypeToString)
 }
+
+// StripDNSSEC removes RRSIG, NSEC and NSEC3 records from all sections of m,
+// except records of qtype. It is used to answer clients that did not set
+// the DO bit.
+func StripDNSSEC(m *dns.Msg, qtype uint16) {
+	keep := func(rr dns.RR) bool {
+		switch t := rr.Header().Rrtype; t {
+		case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+			return t == qtype
+		}
+		return true
+	}
+	m.Answer = filterRR(m.Answer, keep)
+	m.Ns = filterRR(m.Ns, keep)
+	m.Extra = filterRR(m.Extra, keep)
+}
+
+func filterRR(rrs []dns.RR, keep func(rr dns.RR) bool) []dns.RR {
+	n := 0
+	for _, rr := range rrs {
+		if keep(rr) {
+			rrs[n] = rr
+			n++
+		}
+	}
+	return rrs[:n]
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnsutils
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestStripDNSSEC(t *testing.T) {
+	newMsg := func() *dns.Msg {
+		mustRR := func(s string) dns.RR {
+			rr, err := dns.NewRR(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return rr
+		}
+		m := new(dns.Msg)
+		m.Answer = []dns.RR{
+			mustRR("example. 300 IN NSEC a.example. A RRSIG NSEC"),
+			mustRR("example. 300 IN RRSIG NSEC 8 1 300 20300101000000 20200101000000 1 example. AA=="),
+		}
+		m.Ns = []dns.RR{mustRR("example. 300 IN NS ns.example.")}
+		m.Extra = []dns.RR{
+			mustRR("ns.example. 300 IN A 192.0.2.1"),
+			mustRR("ns.example. 300 IN RRSIG A 8 2 300 20300101000000 20200101000000 1 example. AA=="),
+		}
+		m.SetEdns0(1232, true)
+		return m
+	}
+
+	m := newMsg()
+	StripDNSSEC(m, dns.TypeA)
+	if len(m.Answer) != 0 || len(m.Ns) != 1 || len(m.Extra) != 2 || m.IsEdns0() == nil {
+		t.Fatalf("unexpected msg %v", m)
+	}
+	if _, ok := m.Extra[0].(*dns.A); !ok {
+		t.Fatalf("unexpected extra %v", m.Extra)
+	}
+
+	// Records of qtype are kept.
+	m = newMsg()
+	StripDNSSEC(m, dns.TypeNSEC)
+	if len(m.Answer) != 1 || m.Answer[0].Header().Rrtype != dns.TypeNSEC {
+		t.Fatalf("unexpected answer %v", m.Answer)
+	}
+}
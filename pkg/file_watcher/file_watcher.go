@@ -0,0 +1,140 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package file_watcher
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+const defaultDelay = time.Millisecond * 500
+
+// Watcher watches files and calls OnChange after any of them was changed.
+// Parent directories are watched instead of the files, so files that are
+// replaced (e.g. by editors or atomic renames) are still watched.
+type Watcher struct {
+	opts  Opts
+	w     *fsnotify.Watcher
+	files map[string]struct{}
+
+	closeOnce   sync.Once
+	closeNotify chan struct{}
+}
+
+type Opts struct {
+	// OnChange is called in a dedicated goroutine. Required.
+	OnChange func()
+	// Delay merges changes in this period into one OnChange call.
+	// Default is 500ms.
+	Delay  time.Duration
+	Logger *zap.Logger
+}
+
+func (opts *Opts) init() {
+	utils.SetDefaultNum(&opts.Delay, defaultDelay)
+	if opts.Logger == nil {
+		opts.Logger = mlog.Nop()
+	}
+}
+
+// New creates a Watcher that watches files.
+func New(files []string, opts Opts) (*Watcher, error) {
+	opts.init()
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{
+		opts:        opts,
+		w:           fw,
+		files:       make(map[string]struct{}),
+		closeNotify: make(chan struct{}),
+	}
+
+	dirs := make(map[string]struct{})
+	for _, f := range files {
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			_ = fw.Close()
+			return nil, fmt.Errorf("invalid file path %s, %w", f, err)
+		}
+		w.files[abs] = struct{}{}
+		dirs[filepath.Dir(abs)] = struct{}{}
+	}
+	for dir := range dirs {
+		if err := fw.Add(dir); err != nil {
+			_ = fw.Close()
+			return nil, fmt.Errorf("failed to watch dir %s, %w", dir, err)
+		}
+	}
+	go w.loop()
+	return w, nil
+}
+
+func (w *Watcher) loop() {
+	var timer *time.Timer
+	var timerC <-chan time.Time
+	for {
+		select {
+		case e, ok := <-w.w.Events:
+			if !ok {
+				return
+			}
+			if _, ok := w.files[filepath.Clean(e.Name)]; !ok {
+				continue
+			}
+			if e.Op == fsnotify.Chmod {
+				continue
+			}
+			if timer == nil {
+				timer = time.NewTimer(w.opts.Delay)
+				timerC = timer.C
+			}
+		case err, ok := <-w.w.Errors:
+			if !ok {
+				return
+			}
+			w.opts.Logger.Warn("file watcher error", zap.Error(err))
+		case <-timerC:
+			timer, timerC = nil, nil
+			w.opts.OnChange()
+		case <-w.closeNotify:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		}
+	}
+}
+
+// Close stops the Watcher.
+func (w *Watcher) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.closeNotify)
+	})
+	return w.w.Close()
+}
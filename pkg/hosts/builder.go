@@ -0,0 +1,137 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package hosts
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/domain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+)
+
+// Builder builds a Hosts from lines of two formats:
+//   - "pattern ip..." (see ParseIPs). A later line of the same pattern
+//     replaces the former one. Patterns without a match type are full matches.
+//   - The /etc/hosts format "ip name [aliases...]". Addresses of the same
+//     name are merged.
+//
+// PTR records are generated from full matched names.
+type Builder struct {
+	m     *domain.MixMatcher[*IPs]
+	full  map[string]*IPs // normalized domain -> ips
+	names []string        // keys of full in insertion order
+}
+
+func NewBuilder() *Builder {
+	m := domain.NewMixMatcher[*IPs]()
+	m.SetDefaultMatcher(domain.MatcherFull)
+	return &Builder{
+		m:    m,
+		full: make(map[string]*IPs),
+	}
+}
+
+// AddLine adds an entry. s must not contain comments.
+func (b *Builder) AddLine(s string) error {
+	f := strings.Fields(s)
+	if len(f) == 0 {
+		return nil
+	}
+	if ip, err := netip.ParseAddr(f[0]); err == nil {
+		if len(f) < 2 {
+			return fmt.Errorf("missing host name for %s", ip)
+		}
+		for _, name := range f[1:] {
+			ips := b.fullIPs(name)
+			ips.add(ip)
+		}
+		return nil
+	}
+
+	pattern, ips, err := ParseIPs(s)
+	if err != nil {
+		return err
+	}
+	typ, name, ok := utils.SplitString2(pattern, ":")
+	if !ok {
+		name = pattern
+	}
+	if ok && typ != domain.MatcherFull {
+		return b.m.Add(pattern, ips)
+	}
+	*b.fullIPs(name) = *ips
+	return nil
+}
+
+// LoadFromReader adds entries from r, one entry per line.
+// Texts after "#" are comments.
+func (b *Builder) LoadFromReader(r io.Reader) error {
+	line := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line++
+		s := strings.TrimSpace(utils.RemoveComment(scanner.Text(), "#"))
+		if err := b.AddLine(s); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// Build builds the Hosts. The Builder should not be used after.
+func (b *Builder) Build(opts Opts) *Hosts {
+	sm := b.m.GetSubMatcher(domain.MatcherFull)
+	ptr := make(map[netip.Addr][]string)
+	for _, name := range b.names {
+		ips := b.full[name]
+		sm.Add(name, ips)
+		for _, ip := range ips.IPv4 {
+			ptr[ip] = append(ptr[ip], name+".")
+		}
+		for _, ip := range ips.IPv6 {
+			ptr[ip] = append(ptr[ip], name+".")
+		}
+	}
+	opts.PTR = ptr
+	return NewHostsWithOpts(b.m, opts)
+}
+
+func (b *Builder) fullIPs(name string) *IPs {
+	name = domain.NormalizeDomain(name)
+	ips := b.full[name]
+	if ips == nil {
+		ips = new(IPs)
+		b.full[name] = ips
+		b.names = append(b.names, name)
+	}
+	return ips
+}
+
+func (ips *IPs) add(ip netip.Addr) {
+	if ip.Is4() {
+		ips.IPv4 = append(ips.IPv4, ip)
+	} else {
+		ips.IPv6 = append(ips.IPv6, ip)
+	}
+}
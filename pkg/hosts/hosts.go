@@ -23,22 +23,25 @@ import (
 	"errors"
 	"fmt"
 	"net/netip"
-	"os"
 	"strings"
-	"time"
 
+	"github.com/IrineSistiana/mosdns/v5/pkg/dnsutils"
 	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/domain"
 	"github.com/miekg/dns"
 )
 
-var addInfoEnabled bool
-
-func init() {
-	addInfoEnabled = os.Getenv("ADDINFO") == "yes"
-}
-
 type Hosts struct {
 	matcher domain.Matcher[*IPs]
+	opts    Opts
+}
+
+type Opts struct {
+	// PTR maps addresses to fqdns. It is used to answer PTR queries.
+	PTR map[netip.Addr][]string
+
+	// FallThrough makes LookupMsg return nil, instead of an empty response,
+	// if the name exists but has no address of the queried type.
+	FallThrough bool
 }
 
 // NewHosts creates a hosts using m.
@@ -48,6 +51,14 @@ func NewHosts(m domain.Matcher[*IPs]) *Hosts {
 	}
 }
 
+// NewHostsWithOpts creates a hosts using m and opts.
+func NewHostsWithOpts(m domain.Matcher[*IPs], opts Opts) *Hosts {
+	return &Hosts{
+		matcher: m,
+		opts:    opts,
+	}
+}
+
 func (h *Hosts) Lookup(fqdn string) (ipv4, ipv6 []netip.Addr) {
 	ips, ok := h.matcher.Match(fqdn)
 	if !ok {
@@ -56,6 +67,11 @@ func (h *Hosts) Lookup(fqdn string) (ipv4, ipv6 []netip.Addr) {
 	return ips.IPv4, ips.IPv6
 }
 
+// LookupPTR returns the names of addr.
+func (h *Hosts) LookupPTR(addr netip.Addr) []string {
+	return h.opts.PTR[addr.Unmap()]
+}
+
 func (h *Hosts) LookupMsg(m *dns.Msg) *dns.Msg {
 	if len(m.Question) != 1 {
 		return nil
@@ -63,7 +79,13 @@ func (h *Hosts) LookupMsg(m *dns.Msg) *dns.Msg {
 	q := m.Question[0]
 	typ := q.Qtype
 	fqdn := q.Name
-	if q.Qclass != dns.ClassINET || (typ != dns.TypeA && typ != dns.TypeAAAA) {
+	if q.Qclass != dns.ClassINET {
+		return nil
+	}
+	if typ == dns.TypePTR {
+		return h.lookupPTRMsg(m)
+	}
+	if typ != dns.TypeA && typ != dns.TypeAAAA {
 		return nil
 	}
 
@@ -104,19 +126,40 @@ func (h *Hosts) LookupMsg(m *dns.Msg) *dns.Msg {
 	}
 
 	if len(r.Answer) == 0 {
+		if h.opts.FallThrough {
+			return nil
+		}
 		r.Ns = []dns.RR{}
-	} else {
-		if addInfoEnabled && r != nil {
-			txtRecord := new(dns.TXT)
-			txtRecord.Hdr = dns.RR_Header{
-				Name:   time.Now().Format("20060102150405.0000000") + ".usehosts.paopaodns.",
-				Rrtype: dns.TypeTXT,
+	}
+	return r
+}
+
+func (h *Hosts) lookupPTRMsg(m *dns.Msg) *dns.Msg {
+	if len(h.opts.PTR) == 0 {
+		return nil
+	}
+	fqdn := m.Question[0].Name
+	addr, err := dnsutils.ParsePTRQName(strings.ToLower(fqdn))
+	if err != nil {
+		return nil
+	}
+	names := h.LookupPTR(addr)
+	if len(names) == 0 {
+		return nil
+	}
+
+	r := new(dns.Msg)
+	r.SetReply(m)
+	for _, name := range names {
+		r.Answer = append(r.Answer, &dns.PTR{
+			Hdr: dns.RR_Header{
+				Name:   fqdn,
+				Rrtype: dns.TypePTR,
 				Class:  dns.ClassINET,
-				Ttl:    301,
-			}
-			txtRecord.Txt = []string{"USE_HOSTS"}
-			r.Extra = append(r.Extra, txtRecord)
-		}
+				Ttl:    300,
+			},
+			Ptr: name,
+		})
 	}
 	return r
 }
@@ -142,11 +185,7 @@ func ParseIPs(s string) (string, *IPs, error) {
 			return "", nil, fmt.Errorf("invalid ip addr %s, %w", ipStr, err)
 		}
 
-		if ip.Is4() { // is ipv4
-			v.IPv4 = append(v.IPv4, ip)
-		} else { // is ipv6
-			v.IPv6 = append(v.IPv6, ip)
-		}
+		v.add(ip)
 	}
 
 	return pattern, v, nil
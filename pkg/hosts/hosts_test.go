@@ -103,3 +103,62 @@ func Test_hostsContainer_Match(t *testing.T) {
 		})
 	}
 }
+
+func TestHosts_FallThrough(t *testing.T) {
+	m := domain.NewMixMatcher[*IPs]()
+	m.SetDefaultMatcher(domain.MatcherFull)
+	if err := domain.LoadFromTextReader[*IPs](m, bytes.NewBuffer([]byte(test_hosts)), ParseIPs); err != nil {
+		t.Fatal(err)
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("test.com.", dns.TypeAAAA)
+	r := NewHosts(m).LookupMsg(q)
+	if r == nil || r.Rcode != dns.RcodeSuccess || len(r.Answer) != 0 {
+		t.Fatalf("want NODATA by default, got %v", r)
+	}
+	if r := NewHostsWithOpts(m, Opts{FallThrough: true}).LookupMsg(q); r != nil {
+		t.Fatal("name without addresses of the type should fall through")
+	}
+}
+
+const etcHosts = `
+127.0.0.1   localhost
+::1         localhost ip6-localhost
+192.168.1.2 nas.lan nas  # trailing comment
+192.168.1.3 nas.lan
+dns.google 8.8.8.8
+domain:ads.example 0.0.0.0
+`
+
+func TestBuilder(t *testing.T) {
+	b := NewBuilder()
+	if err := b.LoadFromReader(bytes.NewBufferString(etcHosts)); err != nil {
+		t.Fatal(err)
+	}
+	h := b.Build(Opts{})
+
+	if v4, v6 := h.Lookup("localhost."); len(v4) != 1 || len(v6) != 1 {
+		t.Fatalf("localhost should have merged addresses, got %v %v", v4, v6)
+	}
+	if v4, _ := h.Lookup("NAS.lan."); len(v4) != 2 {
+		t.Fatalf("nas.lan should have 2 addresses, got %v", v4)
+	}
+	if v4, _ := h.Lookup("x.ads.example."); len(v4) != 1 {
+		t.Fatalf("domain pattern should match sub domains, got %v", v4)
+	}
+
+	q := new(dns.Msg)
+	q.SetQuestion("2.1.168.192.in-addr.arpa.", dns.TypePTR)
+	r := h.LookupMsg(q)
+	if r == nil || len(r.Answer) != 2 || r.Answer[0].(*dns.PTR).Ptr != "nas.lan." {
+		t.Fatalf("unexpected PTR response %v", r)
+	}
+	q.SetQuestion("8.8.8.8.in-addr.arpa.", dns.TypePTR)
+	if r := h.LookupMsg(q); r == nil || r.Answer[0].(*dns.PTR).Ptr != "dns.google." {
+		t.Fatalf("unexpected PTR response %v", r)
+	}
+
+	if err := NewBuilder().AddLine("192.168.1.1"); err == nil {
+		t.Fatal("line without names should be rejected")
+	}
+}
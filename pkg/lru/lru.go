@@ -116,6 +116,14 @@ func (q *LRU[K, V]) Clean(f func(key K, v V) (remove bool)) (removed int) {
 	return removed
 }
 
+// Range calls f for each key, from the oldest to the newest.
+// It does not change the order of keys.
+func (q *LRU[K, V]) Range(f func(key K, v V)) {
+	for e := q.l.Front(); e != nil; e = e.Next() {
+		f(e.Value.key, e.Value.v)
+	}
+}
+
 func (q *LRU[K, V]) Flush() {
 	q.l = list.New[KV[K, V]]()
 	q.m = make(map[K]*list.Elem[KV[K, V]])
@@ -23,6 +23,7 @@ import (
 	"github.com/miekg/dns"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"slices"
 	"sync/atomic"
 	"time"
 )
@@ -35,12 +36,18 @@ type Context struct {
 	startTime time.Time // when was this Context created
 	q         *dns.Msg
 
+	// Whether q had an OPT before any plugin modified it.
+	clientEDNS bool
+
 	// id for this Context. Not for the dns query. This id is mainly for logging.
 	id uint32
 
 	// Response. Might be nil.
 	r *dns.Msg
 
+	// Extended dns errors that will be attached to the response.
+	ede []*dns.EDNS0_EDE
+
 	// lazy init.
 	kv    map[uint32]any
 	marks map[uint32]struct{}
@@ -55,9 +62,10 @@ func NewContext(q *dns.Msg) *Context {
 		panic("handler: query msg is nil")
 	}
 	ctx := &Context{
-		q:         q,
-		id:        contextUid.Add(1),
-		startTime: time.Now(),
+		q:          q,
+		clientEDNS: q.IsEdns0() != nil,
+		id:         contextUid.Add(1),
+		startTime:  time.Now(),
 	}
 
 	return ctx
@@ -68,6 +76,13 @@ func (ctx *Context) Q() *dns.Msg {
 	return ctx.q
 }
 
+// ClientEDNS reports whether the query had EDNS0 when the Context was
+// created. Unlike Q().IsEdns0(), it is not affected by plugins that
+// add or remove the OPT of the query.
+func (ctx *Context) ClientEDNS() bool {
+	return ctx.clientEDNS
+}
+
 // R returns the response. It might be nil.
 func (ctx *Context) R() *dns.Msg {
 	return ctx.r
@@ -111,11 +126,13 @@ func (ctx *Context) Copy() *Context {
 func (ctx *Context) CopyTo(d *Context) *Context {
 	d.startTime = ctx.startTime
 	d.q = ctx.q.Copy()
+	d.clientEDNS = ctx.clientEDNS
 	d.id = ctx.id
 
 	if r := ctx.r; r != nil {
 		d.r = r.Copy()
 	}
+	d.ede = append([]*dns.EDNS0_EDE(nil), ctx.ede...)
 	d.kv = copyMap(ctx.kv)
 	d.marks = copyMap(ctx.marks)
 	return d
@@ -155,6 +172,19 @@ func (ctx *Context) HasMark(m uint32) bool {
 	return ok
 }
 
+// Marks returns all marks of this Context in ascending order.
+func (ctx *Context) Marks() []uint32 {
+	if len(ctx.marks) == 0 {
+		return nil
+	}
+	ms := make([]uint32, 0, len(ctx.marks))
+	for m := range ctx.marks {
+		ms = append(ms, m)
+	}
+	slices.Sort(ms)
+	return ms
+}
+
 // DeleteMark deletes mark m from this Context.
 func (ctx *Context) DeleteMark(m uint32) {
 	delete(ctx.marks, m)
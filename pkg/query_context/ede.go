@@ -0,0 +1,94 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package query_context
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// AddInfoMode controls how plugins attach diagnostic info to responses.
+type AddInfoMode uint8
+
+const (
+	AddInfoOff AddInfoMode = iota
+	// AddInfoEDE attaches info as extended dns errors (RFC 8914).
+	AddInfoEDE
+	// AddInfoTXT appends TXT records named "*.paopaodns." to the
+	// additional section. It is kept for compatibility.
+	AddInfoTXT
+)
+
+var addInfoMode = sync.OnceValue(func() AddInfoMode {
+	switch strings.ToLower(os.Getenv("ADDINFO")) {
+	case "yes", "ede":
+		return AddInfoEDE
+	case "txt":
+		return AddInfoTXT
+	default:
+		return AddInfoOff
+	}
+})
+
+// GetAddInfoMode returns the mode set by env "ADDINFO".
+// "yes" or "ede" enables AddInfoEDE, "txt" enables AddInfoTXT.
+func GetAddInfoMode() AddInfoMode {
+	return addInfoMode()
+}
+
+// AddEDE attaches an extended dns error to this Context. The server
+// will add it to the response if the client supports EDNS0.
+func (ctx *Context) AddEDE(code uint16, text string) {
+	ctx.ede = append(ctx.ede, &dns.EDNS0_EDE{InfoCode: code, ExtraText: text})
+}
+
+// EDE returns the extended dns errors attached by AddEDE.
+func (ctx *Context) EDE() []*dns.EDNS0_EDE {
+	return ctx.ede
+}
+
+// AddInfo attaches diagnostic info to this Context, depending on
+// GetAddInfoMode. In AddInfoEDE mode, it calls AddEDE. In AddInfoTXT mode,
+// a TXT record "<time>.<label>.paopaodns." with ttl will be appended to
+// the current response, if any.
+func (ctx *Context) AddInfo(code uint16, label string, ttl uint32, text string) {
+	switch GetAddInfoMode() {
+	case AddInfoEDE:
+		ctx.AddEDE(code, text)
+	case AddInfoTXT:
+		r := ctx.R()
+		if r == nil {
+			return
+		}
+		r.Extra = append(r.Extra, &dns.TXT{
+			Hdr: dns.RR_Header{
+				Name:   time.Now().Format("20060102150405.000000000") + "." + label + ".paopaodns.",
+				Rrtype: dns.TypeTXT,
+				Class:  dns.ClassINET,
+				Ttl:    ttl,
+			},
+			Txt: []string{text},
+		})
+	}
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package query_context
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestContext_AddEDE(t *testing.T) {
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	qCtx := NewContext(q)
+	qCtx.AddEDE(dns.ExtendedErrorCodeBlocked, "a")
+
+	c := qCtx.Copy()
+	c.AddEDE(dns.ExtendedErrorCodeFiltered, "b")
+	if len(qCtx.EDE()) != 1 || len(c.EDE()) != 2 {
+		t.Fatalf("copied ede should be independent, got %v, %v", qCtx.EDE(), c.EDE())
+	}
+	if e := c.EDE()[1]; e.InfoCode != dns.ExtendedErrorCodeFiltered || e.ExtraText != "b" {
+		t.Fatalf("unexpected ede %v", e)
+	}
+}
+
+func TestContext_ClientEDNS(t *testing.T) {
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	qCtx := NewContext(q)
+
+	// A plugin adds an OPT to the query.
+	q.SetEdns0(1232, false)
+	if qCtx.ClientEDNS() || qCtx.Copy().ClientEDNS() {
+		t.Fatal("client did not send EDNS0")
+	}
+
+	q = new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	q.SetEdns0(1232, false)
+	qCtx = NewContext(q)
+	q.Extra = nil
+	if !qCtx.ClientEDNS() || !qCtx.Copy().ClientEDNS() {
+		t.Fatal("client sent EDNS0")
+	}
+}
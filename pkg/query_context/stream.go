@@ -0,0 +1,47 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package query_context
+
+import (
+	"github.com/miekg/dns"
+)
+
+var streamWriterKey = RegKey()
+
+// StreamWriter writes a response message to the client immediately.
+// Messages written by a StreamWriter are sent before the final response
+// of the Context, which is still required. It is used by executables that
+// reply with multiple messages, e.g. zone transfers.
+type StreamWriter func(m *dns.Msg) error
+
+// SetStreamWriter is called by servers that support multi-message responses.
+func SetStreamWriter(qCtx *Context, w StreamWriter) {
+	qCtx.StoreValue(streamWriterKey, w)
+}
+
+// GetStreamWriter returns the StreamWriter of qCtx. ok is false if the
+// transport does not support multi-message responses.
+func GetStreamWriter(qCtx *Context) (w StreamWriter, ok bool) {
+	v, ok := qCtx.GetValue(streamWriterKey)
+	if !ok {
+		return nil, false
+	}
+	return v.(StreamWriter), true
+}
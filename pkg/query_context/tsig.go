@@ -0,0 +1,38 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package query_context
+
+var tsigKeyKey = RegKey()
+
+// SetTSIGKey records that the query was signed with key and the signature
+// was verified by the server.
+func SetTSIGKey(qCtx *Context, key string) {
+	qCtx.StoreValue(tsigKeyKey, key)
+}
+
+// GetTSIGKey returns the canonical name of the TSIG key that the query was
+// signed with.
+func GetTSIGKey(qCtx *Context) (string, bool) {
+	v, ok := qCtx.GetValue(tsigKeyKey)
+	if !ok {
+		return "", false
+	}
+	return v.(string), true
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package query_context
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var upstreamKey = RegKey()
+
+// SetUpstream records the name of the upstream that answered the query.
+func SetUpstream(qCtx *Context, name string) {
+	qCtx.StoreValue(upstreamKey, name)
+}
+
+func GetUpstream(qCtx *Context) (string, bool) {
+	v, ok := qCtx.GetValue(upstreamKey)
+	if !ok {
+		return "", false
+	}
+	return v.(string), true
+}
+
+var upstreamObserverKey = RegKey()
+
+// UpstreamObserver is called after a query q was exchanged with an upstream
+// addr. r is nil if the exchange failed. It may be called concurrently
+// from other goroutines, so it must not access the Context.
+type UpstreamObserver func(addr string, q, r *dns.Msg, queryTime, responseTime time.Time)
+
+// SetUpstreamObserver sets an UpstreamObserver that will be called by
+// executables that forward queries to upstreams.
+func SetUpstreamObserver(qCtx *Context, o UpstreamObserver) {
+	qCtx.StoreValue(upstreamObserverKey, o)
+}
+
+func GetUpstreamObserver(qCtx *Context) (UpstreamObserver, bool) {
+	v, ok := qCtx.GetValue(upstreamObserverKey)
+	if !ok {
+		return nil, false
+	}
+	return v.(UpstreamObserver), true
+}
@@ -21,10 +21,10 @@ package dns_handler
 
 import (
 	"context"
-	"os"
 	"time"
 
 	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/pkg/dnsutils"
 	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
 	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
 	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
@@ -32,8 +32,6 @@ import (
 	"go.uber.org/zap"
 )
 
-var addInfoEnabled bool
-
 const (
 	defaultQueryTimeout = time.Millisecond * 4500
 )
@@ -51,6 +49,9 @@ type Handler interface {
 	// If ServeDNS returns an error, caller considers that the error is associated
 	// with the downstream connection and will close the downstream connection
 	// immediately.
+	// Stream transports (TCP) set a query_context.StreamWriter in qCtx. Messages
+	// written by it are sent before the response, so a request can be answered
+	// with multiple messages (e.g. zone transfers). The response is the last one.
 	ServeDNS(ctx context.Context, qCtx *query_context.Context) error
 }
 
@@ -58,16 +59,23 @@ type EntryHandlerOpts struct {
 	// Logger is used for logging. Default is a noop logger.
 	Logger *zap.Logger
 
-	// Required.
+	// Required. Entry handles queries.
 	Entry sequence.Executable
 
+	// Update handles RFC 2136 UPDATE messages. If it is nil, UPDATE messages
+	// will get NOTIMP responses.
+	Update sequence.Executable
+
+	// Notify handles RFC 1996 NOTIFY messages. If it is nil, NOTIFY messages
+	// will get NOTIMP responses.
+	Notify sequence.Executable
+
 	// QueryTimeout limits the timeout value of each query.
 	// Default is defaultQueryTimeout.
 	QueryTimeout time.Duration
 }
 
 func (opts *EntryHandlerOpts) init() {
-	addInfoEnabled = os.Getenv("ADDINFO") == "yes"
 	if opts.Logger == nil {
 		opts.Logger = nopLogger
 	}
@@ -84,42 +92,67 @@ func NewEntryHandler(opts EntryHandlerOpts) *EntryHandler {
 }
 
 // ServeDNS implements Handler.
+// Messages are dispatched by their opcode. Messages with unsupported
+// opcodes will get NOTIMP responses.
 // If entry returns an error, a SERVFAIL response will be set.
-// If entry returns without a response, a REFUSED response will be set.
+// If entry returns without a response, a NXDOMAIN response will be set
+// for queries and a REFUSED response will be set for other messages.
+// Extended dns errors attached to qCtx will be added to the response if
+// the query has EDNS0.
 func (h *EntryHandler) ServeDNS(ctx context.Context, qCtx *query_context.Context) error {
+	var entry sequence.Executable
+	switch qCtx.Q().Opcode {
+	case dns.OpcodeQuery:
+		entry = h.opts.Entry
+	case dns.OpcodeUpdate:
+		entry = h.opts.Update
+	case dns.OpcodeNotify:
+		entry = h.opts.Notify
+	}
+	if entry == nil {
+		respMsg := new(dns.Msg)
+		respMsg.SetRcode(qCtx.Q(), dns.RcodeNotImplemented)
+		qCtx.SetResponse(respMsg)
+		return nil
+	}
+
 	ddl := time.Now().Add(h.opts.QueryTimeout)
 	ctx, cancel := context.WithDeadline(ctx, ddl)
 	defer cancel()
 
 	// exec entry
-	err := h.opts.Entry.Exec(ctx, qCtx)
+	err := entry.Exec(ctx, qCtx)
 	respMsg := qCtx.R()
 	if err != nil {
 		h.opts.Logger.Warn("entry err", qCtx.InfoField(), zap.Error(err))
 	}
 
+	isQuery := qCtx.Q().Opcode == dns.OpcodeQuery
+	if err == nil && respMsg == nil && !isQuery {
+		respMsg = new(dns.Msg)
+		respMsg.SetRcode(qCtx.Q(), dns.RcodeRefused)
+	}
 	if err == nil && respMsg == nil {
 		respMsg = new(dns.Msg)
 		respMsg.SetReply(qCtx.Q())
 		respMsg.Rcode = dns.RcodeNameError
-		if addInfoEnabled {
-			txtRecord := new(dns.TXT)
-			txtRecord.Hdr = dns.RR_Header{
-				Name:   time.Now().Format("20060102150405.000000000") + ".REFUSED.paopaodns.",
-				Rrtype: dns.TypeTXT,
-				Class:  dns.ClassINET,
-				Ttl:    0,
-			}
-			txtRecord.Txt = []string{"Process terminated due to no wanted answers , status: REFUSED"}
-			respMsg.Extra = append(respMsg.Extra, txtRecord)
-		}
+		qCtx.SetResponse(respMsg)
+		qCtx.AddInfo(dns.ExtendedErrorCodeOther, "REFUSED", 0, "Process terminated due to no wanted answers , status: REFUSED")
 	}
 	if err != nil {
 		respMsg = new(dns.Msg)
 		respMsg.SetReply(qCtx.Q())
 		respMsg.Rcode = dns.RcodeServerFailure
 	}
-	respMsg.RecursionAvailable = true
+	if isQuery {
+		respMsg.RecursionAvailable = true
+	}
+	if ede := qCtx.EDE(); len(ede) > 0 && qCtx.ClientEDNS() {
+		opt := dnsutils.UpgradeEDNS0(respMsg)
+		for _, e := range ede {
+			opt.Option = append(opt.Option, e)
+		}
+	}
 	qCtx.SetResponse(respMsg)
 	return nil
 }
@@ -28,6 +28,7 @@ import (
 	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
 	"github.com/IrineSistiana/mosdns/v5/pkg/server/dns_handler"
 	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/miekg/dns"
 	"go.uber.org/zap"
 	"net"
 	"time"
@@ -51,6 +52,8 @@ type TCPServerOpts struct {
 	DNSHandler  dns_handler.Handler // Required.
 	Logger      *zap.Logger
 	IdleTimeout time.Duration
+	// TSIGKeys are used to verify signed requests and sign their responses.
+	TSIGKeys TSIGKeys
 }
 
 func (opts *TCPServerOpts) init() {
@@ -58,15 +61,14 @@ func (opts *TCPServerOpts) init() {
 		opts.Logger = mlog.Nop()
 	}
 	utils.SetDefaultNum(&opts.IdleTimeout, defaultTCPIdleTimeout)
+	opts.TSIGKeys = opts.TSIGKeys.canonical()
 	return
 }
 
 // ServeTCP starts a server at l. It returns if l had an Accept() error.
-// It always returns a non-nil error.
+// It always returns a non-nil error. Connections that are being served are
+// not closed when it returns, so l can be handed over to another server.
 func (s *TCPServer) ServeTCP(l net.Listener) error {
-	// handle listener
-	listenerCtx, cancel := context.WithCancel(context.Background())
-	defer cancel()
 	for {
 		c, err := l.Accept()
 		if err != nil {
@@ -74,7 +76,7 @@ func (s *TCPServer) ServeTCP(l net.Listener) error {
 		}
 
 		// handle connection
-		tcpConnCtx, cancelConn := context.WithCancel(listenerCtx)
+		tcpConnCtx, cancelConn := context.WithCancel(context.Background())
 		go func() {
 			defer c.Close()
 			defer cancelConn()
@@ -95,30 +97,42 @@ func (s *TCPServer) ServeTCP(l net.Listener) error {
 				} else {
 					c.SetReadDeadline(time.Now().Add(idleTimeout))
 				}
-				req, _, err := dnsutils.ReadMsgFromTCP(c)
+				raw, _, err := dnsutils.ReadRawMsgFromTCP(c)
 				if err != nil {
 					return // read err, close the connection
 				}
+				req := new(dns.Msg)
+				err = req.Unpack(raw)
+				var ts *tsigState
+				if err == nil {
+					ts = s.opts.TSIGKeys.checkTSIG(req, raw)
+				}
+				pool.ReleaseBuf(raw)
+				if err != nil {
+					return // invalid msg, close the connection
+				}
 
 				// handle query
 				go func() {
 					qCtx := query_context.NewContext(req)
 					query_context.SetClientAddr(qCtx, &clientAddr)
-					if err := s.opts.DNSHandler.ServeDNS(tcpConnCtx, qCtx); err != nil {
-						s.opts.Logger.Warn("handler err", zap.Error(err))
-						c.Close()
-						return
+					r := ts.errResp(req)
+					if r == nil {
+						if ts != nil {
+							query_context.SetTSIGKey(qCtx, ts.key)
+						}
+						query_context.SetStreamWriter(qCtx, func(m *dns.Msg) error {
+							return writeResp(c, m, ts)
+						})
+						if err := s.opts.DNSHandler.ServeDNS(tcpConnCtx, qCtx); err != nil {
+							s.opts.Logger.Warn("handler err", zap.Error(err))
+							c.Close()
+							return
+						}
+						r = qCtx.R()
 					}
-					r := qCtx.R()
 
-					b, buf, err := pool.PackBuffer(r)
-					if err != nil {
-						s.opts.Logger.Error("failed to unpack handler's response", zap.Error(err), zap.Stringer("msg", r))
-						return
-					}
-					defer pool.ReleaseBuf(buf)
-
-					if _, err := dnsutils.WriteRawMsgToTCP(c, b); err != nil {
+					if err := writeResp(c, r, ts); err != nil {
 						s.opts.Logger.Warn("failed to write response", zap.Stringer("client", c.RemoteAddr()), zap.Error(err))
 						return
 					}
@@ -127,3 +141,16 @@ func (s *TCPServer) ServeTCP(l net.Listener) error {
 		}()
 	}
 }
+
+// writeResp packs, signs if ts is not nil, and writes r to c.
+func writeResp(c net.Conn, r *dns.Msg, ts *tsigState) error {
+	b, buf, err := packResp(r, ts)
+	if err != nil {
+		return fmt.Errorf("failed to pack response, %w", err)
+	}
+	if buf != nil {
+		defer pool.ReleaseBuf(buf)
+	}
+	_, err = dnsutils.WriteRawMsgToTCP(c, b)
+	return err
+}
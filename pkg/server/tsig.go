@@ -0,0 +1,128 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"errors"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
+	"github.com/miekg/dns"
+)
+
+const tsigFudge = 300
+
+// TSIGKeys maps TSIG key names to their base64 encoded secrets.
+type TSIGKeys map[string]string
+
+func (k TSIGKeys) canonical() TSIGKeys {
+	if len(k) == 0 {
+		return nil
+	}
+	c := make(TSIGKeys, len(k))
+	for name, secret := range k {
+		c[dns.CanonicalName(name)] = secret
+	}
+	return c
+}
+
+// tsigState is the TSIG state of a signed request.
+type tsigState struct {
+	key       string
+	algorithm string
+	secret    string
+	mac       string // the request mac, or the mac of the last response
+	err       uint16 // TSIG error, one of dns.RcodeBad*.
+
+	// For multi-message responses, subsequent messages are signed with
+	// timers only (RFC 8945 5.3.1).
+	signed bool
+}
+
+// checkTSIG verifies the TSIG of q. raw is the wire format of q.
+// It returns nil if q is not signed. The TSIG record will be removed
+// from q.
+func (k TSIGKeys) checkTSIG(q *dns.Msg, raw []byte) *tsigState {
+	t := q.IsTsig()
+	if t == nil {
+		return nil
+	}
+	q.Extra = q.Extra[:len(q.Extra)-1]
+
+	s := &tsigState{
+		key:       dns.CanonicalName(t.Hdr.Name),
+		algorithm: t.Algorithm,
+		mac:       t.MAC,
+	}
+	secret, ok := k[s.key]
+	if !ok {
+		s.err = dns.RcodeBadKey
+		return s
+	}
+	s.secret = secret
+	if err := dns.TsigVerify(raw, secret, "", false); err != nil {
+		if errors.Is(err, dns.ErrTime) {
+			s.err = dns.RcodeBadTime
+		} else {
+			s.err = dns.RcodeBadSig
+		}
+	}
+	return s
+}
+
+// errResp returns a NOTAUTH response if the request failed the verification.
+// s can be nil.
+func (s *tsigState) errResp(q *dns.Msg) *dns.Msg {
+	if s == nil || s.err == 0 {
+		return nil
+	}
+	r := new(dns.Msg)
+	r.SetRcode(q, dns.RcodeNotAuth)
+	return r
+}
+
+// sign packs and signs r. Responses of requests with a bad key or a bad
+// signature will not be signed but still carry the TSIG error.
+// sign must not be called concurrently.
+func (s *tsigState) sign(r *dns.Msg) ([]byte, error) {
+	r.SetTsig(s.key, s.algorithm, tsigFudge, time.Now().Unix())
+	r.Extra[len(r.Extra)-1].(*dns.TSIG).Error = s.err
+	mac := s.mac
+	if s.err == dns.RcodeBadKey || s.err == dns.RcodeBadSig {
+		mac = ""
+	}
+	b, newMac, err := dns.TsigGenerate(r, s.secret, mac, s.signed)
+	if err != nil {
+		return nil, err
+	}
+	s.mac = newMac
+	s.signed = true
+	return b, nil
+}
+
+// packResp packs r, and signs it if ts is not nil. The returned buf, if not
+// nil, should be released by pool.ReleaseBuf.
+func packResp(r *dns.Msg, ts *tsigState) (b, buf []byte, err error) {
+	if ts != nil {
+		b, err = ts.sign(r)
+		return b, nil, err
+	}
+	return pool.PackBuffer(r)
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+)
+
+type tsigKeyEcho struct{}
+
+// ServeDNS replies the name of the verified TSIG key in a TXT record.
+func (tsigKeyEcho) ServeDNS(_ context.Context, qCtx *query_context.Context) error {
+	r := new(dns.Msg)
+	r.SetReply(qCtx.Q())
+	key, _ := query_context.GetTSIGKey(qCtx)
+	r.Answer = append(r.Answer, &dns.TXT{
+		Hdr: dns.RR_Header{Name: qCtx.Q().Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET},
+		Txt: []string{key},
+	})
+	qCtx.SetResponse(r)
+	return nil
+}
+
+func TestTSIG(t *testing.T) {
+	const secret = "c2VjcmV0LXNlY3JldC1zZWNyZXQ="
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	s := NewUDPServer(UDPServerOpts{DNSHandler: tsigKeyEcho{}, TSIGKeys: TSIGKeys{"Key": secret}})
+	go s.ServeUDP(c)
+
+	exchange := func(key, secret string) (*dns.Msg, error) {
+		q := new(dns.Msg)
+		q.SetQuestion("example.", dns.TypeTXT)
+		client := new(dns.Client)
+		if len(key) > 0 {
+			q.SetTsig(key, dns.HmacSHA256, 300, 0)
+			client.TsigSecret = map[string]string{key: secret}
+		}
+		r, _, err := client.Exchange(q, c.LocalAddr().String())
+		return r, err
+	}
+
+	r, err := exchange("key.", secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Rcode != dns.RcodeSuccess || r.IsTsig() == nil || r.Answer[0].(*dns.TXT).Txt[0] != "key." {
+		t.Fatalf("unexpected response of a signed query: %v", r)
+	}
+
+	r, err = exchange("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.IsTsig() != nil || r.Answer[0].(*dns.TXT).Txt[0] != "" {
+		t.Fatalf("unexpected response of an unsigned query: %v", r)
+	}
+
+	for _, key := range []struct{ name, secret string }{{"key.", "d3Jvbmc="}, {"unknown.", secret}} {
+		r, _ = exchange(key.name, key.secret)
+		if r == nil || r.Rcode != dns.RcodeNotAuth || len(r.Answer) != 0 {
+			t.Fatalf("key %s: want NOTAUTH, got %v", key.name, r)
+		}
+	}
+}
@@ -44,12 +44,15 @@ func NewUDPServer(opts UDPServerOpts) *UDPServer {
 type UDPServerOpts struct {
 	DNSHandler dns_handler.Handler // Required.
 	Logger     *zap.Logger
+	// TSIGKeys are used to verify signed requests and sign their responses.
+	TSIGKeys TSIGKeys
 }
 
 func (opts *UDPServerOpts) init() {
 	if opts.Logger == nil {
 		opts.Logger = mlog.Nop()
 	}
+	opts.TSIGKeys = opts.TSIGKeys.canonical()
 	return
 }
 
@@ -60,11 +63,9 @@ type cmcUDPConn interface {
 }
 
 // ServeUDP starts a server at c. It returns if c had a read error.
-// It always returns a non-nil error.
+// It always returns a non-nil error. Queries that are being served are not
+// canceled when it returns, so c can be handed over to another server.
 func (s *UDPServer) ServeUDP(c net.PacketConn) error {
-	listenerCtx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
 	rb := pool.GetBuf(dns.MaxMsgSize)
 	defer pool.ReleaseBuf(rb)
 
@@ -92,24 +93,33 @@ func (s *UDPServer) ServeUDP(c net.PacketConn) error {
 			s.opts.Logger.Warn("invalid msg", zap.Error(err), zap.Binary("msg", rb[:n]), zap.Stringer("from", remoteAddr))
 			continue
 		}
+		ts := s.opts.TSIGKeys.checkTSIG(q, rb[:n])
 
 		// handle query
 		go func() {
 			qCtx := query_context.NewContext(q)
 			query_context.SetClientAddr(qCtx, &clientAddr)
-			if err := s.opts.DNSHandler.ServeDNS(listenerCtx, qCtx); err != nil {
-				s.opts.Logger.Warn("handler err", zap.Error(err))
-				return
+			r := ts.errResp(q)
+			if r == nil {
+				if ts != nil {
+					query_context.SetTSIGKey(qCtx, ts.key)
+				}
+				if err := s.opts.DNSHandler.ServeDNS(context.Background(), qCtx); err != nil {
+					s.opts.Logger.Warn("handler err", zap.Error(err))
+					return
+				}
+				r = qCtx.R()
 			}
-			r := qCtx.R()
 			if r != nil {
 				r.Truncate(getUDPSize(q))
-				b, buf, err := pool.PackBuffer(r)
+				b, buf, err := packResp(r, ts)
 				if err != nil {
 					s.opts.Logger.Error("failed to unpack handler's response", zap.Error(err), zap.Stringer("msg", r))
 					return
 				}
-				defer pool.ReleaseBuf(buf)
+				if buf != nil {
+					defer pool.ReleaseBuf(buf)
+				}
 				if _, err := cmc.writeTo(b, localAddr, ifIndex, remoteAddr); err != nil {
 					s.opts.Logger.Warn("failed to write response", zap.Stringer("client", remoteAddr), zap.Error(err))
 				}
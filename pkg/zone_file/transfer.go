@@ -0,0 +1,157 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package zone_file
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// maxTransferMsgSize is the maximum size of records, uncompressed, in one
+// zone transfer message.
+const maxTransferMsgSize = 16 * 1024
+
+// Clone returns a deep copy of z.
+func (z *Zone) Clone() *Zone {
+	c := NewZone(z.origin)
+	for _, rr := range z.Records() {
+		c.insert(dns.Copy(rr))
+	}
+	return c
+}
+
+// Transfer replies q, an AXFR or IXFR query, with the whole zone. IXFR is
+// answered as AXFR (RFC 1995 4), unless the client is up to date, in which
+// case only the SOA is sent. Messages are passed to w in order.
+func (z *Zone) Transfer(q *dns.Msg, w func(m *dns.Msg) error) error {
+	rrs := z.Records()
+	if len(rrs) == 0 || rrs[0].Header().Rrtype != dns.TypeSOA {
+		return errors.New("zone has no SOA")
+	}
+	soa := rrs[0].(*dns.SOA)
+
+	newMsg := func() *dns.Msg {
+		m := new(dns.Msg)
+		m.SetReply(q)
+		m.Authoritative = true
+		return m
+	}
+
+	if q.Question[0].Qtype == dns.TypeIXFR && len(q.Ns) > 0 {
+		if clientSOA, ok := q.Ns[0].(*dns.SOA); ok && !SerialGreater(soa.Serial, clientSOA.Serial) {
+			m := newMsg()
+			m.Answer = []dns.RR{soa}
+			return w(m)
+		}
+	}
+
+	rrs = append(rrs, soa)
+	m := newMsg()
+	size := 0
+	for _, rr := range rrs {
+		l := dns.Len(rr)
+		if size+l > maxTransferMsgSize && len(m.Answer) > 0 {
+			if err := w(m); err != nil {
+				return err
+			}
+			m = newMsg()
+			size = 0
+		}
+		m.Answer = append(m.Answer, rr)
+		size += l
+	}
+	return w(m)
+}
+
+// ApplyTransfer applies the records received from an AXFR or IXFR transfer
+// and returns the new zone. z is not modified. It returns z if z is
+// already up to date.
+func (z *Zone) ApplyTransfer(rrs []dns.RR) (*Zone, error) {
+	if len(rrs) == 0 {
+		return nil, errors.New("empty transfer")
+	}
+	newSOA, ok := rrs[0].(*dns.SOA)
+	if !ok {
+		return nil, errors.New("transfer does not start with a SOA")
+	}
+	if old := z.SOA(); old != nil && !SerialGreater(newSOA.Serial, old.Serial) {
+		return z, nil
+	}
+	if len(rrs) < 2 {
+		return nil, errors.New("transfer is truncated")
+	}
+	last, ok := rrs[len(rrs)-1].(*dns.SOA)
+	if !ok || last.Serial != newSOA.Serial {
+		return nil, errors.New("transfer does not end with the SOA")
+	}
+
+	// IXFR with differences, RFC 1995 4.
+	if _, isSOA := rrs[1].(*dns.SOA); isSOA && len(rrs) > 2 {
+		return z.applyIncremental(rrs)
+	}
+
+	// AXFR, or IXFR with the whole zone.
+	c := NewZone(z.origin)
+	for _, rr := range rrs[:len(rrs)-1] {
+		if _, err := c.insert(rr); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// applyIncremental applies IXFR differences to a copy of z.
+func (z *Zone) applyIncremental(rrs []dns.RR) (*Zone, error) {
+	c := z.Clone()
+	isSOA := func(rr dns.RR) bool { return rr.Header().Rrtype == dns.TypeSOA }
+	i := 1
+	end := len(rrs) - 1
+	for i < end {
+		fromSOA := rrs[i].(*dns.SOA)
+		cur := c.soa()
+		if cur == nil {
+			return nil, errors.New("incremental transfer to an empty zone")
+		}
+		if cur.Serial != fromSOA.Serial {
+			return nil, fmt.Errorf("difference sequence starts from serial %d, but current serial is %d", fromSOA.Serial, cur.Serial)
+		}
+		i++
+		for ; i < end && !isSOA(rrs[i]); i++ {
+			target := rrs[i]
+			c.delete(dns.CanonicalName(target.Header().Name), target.Header().Rrtype, func(rr dns.RR) bool {
+				return dns.IsDuplicate(rr, target)
+			})
+		}
+		if i >= end {
+			return nil, errors.New("difference sequence has no additions")
+		}
+		toSOA := rrs[i]
+		i++
+		for ; i < end && !isSOA(rrs[i]); i++ {
+			if _, err := c.insert(rrs[i]); err != nil {
+				return nil, err
+			}
+		}
+		c.insert(toSOA)
+	}
+	return c, nil
+}
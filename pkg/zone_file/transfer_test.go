@@ -0,0 +1,102 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package zone_file
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestZone_Transfer(t *testing.T) {
+	z := mustParse(t)
+	q := new(dns.Msg)
+	q.SetAxfr("example.com.")
+
+	var rrs []dns.RR
+	if err := z.Transfer(q, func(m *dns.Msg) error {
+		rrs = append(rrs, m.Answer...)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(rrs) != len(z.Records())+1 {
+		t.Fatalf("want %d records, got %d", len(z.Records())+1, len(rrs))
+	}
+
+	// Full transfer to an empty zone.
+	c, err := NewZone("example.com.").ApplyTransfer(rrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Records()) != len(z.Records()) {
+		t.Fatalf("want %d records after axfr, got %d", len(z.Records()), len(c.Records()))
+	}
+
+	// Up to date IXFR.
+	q.SetIxfr("example.com.", z.SOA().Serial, "", "")
+	var msgs []*dns.Msg
+	z.Transfer(q, func(m *dns.Msg) error {
+		msgs = append(msgs, m)
+		return nil
+	})
+	if len(msgs) != 1 || len(msgs[0].Answer) != 1 {
+		t.Fatalf("up to date ixfr should only have the soa, got %v", msgs)
+	}
+	if n, err := z.ApplyTransfer(msgs[0].Answer); err != nil || n != z {
+		t.Fatalf("up to date transfer should not change the zone, %v", err)
+	}
+}
+
+func TestZone_ApplyTransfer_Incremental(t *testing.T) {
+	z := mustParse(t)
+	oldSOA := dns.Copy(z.SOA()).(*dns.SOA)
+	newSOA := dns.Copy(oldSOA).(*dns.SOA)
+	newSOA.Serial++
+
+	rrs := []dns.RR{
+		newSOA,
+		oldSOA,
+		mustRR(t, "www.example.com. 3600 IN A 192.0.2.1"),
+		newSOA,
+		mustRR(t, "www.example.com. 3600 IN A 192.0.2.10"),
+		newSOA,
+	}
+	c, err := z.ApplyTransfer(rrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.SOA().Serial; got != newSOA.Serial {
+		t.Fatalf("serial = %d, want %d", got, newSOA.Serial)
+	}
+	r := query(c, "www.example.com.", dns.TypeA)
+	if len(r.Answer) != 1 || r.Answer[0].(*dns.A).A.String() != "192.0.2.10" {
+		t.Fatalf("unexpected answer after ixfr: %v", r.Answer)
+	}
+	if r := query(z, "www.example.com.", dns.TypeA); r.Answer[0].(*dns.A).A.String() != "192.0.2.1" {
+		t.Fatal("original zone should not be modified")
+	}
+
+	// Differences that do not start from the current serial.
+	rrs[1] = newSOA
+	if _, err := z.ApplyTransfer(rrs); err == nil {
+		t.Fatal("want an error")
+	}
+}
@@ -0,0 +1,268 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package zone_file
+
+import (
+	"github.com/miekg/dns"
+)
+
+type rrsetKey struct {
+	name   string
+	rrtype uint16
+}
+
+// Update applies the RFC 2136 UPDATE message m to z. Prerequisites are
+// checked and the update section is applied as a whole under the zone lock.
+// It returns the rcode of the update and whether z was changed. If z was
+// changed and m did not update the SOA itself, the SOA serial is increased.
+func (z *Zone) Update(m *dns.Msg) (rcode int, changed bool) {
+	if len(m.Question) != 1 || m.Question[0].Qtype != dns.TypeSOA {
+		return dns.RcodeFormatError, false
+	}
+	if dns.CanonicalName(m.Question[0].Name) != z.origin {
+		return dns.RcodeNotAuth, false
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if rcode := z.checkPrerequisites(m.Answer); rcode != dns.RcodeSuccess {
+		return rcode, false
+	}
+	if rcode := z.prescan(m.Ns); rcode != dns.RcodeSuccess {
+		return rcode, false
+	}
+
+	soaUpdated := false
+	for _, rr := range m.Ns {
+		c, soa := z.apply(rr)
+		changed = changed || c
+		soaUpdated = soaUpdated || soa
+	}
+	if changed && !soaUpdated {
+		if soa := z.soa(); soa != nil {
+			c := dns.Copy(soa).(*dns.SOA)
+			c.Serial++
+			z.nodes[z.origin][dns.TypeSOA][0] = c
+		}
+	}
+	return dns.RcodeSuccess, changed
+}
+
+// checkPrerequisites checks the prerequisite section. See RFC 2136 3.2.
+func (z *Zone) checkPrerequisites(rrs []dns.RR) int {
+	var sets map[rrsetKey][]dns.RR
+	for _, rr := range rrs {
+		h := rr.Header()
+		name := dns.CanonicalName(h.Name)
+		if h.Ttl != 0 {
+			return dns.RcodeFormatError
+		}
+		if !dns.IsSubDomain(z.origin, name) {
+			return dns.RcodeNotZone
+		}
+		node := z.nodes[name]
+		switch h.Class {
+		case dns.ClassANY:
+			if h.Rdlength != 0 {
+				return dns.RcodeFormatError
+			}
+			if h.Rrtype == dns.TypeANY {
+				if len(node) == 0 {
+					return dns.RcodeNameError
+				}
+			} else if len(node[h.Rrtype]) == 0 {
+				return dns.RcodeNXRrset
+			}
+		case dns.ClassNONE:
+			if h.Rdlength != 0 {
+				return dns.RcodeFormatError
+			}
+			if h.Rrtype == dns.TypeANY {
+				if len(node) > 0 {
+					return dns.RcodeYXDomain
+				}
+			} else if len(node[h.Rrtype]) > 0 {
+				return dns.RcodeYXRrset
+			}
+		case dns.ClassINET:
+			if sets == nil {
+				sets = make(map[rrsetKey][]dns.RR)
+			}
+			k := rrsetKey{name: name, rrtype: h.Rrtype}
+			sets[k] = appendUnique(sets[k], rr)
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+
+	for k, set := range sets {
+		if !equalRRset(set, z.nodes[k.name][k.rrtype]) {
+			return dns.RcodeNXRrset
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// prescan checks the update section. See RFC 2136 3.4.1.
+func (z *Zone) prescan(rrs []dns.RR) int {
+	for _, rr := range rrs {
+		h := rr.Header()
+		if !dns.IsSubDomain(z.origin, dns.CanonicalName(h.Name)) {
+			return dns.RcodeNotZone
+		}
+		switch h.Class {
+		case dns.ClassINET:
+			if isMetaType(h.Rrtype) {
+				return dns.RcodeFormatError
+			}
+		case dns.ClassANY:
+			if h.Ttl != 0 || h.Rdlength != 0 || (h.Rrtype != dns.TypeANY && isMetaType(h.Rrtype)) {
+				return dns.RcodeFormatError
+			}
+		case dns.ClassNONE:
+			if h.Ttl != 0 || isMetaType(h.Rrtype) {
+				return dns.RcodeFormatError
+			}
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// apply applies one record of the update section. See RFC 2136 3.4.2.
+func (z *Zone) apply(rr dns.RR) (changed, soaUpdated bool) {
+	h := rr.Header()
+	name := dns.CanonicalName(h.Name)
+	node := z.nodes[name]
+	isApex := name == z.origin
+
+	switch h.Class {
+	case dns.ClassINET:
+		switch h.Rrtype {
+		case dns.TypeSOA:
+			old := z.soa()
+			if !isApex || (old != nil && !SerialGreater(rr.(*dns.SOA).Serial, old.Serial)) {
+				return false, false
+			}
+			z.insert(dns.Copy(rr))
+			return true, true
+		case dns.TypeCNAME:
+			for t := range node {
+				if t != dns.TypeCNAME && !isDNSSECType(t) {
+					return false, false
+				}
+			}
+			if len(node[dns.TypeCNAME]) > 0 {
+				if dns.IsDuplicate(node[dns.TypeCNAME][0], rr) {
+					return false, false
+				}
+				z.delete(name, dns.TypeCNAME, nil)
+			}
+		default:
+			if len(node[dns.TypeCNAME]) > 0 && !isDNSSECType(h.Rrtype) {
+				return false, false
+			}
+		}
+		added, _ := z.insert(dns.Copy(rr))
+		return added, false
+
+	case dns.ClassANY:
+		if h.Rrtype == dns.TypeANY {
+			if !isApex {
+				return z.delete(name, dns.TypeANY, nil) > 0, false
+			}
+			n := 0
+			for t := range node {
+				if t != dns.TypeSOA && t != dns.TypeNS {
+					n += z.delete(name, t, nil)
+				}
+			}
+			return n > 0, false
+		}
+		if isApex && (h.Rrtype == dns.TypeSOA || h.Rrtype == dns.TypeNS) {
+			return false, false
+		}
+		return z.delete(name, h.Rrtype, nil) > 0, false
+
+	case dns.ClassNONE:
+		if h.Rrtype == dns.TypeSOA {
+			return false, false
+		}
+		target := dns.Copy(rr)
+		target.Header().Class = dns.ClassINET
+		if isApex && h.Rrtype == dns.TypeNS {
+			rrs := node[dns.TypeNS]
+			if len(rrs) == 1 && dns.IsDuplicate(rrs[0], target) {
+				return false, false // Never delete the last NS of the apex.
+			}
+		}
+		return z.delete(name, h.Rrtype, func(rr dns.RR) bool { return dns.IsDuplicate(rr, target) }) > 0, false
+	}
+	return false, false
+}
+
+func isMetaType(t uint16) bool {
+	switch t {
+	case dns.TypeANY, dns.TypeAXFR, dns.TypeIXFR, dns.TypeMAILA, dns.TypeMAILB,
+		dns.TypeOPT, dns.TypeTSIG, dns.TypeTKEY:
+		return true
+	}
+	return false
+}
+
+func isDNSSECType(t uint16) bool {
+	return t == dns.TypeRRSIG || t == dns.TypeNSEC || t == dns.TypeNSEC3
+}
+
+// SerialGreater reports whether a is greater than b in RFC 1982 serial arithmetic.
+func SerialGreater(a, b uint32) bool {
+	return a != b && int32(a-b) > 0
+}
+
+func appendUnique(s []dns.RR, rr dns.RR) []dns.RR {
+	for _, e := range s {
+		if dns.IsDuplicate(e, rr) {
+			return s
+		}
+	}
+	return append(s, rr)
+}
+
+// equalRRset reports whether a and b contain the same records, TTLs are ignored.
+// a and b must not contain duplicated records.
+func equalRRset(a, b []dns.RR) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, ra := range a {
+		found := false
+		for _, rb := range b {
+			if dns.IsDuplicate(ra, rb) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package zone_file
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+// wire packs and unpacks m, so rdlength of records are set as they are
+// received from the network.
+func wire(t *testing.T, m *dns.Msg) *dns.Msg {
+	t.Helper()
+	b, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := new(dns.Msg)
+	if err := u.Unpack(b); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestZone_Update(t *testing.T) {
+	z := mustParse(t)
+	serial := z.SOA().Serial
+
+	// Add a record.
+	m := new(dns.Msg)
+	m.SetUpdate("example.com.")
+	m.NameNotUsed([]dns.RR{mustRR(t, "laptop.example.com. A 0.0.0.0")})
+	m.Insert([]dns.RR{mustRR(t, "laptop.example.com. 300 IN A 192.0.2.100")})
+	rcode, changed := z.Update(wire(t, m))
+	if rcode != dns.RcodeSuccess || !changed {
+		t.Fatalf("add: rcode %d, changed %v", rcode, changed)
+	}
+	if r := query(z, "laptop.example.com.", dns.TypeA); len(r.Answer) != 1 {
+		t.Fatalf("added record not found: %v", r)
+	}
+	if got := z.SOA().Serial; got != serial+1 {
+		t.Fatalf("serial = %d, want %d", got, serial+1)
+	}
+
+	// The same update fails now, because the name is in use.
+	if rcode, _ := z.Update(wire(t, m)); rcode != dns.RcodeYXDomain {
+		t.Fatalf("want YXDOMAIN, got %d", rcode)
+	}
+
+	// Value dependent prerequisite.
+	m = new(dns.Msg)
+	m.SetUpdate("example.com.")
+	m.Used([]dns.RR{mustRR(t, "laptop.example.com. IN A 192.0.2.101")})
+	m.RemoveRRset([]dns.RR{mustRR(t, "laptop.example.com. A 0.0.0.0")})
+	if rcode, _ := z.Update(wire(t, m)); rcode != dns.RcodeNXRrset {
+		t.Fatalf("want NXRRSET, got %d", rcode)
+	}
+	m.Answer[0].(*dns.A).A = mustRR(t, "x. A 192.0.2.100").(*dns.A).A
+	if rcode, changed := z.Update(wire(t, m)); rcode != dns.RcodeSuccess || !changed {
+		t.Fatalf("delete rrset: rcode %d, changed %v", rcode, changed)
+	}
+	if r := query(z, "laptop.example.com.", dns.TypeA); r.Rcode != dns.RcodeNameError {
+		t.Fatalf("want NXDOMAIN after delete, got %v", r)
+	}
+
+	// Delete a single record, and the apex NS and SOA are protected.
+	m = new(dns.Msg)
+	m.SetUpdate("example.com.")
+	m.Remove([]dns.RR{mustRR(t, "www.example.com. IN A 192.0.2.1"), mustRR(t, "example.com. IN NS ns1.example.com.")})
+	m.RemoveName([]dns.RR{mustRR(t, "example.com. A 0.0.0.0")})
+	if rcode, changed := z.Update(wire(t, m)); rcode != dns.RcodeSuccess || !changed {
+		t.Fatalf("delete: rcode %d, changed %v", rcode, changed)
+	}
+	if r := query(z, "www.example.com.", dns.TypeA); r.Rcode != dns.RcodeNameError {
+		t.Fatalf("want NXDOMAIN after delete, got %v", r)
+	}
+	if len(z.Lookup("example.com.", dns.TypeNS)) != 1 || z.SOA() == nil {
+		t.Fatal("apex NS and SOA should not be deleted")
+	}
+
+	// CNAME and other data cannot coexist.
+	m = new(dns.Msg)
+	m.SetUpdate("example.com.")
+	m.Insert([]dns.RR{mustRR(t, "alias.example.com. 300 IN A 192.0.2.5")})
+	if _, changed := z.Update(wire(t, m)); changed {
+		t.Fatal("A record should not be added to a CNAME")
+	}
+
+	// Out of zone.
+	m = new(dns.Msg)
+	m.SetUpdate("example.com.")
+	m.Insert([]dns.RR{mustRR(t, "www.example.net. 300 IN A 192.0.2.5")})
+	if rcode, _ := z.Update(wire(t, m)); rcode != dns.RcodeNotZone {
+		t.Fatalf("want NOTZONE, got %d", rcode)
+	}
+	m = new(dns.Msg)
+	m.SetUpdate("example.net.")
+	if rcode, _ := z.Update(wire(t, m)); rcode != dns.RcodeNotAuth {
+		t.Fatalf("want NOTAUTH, got %d", rcode)
+	}
+}
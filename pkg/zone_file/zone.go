@@ -0,0 +1,393 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package zone_file
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+const maxCNAMEChase = 8
+
+// Zone is an authoritative zone. It is safe for concurrent use.
+type Zone struct {
+	origin string // canonical name
+
+	mu    sync.RWMutex
+	nodes map[string]map[uint16][]dns.RR // canonical owner -> type -> rrset
+	names map[string]int                 // canonical name -> number of rr at or below it
+}
+
+// NewZone creates an empty zone of origin.
+func NewZone(origin string) *Zone {
+	return &Zone{
+		origin: dns.CanonicalName(origin),
+		nodes:  make(map[string]map[uint16][]dns.RR),
+		names:  make(map[string]int),
+	}
+}
+
+// ParseZone parses a zone from r. If origin is empty, the owner of the
+// first SOA record will be used. file is used in error messages and to
+// resolve $INCLUDE.
+func ParseZone(r io.Reader, origin, file string) (*Zone, error) {
+	parser := dns.NewZoneParser(r, dns.Fqdn(origin), file)
+	parser.SetDefaultTTL(3600)
+	parser.SetIncludeAllowed(true)
+
+	var z *Zone
+	if len(origin) > 0 {
+		z = NewZone(origin)
+	}
+	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
+		if z == nil {
+			if rr.Header().Rrtype != dns.TypeSOA {
+				return nil, errors.New("the first record must be a SOA if origin is not specified")
+			}
+			z = NewZone(rr.Header().Name)
+		}
+		if err := z.Insert(rr); err != nil {
+			return nil, err
+		}
+	}
+	if err := parser.Err(); err != nil {
+		return nil, err
+	}
+	if z == nil {
+		return nil, errors.New("empty zone")
+	}
+	if z.SOA() == nil {
+		return nil, fmt.Errorf("zone %s has no SOA record", z.origin)
+	}
+	return z, nil
+}
+
+// LoadZoneFile parses a zone from a file. See ParseZone.
+func LoadZoneFile(file, origin string) (*Zone, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseZone(f, origin, file)
+}
+
+// Origin returns the canonical origin of z.
+func (z *Zone) Origin() string {
+	return z.origin
+}
+
+// SOA returns the SOA record of z, or nil if z does not have one.
+func (z *Zone) SOA() *dns.SOA {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	return z.soa()
+}
+
+func (z *Zone) soa() *dns.SOA {
+	if rrs := z.nodes[z.origin][dns.TypeSOA]; len(rrs) > 0 {
+		return rrs[0].(*dns.SOA)
+	}
+	return nil
+}
+
+// Insert inserts rr into z. Duplicated records are ignored.
+// A SOA record replaces the old one.
+func (z *Zone) Insert(rr dns.RR) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	_, err := z.insert(rr)
+	return err
+}
+
+func (z *Zone) insert(rr dns.RR) (bool, error) {
+	h := rr.Header()
+	name := dns.CanonicalName(h.Name)
+	if !dns.IsSubDomain(z.origin, name) {
+		return false, fmt.Errorf("record %s is out of zone %s", h.Name, z.origin)
+	}
+	if h.Rrtype == dns.TypeSOA && name != z.origin {
+		return false, fmt.Errorf("SOA record %s is not at the zone apex", h.Name)
+	}
+
+	node := z.nodes[name]
+	if node == nil {
+		node = make(map[uint16][]dns.RR)
+		z.nodes[name] = node
+	}
+	if h.Rrtype == dns.TypeSOA && len(node[dns.TypeSOA]) > 0 {
+		node[dns.TypeSOA][0] = rr
+		return true, nil
+	}
+	for _, old := range node[h.Rrtype] {
+		if dns.IsDuplicate(old, rr) {
+			return false, nil
+		}
+	}
+	node[h.Rrtype] = append(node[h.Rrtype], rr)
+	z.addName(name, 1)
+	return true, nil
+}
+
+// Delete deletes records of name and rrtype that match from z. A nil match
+// matches all records. If rrtype is dns.TypeANY, all rrsets of the name
+// will be checked. It returns the number of deleted records.
+func (z *Zone) Delete(name string, rrtype uint16, match func(rr dns.RR) bool) int {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.delete(dns.CanonicalName(name), rrtype, match)
+}
+
+func (z *Zone) delete(name string, rrtype uint16, match func(rr dns.RR) bool) int {
+	node := z.nodes[name]
+	if node == nil {
+		return 0
+	}
+	n := 0
+	for t, rrs := range node {
+		if rrtype != dns.TypeANY && t != rrtype {
+			continue
+		}
+		kept := make([]dns.RR, 0, len(rrs))
+		for _, rr := range rrs {
+			if match == nil || match(rr) {
+				n++
+				continue
+			}
+			kept = append(kept, rr)
+		}
+		if len(kept) == 0 {
+			delete(node, t)
+		} else {
+			node[t] = kept
+		}
+	}
+	if len(node) == 0 {
+		delete(z.nodes, name)
+	}
+	z.addName(name, -n)
+	return n
+}
+
+// addName adds delta to the counters of name and its ancestors in z.
+func (z *Zone) addName(name string, delta int) {
+	if delta == 0 {
+		return
+	}
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		s := name[off:]
+		if c := z.names[s] + delta; c > 0 {
+			z.names[s] = c
+		} else {
+			delete(z.names, s)
+		}
+		if s == z.origin {
+			return
+		}
+	}
+}
+
+// Records returns all records of z. The SOA record is the first one.
+// Returned records are shared with z and must not be modified.
+func (z *Zone) Records() []dns.RR {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	names := make([]string, 0, len(z.nodes))
+	for name := range z.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var s []dns.RR
+	if soa := z.soa(); soa != nil {
+		s = append(s, soa)
+	}
+	for _, name := range names {
+		node := z.nodes[name]
+		types := make([]int, 0, len(node))
+		for t := range node {
+			types = append(types, int(t))
+		}
+		sort.Ints(types)
+		for _, t := range types {
+			if uint16(t) == dns.TypeSOA {
+				continue
+			}
+			s = append(s, node[uint16(t)]...)
+		}
+	}
+	return s
+}
+
+// WriteTo writes all records of z to w in zone file format.
+func (z *Zone) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for _, rr := range z.Records() {
+		nw, err := io.WriteString(w, rr.String()+"\n")
+		n += int64(nw)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Lookup returns the rrset of name and type.
+// Returned records are shared with z and must not be modified.
+func (z *Zone) Lookup(name string, rrtype uint16) []dns.RR {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	return z.nodes[dns.CanonicalName(name)][rrtype]
+}
+
+// Reply returns an authoritative response to q. It returns nil if q
+// is not a query for z.
+func (z *Zone) Reply(q *dns.Msg) *dns.Msg {
+	if len(q.Question) != 1 {
+		return nil
+	}
+	question := q.Question[0]
+	qname := dns.CanonicalName(question.Name)
+	if !dns.IsSubDomain(z.origin, qname) {
+		return nil
+	}
+	if question.Qclass != dns.ClassINET && question.Qclass != dns.ClassANY {
+		return nil
+	}
+
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	r := new(dns.Msg)
+	r.SetReply(q)
+	z.answer(r, qname, question.Qtype, 0)
+	return r
+}
+
+func (z *Zone) answer(r *dns.Msg, qname string, qtype uint16, depth int) {
+	if cut := z.findCut(qname, qtype); len(cut) > 0 {
+		z.referral(r, cut)
+		return
+	}
+	r.Authoritative = true
+
+	node, ok := z.nodes[qname]
+	owner := qname
+	if !ok && z.names[qname] == 0 {
+		node, ok = z.wildcard(qname)
+		if !ok {
+			r.Rcode = dns.RcodeNameError
+			z.addNegativeSOA(r)
+			return
+		}
+	}
+
+	switch {
+	case qtype == dns.TypeANY && len(node) > 0:
+		for _, rrs := range node {
+			r.Answer = append(r.Answer, withOwner(rrs, owner)...)
+		}
+	case len(node[qtype]) > 0:
+		r.Answer = append(r.Answer, withOwner(node[qtype], owner)...)
+	case len(node[dns.TypeCNAME]) > 0:
+		cname := withOwner(node[dns.TypeCNAME], owner)
+		r.Answer = append(r.Answer, cname...)
+		target := dns.CanonicalName(cname[0].(*dns.CNAME).Target)
+		if depth < maxCNAMEChase && dns.IsSubDomain(z.origin, target) {
+			z.answer(r, target, qtype, depth+1)
+		}
+	default: // NODATA, empty non-terminal included.
+		z.addNegativeSOA(r)
+	}
+}
+
+// findCut returns the delegation point between the zone apex and qname.
+func (z *Zone) findCut(qname string, qtype uint16) string {
+	idx := dns.Split(qname)
+	apexLabels := dns.CountLabel(z.origin)
+	for i := len(idx) - apexLabels - 1; i >= 0; i-- {
+		name := qname[idx[i]:]
+		if len(z.nodes[name][dns.TypeNS]) == 0 {
+			continue
+		}
+		if name == qname && qtype == dns.TypeDS { // DS is served by the parent side.
+			return ""
+		}
+		return name
+	}
+	return ""
+}
+
+func (z *Zone) referral(r *dns.Msg, cut string) {
+	ns := z.nodes[cut][dns.TypeNS]
+	r.Ns = append(r.Ns, ns...)
+	for _, rr := range ns {
+		target := dns.CanonicalName(rr.(*dns.NS).Ns)
+		if !dns.IsSubDomain(z.origin, target) {
+			continue
+		}
+		r.Extra = append(r.Extra, z.nodes[target][dns.TypeA]...)
+		r.Extra = append(r.Extra, z.nodes[target][dns.TypeAAAA]...)
+	}
+}
+
+// wildcard returns the wildcard node that matches qname.
+func (z *Zone) wildcard(qname string) (map[uint16][]dns.RR, bool) {
+	for off, end := dns.NextLabel(qname, 0); !end; off, end = dns.NextLabel(qname, off) {
+		closest := qname[off:]
+		if z.names[closest] == 0 && closest != z.origin {
+			continue
+		}
+		node, ok := z.nodes["*."+closest]
+		return node, ok
+	}
+	return nil, false
+}
+
+func (z *Zone) addNegativeSOA(r *dns.Msg) {
+	soa := z.soa()
+	if soa == nil {
+		return
+	}
+	c := dns.Copy(soa).(*dns.SOA)
+	c.Hdr.Ttl = min(soa.Hdr.Ttl, soa.Minttl)
+	r.Ns = append(r.Ns, c)
+}
+
+// withOwner returns copies of rrs with owner name if the rrs' owner is
+// not the name (e.g. wildcard).
+func withOwner(rrs []dns.RR, owner string) []dns.RR {
+	if len(rrs) == 0 || dns.CanonicalName(rrs[0].Header().Name) == owner {
+		return rrs
+	}
+	s := make([]dns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		c := dns.Copy(rr)
+		c.Header().Name = owner
+		s = append(s, c)
+	}
+	return s
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package zone_file
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+const zoneData = `
+$ORIGIN example.com.
+$TTL 3600
+@         IN SOA  ns1 admin 1 7200 3600 1209600 300
+@         IN NS   ns1
+ns1       IN A    192.0.2.53
+www       IN A    192.0.2.1
+alias     IN CNAME www
+ext       IN CNAME www.example.net.
+a.b.c     IN A    192.0.2.2
+*.wild    IN A    192.0.2.3
+sub       IN NS   ns.sub
+sub       IN DS   12345 13 2 0000000000000000000000000000000000000000000000000000000000000000
+ns.sub    IN A    192.0.2.54
+`
+
+func mustParse(t *testing.T) *Zone {
+	t.Helper()
+	z, err := ParseZone(strings.NewReader(zoneData), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return z
+}
+
+func query(z *Zone, name string, qtype uint16) *dns.Msg {
+	q := new(dns.Msg)
+	q.SetQuestion(name, qtype)
+	return z.Reply(q)
+}
+
+func TestZone_Reply(t *testing.T) {
+	z := mustParse(t)
+	if z.Origin() != "example.com." {
+		t.Fatalf("unexpected origin %s", z.Origin())
+	}
+
+	tests := []struct {
+		name      string
+		qname     string
+		qtype     uint16
+		rcode     int
+		aa        bool
+		answer    int
+		soa       bool
+		ns        int
+		extra     int
+		lastOwner string
+	}{
+		{"answer", "www.example.com.", dns.TypeA, dns.RcodeSuccess, true, 1, false, 0, 0, "www.example.com."},
+		{"case insensitive", "WWW.Example.com.", dns.TypeA, dns.RcodeSuccess, true, 1, false, 0, 0, "www.example.com."},
+		{"nodata", "www.example.com.", dns.TypeAAAA, dns.RcodeSuccess, true, 0, true, 0, 0, ""},
+		{"nxdomain", "nx.example.com.", dns.TypeA, dns.RcodeNameError, true, 0, true, 0, 0, ""},
+		{"empty non-terminal", "b.c.example.com.", dns.TypeA, dns.RcodeSuccess, true, 0, true, 0, 0, ""},
+		{"cname chase", "alias.example.com.", dns.TypeA, dns.RcodeSuccess, true, 2, false, 0, 0, "www.example.com."},
+		{"cname query", "alias.example.com.", dns.TypeCNAME, dns.RcodeSuccess, true, 1, false, 0, 0, "alias.example.com."},
+		{"out of zone cname", "ext.example.com.", dns.TypeA, dns.RcodeSuccess, true, 1, false, 0, 0, "ext.example.com."},
+		{"wildcard", "x.y.wild.example.com.", dns.TypeA, dns.RcodeSuccess, true, 1, false, 0, 0, "x.y.wild.example.com."},
+		{"wildcard nodata", "x.wild.example.com.", dns.TypeAAAA, dns.RcodeSuccess, true, 0, true, 0, 0, ""},
+		{"referral", "www.sub.example.com.", dns.TypeA, dns.RcodeSuccess, false, 0, false, 1, 1, ""},
+		{"referral at cut", "sub.example.com.", dns.TypeA, dns.RcodeSuccess, false, 0, false, 1, 1, ""},
+		{"ds at cut", "sub.example.com.", dns.TypeDS, dns.RcodeSuccess, true, 1, false, 0, 0, "sub.example.com."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := query(z, tt.qname, tt.qtype)
+			if r == nil {
+				t.Fatal("nil reply")
+			}
+			if r.Rcode != tt.rcode {
+				t.Errorf("rcode = %d, want %d", r.Rcode, tt.rcode)
+			}
+			if r.Authoritative != tt.aa {
+				t.Errorf("aa = %v, want %v", r.Authoritative, tt.aa)
+			}
+			if len(r.Answer) != tt.answer {
+				t.Fatalf("answer = %v, want %d records", r.Answer, tt.answer)
+			}
+			if tt.answer > 0 {
+				if got := r.Answer[len(r.Answer)-1].Header().Name; !strings.EqualFold(got, tt.lastOwner) {
+					t.Errorf("last answer owner = %s, want %s", got, tt.lastOwner)
+				}
+			}
+			if tt.soa {
+				if len(r.Ns) != 1 || r.Ns[0].Header().Rrtype != dns.TypeSOA {
+					t.Fatalf("want soa in authority, got %v", r.Ns)
+				}
+				if ttl := r.Ns[0].Header().Ttl; ttl != 300 {
+					t.Errorf("negative soa ttl = %d, want 300", ttl)
+				}
+			} else if len(r.Ns) != tt.ns {
+				t.Errorf("authority = %v, want %d records", r.Ns, tt.ns)
+			}
+			if len(r.Extra) != tt.extra {
+				t.Errorf("extra = %v, want %d records", r.Extra, tt.extra)
+			}
+		})
+	}
+
+	if r := query(z, "example.net.", dns.TypeA); r != nil {
+		t.Fatal("out of zone query should return nil")
+	}
+}
+
+func TestZone_InsertDelete(t *testing.T) {
+	z := mustParse(t)
+	rr, _ := dns.NewRR("new.x.example.com. 60 IN A 192.0.2.9")
+	if err := z.Insert(rr); err != nil {
+		t.Fatal(err)
+	}
+	if r := query(z, "x.example.com.", dns.TypeA); r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("empty non-terminal should exist after insert, got rcode %d", r.Rcode)
+	}
+	if n := z.Delete("new.x.example.com.", dns.TypeA, nil); n != 1 {
+		t.Fatalf("want 1 deleted record, got %d", n)
+	}
+	if r := query(z, "x.example.com.", dns.TypeA); r.Rcode != dns.RcodeNameError {
+		t.Fatalf("empty non-terminal should be removed after delete, got rcode %d", r.Rcode)
+	}
+
+	out, _ := dns.NewRR("www.example.net. IN A 192.0.2.1")
+	if err := z.Insert(out); err == nil {
+		t.Fatal("out of zone record should be rejected")
+	}
+	if rrs := z.Records(); rrs[0].Header().Rrtype != dns.TypeSOA {
+		t.Fatal("first record should be soa")
+	}
+}
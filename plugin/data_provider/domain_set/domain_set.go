@@ -48,6 +48,11 @@ type Args struct {
 	Files []string `yaml:"files"`
 }
 
+// Deps implements coremain.ArgsWithDeps.
+func (a *Args) Deps() []string {
+	return a.Sets
+}
+
 var _ data_provider.DomainMatcherProvider = (*DomainSet)(nil)
 
 type DomainSet struct {
@@ -58,34 +58,52 @@ import (
 	// _ "github.com/IrineSistiana/mosdns/v5/plugin/executable/arbitrary"
 
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/black_hole"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/block"
 
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/ip_hosts"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/ip_rewrite"
 
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/blocklist"
+
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/cache"
 
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/cname_flatten"
+
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/dhcp_leases"
+
 	// _ "github.com/IrineSistiana/mosdns/v5/plugin/executable/debug_print"
 
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/dns64"
+
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/dnssec_validate"
+
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/dnstap"
+
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/drop_resp"
 
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/dual_selector"
 
-	// _ "github.com/IrineSistiana/mosdns/v5/plugin/executable/ecs"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/ecs"
+
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/fakeip"
 
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/forward"
 
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/hosts"
 
 	// _ "github.com/IrineSistiana/mosdns/v5/plugin/executable/ipset"
-	// _ "github.com/IrineSistiana/mosdns/v5/plugin/executable/metrics_collector"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/metrics_collector"
 
 	// _ "github.com/IrineSistiana/mosdns/v5/plugin/executable/nftset"
 
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/query_log"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/query_summary"
 
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/recursive"
+
 	// _ "github.com/IrineSistiana/mosdns/v5/plugin/executable/redirect"
 
-	// _ "github.com/IrineSistiana/mosdns/v5/plugin/executable/reverse_lookup"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/reverse_lookup"
 
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
 
@@ -95,8 +113,11 @@ import (
 
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/addinfo"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/shuffle"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/svcb_rewrite"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/ttl"
 
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/zone"
+
 	// other
 
 	// _ "github.com/IrineSistiana/mosdns/v5/plugin/mark"
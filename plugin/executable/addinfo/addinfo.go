@@ -41,17 +41,14 @@ func (t *addinfo) Exec(_ context.Context, qCtx *query_context.Context) error {
 		if len(qCtx.R().Answer) > 0 {
 			ttl = qCtx.R().Answer[0].Header().Ttl + 1
 		}
-		query_time := fmt.Sprintf("Since %dms ", time.Since(qCtx.StartTime()).Milliseconds())
-		txtRecord := new(dns.TXT)
-		txtRecord.Hdr = dns.RR_Header{
-			Name:   time.Now().Format("20060102150405.000000000") + ".addinfo.paopaodns.",
-			Rrtype: dns.TypeTXT,
-			Class:  dns.ClassINET,
-			Ttl:    ttl,
+		text := fmt.Sprintf("Since %dms From:%s", time.Since(qCtx.StartTime()).Milliseconds(), t.txtRecord)
+		// This plugin is configured explicitly, so it always attaches info,
+		// as an extended dns error unless the legacy TXT mode is enabled.
+		if query_context.GetAddInfoMode() == query_context.AddInfoTXT {
+			qCtx.AddInfo(dns.ExtendedErrorCodeOther, "addinfo", ttl, text)
+		} else {
+			qCtx.AddEDE(dns.ExtendedErrorCodeOther, text)
 		}
-		txtRecord.Txt = []string{query_time + "From:" + t.txtRecord}
-
-		r.Extra = append(r.Extra, txtRecord)
 	}
 	return nil
 }
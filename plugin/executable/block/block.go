@@ -0,0 +1,213 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package block
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+)
+
+const PluginType = "block"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
+}
+
+const (
+	ModeNXDomain = "nxdomain"
+	ModeNoData   = "nodata"
+	ModeRefused  = "refused"
+	ModeNull     = "null"     // 0.0.0.0 and ::
+	ModeSinkhole = "sinkhole" // custom ips
+)
+
+const (
+	soaNs   = "fake-for-negative-caching.mosdns."
+	soaMbox = "hostmaster.mosdns."
+)
+
+var _ sequence.Executable = (*Block)(nil)
+
+type Args struct {
+	// Mode is one of nxdomain, nodata, refused, null and sinkhole.
+	// Default is nxdomain.
+	Mode string `yaml:"mode"`
+	// IPs are the addresses of sinkhole mode. Queries of a family without
+	// address get NODATA.
+	IPs []string `yaml:"ips"`
+	// TTL is the TTL of answers and the negative TTL of the SOA, in
+	// seconds. Default is 300.
+	TTL int `yaml:"ttl"`
+	// EDE adds an extended dns error (RFC 8914) to responses if clients
+	// support EDNS0. It is "blocked", "filtered" or empty (disabled).
+	EDE string `yaml:"ede"`
+	// EDEText is the extra text of the extended dns error. Optional.
+	EDEText string `yaml:"ede_text"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultString(&a.Mode, ModeNXDomain)
+	utils.SetDefaultNum(&a.TTL, 300)
+}
+
+type Block struct {
+	mode    string
+	ipv4    []netip.Addr
+	ipv6    []netip.Addr
+	ttl     uint32
+	ede     uint16 // 0 if disabled
+	edeText string
+}
+
+func Init(_ *coremain.BP, args any) (any, error) {
+	return NewBlock(args.(*Args))
+}
+
+// QuickSetup format: [mode] [ip...] [ede=blocked|filtered]
+// e.g. "nodata", "sinkhole 192.0.2.1 2001:db8::1 ede=blocked".
+func QuickSetup(_ sequence.BQ, s string) (any, error) {
+	args := new(Args)
+	for i, f := range strings.Fields(s) {
+		switch {
+		case strings.HasPrefix(f, "ede="):
+			args.EDE = strings.TrimPrefix(f, "ede=")
+		case i == 0:
+			args.Mode = f
+		default:
+			args.IPs = append(args.IPs, f)
+		}
+	}
+	return NewBlock(args)
+}
+
+func NewBlock(args *Args) (*Block, error) {
+	args.init()
+	b := &Block{
+		mode:    strings.ToLower(args.Mode),
+		ttl:     uint32(args.TTL),
+		edeText: args.EDEText,
+	}
+	switch b.mode {
+	case ModeNXDomain, ModeNoData, ModeRefused:
+	case ModeNull:
+		b.ipv4 = []netip.Addr{netip.IPv4Unspecified()}
+		b.ipv6 = []netip.Addr{netip.IPv6Unspecified()}
+	case ModeSinkhole:
+		for _, s := range args.IPs {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ip %s, %w", s, err)
+			}
+			if addr.Unmap().Is4() {
+				b.ipv4 = append(b.ipv4, addr.Unmap())
+			} else {
+				b.ipv6 = append(b.ipv6, addr)
+			}
+		}
+		if len(b.ipv4)+len(b.ipv6) == 0 {
+			return nil, fmt.Errorf("sinkhole mode requires ips")
+		}
+	default:
+		return nil, fmt.Errorf("invalid mode %s", args.Mode)
+	}
+	switch strings.ToLower(args.EDE) {
+	case "":
+	case "blocked":
+		b.ede = dns.ExtendedErrorCodeBlocked
+	case "filtered":
+		b.ede = dns.ExtendedErrorCodeFiltered
+	default:
+		return nil, fmt.Errorf("invalid ede %s", args.EDE)
+	}
+	return b, nil
+}
+
+func (b *Block) Exec(_ context.Context, qCtx *query_context.Context) error {
+	qCtx.SetResponse(b.Response(qCtx.Q()))
+	if b.ede != 0 {
+		qCtx.AddEDE(b.ede, b.edeText)
+	}
+	return nil
+}
+
+// Response returns the block response of q. The extended dns error is
+// not included, it is added by Exec through the query context.
+func (b *Block) Response(q *dns.Msg) *dns.Msg {
+	r := new(dns.Msg)
+	r.SetReply(q)
+	r.RecursionAvailable = true
+
+	var (
+		name  = "."
+		qtype uint16
+	)
+	if len(q.Question) == 1 {
+		name, qtype = q.Question[0].Name, q.Question[0].Qtype
+	}
+	hdr := dns.RR_Header{Name: name, Rrtype: qtype, Class: dns.ClassINET, Ttl: b.ttl}
+	switch b.mode {
+	case ModeNXDomain:
+		r.Rcode = dns.RcodeNameError
+	case ModeRefused:
+		r.Rcode = dns.RcodeRefused
+	case ModeNull, ModeSinkhole:
+		switch qtype {
+		case dns.TypeA:
+			for _, addr := range b.ipv4 {
+				r.Answer = append(r.Answer, &dns.A{Hdr: hdr, A: addr.AsSlice()})
+			}
+		case dns.TypeAAAA:
+			for _, addr := range b.ipv6 {
+				r.Answer = append(r.Answer, &dns.AAAA{Hdr: hdr, AAAA: addr.AsSlice()})
+			}
+		}
+	}
+	if len(r.Answer) == 0 {
+		r.Ns = append(r.Ns, b.soa(name))
+	}
+
+	if opt := q.IsEdns0(); opt != nil {
+		r.SetEdns0(opt.UDPSize(), opt.Do())
+	}
+	return r
+}
+
+// soa returns a synthetic SOA for negative caching. See RFC 2308.
+func (b *Block) soa(name string) *dns.SOA {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: name, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: b.ttl},
+		Ns:      soaNs,
+		Mbox:    soaMbox,
+		Serial:  1,
+		Refresh: 1800,
+		Retry:   900,
+		Expire:  604800,
+		Minttl:  b.ttl,
+	}
+}
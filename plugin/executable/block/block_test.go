@@ -0,0 +1,110 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package block
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+)
+
+func TestBlock_Response(t *testing.T) {
+	tests := []struct {
+		setup      string
+		qtype      uint16
+		wantRcode  int
+		wantAnswer string // empty means a SOA is expected
+	}{
+		{"", dns.TypeA, dns.RcodeNameError, ""},
+		{"nodata", dns.TypeA, dns.RcodeSuccess, ""},
+		{"refused", dns.TypeA, dns.RcodeRefused, ""},
+		{"null", dns.TypeA, dns.RcodeSuccess, "0.0.0.0"},
+		{"null", dns.TypeAAAA, dns.RcodeSuccess, "::"},
+		{"null", dns.TypeMX, dns.RcodeSuccess, ""},
+		{"sinkhole 192.0.2.1", dns.TypeA, dns.RcodeSuccess, "192.0.2.1"},
+		{"sinkhole 192.0.2.1", dns.TypeAAAA, dns.RcodeSuccess, ""},
+	}
+	for _, tt := range tests {
+		v, err := QuickSetup(nil, tt.setup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := new(dns.Msg)
+		q.SetQuestion("ads.example.", tt.qtype)
+		r := v.(*Block).Response(q)
+		if r.Rcode != tt.wantRcode {
+			t.Fatalf("%q: want rcode %d, got %d", tt.setup, tt.wantRcode, r.Rcode)
+		}
+		if len(tt.wantAnswer) == 0 {
+			if len(r.Answer) != 0 || len(r.Ns) != 1 {
+				t.Fatalf("%q: want a soa, got %v", tt.setup, r)
+			}
+			soa := r.Ns[0].(*dns.SOA)
+			if soa.Hdr.Ttl != 300 || soa.Minttl != 300 {
+				t.Fatalf("%q: unexpected soa %s", tt.setup, soa)
+			}
+			continue
+		}
+		if len(r.Answer) != 1 {
+			t.Fatalf("%q: want one answer, got %v", tt.setup, r)
+		}
+		var got string
+		switch rr := r.Answer[0].(type) {
+		case *dns.A:
+			got = rr.A.String()
+		case *dns.AAAA:
+			got = rr.AAAA.String()
+		}
+		if got != tt.wantAnswer {
+			t.Fatalf("%q: want %s, got %s", tt.setup, tt.wantAnswer, got)
+		}
+	}
+
+	for _, s := range []string{"unknown", "sinkhole", "sinkhole bad-ip", "nodata ede=unknown"} {
+		if _, err := QuickSetup(nil, s); err == nil {
+			t.Fatalf("%q: want error", s)
+		}
+	}
+}
+
+func TestBlock_EDE(t *testing.T) {
+	b, err := NewBlock(&Args{EDE: "filtered", EDEText: "ads", TTL: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("ads.example.", dns.TypeA)
+	if r := b.Response(q); r.IsEdns0() != nil {
+		t.Fatal("response should not have edns0 if the query has none")
+	}
+	q.SetEdns0(1232, true)
+	qCtx := query_context.NewContext(q)
+	if err := b.Exec(context.Background(), qCtx); err != nil {
+		t.Fatal(err)
+	}
+	if opt := qCtx.R().IsEdns0(); opt == nil || !opt.Do() {
+		t.Fatal("do bit of the client was not kept")
+	}
+	if ede := qCtx.EDE(); len(ede) != 1 || ede[0].InfoCode != dns.ExtendedErrorCodeFiltered || ede[0].ExtraText != "ads" {
+		t.Fatalf("unexpected ede %v", ede)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package blocklist
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"sync/atomic"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/pkg/blocklist"
+	"github.com/IrineSistiana/mosdns/v5/pkg/file_watcher"
+	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/domain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/data_provider"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const PluginType = "blocklist"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+var (
+	_ sequence.Executable                 = (*Blocklist)(nil)
+	_ data_provider.DomainMatcherProvider = (*Blocklist)(nil)
+)
+
+var ruleKey = query_context.RegKey()
+
+// MatchedRule returns the rule that decided the response of the query.
+func MatchedRule(qCtx *query_context.Context) (*blocklist.Rule, bool) {
+	v, ok := qCtx.GetValue(ruleKey)
+	if !ok {
+		return nil, false
+	}
+	return v.(*blocklist.Rule), true
+}
+
+// Args: Files and Rules are AdGuard/uBlock style DNS filter rules. See
+// blocklist.List for the supported syntax.
+type Args struct {
+	Files []string `yaml:"files"`
+	Rules []string `yaml:"rules"`
+	// TTL of blocked responses in seconds. Default is 10.
+	TTL int `yaml:"ttl"`
+	// DisableReload disables reloading rules when files are changed.
+	DisableReload bool `yaml:"disable_reload"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultNum(&a.TTL, 10)
+}
+
+// Blocklist answers queries that are blocked or rewritten by rules. As a
+// domain set, it matches names that are blocked by any rule.
+type Blocklist struct {
+	args    *Args
+	logger  *zap.Logger
+	l       atomic.Pointer[blocklist.List]
+	watcher *file_watcher.Watcher
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return NewBlocklist(args.(*Args), bp.L())
+}
+
+func NewBlocklist(args *Args, logger *zap.Logger) (*Blocklist, error) {
+	args.init()
+	if logger == nil {
+		logger = mlog.Nop()
+	}
+	b := &Blocklist{args: args, logger: logger}
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+	if !args.DisableReload && len(args.Files) > 0 {
+		w, err := file_watcher.New(args.Files, file_watcher.Opts{OnChange: b.reload, Logger: logger})
+		if err != nil {
+			return nil, fmt.Errorf("failed to watch files, %w", err)
+		}
+		b.watcher = w
+	}
+	return b, nil
+}
+
+func (b *Blocklist) load() error {
+	l := blocklist.NewList()
+	for i, rule := range b.args.Rules {
+		if err := l.AddRule(rule, "rules", i+1); err != nil {
+			return fmt.Errorf("invalid rule #%d %s, %w", i, rule, err)
+		}
+	}
+	for i, file := range b.args.Files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read file #%d %s, %w", i, file, err)
+		}
+		if err := l.LoadFromReader(bytes.NewReader(data), file); err != nil {
+			return fmt.Errorf("failed to load file #%d %s, %w", i, file, err)
+		}
+	}
+	b.logger.Info("rules loaded", zap.Int("rules", l.Len()), zap.Int("skipped", l.Skipped()))
+	b.l.Store(l)
+	return nil
+}
+
+func (b *Blocklist) reload() {
+	if err := b.load(); err != nil {
+		b.logger.Error("failed to reload rules, old rules are kept", zap.Error(err))
+		return
+	}
+	b.logger.Info("rules reloaded")
+}
+
+// Match returns the rules that decide q. See blocklist.List.Match.
+func (b *Blocklist) Match(q *dns.Msg, client netip.Addr) []*blocklist.Rule {
+	if len(q.Question) != 1 {
+		return nil
+	}
+	question := q.Question[0]
+	return b.l.Load().Match(question.Name, question.Qtype, client)
+}
+
+func (b *Blocklist) Exec(_ context.Context, qCtx *query_context.Context) error {
+	var client netip.Addr
+	if addr, ok := query_context.GetClientAddr(qCtx); ok {
+		client = *addr
+	}
+	rules := b.Match(qCtx.Q(), client)
+	if len(rules) == 0 {
+		return nil
+	}
+	qCtx.StoreValue(ruleKey, rules[0])
+	b.logger.Debug("query blocked", qCtx.InfoField(), zap.Stringer("rule", rules[0]))
+	qCtx.SetResponse(blocklist.Response(qCtx.Q(), rules, uint32(b.args.TTL)))
+	return nil
+}
+
+func (b *Blocklist) GetDomainMatcher() domain.Matcher[struct{}] {
+	return domainMatcher{b: b}
+}
+
+type domainMatcher struct {
+	b *Blocklist
+}
+
+func (m domainMatcher) Match(s string) (struct{}, bool) {
+	return struct{}{}, m.b.l.Load().MatchDomain(s)
+}
+
+func (b *Blocklist) Close() error {
+	if b.watcher != nil {
+		return b.watcher.Close()
+	}
+	return nil
+}
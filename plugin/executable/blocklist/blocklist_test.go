@@ -0,0 +1,91 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package blocklist
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+)
+
+func TestBlocklist(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "list.txt")
+	if err := os.WriteFile(file, []byte("||ads.example^\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewBlocklist(&Args{
+		Files: []string{file},
+		Rules: []string{"||lan.example^$client=192.168.0.0/16"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	exec := func(name string, client string) *query_context.Context {
+		t.Helper()
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeA)
+		qCtx := query_context.NewContext(q)
+		if len(client) > 0 {
+			addr := netip.MustParseAddr(client)
+			query_context.SetClientAddr(qCtx, &addr)
+		}
+		if err := b.Exec(context.Background(), qCtx); err != nil {
+			t.Fatal(err)
+		}
+		return qCtx
+	}
+
+	qCtx := exec("www.ads.example.", "")
+	if r := qCtx.R(); r == nil || r.Rcode != dns.RcodeNameError {
+		t.Fatalf("want nxdomain, got %v", r)
+	}
+	rule, ok := MatchedRule(qCtx)
+	if !ok || rule.Source != file || rule.Line != 1 {
+		t.Fatalf("unexpected matched rule %v", rule)
+	}
+	if qCtx := exec("lan.example.", "10.0.0.1"); qCtx.R() != nil {
+		t.Fatal("client should not be blocked")
+	}
+	if qCtx := exec("lan.example.", "192.168.1.1"); qCtx.R() == nil {
+		t.Fatal("client should be blocked")
+	}
+	if _, ok := b.GetDomainMatcher().Match("ads.example"); !ok {
+		t.Fatal("domain matcher should match")
+	}
+
+	if err := os.WriteFile(file, []byte("||tracker.example^\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second * 5)
+	for exec("tracker.example.", "").R() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("rules not reloaded")
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
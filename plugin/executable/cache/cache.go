@@ -22,6 +22,7 @@ package cache
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"strconv"
 	"sync/atomic"
 	"time"
@@ -31,6 +32,7 @@ import (
 	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
 	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
 	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 	"golang.org/x/sync/singleflight"
 )
@@ -67,12 +69,27 @@ type Cache struct {
 	backend      *cache.Cache[key, *item]
 	lazyUpdateSF singleflight.Group
 	updatedKey   atomic.Uint64
+
+	queryTotal   prometheus.Counter
+	hitTotal     prometheus.Counter
+	lazyHitTotal prometheus.Counter
+	size         prometheus.GaugeFunc
 }
 
 func Init(bp *coremain.BP, args any) (any, error) {
-	c := NewCache(args.(*Args), Opts{
-		Logger: bp.L(),
-	})
+	a := args.(*Args)
+	a.init()
+	// Keep cached records on reload if the cache args are unchanged.
+	c, ok := bp.PrevPlugin().(*Cache)
+	if !ok || *c.args != *a {
+		c = NewCache(a, Opts{
+			Logger: bp.L(),
+		})
+	}
+	if err := c.RegMetricsTo(bp.MetricsReg()); err != nil {
+		return nil, fmt.Errorf("failed to register metrics, %w", err)
+	}
+	bp.RegAPI(c.Api())
 	return c, nil
 }
 
@@ -108,11 +125,52 @@ func NewCache(args *Args, opts Opts) *Cache {
 		args:    args,
 		logger:  logger,
 		backend: backend,
+
+		queryTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cache_query_total",
+			Help: "The total number of processed queries",
+		}),
+		hitTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cache_hit_total",
+			Help: "The total number of queries that hit the cache",
+		}),
+		lazyHitTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cache_lazy_hit_total",
+			Help: "The total number of queries that hit the expired cache",
+		}),
+		size: prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "cache_size_current",
+			Help: "Current cache size in records",
+		}, func() float64 {
+			return float64(backend.Len())
+		}),
 	}
 
 	return p
 }
 
+// RegMetricsTo registers metrics of this cache to r.
+func (c *Cache) RegMetricsTo(r prometheus.Registerer) error {
+	for _, collector := range [...]prometheus.Collector{c.queryTotal, c.hitTotal, c.lazyHitTotal, c.size} {
+		if err := r.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Api returns the api handler of this cache.
+// "POST /flush" removes all cached responses.
+func (c *Cache) Api() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /flush", func(w http.ResponseWriter, _ *http.Request) {
+		c.backend.Flush()
+		c.logger.Info("cache flushed")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
 func (c *Cache) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
 	q := qCtx.Q()
 
@@ -121,11 +179,14 @@ func (c *Cache) Exec(ctx context.Context, qCtx *query_context.Context, next sequ
 		return next.ExecNext(ctx, qCtx)
 	}
 
+	c.queryTotal.Inc()
 	cachedResp, lazyHit := getRespFromCache(msgKey, c.backend, c.args.LazyCacheTTL > 0, expiredMsgTtl)
 	if lazyHit {
+		c.lazyHitTotal.Inc()
 		c.doLazyUpdate(msgKey, qCtx, next)
 	}
 	if cachedResp != nil { // cache hit
+		c.hitTotal.Inc()
 		cachedResp.Id = q.Id // change msg id
 		qCtx.SetResponse(cachedResp)
 	}
@@ -0,0 +1,184 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package cname_flatten
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+)
+
+const PluginType = "cname_flatten"
+
+func init() {
+	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
+}
+
+const (
+	maxChainLen  = 16
+	maxFollowUps = 4
+)
+
+var _ sequence.RecursiveExecutable = (*Flatten)(nil)
+
+// Flatten collapses CNAME chains in A/AAAA responses into records that are
+// owned by the query name. Each record gets the minimum TTL across the
+// chain. If a chain is incomplete, the rest of it is queried through the
+// rest of the sequence. Responses that cannot be flattened, e.g. chains
+// that are too long or loop, are left unchanged.
+type Flatten struct{}
+
+// QuickSetup format: no args.
+func QuickSetup(_ sequence.BQ, s string) (any, error) {
+	if len(s) > 0 {
+		return nil, fmt.Errorf("%s has no args", PluginType)
+	}
+	return new(Flatten), nil
+}
+
+func (f *Flatten) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	if err := next.ExecNext(ctx, qCtx); err != nil {
+		return err
+	}
+	q := qCtx.Q()
+	r := qCtx.R()
+	if r == nil || r.Rcode != dns.RcodeSuccess || len(q.Question) != 1 {
+		return nil
+	}
+	qt := q.Question[0].Qtype
+	if qt != dns.TypeA && qt != dns.TypeAAAA {
+		return nil
+	}
+
+	qName := q.Question[0].Name
+	name, queried := qName, qName
+	answer, ns, rcode := r.Answer, r.Ns, r.Rcode
+	minTTL := ^uint32(0)
+	followUps := 0
+	for chainLen := 0; ; {
+		var records []dns.RR
+		var cname *dns.CNAME
+		for _, rr := range answer {
+			hdr := rr.Header()
+			if !strings.EqualFold(hdr.Name, name) {
+				continue
+			}
+			switch rr := rr.(type) {
+			case *dns.CNAME:
+				cname = rr
+			default:
+				if hdr.Rrtype == qt {
+					records = append(records, rr)
+				}
+			}
+		}
+
+		switch {
+		case len(records) > 0:
+			if chainLen == 0 {
+				return nil
+			}
+			flattened := make([]dns.RR, 0, len(records))
+			for _, rr := range records {
+				rr = dns.Copy(rr)
+				hdr := rr.Header()
+				hdr.Name = qName
+				hdr.Ttl = min(hdr.Ttl, minTTL)
+				flattened = append(flattened, rr)
+			}
+			setResponse(r, dns.RcodeSuccess, flattened, nil)
+			return nil
+
+		case cname != nil:
+			chainLen++
+			if chainLen > maxChainLen {
+				return nil
+			}
+			minTTL = min(minTTL, cname.Hdr.Ttl)
+			name = cname.Target
+
+		case chainLen == 0:
+			return nil
+
+		case strings.EqualFold(name, queried) || hasSOAFor(ns, name):
+			// The end of the chain has no record.
+			negNs := make([]dns.RR, 0, len(ns))
+			for _, rr := range ns {
+				if soa, ok := rr.(*dns.SOA); ok {
+					soa = dns.Copy(soa).(*dns.SOA)
+					soa.Hdr.Ttl = min(soa.Hdr.Ttl, minTTL)
+					rr = soa
+				}
+				negNs = append(negNs, rr)
+			}
+			setResponse(r, rcode, nil, negNs)
+			return nil
+
+		default:
+			// Incomplete chain. Query the rest of it.
+			followUps++
+			if followUps > maxFollowUps {
+				return nil
+			}
+			fCtx := qCtx.Copy()
+			fCtx.Q().Question[0].Name = name
+			fCtx.SetResponse(nil)
+			if err := next.ExecNext(ctx, fCtx); err != nil {
+				return nil
+			}
+			fr := fCtx.R()
+			if fr == nil || (fr.Rcode != dns.RcodeSuccess && fr.Rcode != dns.RcodeNameError) {
+				return nil
+			}
+			queried = name
+			answer, ns, rcode = fr.Answer, fr.Ns, fr.Rcode
+		}
+	}
+}
+
+// setResponse replaces sections of r. Only the OPT record is kept in the
+// additional section. Flattened records are not authenticated anymore.
+func setResponse(r *dns.Msg, rcode int, answer, ns []dns.RR) {
+	r.Rcode = rcode
+	r.Answer = answer
+	r.Ns = ns
+	extra := r.Extra[:0]
+	for _, rr := range r.Extra {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			extra = append(extra, rr)
+		}
+	}
+	r.Extra = extra
+	r.AuthenticatedData = false
+}
+
+// hasSOAFor reports whether ns has a SOA of a zone that name belongs to.
+func hasSOAFor(ns []dns.RR, name string) bool {
+	for _, rr := range ns {
+		if soa, ok := rr.(*dns.SOA); ok && dns.IsSubDomain(soa.Hdr.Name, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package cname_flatten
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+)
+
+// fakeUpstream answers queries from fixed responses, keyed by qname.
+type fakeUpstream struct {
+	resps   map[string][]string // qname -> answer records, "NXDOMAIN" or "SOA ..."
+	queries []string
+}
+
+func (u *fakeUpstream) Exec(_ context.Context, qCtx *query_context.Context) error {
+	q := qCtx.Q()
+	u.queries = append(u.queries, q.Question[0].Name)
+	r := new(dns.Msg)
+	r.SetReply(q)
+	for _, s := range u.resps[q.Question[0].Name] {
+		if s == "NXDOMAIN" {
+			r.Rcode = dns.RcodeNameError
+			continue
+		}
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			return err
+		}
+		if rr.Header().Rrtype == dns.TypeSOA {
+			r.Ns = append(r.Ns, rr)
+		} else {
+			r.Answer = append(r.Answer, rr)
+		}
+	}
+	qCtx.SetResponse(r)
+	return nil
+}
+
+func TestFlatten(t *testing.T) {
+	u := &fakeUpstream{resps: map[string][]string{
+		"www.example.": {
+			"www.example. 300 IN CNAME cdn.example.",
+			"cdn.example. 60 IN CNAME edge.example.",
+			"edge.example. 120 IN A 192.0.2.1",
+			"edge.example. 30 IN A 192.0.2.2",
+		},
+		"direct.example.":  {"direct.example. 300 IN A 192.0.2.3"},
+		"partial.example.": {"partial.example. 300 IN CNAME www.cdn.test."},
+		"www.cdn.test.":    {"www.cdn.test. 100 IN A 198.51.100.1"},
+		"nodata.example.": {
+			"nodata.example. 300 IN CNAME v4only.example.",
+			"example. 3600 IN SOA ns.example. admin.example. 1 7200 3600 1209600 600",
+		},
+		"gone.example.": {"gone.example. 300 IN CNAME gone.test."},
+		"gone.test.": {
+			"NXDOMAIN",
+			"test. 3600 IN SOA ns.test. admin.test. 1 7200 3600 1209600 600",
+		},
+		"loop.example.": {
+			"loop.example. 300 IN CNAME loop2.example.",
+			"loop2.example. 300 IN CNAME loop.example.",
+		},
+	}}
+
+	exec := func(name string) *dns.Msg {
+		t.Helper()
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeA)
+		qCtx := query_context.NewContext(q)
+		cw := sequence.NewChainWalker([]*sequence.ChainNode{{E: u}}, nil)
+		if err := new(Flatten).Exec(context.Background(), qCtx, cw); err != nil {
+			t.Fatal(err)
+		}
+		return qCtx.R()
+	}
+
+	r := exec("www.example.")
+	if len(r.Answer) != 2 {
+		t.Fatalf("want 2 records, got %v", r.Answer)
+	}
+	for i, wantTTL := range []uint32{60, 30} {
+		hdr := r.Answer[i].Header()
+		if hdr.Name != "www.example." || hdr.Ttl != wantTTL {
+			t.Fatalf("unexpected record %s", r.Answer[i])
+		}
+	}
+
+	if r := exec("direct.example."); len(r.Answer) != 1 || r.Answer[0].Header().Ttl != 300 {
+		t.Fatalf("response without cname should be unchanged, got %v", r.Answer)
+	}
+
+	u.queries = nil
+	r = exec("partial.example.")
+	if len(r.Answer) != 1 || r.Answer[0].Header().Name != "partial.example." || r.Answer[0].Header().Ttl != 100 {
+		t.Fatalf("unexpected answer %v", r.Answer)
+	}
+	if len(u.queries) != 2 || u.queries[1] != "www.cdn.test." {
+		t.Fatalf("want a follow-up query, got %v", u.queries)
+	}
+
+	u.queries = nil
+	r = exec("nodata.example.")
+	if len(r.Answer) != 0 || r.Rcode != dns.RcodeSuccess || len(r.Ns) != 1 || r.Ns[0].Header().Ttl != 300 {
+		t.Fatalf("want nodata with soa ttl 300, got %v", r)
+	}
+	if len(u.queries) != 1 {
+		t.Fatalf("soa of the target zone should stop follow-ups, got %v", u.queries)
+	}
+
+	r = exec("gone.example.")
+	if len(r.Answer) != 0 || r.Rcode != dns.RcodeNameError {
+		t.Fatalf("want nxdomain, got %v", r)
+	}
+
+	if r := exec("loop.example."); len(r.Answer) != 2 {
+		t.Fatalf("loop should be unchanged, got %v", r.Answer)
+	}
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dhcp_leases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/pkg/file_watcher"
+	"github.com/IrineSistiana/mosdns/v5/pkg/hosts"
+	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/domain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const PluginType = "dhcp_leases"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+var _ sequence.Executable = (*DhcpLeases)(nil)
+
+type Args struct {
+	// Files are lease files. Required.
+	Files []string `yaml:"files"`
+	// Format is the format of the files. Can be "dnsmasq" (also used by
+	// OpenWrt /tmp/dhcp.leases), "isc" or "kea". Default is "dnsmasq".
+	Format string `yaml:"format"`
+	// Suffix is appended to hostnames that are not fqdns. Default is "lan".
+	Suffix string `yaml:"suffix"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultString(&a.Format, FormatDnsmasq)
+	utils.SetDefaultString(&a.Suffix, "lan")
+}
+
+type DhcpLeases struct {
+	files   []string
+	parse   parseFunc
+	suffix  string
+	logger  *zap.Logger
+	h       atomic.Pointer[hosts.Hosts]
+	watcher *file_watcher.Watcher
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return NewDhcpLeases(args.(*Args), bp.L())
+}
+
+func NewDhcpLeases(args *Args, logger *zap.Logger) (*DhcpLeases, error) {
+	args.init()
+	if len(args.Files) == 0 {
+		return nil, errors.New("no lease file")
+	}
+	parse, err := getParser(args.Format)
+	if err != nil {
+		return nil, err
+	}
+	if logger == nil {
+		logger = mlog.Nop()
+	}
+	d := &DhcpLeases{
+		files:  args.Files,
+		parse:  parse,
+		suffix: dns.CanonicalName(args.Suffix),
+		logger: logger,
+	}
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+	w, err := file_watcher.New(args.Files, file_watcher.Opts{OnChange: d.reload, Logger: logger})
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch lease files, %w", err)
+	}
+	d.watcher = w
+	return d, nil
+}
+
+func (d *DhcpLeases) load() error {
+	now := time.Now()
+	m := domain.NewFullMatcher[*hosts.IPs]()
+	ptr := make(map[netip.Addr][]string)
+	for i, file := range d.files {
+		f, err := os.Open(file)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) { // The dhcp server may not have created it yet.
+				continue
+			}
+			return fmt.Errorf("failed to open lease file #%d %s, %w", i, file, err)
+		}
+		leases, err := d.parse(f, now)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to parse lease file #%d %s, %w", i, file, err)
+		}
+		for _, l := range leases {
+			name, ok := d.fqdn(l.hostname)
+			if !ok {
+				continue
+			}
+			ips, _ := m.Match(name)
+			if ips == nil {
+				ips = new(hosts.IPs)
+				m.Add(name, ips)
+			}
+			addr := l.addr.Unmap()
+			if addr.Is4() {
+				ips.IPv4 = append(ips.IPv4, addr)
+			} else {
+				ips.IPv6 = append(ips.IPv6, addr)
+			}
+			ptr[addr] = append(ptr[addr], name)
+		}
+	}
+	d.h.Store(hosts.NewHostsWithOpts(m, hosts.Opts{PTR: ptr}))
+	return nil
+}
+
+func (d *DhcpLeases) reload() {
+	if err := d.load(); err != nil {
+		d.logger.Error("failed to reload leases, old leases are kept", zap.Error(err))
+		return
+	}
+	d.logger.Info("leases reloaded")
+}
+
+// fqdn returns the fqdn of a hostname from a lease file.
+func (d *DhcpLeases) fqdn(hostname string) (string, bool) {
+	hostname = strings.TrimSuffix(strings.ToLower(hostname), ".")
+	if len(hostname) == 0 {
+		return "", false
+	}
+	name := dns.Fqdn(hostname)
+	if !strings.Contains(hostname, ".") {
+		name = hostname + "." + d.suffix
+	}
+	if _, ok := dns.IsDomainName(name); !ok {
+		return "", false
+	}
+	return name, true
+}
+
+func (d *DhcpLeases) Response(q *dns.Msg) *dns.Msg {
+	return d.h.Load().LookupMsg(q)
+}
+
+func (d *DhcpLeases) Exec(_ context.Context, qCtx *query_context.Context) error {
+	if r := d.Response(qCtx.Q()); r != nil {
+		qCtx.SetResponse(r)
+		if len(r.Answer) > 0 {
+			qCtx.AddInfo(dns.ExtendedErrorCodeForgedAnswer, "usehosts", 301, "USE_HOSTS")
+		}
+	}
+	return nil
+}
+
+func (d *DhcpLeases) Close() error {
+	return d.watcher.Close()
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dhcp_leases
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var now = time.Unix(1700000000, 0)
+
+const dnsmasqLeases = `1700003600 aa:bb:cc:dd:ee:01 192.168.1.10 laptop 01:aa:bb:cc:dd:ee:01
+1600000000 aa:bb:cc:dd:ee:02 192.168.1.11 expired *
+0 aa:bb:cc:dd:ee:03 192.168.1.12 * *
+duid 00:01:00:01:2c:1e:2f:3a:aa:bb:cc:dd:ee:01
+1700003600 1234 fd00::10 laptop 00:01:00:01
+`
+
+const iscLeases = `# The format of this file is documented in the dhcpd.leases(5) manual page.
+lease 192.168.1.20 {
+  starts 4 2023/11/14 20:00:00;
+  ends 4 2023/11/14 21:00:00;
+  binding state active;
+  client-hostname "old";
+}
+lease 192.168.1.21 {
+  ends never;
+  binding state active;
+  client-hostname "printer";
+}
+lease 192.168.1.22 {
+  ends 6 2033/01/01 00:00:00;
+  binding state free;
+  client-hostname "freed";
+}
+lease 192.168.1.20 {
+  ends 6 2033/01/01 00:00:00;
+  binding state active;
+  client-hostname "phone";
+}
+`
+
+const keaLeases = `address,hwaddr,client_id,valid_lifetime,expire,subnet_id,fqdn_fwd,fqdn_rev,hostname,state,user_context
+192.168.1.30,aa:bb:cc:dd:ee:30,,3600,1700003600,1,0,0,tv.example.com.,0,
+192.168.1.31,aa:bb:cc:dd:ee:31,,3600,1700003600,1,0,0,nas,0,
+192.168.1.31,aa:bb:cc:dd:ee:31,,3600,1700003600,1,0,0,nas,2,
+192.168.1.32,aa:bb:cc:dd:ee:32,,3600,1600000000,1,0,0,gone,0,
+`
+
+func Test_parsers(t *testing.T) {
+	tests := []struct {
+		format string
+		data   string
+		want   []lease
+	}{
+		{FormatDnsmasq, dnsmasqLeases, []lease{
+			{"laptop", netip.MustParseAddr("192.168.1.10")},
+			{"laptop", netip.MustParseAddr("fd00::10")},
+		}},
+		{FormatISC, iscLeases, []lease{
+			{"phone", netip.MustParseAddr("192.168.1.20")},
+			{"printer", netip.MustParseAddr("192.168.1.21")},
+		}},
+		{FormatKea, keaLeases, []lease{
+			{"tv.example.com.", netip.MustParseAddr("192.168.1.30")},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			parse, err := getParser(tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parse(strings.NewReader(tt.data), now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDhcpLeases(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dhcp.leases")
+	data := "0 aa:bb:cc:dd:ee:01 192.168.1.10 Laptop *\n"
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDhcpLeases(&Args{Files: []string{file}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	q := new(dns.Msg)
+	q.SetQuestion("laptop.lan.", dns.TypeA)
+	if r := d.Response(q); r == nil || len(r.Answer) != 1 {
+		t.Fatalf("unexpected A response %v", r)
+	}
+	q.SetQuestion("10.1.168.192.in-addr.arpa.", dns.TypePTR)
+	if r := d.Response(q); r == nil || len(r.Answer) != 1 || r.Answer[0].(*dns.PTR).Ptr != "laptop.lan." {
+		t.Fatalf("unexpected PTR response %v", r)
+	}
+
+	data += "0 aa:bb:cc:dd:ee:02 192.168.1.11 phone *\n"
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	q.SetQuestion("phone.lan.", dns.TypeA)
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		if r := d.Response(q); r != nil && len(r.Answer) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("leases were not reloaded")
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
@@ -0,0 +1,238 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dhcp_leases
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatDnsmasq = "dnsmasq" // dnsmasq and OpenWrt /tmp/dhcp.leases
+	FormatISC     = "isc"     // ISC dhcpd.leases
+	FormatKea     = "kea"     // Kea memfile csv
+)
+
+type lease struct {
+	hostname string
+	addr     netip.Addr
+}
+
+type parseFunc func(r io.Reader, now time.Time) ([]lease, error)
+
+func getParser(format string) (parseFunc, error) {
+	switch format {
+	case FormatDnsmasq:
+		return parseDnsmasq, nil
+	case FormatISC:
+		return parseISC, nil
+	case FormatKea:
+		return parseKea, nil
+	default:
+		return nil, fmt.Errorf("unknown lease file format %s", format)
+	}
+}
+
+// parseDnsmasq parses lines like
+// "<expiry> <mac|iaid> <ip> <hostname|*> <client-id|*>".
+// Lines that start with "duid" are ignored.
+func parseDnsmasq(r io.Reader, now time.Time) ([]lease, error) {
+	var leases []lease
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		f := strings.Fields(scanner.Text())
+		if len(f) == 0 || f[0] == "duid" {
+			continue
+		}
+		if len(f) < 4 {
+			return nil, fmt.Errorf("invalid lease at line #%d", line)
+		}
+		expiry, err := strconv.ParseInt(f[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiry at line #%d, %w", line, err)
+		}
+		if expiry != 0 && time.Unix(expiry, 0).Before(now) {
+			continue
+		}
+		addr, err := netip.ParseAddr(f[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid address at line #%d, %w", line, err)
+		}
+		if f[3] == "*" {
+			continue
+		}
+		leases = append(leases, lease{hostname: f[3], addr: addr})
+	}
+	return leases, scanner.Err()
+}
+
+// parseISC parses ISC dhcpd.leases. The file is a log, later lease
+// declarations of an address replace earlier ones.
+func parseISC(r io.Reader, now time.Time) ([]lease, error) {
+	type iscLease struct {
+		lease
+		active bool
+		ends   time.Time // zero means never
+	}
+	var order []netip.Addr
+	byAddr := make(map[netip.Addr]*iscLease)
+
+	var cur *iscLease
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		s := strings.TrimSpace(scanner.Text())
+		if len(s) == 0 || strings.HasPrefix(s, "#") {
+			continue
+		}
+		if cur == nil {
+			if !strings.HasPrefix(s, "lease ") {
+				continue
+			}
+			f := strings.Fields(s)
+			if len(f) < 2 {
+				return nil, fmt.Errorf("invalid lease at line #%d", line)
+			}
+			addr, err := netip.ParseAddr(f[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid address at line #%d, %w", line, err)
+			}
+			cur = &iscLease{lease: lease{addr: addr}, active: true}
+			continue
+		}
+
+		if s == "}" {
+			if _, ok := byAddr[cur.addr]; !ok {
+				order = append(order, cur.addr)
+			}
+			byAddr[cur.addr] = cur
+			cur = nil
+			continue
+		}
+		s = strings.TrimSuffix(s, ";")
+		switch {
+		case strings.HasPrefix(s, "binding state "):
+			cur.active = strings.TrimPrefix(s, "binding state ") == "active"
+		case strings.HasPrefix(s, "client-hostname "):
+			cur.hostname = strings.Trim(strings.TrimPrefix(s, "client-hostname "), `"`)
+		case strings.HasPrefix(s, "ends "):
+			f := strings.Fields(s)
+			if len(f) == 2 && f[1] == "never" {
+				continue
+			}
+			if len(f) < 4 {
+				return nil, fmt.Errorf("invalid ends at line #%d", line)
+			}
+			t, err := time.Parse("2006/01/02 15:04:05", f[2]+" "+f[3])
+			if err != nil {
+				return nil, fmt.Errorf("invalid ends at line #%d, %w", line, err)
+			}
+			cur.ends = t
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var leases []lease
+	for _, addr := range order {
+		l := byAddr[addr]
+		if !l.active || len(l.hostname) == 0 || (!l.ends.IsZero() && l.ends.Before(now)) {
+			continue
+		}
+		leases = append(leases, l.lease)
+	}
+	return leases, nil
+}
+
+// parseKea parses Kea memfile csv leases (v4 and v6). The file is a log,
+// later rows of an address replace earlier ones.
+func parseKea(r io.Reader, now time.Time) ([]lease, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	cols := make(map[string]int)
+	for i, name := range header {
+		cols[name] = i
+	}
+	for _, name := range []string{"address", "expire", "hostname", "state"} {
+		if _, ok := cols[name]; !ok {
+			return nil, fmt.Errorf("missing column %s", name)
+		}
+	}
+
+	var order []netip.Addr
+	byAddr := make(map[netip.Addr]*lease)
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		if len(row) < len(header) {
+			return nil, fmt.Errorf("invalid lease at line #%d", line)
+		}
+		addr, err := netip.ParseAddr(row[cols["address"]])
+		if err != nil {
+			return nil, fmt.Errorf("invalid address at line #%d, %w", line, err)
+		}
+		expire, err := strconv.ParseInt(row[cols["expire"]], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expire at line #%d, %w", line, err)
+		}
+		if _, ok := byAddr[addr]; !ok {
+			order = append(order, addr)
+		}
+		// State 0 is the default state, others are declined or reclaimed.
+		hostname := row[cols["hostname"]]
+		if row[cols["state"]] != "0" || len(hostname) == 0 || time.Unix(expire, 0).Before(now) {
+			byAddr[addr] = nil
+			continue
+		}
+		byAddr[addr] = &lease{hostname: hostname, addr: addr}
+	}
+
+	var leases []lease
+	for _, addr := range order {
+		if l := byAddr[addr]; l != nil {
+			leases = append(leases, *l)
+		}
+	}
+	return leases, nil
+}
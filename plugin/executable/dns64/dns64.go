@@ -0,0 +1,308 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dns64
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/pkg/dnsutils"
+	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/netlist"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+const PluginType = "dns64"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+	sequence.MustRegExecQuickSetup(PluginType, quickSetup)
+}
+
+const (
+	ipv4OnlyArpa = "ipv4only.arpa."
+
+	minDiscoveryTTL    = time.Minute
+	maxDiscoveryTTL    = time.Hour
+	discoveryRetryWait = time.Second * 30
+)
+
+var _ sequence.RecursiveExecutable = (*DNS64)(nil)
+
+type Args struct {
+	// Prefix is the NAT64 prefix, e.g. "64:ff9b::/96". If it is empty,
+	// the prefix is discovered by querying ipv4only.arpa through the
+	// rest of the sequence. See RFC 7050.
+	Prefix string `yaml:"prefix"`
+	// Exclude are IPv4 addresses or CIDRs that are not synthesized.
+	Exclude []string `yaml:"exclude"`
+}
+
+type DNS64 struct {
+	logger  *zap.Logger
+	prefix  netip.Prefix  // static prefix, invalid if discovery is used
+	exclude *netlist.List // nil if no exclusion
+
+	sf         singleflight.Group
+	mu         sync.Mutex
+	discovered netip.Prefix // invalid if the discovery failed
+	expire     time.Time
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return NewDNS64(args.(*Args), bp.L())
+}
+
+// QuickSetup format: [prefix]
+// If prefix is omitted, it is discovered.
+func quickSetup(bq sequence.BQ, s string) (any, error) {
+	return NewDNS64(&Args{Prefix: s}, bq.L())
+}
+
+func NewDNS64(args *Args, logger *zap.Logger) (*DNS64, error) {
+	if logger == nil {
+		logger = mlog.Nop()
+	}
+	d := &DNS64{logger: logger}
+	if len(args.Prefix) > 0 {
+		p, err := parsePrefix(args.Prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefix, %w", err)
+		}
+		d.prefix = p
+	}
+	if len(args.Exclude) > 0 {
+		d.exclude = netlist.NewList()
+		for i, s := range args.Exclude {
+			if err := netlist.LoadFromText(d.exclude, s); err != nil {
+				return nil, fmt.Errorf("invalid exclude #%d %s, %w", i, s, err)
+			}
+		}
+		d.exclude.Sort()
+	}
+	return d, nil
+}
+
+func (d *DNS64) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	q := qCtx.Q()
+	if len(q.Question) != 1 || q.Question[0].Qclass != dns.ClassINET {
+		return next.ExecNext(ctx, qCtx)
+	}
+	switch q.Question[0].Qtype {
+	case dns.TypeAAAA:
+		return d.execAAAA(ctx, qCtx, next)
+	case dns.TypePTR:
+		return d.execPTR(ctx, qCtx, next)
+	}
+	return next.ExecNext(ctx, qCtx)
+}
+
+func (d *DNS64) execAAAA(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	if err := next.ExecNext(ctx, qCtx); err != nil {
+		return err
+	}
+	r := qCtx.R()
+	if r == nil || r.Rcode != dns.RcodeSuccess || hasRR(r, dns.TypeAAAA) {
+		return nil
+	}
+	// Clients that validate DNSSEC by themselves must not get synthesized
+	// answers. See RFC 6147 section 5.5.
+	if q := qCtx.Q(); q.CheckingDisabled {
+		if opt := q.IsEdns0(); opt != nil && opt.Do() {
+			return nil
+		}
+	}
+
+	prefix, ok := d.getPrefix(ctx, qCtx, next)
+	if !ok {
+		return nil
+	}
+
+	aCtx := qCtx.Copy()
+	aCtx.Q().Question[0].Qtype = dns.TypeA
+	aCtx.SetResponse(nil)
+	if err := next.ExecNext(ctx, aCtx); err != nil {
+		d.logger.Warn("failed to query A record", qCtx.InfoField(), zap.Error(err))
+		return nil
+	}
+	ar := aCtx.R()
+	if ar == nil || ar.Rcode != dns.RcodeSuccess {
+		return nil
+	}
+	if sr := d.synthesize(qCtx.Q(), ar, prefix); sr != nil {
+		qCtx.SetResponse(sr)
+	}
+	return nil
+}
+
+// synthesize builds a AAAA response from A response ar. It returns nil if
+// no AAAA record can be synthesized.
+func (d *DNS64) synthesize(q, ar *dns.Msg, prefix netip.Prefix) *dns.Msg {
+	r := new(dns.Msg)
+	r.SetReply(q)
+	r.RecursionAvailable = ar.RecursionAvailable
+	synthesized := false
+	for _, rr := range ar.Answer {
+		switch rr := rr.(type) {
+		case *dns.A:
+			addr, ok := netip.AddrFromSlice(rr.A)
+			if !ok {
+				continue
+			}
+			addr = addr.Unmap()
+			if d.exclude != nil && d.exclude.Contains(addr) {
+				continue
+			}
+			hdr := rr.Hdr
+			hdr.Rrtype = dns.TypeAAAA
+			r.Answer = append(r.Answer, &dns.AAAA{Hdr: hdr, AAAA: embed(prefix, addr).AsSlice()})
+			synthesized = true
+		case *dns.CNAME, *dns.DNAME:
+			r.Answer = append(r.Answer, dns.Copy(rr))
+		}
+	}
+	if !synthesized {
+		return nil
+	}
+	if opt := ar.IsEdns0(); opt != nil {
+		r.Extra = append(r.Extra, dns.Copy(opt))
+	}
+	return r
+}
+
+// execPTR answers PTR queries of synthesized addresses with a CNAME to
+// the in-addr.arpa name, and the PTR records of the ipv4 address.
+// See RFC 6147 section 5.3.1.
+func (d *DNS64) execPTR(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	q := qCtx.Q()
+	name := q.Question[0].Name
+	addr, err := dnsutils.ParsePTRQName(strings.ToLower(name))
+	if err != nil || !addr.Is6() {
+		return next.ExecNext(ctx, qCtx)
+	}
+	prefix, ok := d.getPrefix(ctx, qCtx, next)
+	if !ok {
+		return next.ExecNext(ctx, qCtx)
+	}
+	v4, ok := extract(prefix, addr)
+	if !ok {
+		return next.ExecNext(ctx, qCtx)
+	}
+	target, err := dns.ReverseAddr(v4.String())
+	if err != nil {
+		return next.ExecNext(ctx, qCtx)
+	}
+
+	pCtx := qCtx.Copy()
+	pCtx.Q().Question[0].Name = target
+	pCtx.SetResponse(nil)
+	if err := next.ExecNext(ctx, pCtx); err != nil {
+		return err
+	}
+	pr := pCtx.R()
+	if pr == nil {
+		return nil
+	}
+	r := new(dns.Msg)
+	r.SetReply(q)
+	r.RecursionAvailable = pr.RecursionAvailable
+	r.Rcode = pr.Rcode
+	ttl := dnsutils.GetMinimalTTL(pr)
+	r.Answer = append(r.Answer, &dns.CNAME{
+		Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: ttl},
+		Target: target,
+	})
+	for _, rr := range pr.Answer {
+		r.Answer = append(r.Answer, dns.Copy(rr))
+	}
+	r.Ns = append(r.Ns, pr.Ns...)
+	qCtx.SetResponse(r)
+	return nil
+}
+
+// getPrefix returns the NAT64 prefix. If the prefix needs to be
+// discovered, it queries ipv4only.arpa through next.
+func (d *DNS64) getPrefix(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) (netip.Prefix, bool) {
+	if d.prefix.IsValid() {
+		return d.prefix, true
+	}
+	d.mu.Lock()
+	p, expire := d.discovered, d.expire
+	d.mu.Unlock()
+	if time.Now().Before(expire) {
+		return p, p.IsValid()
+	}
+
+	v, _, _ := d.sf.Do("", func() (any, error) {
+		p, ttl, err := d.discover(ctx, qCtx, next)
+		wait := discoveryRetryWait
+		if err != nil {
+			d.logger.Warn("failed to discover nat64 prefix", zap.Error(err))
+		} else {
+			d.logger.Debug("nat64 prefix discovered", zap.Stringer("prefix", p))
+			wait = min(max(time.Duration(ttl)*time.Second, minDiscoveryTTL), maxDiscoveryTTL)
+		}
+		d.mu.Lock()
+		d.discovered, d.expire = p, time.Now().Add(wait)
+		d.mu.Unlock()
+		return p, nil
+	})
+	p = v.(netip.Prefix)
+	return p, p.IsValid()
+}
+
+func (d *DNS64) discover(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) (netip.Prefix, uint32, error) {
+	dCtx := qCtx.Copy()
+	dq := dCtx.Q()
+	dq.Question[0] = dns.Question{Name: ipv4OnlyArpa, Qtype: dns.TypeAAAA, Qclass: dns.ClassINET}
+	dq.CheckingDisabled = false
+	dCtx.SetResponse(nil)
+	if err := next.ExecNext(ctx, dCtx); err != nil {
+		return netip.Prefix{}, 0, err
+	}
+	r := dCtx.R()
+	if r == nil {
+		return netip.Prefix{}, 0, errors.New("no response")
+	}
+	p, ttl, ok := discoverPrefix(r)
+	if !ok {
+		return netip.Prefix{}, 0, fmt.Errorf("no nat64 prefix in response, rcode %s", dns.RcodeToString[r.Rcode])
+	}
+	return p, ttl, nil
+}
+
+func hasRR(m *dns.Msg, t uint16) bool {
+	for _, rr := range m.Answer {
+		if rr.Header().Rrtype == t {
+			return true
+		}
+	}
+	return false
+}
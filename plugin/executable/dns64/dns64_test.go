@@ -0,0 +1,149 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dns64
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+)
+
+// zoneNext answers queries from records.
+type zoneNext struct {
+	rrs     []string
+	queries int
+}
+
+func (z *zoneNext) Exec(_ context.Context, qCtx *query_context.Context) error {
+	z.queries++
+	q := qCtx.Q()
+	r := new(dns.Msg)
+	r.SetReply(q)
+	for _, s := range z.rrs {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			return err
+		}
+		if rr.Header().Name == q.Question[0].Name && rr.Header().Rrtype == q.Question[0].Qtype {
+			r.Answer = append(r.Answer, rr)
+		}
+	}
+	qCtx.SetResponse(r)
+	return nil
+}
+
+func exec(t *testing.T, d *DNS64, next *zoneNext, name string, qt uint16) *dns.Msg {
+	t.Helper()
+	q := new(dns.Msg)
+	q.SetQuestion(name, qt)
+	qCtx := query_context.NewContext(q)
+	cw := sequence.NewChainWalker([]*sequence.ChainNode{{E: next}}, nil)
+	if err := d.Exec(context.Background(), qCtx, cw); err != nil {
+		t.Fatal(err)
+	}
+	return qCtx.R()
+}
+
+func TestDNS64(t *testing.T) {
+	next := &zoneNext{rrs: []string{
+		"ipv4only.arpa. 300 IN AAAA 2001:db8:64::c000:aa",
+		"v4.example. 60 IN A 192.0.2.1",
+		"v4.example. 60 IN A 10.0.0.1",
+		"private.example. 60 IN A 10.0.0.2",
+		"dual.example. 60 IN A 192.0.2.2",
+		"dual.example. 60 IN AAAA 2001:db8::2",
+		"1.2.0.192.in-addr.arpa. 60 IN PTR v4.example.",
+	}}
+	d, err := NewDNS64(&Args{Exclude: []string{"10.0.0.0/8"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := exec(t, d, next, "v4.example.", dns.TypeAAAA)
+	if len(r.Answer) != 1 {
+		t.Fatalf("want one synthesized answer, got %v", r)
+	}
+	aaaa := r.Answer[0].(*dns.AAAA)
+	if got, _ := netip.AddrFromSlice(aaaa.AAAA); got != netip.MustParseAddr("2001:db8:64::c000:201") {
+		t.Fatalf("unexpected synthesized addr %s", got)
+	}
+	if aaaa.Hdr.Ttl != 60 {
+		t.Fatalf("unexpected ttl %d", aaaa.Hdr.Ttl)
+	}
+
+	// The discovered prefix is cached.
+	next.queries = 0
+	exec(t, d, next, "v4.example.", dns.TypeAAAA)
+	if next.queries != 2 {
+		t.Fatalf("want 2 queries, got %d", next.queries)
+	}
+
+	if r := exec(t, d, next, "private.example.", dns.TypeAAAA); len(r.Answer) != 0 {
+		t.Fatalf("excluded addr should not be synthesized, got %v", r)
+	}
+	r = exec(t, d, next, "dual.example.", dns.TypeAAAA)
+	if len(r.Answer) != 1 || r.Answer[0].(*dns.AAAA).AAAA.String() != "2001:db8::2" {
+		t.Fatalf("native AAAA should be kept, got %v", r)
+	}
+
+	ptrName, _ := dns.ReverseAddr("2001:db8:64::c000:201")
+	r = exec(t, d, next, ptrName, dns.TypePTR)
+	if len(r.Answer) != 2 {
+		t.Fatalf("want CNAME and PTR, got %v", r)
+	}
+	if cname := r.Answer[0].(*dns.CNAME); cname.Target != "1.2.0.192.in-addr.arpa." {
+		t.Fatalf("unexpected cname %s", cname)
+	}
+	if ptr := r.Answer[1].(*dns.PTR); ptr.Ptr != "v4.example." {
+		t.Fatalf("unexpected ptr %s", ptr)
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	// Examples from RFC 6052 section 2.4.
+	v4 := netip.MustParseAddr("192.0.2.33")
+	for prefix, want := range map[string]string{
+		"2001:db8::/32":         "2001:db8:c000:221::",
+		"2001:db8:100::/40":     "2001:db8:1c0:2:21::",
+		"2001:db8:122::/48":     "2001:db8:122:c000:2:2100::",
+		"2001:db8:122:300::/56": "2001:db8:122:3c0:0:221::",
+		"2001:db8:122:344::/64": "2001:db8:122:344:c0:2:2100:0",
+		"2001:db8:122:344::/96": "2001:db8:122:344::192.0.2.33",
+	} {
+		p, err := parsePrefix(prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := embed(p, v4)
+		if got != netip.MustParseAddr(want) {
+			t.Fatalf("%s: want %s, got %s", prefix, want, got)
+		}
+		if back, ok := extract(p, got); !ok || back != v4 {
+			t.Fatalf("%s: extract failed, got %s", prefix, back)
+		}
+	}
+	if _, err := parsePrefix("64:ff9b::/80"); err == nil {
+		t.Fatal("want error for invalid prefix length")
+	}
+}
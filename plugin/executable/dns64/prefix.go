@@ -0,0 +1,115 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dns64
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/miekg/dns"
+)
+
+// Well-known IPv4 addresses of ipv4only.arpa. See RFC 7050.
+var wkas = [...]netip.Addr{
+	netip.AddrFrom4([4]byte{192, 0, 0, 170}),
+	netip.AddrFrom4([4]byte{192, 0, 0, 171}),
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if !p.Addr().Is6() || p.Addr().Is4In6() {
+		return netip.Prefix{}, fmt.Errorf("%s is not an ipv6 prefix", s)
+	}
+	if !validPrefixLen(p.Bits()) {
+		return netip.Prefix{}, fmt.Errorf("invalid prefix length %d, must be one of 32, 40, 48, 56, 64 or 96", p.Bits())
+	}
+	return p.Masked(), nil
+}
+
+func validPrefixLen(l int) bool {
+	switch l {
+	case 32, 40, 48, 56, 64, 96:
+		return true
+	}
+	return false
+}
+
+// embed embeds v4 into prefix p as RFC 6052 section 2.2.
+// Bits 64 to 71 are skipped and left zero.
+func embed(p netip.Prefix, v4 netip.Addr) netip.Addr {
+	b := p.Addr().As16()
+	i := p.Bits() / 8
+	for _, x := range v4.As4() {
+		if i == 8 {
+			i++
+		}
+		b[i] = x
+		i++
+	}
+	return netip.AddrFrom16(b)
+}
+
+// extract returns the ipv4 address that is embedded in addr.
+// ok is false if addr is not in prefix p.
+func extract(p netip.Prefix, addr netip.Addr) (v4 netip.Addr, ok bool) {
+	if !addr.Is6() || !p.Contains(addr) {
+		return netip.Addr{}, false
+	}
+	b := addr.As16()
+	var out [4]byte
+	i := p.Bits() / 8
+	for j := range out {
+		if i == 8 {
+			i++
+		}
+		out[j] = b[i]
+		i++
+	}
+	return netip.AddrFrom4(out), true
+}
+
+// discoverPrefix finds the NAT64 prefix from a AAAA response of
+// ipv4only.arpa. See RFC 7050 section 3.
+func discoverPrefix(r *dns.Msg) (netip.Prefix, uint32, bool) {
+	for _, rr := range r.Answer {
+		aaaa, ok := rr.(*dns.AAAA)
+		if !ok {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(aaaa.AAAA)
+		if !ok || !addr.Is6() {
+			continue
+		}
+		// Try longer prefixes first.
+		for _, l := range [...]int{96, 64, 56, 48, 40, 32} {
+			p, _ := addr.Prefix(l)
+			v4, _ := extract(p, addr)
+			for _, wka := range wkas {
+				if v4 == wka {
+					return p, aaaa.Hdr.Ttl, true
+				}
+			}
+		}
+	}
+	return netip.Prefix{}, 0, false
+}
@@ -0,0 +1,187 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnssec_validate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/dnsutils"
+	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/domain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const PluginType = "dnssec_validate"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+var _ sequence.RecursiveExecutable = (*DnssecValidate)(nil)
+
+// Default trust anchors, the DS of root KSK-2017 and KSK-2024.
+var defaultTrustAnchors = []string{
+	". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBB683457104237C7F8EC8D",
+	". IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+type Args struct {
+	// TrustAnchors are DS or DNSKEY records in zone file format.
+	// Default is the root trust anchors.
+	TrustAnchors []string `yaml:"trust_anchors"`
+	// NegativeTrustAnchors are domains that will not be validated.
+	NegativeTrustAnchors []string `yaml:"negative_trust_anchors"`
+	// CacheSize is the size of the validated key cache.
+	CacheSize int `yaml:"cache_size"`
+}
+
+func (a *Args) init() {
+	if len(a.TrustAnchors) == 0 {
+		a.TrustAnchors = defaultTrustAnchors
+	}
+	utils.SetDefaultNum(&a.CacheSize, 1024)
+}
+
+type DnssecValidate struct {
+	v      *validator
+	nta    *domain.SubDomainMatcher[struct{}]
+	logger *zap.Logger
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return NewDnssecValidate(args.(*Args), bp.L())
+}
+
+func NewDnssecValidate(args *Args, logger *zap.Logger) (*DnssecValidate, error) {
+	args.init()
+	anchors := make(map[string][]*dns.DS)
+	for i, s := range args.TrustAnchors {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trust anchor #%d, %w", i, err)
+		}
+		var ds *dns.DS
+		switch rr := rr.(type) {
+		case *dns.DS:
+			ds = rr
+		case *dns.DNSKEY:
+			ds = rr.ToDS(dns.SHA256)
+		default:
+			return nil, fmt.Errorf("trust anchor #%d is not a DS or DNSKEY record", i)
+		}
+		if ds == nil {
+			return nil, fmt.Errorf("invalid trust anchor #%d", i)
+		}
+		zone := dns.CanonicalName(ds.Hdr.Name)
+		anchors[zone] = append(anchors[zone], ds)
+	}
+
+	nta := domain.NewSubDomainMatcher[struct{}]()
+	for _, s := range args.NegativeTrustAnchors {
+		if err := nta.Add(s, struct{}{}); err != nil {
+			return nil, fmt.Errorf("invalid negative trust anchor %s, %w", s, err)
+		}
+	}
+
+	return &DnssecValidate{
+		v:      newValidator(anchors, args.CacheSize),
+		nta:    nta,
+		logger: logger,
+	}, nil
+}
+
+// Exec implements sequence.RecursiveExecutable.
+// It sets the DO bit on the query, validates the response and sets the AD bit
+// if the response is secure. Bogus responses will be replaced by SERVFAIL.
+func (d *DnssecValidate) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	q := qCtx.Q()
+	if len(q.Question) != 1 {
+		return next.ExecNext(ctx, qCtx)
+	}
+	if _, ok := d.nta.Match(q.Question[0].Name); ok {
+		return next.ExecNext(ctx, qCtx)
+	}
+
+	clientOpt := q.IsEdns0()
+	clientDO := clientOpt != nil && clientOpt.Do()
+	clientCD := q.CheckingDisabled
+	dnsutils.UpgradeEDNS0(q).SetDo()
+
+	err := next.ExecNext(ctx, qCtx)
+	r := qCtx.R()
+	if err != nil || r == nil {
+		return err
+	}
+
+	if !clientCD {
+		sub := func(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+			return subQuery(ctx, qCtx, next, name, qtype)
+		}
+		s, err := d.v.validate(ctx, r, sub)
+		switch s {
+		case secure:
+			r.AuthenticatedData = true
+		case insecure:
+			r.AuthenticatedData = false
+		case bogus:
+			d.logger.Warn("bogus response", qCtx.InfoField(), zap.Error(err))
+			r = new(dns.Msg)
+			r.SetRcode(q, dns.RcodeServerFailure)
+			qCtx.SetResponse(r)
+		}
+	}
+
+	if !clientDO {
+		dnsutils.StripDNSSEC(r, q.Question[0].Qtype)
+	}
+	if clientOpt == nil {
+		dnsutils.RemoveEDNS0(q)
+		dnsutils.RemoveEDNS0(r)
+	} else if !clientDO {
+		clientOpt.SetDo(false)
+		if opt := r.IsEdns0(); opt != nil {
+			opt.SetDo(false)
+		}
+	}
+	return nil
+}
+
+// subQuery sends a query through next with the checking disabled bit set.
+func subQuery(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker, name string, qtype uint16) (*dns.Msg, error) {
+	subCtx := qCtx.Copy()
+	q := subCtx.Q()
+	q.SetQuestion(name, qtype)
+	q.CheckingDisabled = true
+	dnsutils.UpgradeEDNS0(q).SetDo()
+	subCtx.SetResponse(nil)
+	if err := next.ExecNext(ctx, subCtx); err != nil {
+		return nil, err
+	}
+	r := subCtx.R()
+	if r == nil {
+		return nil, fmt.Errorf("no response for %s %s", name, dns.TypeToString[qtype])
+	}
+	return r, nil
+}
@@ -0,0 +1,548 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnssec_validate
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+type testZone struct {
+	name    string
+	key     *dns.DNSKEY
+	priv    crypto.Signer
+	records []dns.RR // signed records, include RRSIGs
+}
+
+func newTestZone(t *testing.T, name string, signed bool, records ...string) *testZone {
+	z := &testZone{name: name}
+	var rrs []dns.RR
+	for _, s := range records {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rrs = append(rrs, rr)
+	}
+	if !signed {
+		z.records = rrs
+		return z
+	}
+
+	z.key = &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := z.key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.priv = priv.(crypto.Signer)
+	rrs = append(rrs, z.key)
+
+	sets, _ := splitRRsets(rrs)
+	for _, set := range sets {
+		z.records = append(z.records, set...)
+		if set[0].Header().Rrtype == dns.TypeNS && !equalName(set[0].Header().Name, name) {
+			continue // delegations are not signed
+		}
+		z.records = append(z.records, z.sign(t, set))
+	}
+	return z
+}
+
+func (z *testZone) sign(t *testing.T, set []dns.RR) *dns.RRSIG {
+	h := set[0].Header()
+	sig := &dns.RRSIG{
+		Hdr:         dns.RR_Header{Name: h.Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: h.Ttl},
+		TypeCovered: h.Rrtype,
+		Algorithm:   z.key.Algorithm,
+		Labels:      uint8(ownerLabels(h.Name)),
+		OrigTtl:     h.Ttl,
+		Expiration:  uint32(time.Now().Add(time.Hour).Unix()),
+		Inception:   uint32(time.Now().Add(-time.Hour).Unix()),
+		KeyTag:      z.key.KeyTag(),
+		SignerName:  z.name,
+	}
+	if err := sig.Sign(z.priv, set); err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func (z *testZone) ds() string {
+	return z.key.ToDS(dns.SHA256).String()
+}
+
+// fakeResolver answers queries from zones like a non-validating recursive resolver.
+type fakeResolver struct {
+	zones []*testZone
+}
+
+func (f *fakeResolver) Exec(_ context.Context, qCtx *query_context.Context) error {
+	q := qCtx.Q()
+	r := new(dns.Msg)
+	r.SetReply(q)
+	question := q.Question[0]
+	for i := 0; i < 8; i++ {
+		target := f.lookup(r, question)
+		if len(target) == 0 {
+			break
+		}
+		question.Name = target
+	}
+	qCtx.SetResponse(r)
+	return nil
+}
+
+// lookup appends records of question to r. It returns the cname target
+// if question.Name is an alias.
+func (f *fakeResolver) lookup(r *dns.Msg, question dns.Question) string {
+
+	// DS records are served by the parent zone.
+	var zone *testZone
+	for _, z := range f.zones {
+		if !dns.IsSubDomain(z.name, question.Name) {
+			continue
+		}
+		if question.Qtype == dns.TypeDS && equalName(z.name, question.Name) {
+			continue
+		}
+		if zone == nil || len(z.name) > len(zone.name) {
+			zone = z
+		}
+	}
+
+	// Synthesize the answer from a wildcard if the name does not exist.
+	owner := question.Name
+	if !zone.has(owner) {
+		for anc := parentName(owner); dns.IsSubDomain(zone.name, anc); anc = parentName(anc) {
+			if wc := wildcardName(anc); zone.has(wc) {
+				owner = wc
+				break
+			}
+			if anc == "." {
+				break
+			}
+		}
+	}
+	expand := func(rr dns.RR) dns.RR {
+		if owner == question.Name {
+			return rr
+		}
+		rr = dns.Copy(rr)
+		rr.Header().Name = question.Name
+		return rr
+	}
+
+	exist := false
+	var target string
+	found := false
+	for _, rr := range zone.records {
+		h := rr.Header()
+		if !equalName(h.Name, owner) {
+			continue
+		}
+		exist = true
+		if h.Rrtype == question.Qtype || h.Rrtype == dns.TypeCNAME {
+			r.Answer = append(r.Answer, expand(rr))
+			found = true
+		}
+		if cname, ok := rr.(*dns.CNAME); ok {
+			target = cname.Target
+		}
+		if sig, ok := rr.(*dns.RRSIG); ok && (sig.TypeCovered == question.Qtype || sig.TypeCovered == dns.TypeCNAME) {
+			r.Answer = append(r.Answer, expand(rr))
+		}
+	}
+	if !found || owner != question.Name {
+		if !exist {
+			r.Rcode = dns.RcodeNameError
+		}
+		for _, rr := range zone.records {
+			switch rr := rr.(type) {
+			case *dns.SOA:
+				if !found {
+					r.Ns = append(r.Ns, rr)
+				}
+			case *dns.NSEC:
+				r.Ns = append(r.Ns, rr)
+			case *dns.RRSIG:
+				if (rr.TypeCovered == dns.TypeSOA && !found) || rr.TypeCovered == dns.TypeNSEC {
+					r.Ns = append(r.Ns, rr)
+				}
+			}
+		}
+	}
+	return target
+}
+
+func (z *testZone) has(name string) bool {
+	for _, rr := range z.records {
+		if equalName(rr.Header().Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterRRs returns rrs without records that match drop.
+func filterRRs(rrs []dns.RR, drop func(rr dns.RR) bool) []dns.RR {
+	var s []dns.RR
+	for _, rr := range rrs {
+		if !drop(rr) {
+			s = append(s, rr)
+		}
+	}
+	return s
+}
+
+// isProofOf reports whether rr is an NSEC of owner or its signature.
+func isProofOf(rr dns.RR, owner string) bool {
+	if !equalName(rr.Header().Name, owner) {
+		return false
+	}
+	switch rr := rr.(type) {
+	case *dns.NSEC:
+		return true
+	case *dns.RRSIG:
+		return rr.TypeCovered == dns.TypeNSEC
+	}
+	return false
+}
+
+func TestDnssecValidate_Exec(t *testing.T) {
+	example := newTestZone(t, "example.", true,
+		"example. 3600 IN SOA ns.example. admin.example. 1 1800 900 604800 300",
+		"www.example. 300 IN A 192.0.2.1",
+		"alias.example. 300 IN CNAME www.insecure.",
+		"example. 300 IN NSEC alias.example. SOA RRSIG NSEC DNSKEY",
+		"alias.example. 300 IN NSEC www.example. CNAME RRSIG NSEC",
+		"www.example. 300 IN NSEC example. A RRSIG NSEC",
+	)
+	insecureZone := newTestZone(t, "insecure.", false,
+		"insecure. 3600 IN SOA ns.insecure. admin.insecure. 1 1800 900 604800 300",
+		"www.insecure. 300 IN A 192.0.2.2",
+	)
+	wild := newTestZone(t, "wild.", true,
+		"wild. 3600 IN SOA ns.wild. admin.wild. 1 1800 900 604800 300",
+		"*.wild. 300 IN TXT wildcard",
+		"www.wild. 300 IN A 192.0.2.3",
+		"wild. 300 IN NSEC *.wild. SOA RRSIG NSEC DNSKEY",
+		"*.wild. 300 IN NSEC www.wild. TXT RRSIG NSEC",
+		"www.wild. 300 IN NSEC wild. A RRSIG NSEC",
+	)
+	root := newTestZone(t, ".", true,
+		". 86400 IN SOA a.root. admin. 1 1800 900 604800 86400",
+		"example. 86400 IN NS ns.example.",
+		example.ds(),
+		"insecure. 86400 IN NS ns.insecure.",
+		"wild. 86400 IN NS ns.wild.",
+		wild.ds(),
+		". 86400 IN NSEC example. SOA RRSIG NSEC DNSKEY",
+		"example. 86400 IN NSEC insecure. NS DS RRSIG NSEC",
+		"insecure. 86400 IN NSEC wild. NS RRSIG NSEC",
+		"wild. 86400 IN NSEC . NS DS RRSIG NSEC",
+	)
+	next := &fakeResolver{zones: []*testZone{root, example, insecureZone, wild}}
+
+	tests := []struct {
+		name      string
+		qname     string
+		qtype     uint16
+		nta       []string
+		tamper    func(r *dns.Msg)
+		wantRcode int
+		wantAD    bool
+	}{
+		{name: "secure", qname: "www.example.", qtype: dns.TypeA, wantAD: true},
+		{name: "secure_nxdomain", qname: "nx.example.", qtype: dns.TypeA, wantRcode: dns.RcodeNameError, wantAD: true},
+		{name: "secure_nodata", qname: "www.example.", qtype: dns.TypeAAAA, wantAD: true},
+		{name: "insecure", qname: "www.insecure.", qtype: dns.TypeA},
+		{name: "secure_to_insecure_cname", qname: "alias.example.", qtype: dns.TypeA},
+		{
+			name: "bogus_tampered", qname: "www.example.", qtype: dns.TypeA, wantRcode: dns.RcodeServerFailure,
+			tamper: func(r *dns.Msg) { r.Answer[0].(*dns.A).A = net.IPv4(1, 1, 1, 1) },
+		},
+		{
+			name: "bogus_stripped", qname: "www.example.", qtype: dns.TypeA, wantRcode: dns.RcodeServerFailure,
+			tamper: func(r *dns.Msg) { r.Answer = r.Answer[:1] },
+		},
+		{name: "secure_wildcard", qname: "a.wild.", qtype: dns.TypeTXT, wantAD: true},
+		{name: "secure_wildcard_nodata", qname: "a.wild.", qtype: dns.TypeAAAA, wantAD: true},
+		{
+			name: "bogus_wildcard_no_proof", qname: "a.wild.", qtype: dns.TypeTXT, wantRcode: dns.RcodeServerFailure,
+			tamper: func(r *dns.Msg) { r.Ns = nil },
+		},
+		{
+			// The expanded answer is replayed onto a name that exists.
+			name: "bogus_wildcard_replayed", qname: "www.wild.", qtype: dns.TypeTXT, wantRcode: dns.RcodeServerFailure,
+			tamper: func(r *dns.Msg) {
+				replay := new(dns.Msg)
+				next.lookup(replay, dns.Question{Name: "a.wild.", Qtype: dns.TypeTXT, Qclass: dns.ClassINET})
+				for _, rr := range replay.Answer {
+					rr.Header().Name = "www.wild."
+				}
+				r.Rcode = dns.RcodeSuccess
+				r.Answer, r.Ns = replay.Answer, replay.Ns
+			},
+		},
+		{
+			// The nsec that covers *.example. is removed.
+			name: "bogus_nxdomain_no_wildcard_proof", qname: "nx.example.", qtype: dns.TypeA, wantRcode: dns.RcodeServerFailure,
+			tamper: func(r *dns.Msg) {
+				r.Ns = filterRRs(r.Ns, func(rr dns.RR) bool { return isProofOf(rr, "example.") })
+			},
+		},
+		{
+			// A covering nsec does not prove that a name has no data.
+			name: "bogus_forged_nodata", qname: "nx.example.", qtype: dns.TypeA, wantRcode: dns.RcodeServerFailure,
+			tamper: func(r *dns.Msg) { r.Rcode = dns.RcodeSuccess },
+		},
+		{
+			name: "nta", qname: "www.example.", qtype: dns.TypeA, nta: []string{"example"},
+			tamper: func(r *dns.Msg) { r.Answer = r.Answer[:1] },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := NewDnssecValidate(&Args{
+				TrustAnchors:         []string{root.key.String()},
+				NegativeTrustAnchors: tt.nta,
+			}, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var last sequence.Executable = next
+			if tt.tamper != nil {
+				last = sequence.ExecutableFunc(func(ctx context.Context, qCtx *query_context.Context) error {
+					_ = next.Exec(ctx, qCtx)
+					if q := qCtx.Q(); equalName(q.Question[0].Name, tt.qname) && q.Question[0].Qtype == tt.qtype {
+						tt.tamper(qCtx.R())
+					}
+					return nil
+				})
+			}
+
+			q := new(dns.Msg)
+			q.SetQuestion(tt.qname, tt.qtype)
+			qCtx := query_context.NewContext(q)
+			cw := sequence.NewChainWalker([]*sequence.ChainNode{{E: last}}, nil)
+			if err := d.Exec(context.Background(), qCtx, cw); err != nil {
+				t.Fatal(err)
+			}
+			r := qCtx.R()
+			if r.Rcode != tt.wantRcode {
+				t.Fatalf("want rcode %d, got %d, resp: %s", tt.wantRcode, r.Rcode, r)
+			}
+			if r.AuthenticatedData != tt.wantAD {
+				t.Fatalf("want ad %v, got %v, resp: %s", tt.wantAD, r.AuthenticatedData, r)
+			}
+			for _, rr := range r.Answer {
+				if rr.Header().Rrtype == dns.TypeRRSIG {
+					t.Fatal("rrsig was not stripped")
+				}
+			}
+			if r.IsEdns0() != nil {
+				t.Fatal("opt was not removed")
+			}
+		})
+	}
+}
+
+func Test_canonicalCompare(t *testing.T) {
+	ordered := []string{"example.", "a.example.", "yljkjljk.a.example.", "Z.a.example.", "zABC.a.EXAMPLE.", "z.example.", "*.z.example."}
+	for i := 0; i < len(ordered)-1; i++ {
+		if canonicalCompare(ordered[i], ordered[i+1]) >= 0 {
+			t.Errorf("%s should be before %s", ordered[i], ordered[i+1])
+		}
+	}
+}
+
+func mustRRs(t *testing.T, records ...string) []dns.RR {
+	t.Helper()
+	var rrs []dns.RR
+	for _, s := range records {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs
+}
+
+// nsec3Chain builds the NSEC3 chain of zone. names maps owner names to
+// their types.
+func nsec3Chain(t *testing.T, zone string, flags uint8, names map[string]string) []dns.RR {
+	t.Helper()
+	type entry struct {
+		hash, types string
+	}
+	var entries []entry
+	for name, types := range names {
+		entries = append(entries, entry{hash: dns.HashName(name, dns.SHA1, 0, ""), types: types})
+	}
+	slices.SortFunc(entries, func(a, b entry) int { return strings.Compare(a.hash, b.hash) })
+	var rrs []dns.RR
+	for i, e := range entries {
+		next := entries[(i+1)%len(entries)].hash
+		rrs = append(rrs, mustRRs(t, fmt.Sprintf("%s.%s 300 IN NSEC3 1 %d 0 - %s %s", strings.ToLower(e.hash), zone, flags, next, e.types))...)
+	}
+	return rrs
+}
+
+// pickNSEC3 returns records in rrs that match or cover the names.
+func pickNSEC3(rrs []dns.RR, match []string, cover []string) []dns.RR {
+	var s []dns.RR
+	for _, rr := range rrs {
+		n := rr.(*dns.NSEC3)
+		ok := slices.ContainsFunc(match, n.Match) || slices.ContainsFunc(cover, n.Cover)
+		if ok && !slices.Contains(s, rr) {
+			s = append(s, rr)
+		}
+	}
+	return s
+}
+
+func Test_checkDenial(t *testing.T) {
+	nsecs := mustRRs(t,
+		"example. 300 IN NSEC a.example. SOA NS RRSIG NSEC DNSKEY",
+		"a.example. 300 IN NSEC b.c.example. A RRSIG NSEC",
+		"b.c.example. 300 IN NSEC d.example. A RRSIG NSEC",
+		"d.example. 300 IN NSEC *.w.example. NS RRSIG NSEC",
+		"*.w.example. 300 IN NSEC example. TXT RRSIG NSEC",
+	)
+	nsec3s := nsec3Chain(t, "example.", 0, map[string]string{
+		"example.":     "SOA NS RRSIG DNSKEY NSEC3PARAM",
+		"a.example.":   "A RRSIG",
+		"d.example.":   "NS",
+		"w.example.":   "",
+		"*.w.example.": "TXT RRSIG",
+	})
+	optOut := nsec3Chain(t, "example.", 1, map[string]string{
+		"example.":   "SOA NS RRSIG DNSKEY NSEC3PARAM",
+		"a.example.": "A RRSIG",
+	})
+
+	tests := []struct {
+		name    string
+		rcode   int
+		qname   string
+		qtype   uint16
+		rrs     []dns.RR
+		wantErr error
+	}{
+		{name: "nsec_nxdomain", rcode: dns.RcodeNameError, qname: "nx.example.", qtype: dns.TypeA, rrs: nsecs},
+		{name: "nsec_nxdomain_no_wildcard", rcode: dns.RcodeNameError, qname: "nx.example.", qtype: dns.TypeA, rrs: nsecs[3:4], wantErr: errNoWildcardProof},
+		{name: "nsec_nxdomain_exist", rcode: dns.RcodeNameError, qname: "a.example.", qtype: dns.TypeA, rrs: nsecs, wantErr: errBadDenial},
+		{name: "nsec_nxdomain_ent", rcode: dns.RcodeNameError, qname: "c.example.", qtype: dns.TypeA, rrs: nsecs, wantErr: errBadDenial},
+		{name: "nsec_nxdomain_below_delegation", rcode: dns.RcodeNameError, qname: "x.d.example.", qtype: dns.TypeA, rrs: nsecs[3:4], wantErr: errBadDenial},
+		{name: "nsec_nodata", qname: "a.example.", qtype: dns.TypeAAAA, rrs: nsecs},
+		{name: "nsec_nodata_type_exist", qname: "a.example.", qtype: dns.TypeA, rrs: nsecs, wantErr: errBadDenial},
+		{name: "nsec_nodata_covered", qname: "nx.example.", qtype: dns.TypeA, rrs: nsecs, wantErr: errBadDenial},
+		{name: "nsec_nodata_ent", qname: "c.example.", qtype: dns.TypeA, rrs: nsecs},
+		{name: "nsec_nodata_wildcard", qname: "x.w.example.", qtype: dns.TypeA, rrs: nsecs},
+		{name: "nsec_nodata_wildcard_type_exist", qname: "x.w.example.", qtype: dns.TypeTXT, rrs: nsecs, wantErr: errBadDenial},
+		{name: "nsec_no_ds", qname: "d.example.", qtype: dns.TypeDS, rrs: nsecs},
+		{name: "nsec_delegation_nodata", qname: "d.example.", qtype: dns.TypeA, rrs: nsecs, wantErr: errBadDenial},
+		{name: "nsec_no_ds_from_child", qname: "example.", qtype: dns.TypeDS, rrs: nsecs, wantErr: errBadDenial},
+		{name: "no_proof", qname: "a.example.", qtype: dns.TypeAAAA, wantErr: errNoProof},
+
+		{name: "nsec3_nxdomain", rcode: dns.RcodeNameError, qname: "nx.example.", qtype: dns.TypeA, rrs: nsec3s},
+		{
+			// The hash of e.example. is not in the interval that covers *.example.
+			name: "nsec3_nxdomain_no_wildcard", rcode: dns.RcodeNameError, qname: "e.example.", qtype: dns.TypeA,
+			rrs: pickNSEC3(nsec3s, []string{"example."}, []string{"e.example."}), wantErr: errNoWildcardProof,
+		},
+		{
+			name: "nsec3_nxdomain_no_closest_encloser", rcode: dns.RcodeNameError, qname: "nx.example.", qtype: dns.TypeA,
+			rrs: pickNSEC3(nsec3s, nil, []string{"nx.example.", "*.example."}), wantErr: errNoClosestEncloser,
+		},
+		{name: "nsec3_nxdomain_below_delegation", rcode: dns.RcodeNameError, qname: "x.d.example.", qtype: dns.TypeA, rrs: nsec3s, wantErr: errNoClosestEncloser},
+		{name: "nsec3_nxdomain_exist", rcode: dns.RcodeNameError, qname: "a.example.", qtype: dns.TypeA, rrs: nsec3s, wantErr: errBadDenial},
+		{name: "nsec3_nodata", qname: "a.example.", qtype: dns.TypeAAAA, rrs: nsec3s},
+		{name: "nsec3_nodata_type_exist", qname: "a.example.", qtype: dns.TypeA, rrs: nsec3s, wantErr: errBadDenial},
+		{name: "nsec3_nodata_covered", qname: "nx.example.", qtype: dns.TypeA, rrs: nsec3s, wantErr: errBadDenial},
+		{name: "nsec3_nodata_wildcard", qname: "x.w.example.", qtype: dns.TypeA, rrs: nsec3s},
+		{name: "nsec3_nodata_wildcard_type_exist", qname: "x.w.example.", qtype: dns.TypeTXT, rrs: nsec3s, wantErr: errBadDenial},
+		{name: "nsec3_no_ds", qname: "d.example.", qtype: dns.TypeDS, rrs: nsec3s},
+		{name: "nsec3_no_ds_opt_out", qname: "z.example.", qtype: dns.TypeDS, rrs: optOut},
+		{name: "nsec3_no_ds_without_opt_out", qname: "z.example.", qtype: dns.TypeDS, rrs: nsec3s, wantErr: errBadDenial},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sets, _ := splitRRsets(tt.rrs)
+			if err := checkDenial(tt.rcode, tt.qname, tt.qtype, sets); err != tt.wantErr {
+				t.Fatalf("want err %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_checkWildcardAnswer(t *testing.T) {
+	nsecs := mustRRs(t,
+		"example. 300 IN NSEC *.example. SOA NS RRSIG NSEC DNSKEY",
+		"*.example. 300 IN NSEC www.example. TXT RRSIG NSEC",
+		"www.example. 300 IN NSEC example. A RRSIG NSEC",
+	)
+	nsec3s := nsec3Chain(t, "example.", 0, map[string]string{
+		"example.":     "SOA NS RRSIG DNSKEY NSEC3PARAM",
+		"*.example.":   "TXT RRSIG",
+		"www.example.": "A RRSIG",
+	})
+
+	tests := []struct {
+		name    string
+		qname   string
+		ce      string
+		rrs     []dns.RR
+		wantErr error
+	}{
+		{name: "nsec", qname: "a.example.", ce: "example.", rrs: nsecs},
+		{name: "nsec_name_exist", qname: "www.example.", ce: "example.", rrs: nsecs, wantErr: errNoWildcardProof},
+		{name: "nsec_wrong_encloser", qname: "a.b.example.", ce: "b.example.", rrs: nsecs, wantErr: errNoWildcardProof},
+		{name: "nsec3", qname: "a.b.example.", ce: "example.", rrs: nsec3s},
+		{name: "nsec3_name_exist", qname: "www.example.", ce: "example.", rrs: nsec3s, wantErr: errNoWildcardProof},
+		{name: "no_proof", qname: "a.example.", ce: "example.", wantErr: errNoWildcardProof},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sets, _ := splitRRsets(tt.rrs)
+			if err := checkWildcardAnswer(tt.qname, tt.ce, sets); err != tt.wantErr {
+				t.Fatalf("want err %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnssec_validate
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// proofs holds the verified NSEC and NSEC3 records of a response.
+type proofs struct {
+	nsecs  []*dns.NSEC
+	nsec3s []*dns.NSEC3
+}
+
+func newProofs(sets [][]dns.RR) *proofs {
+	p := new(proofs)
+	for _, set := range sets {
+		for _, rr := range set {
+			switch rr := rr.(type) {
+			case *dns.NSEC:
+				p.nsecs = append(p.nsecs, rr)
+			case *dns.NSEC3:
+				p.nsec3s = append(p.nsec3s, rr)
+			}
+		}
+	}
+	return p
+}
+
+// checkDenial checks whether the NSEC or NSEC3 records in sets prove
+// that name does not exist (rcode is NXDOMAIN) or does not have qtype.
+// Signatures of the records must have been verified.
+// See RFC 4035 5.4 and RFC 5155 8.4-8.7.
+func checkDenial(rcode int, name string, qtype uint16, sets [][]dns.RR) error {
+	p := newProofs(sets)
+	switch {
+	case len(p.nsecs) > 0:
+		return p.nsecDenial(rcode, name, qtype)
+	case len(p.nsec3s) > 0:
+		return p.nsec3Denial(rcode, name, qtype)
+	default:
+		return errNoProof
+	}
+}
+
+// checkWildcardAnswer checks whether the NSEC or NSEC3 records in sets
+// prove that name, which was expanded from the wildcard at the closest
+// encloser ce, does not exist. See RFC 4035 5.3.4 and RFC 5155 8.8.
+func checkWildcardAnswer(name, ce string, sets [][]dns.RR) error {
+	p := newProofs(sets)
+	for _, n := range p.nsecs {
+		if nsecProvesNoName(n, name) && equalName(nsecClosestEncloser(n, name), ce) {
+			return nil
+		}
+	}
+	if p.nsec3Cover(nextCloser(name, ce)) != nil {
+		return nil
+	}
+	return errNoWildcardProof
+}
+
+func (p *proofs) nsecDenial(rcode int, name string, qtype uint16) error {
+	for _, n := range p.nsecs {
+		if equalName(n.Hdr.Name, name) {
+			if rcode == dns.RcodeNameError {
+				return errBadDenial // name exists
+			}
+			if !typeDenied(n.TypeBitMap, name, qtype) {
+				return errBadDenial
+			}
+			return nil
+		}
+	}
+
+	var cover *dns.NSEC
+	for _, n := range p.nsecs {
+		if nsecProvesNoName(n, name) {
+			cover = n
+			break
+		}
+	}
+	if cover == nil {
+		return errBadDenial
+	}
+	if isStrictSubDomain(cover.NextDomain, name) { // empty non-terminal
+		if rcode == dns.RcodeNameError {
+			return errBadDenial
+		}
+		return nil
+	}
+
+	// The wildcard at the closest encloser must not exist (NXDOMAIN),
+	// or must not have qtype (wildcard NODATA).
+	wc := wildcardName(nsecClosestEncloser(cover, name))
+	if rcode == dns.RcodeNameError {
+		for _, n := range p.nsecs {
+			if nsecProvesNoName(n, wc) {
+				return nil
+			}
+		}
+		return errNoWildcardProof
+	}
+	for _, n := range p.nsecs {
+		if equalName(n.Hdr.Name, wc) && typeDenied(n.TypeBitMap, wc, qtype) {
+			return nil
+		}
+	}
+	return errBadDenial
+}
+
+func (p *proofs) nsec3Denial(rcode int, name string, qtype uint16) error {
+	if n := p.nsec3Match(name); n != nil {
+		if rcode == dns.RcodeNameError {
+			return errBadDenial // name exists
+		}
+		if !typeDenied(n.TypeBitMap, name, qtype) {
+			return errBadDenial
+		}
+		return nil
+	}
+
+	ce, nc, ok := p.nsec3ClosestEncloser(name)
+	if !ok {
+		return errNoClosestEncloser
+	}
+	wc := wildcardName(ce)
+	if rcode == dns.RcodeNameError {
+		if p.nsec3Cover(wc) != nil {
+			return nil
+		}
+		return errNoWildcardProof
+	}
+	// RFC 5155 8.6, an opt-out NSEC3 covers insecure delegations.
+	if qtype == dns.TypeDS && nc.Flags&1 == 1 {
+		return nil
+	}
+	if n := p.nsec3Match(wc); n != nil && typeDenied(n.TypeBitMap, wc, qtype) {
+		return nil
+	}
+	return errBadDenial
+}
+
+// nsec3ClosestEncloser finds the closest provable encloser of name, and the
+// NSEC3 that covers the next closer name. See RFC 5155 8.3.
+func (p *proofs) nsec3ClosestEncloser(name string) (ce string, nc *dns.NSEC3, ok bool) {
+	next := name
+	for off, end := dns.NextLabel(name, 0); ; off, end = dns.NextLabel(name, off) {
+		candidate := "."
+		if !end {
+			candidate = name[off:]
+		}
+		if n := p.nsec3Match(candidate); n != nil {
+			// A delegation or DNAME cannot be a closest encloser.
+			if (hasType(n.TypeBitMap, dns.TypeNS) && !hasType(n.TypeBitMap, dns.TypeSOA)) || hasType(n.TypeBitMap, dns.TypeDNAME) {
+				return "", nil, false
+			}
+			nc := p.nsec3Cover(next)
+			return candidate, nc, nc != nil
+		}
+		if end {
+			return "", nil, false
+		}
+		next = candidate
+	}
+}
+
+func (p *proofs) nsec3Match(name string) *dns.NSEC3 {
+	for _, n := range p.nsec3s {
+		if n.Match(name) {
+			return n
+		}
+	}
+	return nil
+}
+
+func (p *proofs) nsec3Cover(name string) *dns.NSEC3 {
+	for _, n := range p.nsec3s {
+		if n.Cover(name) {
+			return n
+		}
+	}
+	return nil
+}
+
+// nsecProvesNoName reports whether n proves that name does not exist.
+// An NSEC at a delegation point or a DNAME is from the parent side and
+// cannot prove anything below it.
+func nsecProvesNoName(n *dns.NSEC, name string) bool {
+	if !nsecCovers(n, name) {
+		return false
+	}
+	if isStrictSubDomain(name, n.Hdr.Name) {
+		if hasType(n.TypeBitMap, dns.TypeNS) && !hasType(n.TypeBitMap, dns.TypeSOA) || hasType(n.TypeBitMap, dns.TypeDNAME) {
+			return false
+		}
+	}
+	return true
+}
+
+// nsecClosestEncloser returns the closest encloser of name that does not
+// exist, and is covered by n. It is the longest common ancestor of name
+// and the owner or the next name of n.
+func nsecClosestEncloser(n *dns.NSEC, name string) string {
+	l := max(dns.CompareDomainName(name, n.Hdr.Name), dns.CompareDomainName(name, n.NextDomain))
+	return lastLabels(name, l)
+}
+
+// typeDenied reports whether an NSEC or NSEC3 type bitmap at name proves
+// that name has no qtype records.
+func typeDenied(bitmap []uint16, name string, qtype uint16) bool {
+	if hasType(bitmap, qtype) || hasType(bitmap, dns.TypeCNAME) {
+		return false
+	}
+	delegation := hasType(bitmap, dns.TypeNS) && !hasType(bitmap, dns.TypeSOA)
+	if qtype == dns.TypeDS {
+		// Must be from the parent side, except the root.
+		return !hasType(bitmap, dns.TypeSOA) || name == "."
+	}
+	// A delegation only proves the absence of DS.
+	return !delegation
+}
+
+// nsecCovers reports whether name is between the owner and next name of n.
+func nsecCovers(n *dns.NSEC, name string) bool {
+	owner, next := n.Hdr.Name, n.NextDomain
+	if canonicalCompare(owner, next) < 0 {
+		return canonicalCompare(owner, name) < 0 && canonicalCompare(name, next) < 0
+	}
+	// The last NSEC in the zone, next is the apex.
+	return canonicalCompare(owner, name) < 0 && dns.IsSubDomain(next, name)
+}
+
+// nextCloser returns the name that has one more label than its closest
+// encloser ce.
+func nextCloser(name, ce string) string {
+	return lastLabels(name, dns.CountLabel(ce)+1)
+}
+
+// lastLabels returns the last n labels of name.
+func lastLabels(name string, n int) string {
+	idx := dns.Split(name)
+	if n <= 0 || len(idx) == 0 {
+		return "."
+	}
+	if n >= len(idx) {
+		return name
+	}
+	return name[idx[len(idx)-n]:]
+}
+
+func wildcardName(ce string) string {
+	if ce == "." {
+		return "*."
+	}
+	return "*." + ce
+}
+
+// isStrictSubDomain reports whether child is a subdomain of parent but
+// not parent itself.
+func isStrictSubDomain(child, parent string) bool {
+	return dns.IsSubDomain(parent, child) && !equalName(parent, child)
+}
+
+func hasType(bitmap []uint16, t uint16) bool {
+	for _, b := range bitmap {
+		if b == t {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalCompare compares a and b in canonical order, see RFC 4034 6.1.
+func canonicalCompare(a, b string) int {
+	la := dns.SplitDomainName(strings.ToLower(a))
+	lb := dns.SplitDomainName(strings.ToLower(b))
+	for i := 1; i <= len(la) && i <= len(lb); i++ {
+		if c := strings.Compare(la[len(la)-i], lb[len(lb)-i]); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
@@ -0,0 +1,503 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnssec_validate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"strings"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/cache"
+	"github.com/miekg/dns"
+)
+
+type status int
+
+// Statuses are ordered. A response is as secure as its least secure part.
+const (
+	secure status = iota
+	insecure
+	bogus
+)
+
+const (
+	maxChainDepth    = 16
+	maxKeyCacheTTL   = 3600
+	insecureCacheTTL = 300
+)
+
+var (
+	errMaxDepth          = errors.New("maximum chain depth reached")
+	errNoProof           = errors.New("no denial of existence proof")
+	errBadDenial         = errors.New("denial of existence proof does not match")
+	errNoWildcardProof   = errors.New("no wildcard denial of existence proof")
+	errNoClosestEncloser = errors.New("no closest encloser proof")
+)
+
+var supportedAlgorithms = map[uint8]struct{}{
+	dns.RSASHA1:          {},
+	dns.RSASHA1NSEC3SHA1: {},
+	dns.RSASHA256:        {},
+	dns.RSASHA512:        {},
+	dns.ECDSAP256SHA256:  {},
+	dns.ECDSAP384SHA384:  {},
+	dns.ED25519:          {},
+}
+
+// queryFunc sends a query for name and qtype, with DO and CD bits set.
+type queryFunc func(ctx context.Context, name string, qtype uint16) (*dns.Msg, error)
+
+type key string
+
+var seed = maphash.MakeSeed()
+
+func (k key) Sum() uint64 {
+	return maphash.String(seed, string(k))
+}
+
+// zoneKeys is the validation result of a zone's DNSKEY RRset.
+type zoneKeys struct {
+	status status // secure or insecure
+	keys   []*dns.DNSKEY
+}
+
+type validator struct {
+	anchors map[string][]*dns.DS // canonical zone name -> DS
+	keys    *cache.Cache[key, *zoneKeys]
+	now     func() time.Time
+}
+
+func newValidator(anchors map[string][]*dns.DS, cacheSize int) *validator {
+	return &validator{
+		anchors: anchors,
+		keys:    cache.New[key, *zoneKeys](cache.Opts{Size: cacheSize}),
+		now:     time.Now,
+	}
+}
+
+// validate validates r. If r is bogus, a non-nil error describes why.
+func (v *validator) validate(ctx context.Context, r *dns.Msg, q queryFunc) (status, error) {
+	if len(r.Question) != 1 {
+		return insecure, nil
+	}
+	if r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+		return insecure, nil
+	}
+	question := r.Question[0]
+	qname := dns.CanonicalName(question.Name)
+
+	s := secure
+	type expansion struct{ name, ce string }
+	var expanded []expansion
+	sets, sigs := splitRRsets(r.Answer)
+	for _, set := range sets {
+		st, sig, err := v.verifyRRset(ctx, set, sigs, q, 0)
+		if st == bogus {
+			return bogus, err
+		}
+		s = max(s, st)
+		if ce, ok := wildcardEncloser(set[0].Header().Name, sig); ok {
+			expanded = append(expanded, expansion{name: dns.CanonicalName(set[0].Header().Name), ce: ce})
+		}
+	}
+
+	name, positive := chainEnd(r.Answer, qname, question.Qtype)
+	if positive && len(expanded) == 0 {
+		return s, nil
+	}
+
+	sets, sigs = splitRRsets(r.Ns)
+	var proofSets [][]dns.RR
+	proved := false
+	for _, set := range sets {
+		h := set[0].Header()
+		switch h.Rrtype {
+		case dns.TypeSOA, dns.TypeNSEC, dns.TypeNSEC3:
+		default:
+			continue
+		}
+		st, sig, err := v.verifyRRset(ctx, set, sigs, q, 0)
+		if st == bogus {
+			return bogus, err
+		}
+		s = max(s, st)
+		proved = true
+		if h.Rrtype == dns.TypeSOA || st != secure {
+			continue
+		}
+		if _, ok := wildcardEncloser(h.Name, sig); ok {
+			return bogus, fmt.Errorf("%s %s is expanded from a wildcard", h.Name, dns.TypeToString[h.Rrtype])
+		}
+		proofSets = append(proofSets, set)
+	}
+	if !proved && !positive { // no authority data at all, must be insecure
+		st, err := v.provenInsecure(ctx, name, q, 0)
+		return max(s, st), err
+	}
+	if s != secure {
+		return s, nil
+	}
+	// RFC 4035 5.3.4, a wildcard expansion is only valid if the
+	// queried name does not exist.
+	for _, e := range expanded {
+		if err := checkWildcardAnswer(e.name, e.ce, proofSets); err != nil {
+			return bogus, fmt.Errorf("wildcard answer of %s, %w", e.name, err)
+		}
+	}
+	if !positive {
+		if err := checkDenial(r.Rcode, name, question.Qtype, proofSets); err != nil {
+			return bogus, err
+		}
+	}
+	return s, nil
+}
+
+// verifyRRset verifies set with one of sigs. If set is secure, the
+// signature that verified it is returned.
+func (v *validator) verifyRRset(ctx context.Context, set []dns.RR, sigs []*dns.RRSIG, q queryFunc, depth int) (status, *dns.RRSIG, error) {
+	h := set[0].Header()
+	matched := sigsFor(sigs, h.Name, h.Rrtype)
+	if len(matched) == 0 {
+		st, err := v.provenInsecure(ctx, h.Name, q, depth+1)
+		if st == bogus && err == nil {
+			err = fmt.Errorf("missing signature for %s %s", h.Name, dns.TypeToString[h.Rrtype])
+		}
+		return st, nil, err
+	}
+
+	var lastErr error
+	for _, sig := range matched {
+		signer := dns.CanonicalName(sig.SignerName)
+		if !dns.IsSubDomain(signer, h.Name) {
+			lastErr = fmt.Errorf("signer %s is not a parent of %s", signer, h.Name)
+			continue
+		}
+		if int(sig.Labels) > ownerLabels(h.Name) {
+			lastErr = fmt.Errorf("invalid rrsig labels %d for %s", sig.Labels, h.Name)
+			continue
+		}
+		zk, err := v.zoneKeys(ctx, signer, q, depth+1)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if zk.status == insecure {
+			return insecure, nil, nil
+		}
+		if err := v.verifySig(sig, zk.keys, set); err != nil {
+			lastErr = fmt.Errorf("failed to verify %s %s, %w", h.Name, dns.TypeToString[h.Rrtype], err)
+			continue
+		}
+		return secure, sig, nil
+	}
+	return bogus, nil, lastErr
+}
+
+// ownerLabels returns the label count of owner for the rrsig labels field,
+// a leading wildcard label is not counted. See RFC 4034 3.1.3.
+func ownerLabels(owner string) int {
+	n := dns.CountLabel(owner)
+	if strings.HasPrefix(owner, "*.") {
+		n--
+	}
+	return n
+}
+
+// wildcardEncloser reports whether the RRset at owner verified by sig was
+// expanded from a wildcard, and returns the closest encloser of owner.
+func wildcardEncloser(owner string, sig *dns.RRSIG) (string, bool) {
+	if sig == nil || int(sig.Labels) >= ownerLabels(owner) {
+		return "", false
+	}
+	return lastLabels(dns.CanonicalName(owner), int(sig.Labels)), true
+}
+
+func (v *validator) verifySig(sig *dns.RRSIG, keys []*dns.DNSKEY, set []dns.RR) error {
+	if !sig.ValidityPeriod(v.now()) {
+		return errors.New("signature expired or not yet valid")
+	}
+	err := error(dns.ErrKey)
+	for _, k := range keys {
+		if k.KeyTag() != sig.KeyTag || k.Algorithm != sig.Algorithm {
+			continue
+		}
+		if err = sig.Verify(k, set); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// zoneKeys returns the validated DNSKEYs of zone. An error is returned if
+// the zone is bogus.
+func (v *validator) zoneKeys(ctx context.Context, zone string, q queryFunc, depth int) (*zoneKeys, error) {
+	if depth > maxChainDepth {
+		return nil, errMaxDepth
+	}
+	if zk, _, ok := v.keys.Get(key(zone)); ok {
+		return zk, nil
+	}
+
+	dsSet := v.anchors[zone]
+	if len(dsSet) == 0 {
+		r, err := q(ctx, zone, dns.TypeDS)
+		if err != nil {
+			return nil, err
+		}
+		sets, sigs := splitRRsets(r.Answer)
+		var dsRRs []dns.RR
+		for _, set := range sets {
+			if h := set[0].Header(); h.Rrtype == dns.TypeDS && equalName(h.Name, zone) {
+				dsRRs = set
+			}
+		}
+		if len(dsRRs) == 0 {
+			st, err := v.validateNoDS(ctx, zone, r, q, depth)
+			if st == bogus {
+				return nil, err
+			}
+			return v.storeInsecure(zone), nil
+		}
+
+		st, _, err := v.verifyRRset(ctx, dsRRs, sigs, q, depth)
+		switch st {
+		case bogus:
+			return nil, err
+		case insecure:
+			return v.storeInsecure(zone), nil
+		}
+		for _, rr := range dsRRs {
+			dsSet = append(dsSet, rr.(*dns.DS))
+		}
+	}
+
+	supported := false
+	for _, ds := range dsSet {
+		if _, ok := supportedAlgorithms[ds.Algorithm]; ok {
+			supported = true
+		}
+	}
+	if !supported { // RFC 4035 5.2, treat the zone as unsigned.
+		return v.storeInsecure(zone), nil
+	}
+
+	r, err := q(ctx, zone, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, err
+	}
+	var keyRRs []dns.RR
+	var keys []*dns.DNSKEY
+	for _, rr := range r.Answer {
+		if k, ok := rr.(*dns.DNSKEY); ok && equalName(k.Hdr.Name, zone) {
+			keyRRs = append(keyRRs, k)
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("zone %s has DS but no DNSKEY", zone)
+	}
+	_, sigs := splitRRsets(r.Answer)
+	sigs = sigsFor(sigs, zone, dns.TypeDNSKEY)
+
+	for _, ds := range dsSet {
+		for _, k := range keys {
+			if k.KeyTag() != ds.KeyTag || k.Algorithm != ds.Algorithm {
+				continue
+			}
+			kds := k.ToDS(ds.DigestType)
+			if kds == nil || !strings.EqualFold(kds.Digest, ds.Digest) {
+				continue
+			}
+			for _, sig := range sigs {
+				if sig.KeyTag != k.KeyTag() {
+					continue
+				}
+				if err := v.verifySig(sig, []*dns.DNSKEY{k}, keyRRs); err != nil {
+					continue
+				}
+				zk := &zoneKeys{status: secure, keys: keys}
+				ttl := min(keys[0].Hdr.Ttl, sig.OrigTtl, maxKeyCacheTTL)
+				exp := v.now().Add(time.Duration(ttl) * time.Second)
+				if sigExp := time.Unix(int64(sig.Expiration), 0); sigExp.Before(exp) {
+					exp = sigExp
+				}
+				v.keys.Store(key(zone), zk, exp)
+				return zk, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no valid DNSKEY matches DS for zone %s", zone)
+}
+
+func (v *validator) storeInsecure(zone string) *zoneKeys {
+	zk := &zoneKeys{status: insecure}
+	v.keys.Store(key(zone), zk, v.now().Add(insecureCacheTTL*time.Second))
+	return zk
+}
+
+// validateNoDS checks the negative DS response r of zone. It returns insecure
+// if the absence of DS is proven or the parent zone is insecure.
+func (v *validator) validateNoDS(ctx context.Context, zone string, r *dns.Msg, q queryFunc, depth int) (status, error) {
+	if r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+		return bogus, fmt.Errorf("unexpected rcode %s for DS of %s", dns.RcodeToString[r.Rcode], zone)
+	}
+	sets, sigs := splitRRsets(r.Ns)
+	if len(sets) == 0 {
+		return v.provenInsecure(ctx, parentName(zone), q, depth+1)
+	}
+	s := secure
+	for _, set := range sets {
+		st, sig, err := v.verifyRRset(ctx, set, sigs, q, depth)
+		if st == bogus {
+			return bogus, err
+		}
+		s = max(s, st)
+		if _, ok := wildcardEncloser(set[0].Header().Name, sig); ok {
+			return bogus, fmt.Errorf("denial of DS for %s is expanded from a wildcard", zone)
+		}
+	}
+	if s == secure {
+		if err := checkDenial(r.Rcode, zone, dns.TypeDS, sets); err != nil {
+			return bogus, fmt.Errorf("failed to prove no DS for %s, %w", zone, err)
+		}
+	}
+	return insecure, nil
+}
+
+// provenInsecure checks whether unsigned data of name is in an insecure zone.
+func (v *validator) provenInsecure(ctx context.Context, name string, q queryFunc, depth int) (status, error) {
+	if depth > maxChainDepth {
+		return bogus, errMaxDepth
+	}
+	zone, err := findZone(ctx, name, q)
+	if err != nil {
+		return bogus, err
+	}
+	if _, ok := v.anchors[zone]; ok {
+		return bogus, fmt.Errorf("unsigned data in trust anchor zone %s", zone)
+	}
+	zk, err := v.zoneKeys(ctx, zone, q, depth+1)
+	if err != nil {
+		return bogus, err
+	}
+	if zk.status == insecure {
+		return insecure, nil
+	}
+	return bogus, fmt.Errorf("unsigned data %s in secure zone %s", name, zone)
+}
+
+// findZone finds the apex of the zone that name belongs to.
+func findZone(ctx context.Context, name string, q queryFunc) (string, error) {
+	r, err := q(ctx, name, dns.TypeSOA)
+	if err != nil {
+		return "", err
+	}
+	for _, section := range [...][]dns.RR{r.Answer, r.Ns} {
+		for _, rr := range section {
+			if soa, ok := rr.(*dns.SOA); ok && dns.IsSubDomain(soa.Hdr.Name, name) {
+				return dns.CanonicalName(soa.Hdr.Name), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("cannot find the zone of %s", name)
+}
+
+// chainEnd follows the cname chain of qname in answer. It returns the last
+// name of the chain and whether the answer has records of qtype.
+func chainEnd(answer []dns.RR, qname string, qtype uint16) (string, bool) {
+	cur := qname
+	for i := 0; i <= len(answer); i++ {
+		var next string
+		for _, rr := range answer {
+			h := rr.Header()
+			if !equalName(h.Name, cur) {
+				continue
+			}
+			if h.Rrtype == qtype || qtype == dns.TypeANY {
+				return cur, true
+			}
+			if cname, ok := rr.(*dns.CNAME); ok {
+				next = dns.CanonicalName(cname.Target)
+			}
+		}
+		if len(next) == 0 {
+			break
+		}
+		if qtype == dns.TypeCNAME {
+			return cur, true
+		}
+		cur = next
+	}
+	return cur, false
+}
+
+// splitRRsets groups rrs into RRsets. RRSIG and OPT records are not
+// included in sets. RRSIG records are returned separately.
+func splitRRsets(rrs []dns.RR) (sets [][]dns.RR, sigs []*dns.RRSIG) {
+	type setKey struct {
+		name  string
+		typ   uint16
+		class uint16
+	}
+	idx := make(map[setKey]int)
+	for _, rr := range rrs {
+		h := rr.Header()
+		switch h.Rrtype {
+		case dns.TypeOPT:
+			continue
+		case dns.TypeRRSIG:
+			sigs = append(sigs, rr.(*dns.RRSIG))
+			continue
+		}
+		k := setKey{name: dns.CanonicalName(h.Name), typ: h.Rrtype, class: h.Class}
+		if i, ok := idx[k]; ok {
+			sets[i] = append(sets[i], rr)
+			continue
+		}
+		idx[k] = len(sets)
+		sets = append(sets, []dns.RR{rr})
+	}
+	return sets, sigs
+}
+
+func sigsFor(sigs []*dns.RRSIG, name string, typ uint16) []*dns.RRSIG {
+	var s []*dns.RRSIG
+	for _, sig := range sigs {
+		if sig.TypeCovered == typ && equalName(sig.Hdr.Name, name) {
+			s = append(s, sig)
+		}
+	}
+	return s
+}
+
+func parentName(name string) string {
+	off, end := dns.NextLabel(name, 0)
+	if end {
+		return "."
+	}
+	return name[off:]
+}
+
+func equalName(a, b string) bool {
+	return dns.CanonicalName(a) == dns.CanonicalName(b)
+}
@@ -0,0 +1,254 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnstap
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const PluginType = "dnstap"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+var _ sequence.RecursiveExecutable = (*Dnstap)(nil)
+
+type Args struct {
+	// Exactly one of Unix, TCP and File is required.
+	Unix string `yaml:"unix"`
+	TCP  string `yaml:"tcp"`
+	// File will be truncated on start.
+	// The output, and its queue, is kept across reloads if it is unchanged.
+	File string `yaml:"file"`
+
+	// Identity of this server. Default is the hostname.
+	Identity string `yaml:"identity"`
+	// Version of this server. Default is "mosdns".
+	Version string `yaml:"version"`
+	// Forwarder enables FORWARDER_QUERY and FORWARDER_RESPONSE messages of
+	// queries that are sent by forward.
+	Forwarder bool `yaml:"forwarder"`
+	// QueueSize is the number of messages that can be queued for sending.
+	// Messages are dropped when the queue is full. Default is 4096.
+	QueueSize int `yaml:"queue_size"`
+}
+
+func (a *Args) init() {
+	if len(a.Identity) == 0 {
+		a.Identity, _ = os.Hostname()
+	}
+	utils.SetDefaultString(&a.Version, "mosdns")
+	utils.SetDefaultNum(&a.QueueSize, 4096)
+}
+
+type Dnstap struct {
+	identity  []byte
+	version   []byte
+	forwarder bool
+	out       *output
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	a := args.(*Args)
+	if bp.CheckOnly() {
+		// Don't open the output.
+		if _, _, err := a.output(); err != nil {
+			return nil, err
+		}
+		return &Dnstap{}, nil
+	}
+
+	// Reuse the output of the running plugin, so the file will not be
+	// truncated and written by two outputs.
+	if prev, ok := bp.PrevPlugin().(*Dnstap); ok && prev.out != nil {
+		a.init()
+		network, addr, err := a.output()
+		if err != nil {
+			return nil, err
+		}
+		if prev.out.network == network && prev.out.addr == addr {
+			prev.out.acquire()
+			return newDnstap(a, prev.out), nil
+		}
+	}
+	return NewDnstap(a, bp.L())
+}
+
+// output returns the network and the address of the output.
+// network is empty for file output.
+func (a *Args) output() (network, addr string, err error) {
+	n := 0
+	if len(a.Unix) > 0 {
+		network, addr = "unix", a.Unix
+		n++
+	}
+	if len(a.TCP) > 0 {
+		network, addr = "tcp", a.TCP
+		n++
+	}
+	if len(a.File) > 0 {
+		network, addr = "", a.File
+		n++
+	}
+	if n != 1 {
+		return "", "", errors.New("exactly one of unix, tcp and file is required")
+	}
+	return network, addr, nil
+}
+
+func NewDnstap(args *Args, logger *zap.Logger) (*Dnstap, error) {
+	args.init()
+	if logger == nil {
+		logger = mlog.Nop()
+	}
+	network, addr, err := args.output()
+	if err != nil {
+		return nil, err
+	}
+	out, err := newOutput(network, addr, args.QueueSize, logger)
+	if err != nil {
+		return nil, err
+	}
+	return newDnstap(args, out), nil
+}
+
+func newDnstap(args *Args, out *output) *Dnstap {
+	return &Dnstap{
+		identity:  []byte(args.Identity),
+		version:   []byte(args.Version),
+		forwarder: args.Forwarder,
+		out:       out,
+	}
+}
+
+func (d *Dnstap) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	var client netip.AddrPort
+	if addr, ok := query_context.GetClientAddr(qCtx); ok && addr.IsValid() {
+		client = netip.AddrPortFrom(*addr, 0)
+	}
+	// Servers that support streams are tcp servers.
+	_, tcp := query_context.GetStreamWriter(qCtx)
+
+	d.send(&message{
+		typ:       typeClientQuery,
+		tcp:       tcp,
+		queryAddr: client,
+		queryTime: qCtx.StartTime(),
+		query:     pack(qCtx.Q()),
+	})
+	if d.forwarder {
+		query_context.SetUpstreamObserver(qCtx, d.observeUpstream)
+	}
+
+	err := next.ExecNext(ctx, qCtx)
+
+	if r := qCtx.R(); r != nil {
+		d.send(&message{
+			typ:          typeClientResponse,
+			tcp:          tcp,
+			queryAddr:    client,
+			queryTime:    qCtx.StartTime(),
+			query:        pack(qCtx.Q()),
+			responseTime: time.Now(),
+			response:     pack(r),
+		})
+	}
+	return err
+}
+
+func (d *Dnstap) observeUpstream(addr string, q, r *dns.Msg, queryTime, responseTime time.Time) {
+	upstream, tcp := parseUpstreamAddr(addr)
+	packedQ := pack(q)
+	d.send(&message{
+		typ:          typeForwarderQuery,
+		tcp:          tcp,
+		responseAddr: upstream,
+		queryTime:    queryTime,
+		query:        packedQ,
+	})
+	if r != nil {
+		d.send(&message{
+			typ:          typeForwarderResponse,
+			tcp:          tcp,
+			responseAddr: upstream,
+			queryTime:    queryTime,
+			query:        packedQ,
+			responseTime: responseTime,
+			response:     pack(r),
+		})
+	}
+}
+
+func (d *Dnstap) send(m *message) {
+	d.out.write(appendDnstap(nil, d.identity, d.version, m))
+}
+
+func pack(m *dns.Msg) []byte {
+	b, err := m.Pack()
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// parseUpstreamAddr parses upstream addresses like "8.8.8.8",
+// "tcp://8.8.8.8:53" and "tls://[2001:db8::1]:853". Addresses that are
+// domain names are not resolved and return an invalid AddrPort.
+func parseUpstreamAddr(s string) (ap netip.AddrPort, tcp bool) {
+	scheme, host, ok := strings.Cut(s, "://")
+	if !ok {
+		scheme, host = "udp", s
+	}
+	tcp = scheme != "udp" && scheme != "quic"
+	host, _, _ = strings.Cut(host, "/")
+	if ap, err := netip.ParseAddrPort(host); err == nil {
+		return ap, tcp
+	}
+	if addr, err := netip.ParseAddr(strings.Trim(host, "[]")); err == nil {
+		return netip.AddrPortFrom(addr, 0), tcp
+	}
+	return netip.AddrPort{}, tcp
+}
+
+// Dropped returns the number of messages dropped because the queue was full.
+func (d *Dnstap) Dropped() uint64 {
+	return d.out.dropped.Load()
+}
+
+func (d *Dnstap) Close() error {
+	if d.out != nil { // nil in check mode
+		d.out.close()
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnstap
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// readFrames reads a unidirectional frame stream and returns data frames.
+func readFrames(t *testing.T, r io.Reader) [][]byte {
+	t.Helper()
+	br := bufio.NewReader(r)
+	if typ, cts, err := readControlFrame(br); err != nil || typ != controlStart || len(cts) != 1 || cts[0] != contentType {
+		t.Fatalf("invalid start frame, %d %v %v", typ, cts, err)
+	}
+	var frames [][]byte
+	for {
+		var h [4]byte
+		if _, err := io.ReadFull(br, h[:]); err != nil {
+			t.Fatal(err)
+		}
+		l := binary.BigEndian.Uint32(h[:])
+		if l == 0 {
+			l2, _ := br.Peek(8)
+			if binary.BigEndian.Uint32(l2[4:]) != controlStop {
+				t.Fatal("want stop frame")
+			}
+			return frames
+		}
+		b := make([]byte, l)
+		if _, err := io.ReadFull(br, b); err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, b)
+	}
+}
+
+// messageFields decodes the Message of a Dnstap frame into field number
+// to raw value, varint fields are decoded.
+func messageFields(t *testing.T, frame []byte) map[protowire.Number]any {
+	t.Helper()
+	var msg []byte
+	for len(frame) > 0 {
+		num, typ, n := protowire.ConsumeTag(frame)
+		frame = frame[n:]
+		n = protowire.ConsumeFieldValue(num, typ, frame)
+		if num == fieldMessage {
+			msg, _ = protowire.ConsumeBytes(frame)
+		}
+		frame = frame[n:]
+	}
+	fields := make(map[protowire.Number]any)
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		msg = msg[n:]
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(msg)
+			fields[num] = v
+			msg = msg[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(msg)
+			fields[num] = v
+			msg = msg[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, msg)
+			fields[num] = nil
+			msg = msg[n:]
+		}
+	}
+	return fields
+}
+
+func TestDnstap_File(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dnstap.fstrm")
+	d, err := NewDnstap(&Args{File: file, Forwarder: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var next sequence.ExecutableFunc = func(_ context.Context, qCtx *query_context.Context) error {
+		o, ok := query_context.GetUpstreamObserver(qCtx)
+		if !ok {
+			t.Fatal("missing upstream observer")
+		}
+		r := new(dns.Msg)
+		r.SetReply(qCtx.Q())
+		o("tcp://192.0.2.53:53", qCtx.Q(), r, qCtx.StartTime(), qCtx.StartTime())
+		qCtx.SetResponse(r)
+		return nil
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	qCtx := query_context.NewContext(q)
+	client := netip.MustParseAddr("192.0.2.1")
+	query_context.SetClientAddr(qCtx, &client)
+	cw := sequence.NewChainWalker([]*sequence.ChainNode{{E: next}}, nil)
+	if err := d.Exec(context.Background(), qCtx, cw); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	frames := readFrames(t, f)
+	wantTypes := []messageType{typeClientQuery, typeForwarderQuery, typeForwarderResponse, typeClientResponse}
+	if len(frames) != len(wantTypes) {
+		t.Fatalf("want %d frames, got %d", len(wantTypes), len(frames))
+	}
+	for i, frame := range frames {
+		fields := messageFields(t, frame)
+		if got := messageType(fields[fieldMsgType].(uint64)); got != wantTypes[i] {
+			t.Fatalf("frame #%d: want type %d, got %d", i, wantTypes[i], got)
+		}
+		if _, ok := fields[fieldQueryMessage]; !ok {
+			t.Fatalf("frame #%d: missing query message", i)
+		}
+	}
+	if addr := messageFields(t, frames[0])[fieldQueryAddress].([]byte); !net.IP(addr).Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Fatalf("unexpected query address %v", addr)
+	}
+	fwd := messageFields(t, frames[1])
+	if addr := fwd[fieldResponseAddress].([]byte); !net.IP(addr).Equal(net.IPv4(192, 0, 2, 53)) {
+		t.Fatalf("unexpected response address %v", addr)
+	}
+	if fwd[fieldSocketProtocol].(uint64) != socketProtocolTCP || fwd[fieldResponsePort].(uint64) != 53 {
+		t.Fatalf("unexpected forwarder message %v", fwd)
+	}
+}
+
+func TestDnstap_Reload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dnstap.fstrm")
+	plugins := make(map[string]any)
+	m := coremain.NewTestMosdnsWithPlugins(plugins)
+	p, err := Init(coremain.NewBP("tap", m), &Args{File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugins["tap"] = p
+	prev := p.(*Dnstap)
+
+	g, commit := coremain.NewTestReloadGraph(m, map[string]any{})
+	p, err = Init(coremain.NewBP("tap", g), &Args{File: file, Version: "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit()
+	d := p.(*Dnstap)
+	if d.out != prev.out {
+		t.Fatal("file output was not reused")
+	}
+
+	// The old plugin is closed after the reload, the output must be kept.
+	exec := func(d *Dnstap) {
+		q := new(dns.Msg)
+		q.SetQuestion("example.com.", dns.TypeA)
+		cw := sequence.NewChainWalker(nil, nil)
+		if err := d.Exec(context.Background(), query_context.NewContext(q), cw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exec(prev)
+	if err := prev.Close(); err != nil {
+		t.Fatal(err)
+	}
+	exec(d)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	frames := readFrames(t, f)
+	if len(frames) != 2 {
+		t.Fatalf("want 2 frames, got %d", len(frames))
+	}
+	var version []byte
+	for b := frames[1]; len(b) > 0; {
+		num, typ, n := protowire.ConsumeTag(b)
+		b = b[n:]
+		if num == fieldVersion {
+			version, _ = protowire.ConsumeBytes(b)
+		}
+		b = b[protowire.ConsumeFieldValue(num, typ, b):]
+	}
+	if string(version) != "new" {
+		t.Fatalf("frame of the new plugin has version %q", version)
+	}
+}
+
+func TestDnstap_Unix(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "dnstap.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	framesChan := make(chan [][]byte, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		if typ, _, err := readControlFrame(c); err != nil || typ != controlReady {
+			t.Errorf("want ready frame, got %d %v", typ, err)
+			return
+		}
+		if _, err := c.Write(appendControlFrame(nil, controlAccept, true)); err != nil {
+			return
+		}
+		framesChan <- readFrames(t, c)
+		_, _ = c.Write(appendControlFrame(nil, controlFinish, false))
+	}()
+
+	d, err := NewDnstap(&Args{Unix: sock}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	if err := d.Exec(context.Background(), query_context.NewContext(q), sequence.ChainWalker{}); err != nil {
+		t.Fatal(err)
+	}
+	_ = d.Close()
+
+	// Queued frames are sent before the stop frame.
+	if frames := <-framesChan; len(frames) != 1 {
+		t.Fatalf("want 1 frame, got %d", len(frames))
+	}
+}
+
+func Test_parseUpstreamAddr(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+		tcp  bool
+	}{
+		{"8.8.8.8", "8.8.8.8:0", false},
+		{"udp://8.8.8.8:53", "8.8.8.8:53", false},
+		{"tls://[2001:db8::1]:853", "[2001:db8::1]:853", true},
+		{"https://1.1.1.1/dns-query", "1.1.1.1:0", true},
+		{"https://dns.google/dns-query", "invalid AddrPort", true},
+	}
+	for _, tt := range tests {
+		ap, tcp := parseUpstreamAddr(tt.s)
+		if ap.String() != tt.want || tcp != tt.tcp {
+			t.Errorf("%s: want %s %v, got %s %v", tt.s, tt.want, tt.tcp, ap, tcp)
+		}
+	}
+}
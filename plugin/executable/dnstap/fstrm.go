@@ -0,0 +1,286 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnstap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Frame Streams, see https://github.com/farsightsec/fstrm
+
+const contentType = "protobuf:dnstap.Dnstap"
+
+const (
+	controlAccept = 1
+	controlStart  = 2
+	controlStop   = 3
+	controlReady  = 4
+	controlFinish = 5
+
+	controlFieldContentType = 1
+
+	maxControlFrameSize = 512
+)
+
+const (
+	dialTimeout      = time.Second * 5
+	handshakeTimeout = time.Second * 5
+	minRetryInterval = time.Second
+	maxRetryInterval = time.Second * 30
+)
+
+// appendControlFrame appends an escaped control frame to b.
+func appendControlFrame(b []byte, typ uint32, withContentType bool) []byte {
+	l := 4
+	if withContentType {
+		l += 8 + len(contentType)
+	}
+	b = binary.BigEndian.AppendUint32(b, 0) // escape
+	b = binary.BigEndian.AppendUint32(b, uint32(l))
+	b = binary.BigEndian.AppendUint32(b, typ)
+	if withContentType {
+		b = binary.BigEndian.AppendUint32(b, controlFieldContentType)
+		b = binary.BigEndian.AppendUint32(b, uint32(len(contentType)))
+		b = append(b, contentType...)
+	}
+	return b
+}
+
+// readControlFrame reads an escaped control frame from r.
+func readControlFrame(r io.Reader) (typ uint32, contentTypes []string, err error) {
+	var h [8]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return 0, nil, err
+	}
+	if binary.BigEndian.Uint32(h[:4]) != 0 {
+		return 0, nil, errors.New("not a control frame")
+	}
+	l := binary.BigEndian.Uint32(h[4:])
+	if l < 4 || l > maxControlFrameSize {
+		return 0, nil, fmt.Errorf("invalid control frame length %d", l)
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, nil, err
+	}
+	typ = binary.BigEndian.Uint32(b)
+	b = b[4:]
+	for len(b) >= 8 {
+		field, fl := binary.BigEndian.Uint32(b), binary.BigEndian.Uint32(b[4:])
+		b = b[8:]
+		if uint32(len(b)) < fl {
+			return 0, nil, errors.New("invalid control field length")
+		}
+		if field == controlFieldContentType {
+			contentTypes = append(contentTypes, string(b[:fl]))
+		}
+		b = b[fl:]
+	}
+	return typ, contentTypes, nil
+}
+
+// output writes data frames to a unix socket, a tcp address or a file.
+// Frames are queued and dropped when the queue is full. Sockets are
+// reconnected if the connection is lost.
+type output struct {
+	network string // "unix" or "tcp", empty for file
+	addr    string
+	file    *os.File
+	logger  *zap.Logger
+
+	queue   chan []byte
+	dropped atomic.Uint64
+
+	refs        atomic.Int32 // see acquire
+	closeOnce   sync.Once
+	closeNotify chan struct{}
+	closeDone   chan struct{}
+}
+
+// newOutput creates an output. If network is empty, addr is a file path,
+// and the file will be truncated.
+func newOutput(network, addr string, queueSize int, logger *zap.Logger) (*output, error) {
+	o := &output{
+		network:     network,
+		addr:        addr,
+		logger:      logger,
+		queue:       make(chan []byte, queueSize),
+		closeNotify: make(chan struct{}),
+		closeDone:   make(chan struct{}),
+	}
+	o.refs.Store(1)
+	if len(network) == 0 {
+		f, err := os.OpenFile(addr, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		o.file = f
+	}
+	go o.loop()
+	return o, nil
+}
+
+// write queues a data frame payload. It never blocks.
+func (o *output) write(b []byte) {
+	select {
+	case o.queue <- b:
+	case <-o.closeNotify:
+	default:
+		o.dropped.Add(1)
+	}
+}
+
+func (o *output) loop() {
+	defer close(o.closeDone)
+	if o.file != nil {
+		defer o.file.Close()
+		if err := o.serve(o.file, nil); err != nil {
+			o.logger.Error("failed to write dnstap file, output stopped", zap.Error(err))
+		}
+		return
+	}
+
+	retry := minRetryInterval
+	for {
+		c, err := o.dial()
+		if err == nil {
+			retry = minRetryInterval
+			err = o.serve(c, c)
+			_ = c.Close()
+			if err == nil { // closed
+				return
+			}
+			o.logger.Warn("dnstap connection lost", zap.String("addr", o.addr), zap.Error(err))
+		} else {
+			o.logger.Warn("failed to connect dnstap receiver", zap.String("addr", o.addr), zap.Error(err))
+		}
+
+		t := time.NewTimer(retry)
+		select {
+		case <-t.C:
+		case <-o.closeNotify:
+			t.Stop()
+			return
+		}
+		retry = min(retry*2, maxRetryInterval)
+	}
+}
+
+// dial connects to the receiver and does a bidirectional handshake.
+func (o *output) dial() (net.Conn, error) {
+	c, err := net.DialTimeout(o.network, o.addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	_ = c.SetDeadline(time.Now().Add(handshakeTimeout))
+	if _, err := c.Write(appendControlFrame(nil, controlReady, true)); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	typ, cts, err := readControlFrame(c)
+	if err != nil {
+		_ = c.Close()
+		return nil, fmt.Errorf("failed to read accept frame, %w", err)
+	}
+	if typ != controlAccept || !slices.Contains(cts, contentType) {
+		_ = c.Close()
+		return nil, errors.New("receiver does not accept dnstap")
+	}
+	_ = c.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// serve writes a start frame, then queued frames to w until the output
+// is closed. If c is not nil, it waits the finish frame from c after the
+// stop frame was written. It returns nil if output was closed.
+func (o *output) serve(w io.Writer, c net.Conn) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(appendControlFrame(nil, controlStart, true)); err != nil {
+		return err
+	}
+	writeFrame := func(b []byte) error {
+		var h [4]byte
+		binary.BigEndian.PutUint32(h[:], uint32(len(b)))
+		if _, err := bw.Write(h[:]); err != nil {
+			return err
+		}
+		_, err := bw.Write(b)
+		return err
+	}
+	for {
+		select {
+		case b := <-o.queue:
+			if err := writeFrame(b); err != nil {
+				return err
+			}
+			if len(o.queue) == 0 {
+				if err := bw.Flush(); err != nil {
+					return err
+				}
+			}
+		case <-o.closeNotify:
+			for len(o.queue) > 0 {
+				if err := writeFrame(<-o.queue); err != nil {
+					return err
+				}
+			}
+			if _, err := bw.Write(appendControlFrame(nil, controlStop, false)); err != nil {
+				return err
+			}
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+			if c != nil {
+				_ = c.SetReadDeadline(time.Now().Add(handshakeTimeout))
+				_, _, _ = readControlFrame(c) // finish frame
+			}
+			return nil
+		}
+	}
+}
+
+// acquire adds a reference to o. o is closed after all references were
+// released by close. It is used to share o with the plugin of a new graph
+// while mosdns is reloading.
+func (o *output) acquire() {
+	o.refs.Add(1)
+}
+
+func (o *output) close() {
+	if o.refs.Add(-1) > 0 {
+		return
+	}
+	o.closeOnce.Do(func() {
+		close(o.closeNotify)
+	})
+	<-o.closeDone
+}
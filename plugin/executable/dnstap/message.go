@@ -0,0 +1,164 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnstap
+
+import (
+	"net/netip"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Types and field numbers from dnstap.proto.
+// See https://github.com/dnstap/dnstap.pb/blob/master/dnstap.proto
+
+type messageType uint64
+
+const (
+	typeClientQuery       messageType = 5
+	typeClientResponse    messageType = 6
+	typeForwarderQuery    messageType = 7
+	typeForwarderResponse messageType = 8
+)
+
+const (
+	socketFamilyINET  = 1
+	socketFamilyINET6 = 2
+
+	socketProtocolUDP = 1
+	socketProtocolTCP = 2
+)
+
+const (
+	dnstapTypeMessage = 1
+
+	// Dnstap fields
+	fieldIdentity = 1
+	fieldVersion  = 2
+	fieldMessage  = 14
+	fieldType     = 15
+
+	// Message fields
+	fieldMsgType          = 1
+	fieldSocketFamily     = 2
+	fieldSocketProtocol   = 3
+	fieldQueryAddress     = 4
+	fieldResponseAddress  = 5
+	fieldQueryPort        = 6
+	fieldResponsePort     = 7
+	fieldQueryTimeSec     = 8
+	fieldQueryTimeNsec    = 9
+	fieldQueryMessage     = 10
+	fieldResponseTimeSec  = 12
+	fieldResponseTimeNsec = 13
+	fieldResponseMessage  = 14
+)
+
+// message is a dnstap Message.
+type message struct {
+	typ          messageType
+	tcp          bool
+	queryAddr    netip.AddrPort // client for client messages
+	responseAddr netip.AddrPort // upstream for forwarder messages
+	queryTime    time.Time
+	query        []byte // packed dns msg
+	responseTime time.Time
+	response     []byte // packed dns msg
+}
+
+// appendDnstap appends a protobuf encoded Dnstap that wraps m to b.
+func appendDnstap(b []byte, identity, version []byte, m *message) []byte {
+	if len(identity) > 0 {
+		b = protowire.AppendTag(b, fieldIdentity, protowire.BytesType)
+		b = protowire.AppendBytes(b, identity)
+	}
+	if len(version) > 0 {
+		b = protowire.AppendTag(b, fieldVersion, protowire.BytesType)
+		b = protowire.AppendBytes(b, version)
+	}
+	msg := appendMessage(nil, m)
+	b = protowire.AppendTag(b, fieldMessage, protowire.BytesType)
+	b = protowire.AppendBytes(b, msg)
+	b = protowire.AppendTag(b, fieldType, protowire.VarintType)
+	b = protowire.AppendVarint(b, dnstapTypeMessage)
+	return b
+}
+
+func appendMessage(b []byte, m *message) []byte {
+	b = protowire.AppendTag(b, fieldMsgType, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(m.typ))
+
+	addr := m.queryAddr
+	if !addr.IsValid() {
+		addr = m.responseAddr
+	}
+	if addr.IsValid() {
+		family := uint64(socketFamilyINET6)
+		if addr.Addr().Unmap().Is4() {
+			family = socketFamilyINET
+		}
+		b = protowire.AppendTag(b, fieldSocketFamily, protowire.VarintType)
+		b = protowire.AppendVarint(b, family)
+	}
+	protocol := uint64(socketProtocolUDP)
+	if m.tcp {
+		protocol = socketProtocolTCP
+	}
+	b = protowire.AppendTag(b, fieldSocketProtocol, protowire.VarintType)
+	b = protowire.AppendVarint(b, protocol)
+
+	b = appendAddrPort(b, fieldQueryAddress, fieldQueryPort, m.queryAddr)
+	b = appendAddrPort(b, fieldResponseAddress, fieldResponsePort, m.responseAddr)
+	b = appendTime(b, fieldQueryTimeSec, fieldQueryTimeNsec, m.queryTime)
+	if m.query != nil {
+		b = protowire.AppendTag(b, fieldQueryMessage, protowire.BytesType)
+		b = protowire.AppendBytes(b, m.query)
+	}
+	b = appendTime(b, fieldResponseTimeSec, fieldResponseTimeNsec, m.responseTime)
+	if m.response != nil {
+		b = protowire.AppendTag(b, fieldResponseMessage, protowire.BytesType)
+		b = protowire.AppendBytes(b, m.response)
+	}
+	return b
+}
+
+func appendAddrPort(b []byte, addrField, portField protowire.Number, ap netip.AddrPort) []byte {
+	if !ap.IsValid() {
+		return b
+	}
+	b = protowire.AppendTag(b, addrField, protowire.BytesType)
+	b = protowire.AppendBytes(b, ap.Addr().Unmap().AsSlice())
+	if ap.Port() != 0 {
+		b = protowire.AppendTag(b, portField, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(ap.Port()))
+	}
+	return b
+}
+
+func appendTime(b []byte, secField, nsecField protowire.Number, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	b = protowire.AppendTag(b, secField, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(t.Unix()))
+	b = protowire.AppendTag(b, nsecField, protowire.Fixed32Type)
+	b = protowire.AppendFixed32(b, uint32(t.Nanosecond()))
+	return b
+}
@@ -21,7 +21,6 @@ package dual_selector
 
 import (
 	"context"
-	"os"
 	"time"
 
 	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
@@ -38,10 +37,7 @@ const (
 
 func init() {
 	sequence.MustRegExecQuickSetup("prefer_ipv4", func(bq sequence.BQ, _ string) (any, error) {
-		if os.Getenv("ADDINFO") == "yes" {
-			return NewPreferIpv4(bq, true), nil
-		}
-		return NewPreferIpv4(bq, false), nil
+		return NewPreferIpv4(bq), nil
 	})
 	sequence.MustRegExecQuickSetup("prefer_ipv6", func(bq sequence.BQ, _ string) (any, error) {
 		return NewPreferIpv6(bq), nil
@@ -52,8 +48,7 @@ var _ sequence.RecursiveExecutable = (*Selector)(nil)
 
 type Selector struct {
 	sequence.BQ
-	prefer  uint16 // dns.TypeA or dns.TypeAAAA
-	addinfo bool
+	prefer uint16 // dns.TypeA or dns.TypeAAAA
 }
 
 // Exec implements handler.Executable.
@@ -119,13 +114,7 @@ func (s *Selector) Exec(ctx context.Context, qCtx *query_context.Context, next s
 	case <-ctx.Done():
 		return ctx.Err()
 	case <-shouldBlock: // Reference indicates we should block this query before the original query finished.
-		if s.addinfo {
-			r := BlockRecordv6(q)
-			qCtx.SetResponse(r)
-		} else {
-			r := BlockRecord0(q)
-			qCtx.SetResponse(r)
-		}
+		s.block(qCtx)
 		return nil
 	case err := <-doneChan: // The original query finished. Waiting for reference.
 		waitTimeoutTimer := pool.GetTimer(referenceWaitTimeout)
@@ -134,13 +123,7 @@ func (s *Selector) Exec(ctx context.Context, qCtx *query_context.Context, next s
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-shouldBlock:
-			if s.addinfo {
-				r := BlockRecordv6(q)
-				qCtx.SetResponse(r)
-			} else {
-				r := BlockRecord0(q)
-				qCtx.SetResponse(r)
-			}
+			s.block(qCtx)
 			return nil
 		case <-shouldPass:
 			*qCtx = *qCtxOrg // replace qCtx
@@ -161,35 +144,24 @@ func BlockRecord0(q *dns.Msg) *dns.Msg {
 	return r
 }
 
-func BlockRecordv6(q *dns.Msg) *dns.Msg {
-	r := new(dns.Msg)
-	r.SetRcode(q, 0)
-	r.Answer = []dns.RR{}
-	txtRecord := new(dns.TXT)
-	txtRecord.Hdr = dns.RR_Header{
-		Name:   time.Now().Format("20060102150405.000") + ".block.paopaodns.",
-		Rrtype: dns.TypeTXT,
-		Class:  dns.ClassINET,
-		Ttl:    0,
+func (s *Selector) block(qCtx *query_context.Context) {
+	qCtx.SetResponse(BlockRecord0(qCtx.Q()))
+	if s.prefer == dns.TypeA {
+		qCtx.AddInfo(dns.ExtendedErrorCodeOther, "block", 0, "Records may exist, but blocked by PaoPaoDNS IPV6 option.")
 	}
-	txtRecord.Txt = []string{"Records may exist, but blocked by PaoPaoDNS IPV6 option."}
-	r.Extra = append(r.Extra, txtRecord)
-	return r
 }
 
-func NewPreferIpv4(bq sequence.BQ, addinfo bool) *Selector {
+func NewPreferIpv4(bq sequence.BQ) *Selector {
 	return &Selector{
-		BQ:      bq,
-		prefer:  dns.TypeA,
-		addinfo: addinfo,
+		BQ:     bq,
+		prefer: dns.TypeA,
 	}
 }
 
 func NewPreferIpv6(bq sequence.BQ) *Selector {
 	return &Selector{
-		BQ:      bq,
-		prefer:  dns.TypeAAAA,
-		addinfo: false,
+		BQ:     bq,
+		prefer: dns.TypeAAAA,
 	}
 }
 
@@ -0,0 +1,234 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ecs
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/dnsutils"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+)
+
+const PluginType = "ecs"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
+}
+
+var _ sequence.RecursiveExecutable = (*ECS)(nil)
+
+type Args struct {
+	// Forward forwards the client's ecs unchanged.
+	Forward bool `yaml:"forward"`
+	// Send derives a subnet from the client address.
+	Send bool `yaml:"send"`
+	// Preset is a fixed subnet, e.g. "1.2.3.0/24" or "1.2.3.4".
+	// It is used if the client did not send an ecs (or Forward is false)
+	// and no subnet can be derived from the client address.
+	Preset string `yaml:"preset"`
+	// Strip removes the client's ecs from the query if Send and Preset
+	// did not add one. An ecs added by Send or Preset always replaces
+	// the client's ecs, unless Forward is set. Without Strip, the client's
+	// ecs is forwarded if nothing replaces it.
+	Strip bool `yaml:"strip"`
+	Mask4 int  `yaml:"mask4"` // default 24
+	Mask6 int  `yaml:"mask6"` // default 48
+}
+
+func (a *Args) init() error {
+	utils.SetDefaultNum(&a.Mask4, 24)
+	utils.SetDefaultNum(&a.Mask6, 48)
+	if !utils.CheckNumRange(a.Mask4, 0, 32) {
+		return fmt.Errorf("invalid mask4 %d", a.Mask4)
+	}
+	if !utils.CheckNumRange(a.Mask6, 0, 128) {
+		return fmt.Errorf("invalid mask6 %d", a.Mask6)
+	}
+	return nil
+}
+
+type ECS struct {
+	args   *Args
+	preset *dns.EDNS0_SUBNET
+}
+
+func Init(_ *coremain.BP, args any) (any, error) {
+	return NewECS(args.(*Args))
+}
+
+// QuickSetup format: [forward|send|strip|preset_subnet]...
+// e.g. "send 1.2.3.0/24" derives ecs from the client address and
+// falls back to 1.2.3.0/24.
+func QuickSetup(_ sequence.BQ, s string) (any, error) {
+	args := new(Args)
+	for _, f := range strings.Fields(s) {
+		switch f {
+		case "forward":
+			args.Forward = true
+		case "send":
+			args.Send = true
+		case "strip":
+			args.Strip = true
+		default:
+			if len(args.Preset) > 0 {
+				return nil, fmt.Errorf("invalid args %s", f)
+			}
+			args.Preset = f
+		}
+	}
+	return NewECS(args)
+}
+
+func NewECS(args *Args) (*ECS, error) {
+	if err := args.init(); err != nil {
+		return nil, err
+	}
+	e := &ECS{args: args}
+	if len(args.Preset) > 0 {
+		ecs, err := parsePreset(args.Preset, args.Mask4, args.Mask6)
+		if err != nil {
+			return nil, fmt.Errorf("invalid preset, %w", err)
+		}
+		e.preset = ecs
+	}
+	return e, nil
+}
+
+func parsePreset(s string, mask4, mask6 int) (*dns.EDNS0_SUBNET, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		return dnsutils.NewEDNS0Subnet(p.Addr(), uint8(p.Bits())), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return nil, err
+	}
+	addr = addr.Unmap()
+	if addr.Is4() {
+		return dnsutils.NewEDNS0Subnet(addr, uint8(mask4)), nil
+	}
+	return dnsutils.NewEDNS0Subnet(addr, uint8(mask6)), nil
+}
+
+// Exec implements sequence.RecursiveExecutable.
+func (e *ECS) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	// handleQuery may add an OPT to a query that had none.
+	// It must not leak to clients that do not support EDNS.
+	clientNoOPT := qCtx.Q().IsEdns0() == nil
+	clientECS := e.handleQuery(qCtx)
+	if err := next.ExecNext(ctx, qCtx); err != nil {
+		return err
+	}
+	r := qCtx.R()
+	if clientNoOPT {
+		dnsutils.RemoveEDNS0(qCtx.Q())
+		if r != nil {
+			dnsutils.RemoveEDNS0(r)
+		}
+		return nil
+	}
+	if r != nil {
+		handleResp(r, clientECS)
+	}
+	return nil
+}
+
+// handleQuery modifies the ecs of the query and returns the ecs
+// that the client originally sent, or nil.
+func (e *ECS) handleQuery(qCtx *query_context.Context) *dns.EDNS0_SUBNET {
+	q := qCtx.Q()
+	clientECS := dnsutils.GetMsgECS(q)
+	if clientECS != nil && e.args.Forward {
+		return clientECS
+	}
+
+	var ecs *dns.EDNS0_SUBNET
+	if e.args.Send {
+		ecs = e.fromClientAddr(qCtx)
+	}
+	if ecs == nil && e.preset != nil {
+		ecs = copyECS(e.preset)
+	}
+
+	switch {
+	case ecs != nil:
+		dnsutils.AddECS(q, ecs, true)
+	case clientECS != nil && e.args.Strip:
+		dnsutils.RemoveMsgECS(q)
+	}
+	return clientECS
+}
+
+func (e *ECS) fromClientAddr(qCtx *query_context.Context) *dns.EDNS0_SUBNET {
+	addr, ok := query_context.GetClientAddr(qCtx)
+	if !ok || addr == nil || !addr.IsValid() {
+		return nil
+	}
+	a := addr.Unmap()
+	// Private or local addresses are meaningless for upstreams.
+	if !a.IsGlobalUnicast() || a.IsPrivate() {
+		return nil
+	}
+	if a.Is4() {
+		return dnsutils.NewEDNS0Subnet(a, uint8(e.args.Mask4))
+	}
+	return dnsutils.NewEDNS0Subnet(a, uint8(e.args.Mask6))
+}
+
+// handleResp makes sure the ecs in r matches the query the client sent.
+// If the client did not send an ecs, the ecs in r will be removed.
+// Otherwise, the source of r's ecs will be restored to the client's.
+func handleResp(r *dns.Msg, clientECS *dns.EDNS0_SUBNET) {
+	respECS := dnsutils.GetMsgECS(r)
+	if respECS == nil {
+		return
+	}
+	if clientECS == nil {
+		dnsutils.RemoveMsgECS(r)
+		return
+	}
+	if respECS == clientECS {
+		return
+	}
+	scope := respECS.SourceScope
+	if scope > clientECS.SourceNetmask {
+		scope = clientECS.SourceNetmask
+	}
+	restored := copyECS(clientECS)
+	restored.SourceScope = scope
+	dnsutils.AddECS(r, restored, true)
+}
+
+func copyECS(ecs *dns.EDNS0_SUBNET) *dns.EDNS0_SUBNET {
+	c := *ecs
+	c.Address = append(c.Address[:0:0], ecs.Address...)
+	return &c
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ecs
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/dnsutils"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+)
+
+// echoNext replies with the ecs it received, and a scope of 24.
+type echoNext struct {
+	gotECS *dns.EDNS0_SUBNET
+}
+
+func (e *echoNext) Exec(_ context.Context, qCtx *query_context.Context) error {
+	q := qCtx.Q()
+	r := new(dns.Msg)
+	r.SetReply(q)
+	e.gotECS = dnsutils.GetMsgECS(q)
+	if e.gotECS != nil {
+		ecs := copyECS(e.gotECS)
+		ecs.SourceScope = 24
+		dnsutils.AddECS(r, ecs, true)
+	}
+	qCtx.SetResponse(r)
+	return nil
+}
+
+func TestECS_Exec(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       *Args
+		clientAddr string
+		clientECS  string
+		wantQuery  string // empty means no ecs
+		wantResp   string
+	}{
+		{"send", &Args{Send: true}, "8.8.8.8", "", "8.8.8.0", ""},
+		{"send_private_fallback_preset", &Args{Send: true, Preset: "1.1.1.0/24"}, "192.168.1.1", "", "1.1.1.0", ""},
+		{"preset", &Args{Preset: "1.1.1.1"}, "8.8.8.8", "", "1.1.1.0", ""},
+		{"forward", &Args{Forward: true, Send: true}, "8.8.8.8", "2.2.2.0", "2.2.2.0", "2.2.2.0"},
+		{"override", &Args{Send: true}, "8.8.8.8", "2.2.2.0", "8.8.8.0", "2.2.2.0"},
+		{"strip", &Args{Strip: true}, "8.8.8.8", "2.2.2.0", "", ""},
+		{"keep", &Args{}, "8.8.8.8", "2.2.2.0", "2.2.2.0", "2.2.2.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := NewECS(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q := new(dns.Msg)
+			q.SetQuestion("example.", dns.TypeA)
+			if len(tt.clientECS) > 0 {
+				dnsutils.AddECS(q, dnsutils.NewEDNS0Subnet(netip.MustParseAddr(tt.clientECS), 24), true)
+			}
+			qCtx := query_context.NewContext(q)
+			clientAddr := netip.MustParseAddr(tt.clientAddr)
+			query_context.SetClientAddr(qCtx, &clientAddr)
+
+			next := new(echoNext)
+			cw := sequence.NewChainWalker([]*sequence.ChainNode{{E: next}}, nil)
+			if err := e.Exec(context.Background(), qCtx, cw); err != nil {
+				t.Fatal(err)
+			}
+
+			checkECS(t, "query", next.gotECS, tt.wantQuery)
+			checkECS(t, "response", dnsutils.GetMsgECS(qCtx.R()), tt.wantResp)
+
+			// The client sent no OPT, so it must not get one back.
+			if len(tt.clientECS) == 0 {
+				if qCtx.Q().IsEdns0() != nil {
+					t.Fatal("query still has an OPT")
+				}
+				if qCtx.R().IsEdns0() != nil {
+					t.Fatal("response has an OPT")
+				}
+			}
+		})
+	}
+}
+
+func checkECS(t *testing.T, section string, ecs *dns.EDNS0_SUBNET, want string) {
+	t.Helper()
+	if len(want) == 0 {
+		if ecs != nil {
+			t.Fatalf("%s: unexpected ecs %s", section, ecs)
+		}
+		return
+	}
+	if ecs == nil {
+		t.Fatalf("%s: missing ecs, want %s", section, want)
+	}
+	addr, _ := netip.AddrFromSlice(ecs.Address)
+	if addr.Unmap() != netip.MustParseAddr(want) {
+		t.Fatalf("%s: got ecs %s, want %s", section, addr, want)
+	}
+}
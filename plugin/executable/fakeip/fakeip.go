@@ -0,0 +1,398 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fakeip
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/pkg/dnsutils"
+	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/domain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/data_provider"
+	"github.com/IrineSistiana/mosdns/v5/plugin/data_provider/domain_set"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const PluginType = "fakeip"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+const saveInterval = time.Minute
+
+var _ sequence.Executable = (*FakeIP)(nil)
+
+type Args struct {
+	// Inet4 and Inet6 are CIDRs of address pools. At least one is required.
+	// A/AAAA queries of a family without a pool get empty responses.
+	Inet4 string `yaml:"inet4"`
+	Inet6 string `yaml:"inet6"`
+	// Size is the maximum number of addresses allocated from each pool.
+	// The least recently used address is recycled when a pool is full.
+	// Default is 65535.
+	Size int `yaml:"size"`
+	// TTL of fake responses in seconds. Default is 1.
+	TTL int `yaml:"ttl"`
+	// File stores mappings. Mappings are loaded from it on start, and saved
+	// to it periodically and on close. Optional.
+	File string `yaml:"file"`
+	// Exclude are tags of domain sets. Queries of names in these sets are
+	// skipped.
+	Exclude []string `yaml:"exclude"`
+}
+
+// Deps implements coremain.ArgsWithDeps.
+func (a *Args) Deps() []string {
+	return a.Exclude
+}
+
+func (a *Args) init() {
+	utils.SetDefaultNum(&a.Size, 65535)
+	utils.SetDefaultNum(&a.TTL, 1)
+}
+
+// samePools reports whether a and b build the same address pools.
+func (a *Args) samePools(b *Args) bool {
+	return a.Inet4 == b.Inet4 && a.Inet6 == b.Inet6 && a.Size == b.Size && a.File == b.File
+}
+
+type FakeIP struct {
+	args    *Args
+	logger  *zap.Logger
+	exclude domain_set.MatcherGroup
+
+	// mappings may be shared with the FakeIP of the previous reload.
+	*mappings
+
+	closeOnce   sync.Once
+	closeNotify chan struct{}
+	closeDone   chan struct{}
+}
+
+type mappings struct {
+	mu    sync.Mutex
+	pool4 *pool // nil if disabled
+	pool6 *pool // nil if disabled
+	dirty bool
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	a := args.(*Args)
+	var exclude []domain.Matcher[struct{}]
+	for _, tag := range a.Exclude {
+		p, _ := bp.M().GetPlugin(tag).(data_provider.DomainMatcherProvider)
+		if p == nil {
+			return nil, fmt.Errorf("cannot find domain set %s", tag)
+		}
+		exclude = append(exclude, p.GetDomainMatcher())
+	}
+	a.init()
+	// Keep allocated addresses on reload if the pools are unchanged, so
+	// the fake ips that clients have cached still map to the same names.
+	var m *mappings
+	if prev, ok := bp.PrevPlugin().(*FakeIP); ok && prev.args.samePools(a) {
+		m = prev.mappings
+	}
+	f, err := newFakeIP(a, exclude, m, bp.L())
+	if err != nil {
+		return nil, err
+	}
+	bp.RegAPI(f.Api())
+	return f, nil
+}
+
+// NewFakeIP creates a FakeIP. Names matched by exclude are skipped.
+func NewFakeIP(args *Args, exclude []domain.Matcher[struct{}], logger *zap.Logger) (*FakeIP, error) {
+	return newFakeIP(args, exclude, nil, logger)
+}
+
+// newFakeIP creates a FakeIP. If m is not nil, f uses m instead of
+// building new pools and loading the file.
+func newFakeIP(args *Args, exclude []domain.Matcher[struct{}], m *mappings, logger *zap.Logger) (*FakeIP, error) {
+	args.init()
+	if len(args.Inet4) == 0 && len(args.Inet6) == 0 {
+		return nil, errors.New("no address pool")
+	}
+	if logger == nil {
+		logger = mlog.Nop()
+	}
+	f := &FakeIP{
+		args:        args,
+		logger:      logger,
+		exclude:     exclude,
+		closeNotify: make(chan struct{}),
+		closeDone:   make(chan struct{}),
+	}
+	if m != nil {
+		f.mappings = m
+		if len(args.File) > 0 {
+			go f.saveLoop()
+		} else {
+			close(f.closeDone)
+		}
+		return f, nil
+	}
+	f.mappings = new(mappings)
+	if len(args.Inet4) > 0 {
+		p, err := newPool(args.Inet4, args.Size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid inet4 pool, %w", err)
+		}
+		if !p.prefix.Addr().Is4() {
+			return nil, fmt.Errorf("inet4 pool %s is not an ipv4 prefix", p.prefix)
+		}
+		f.pool4 = p
+	}
+	if len(args.Inet6) > 0 {
+		p, err := newPool(args.Inet6, args.Size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid inet6 pool, %w", err)
+		}
+		if !p.prefix.Addr().Is6() {
+			return nil, fmt.Errorf("inet6 pool %s is not an ipv6 prefix", p.prefix)
+		}
+		f.pool6 = p
+	}
+	if len(args.File) > 0 {
+		if err := f.load(); err != nil {
+			return nil, fmt.Errorf("failed to load file %s, %w", args.File, err)
+		}
+		go f.saveLoop()
+	} else {
+		close(f.closeDone)
+	}
+	return f, nil
+}
+
+// load restores mappings from the file. A missing file is not an error.
+// Mappings that are out of current pools are ignored.
+func (f *FakeIP) load() error {
+	b, err := os.ReadFile(f.args.File)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := bufio.NewScanner(bytes.NewReader(b))
+	line := 0
+	for s.Scan() {
+		line++
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return fmt.Errorf("invalid line #%d", line)
+		}
+		addr, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			return fmt.Errorf("invalid line #%d, %w", line, err)
+		}
+		if p := f.poolOf(addr); p != nil {
+			p.set(dns.CanonicalName(fields[1]), addr)
+		}
+	}
+	return s.Err()
+}
+
+// save writes mappings to the file, oldest first, so that the recycling
+// order is kept after loading.
+func (f *FakeIP) save() error {
+	f.mu.Lock()
+	if !f.dirty {
+		f.mu.Unlock()
+		return nil
+	}
+	b := new(bytes.Buffer)
+	for _, p := range [...]*pool{f.pool4, f.pool6} {
+		if p == nil {
+			continue
+		}
+		p.rangeOldest(func(name string, addr netip.Addr) {
+			fmt.Fprintf(b, "%s %s\n", addr, name)
+		})
+	}
+	f.dirty = false
+	f.mu.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.args.File), filepath.Base(f.args.File)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.args.File)
+}
+
+func (f *FakeIP) saveLoop() {
+	defer close(f.closeDone)
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-f.closeNotify:
+			if err := f.save(); err != nil {
+				f.logger.Error("failed to save mappings", zap.Error(err))
+			}
+			return
+		}
+		if err := f.save(); err != nil {
+			f.logger.Error("failed to save mappings", zap.Error(err))
+		}
+	}
+}
+
+func (f *FakeIP) poolOf(addr netip.Addr) *pool {
+	addr = addr.Unmap()
+	for _, p := range [...]*pool{f.pool4, f.pool6} {
+		if p != nil && p.prefix.Contains(addr) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Lookup returns the name that addr was allocated to.
+func (f *FakeIP) Lookup(addr netip.Addr) (string, bool) {
+	addr = addr.Unmap()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if p := f.poolOf(addr); p != nil {
+		return p.lookup(addr)
+	}
+	return "", false
+}
+
+// Response returns the fake response of q, or nil if q should be skipped.
+func (f *FakeIP) Response(q *dns.Msg) *dns.Msg {
+	if len(q.Question) != 1 || q.Question[0].Qclass != dns.ClassINET {
+		return nil
+	}
+	question := q.Question[0]
+	name := dns.CanonicalName(question.Name)
+
+	switch question.Qtype {
+	case dns.TypeA, dns.TypeAAAA:
+		if _, excluded := f.exclude.Match(name); excluded {
+			return nil
+		}
+		r := new(dns.Msg)
+		r.SetReply(q)
+		r.RecursionAvailable = true
+		hdr := dns.RR_Header{Name: question.Name, Rrtype: question.Qtype, Class: dns.ClassINET, Ttl: uint32(f.args.TTL)}
+		p := f.pool4
+		if question.Qtype == dns.TypeAAAA {
+			p = f.pool6
+		}
+		if p == nil {
+			return r
+		}
+		f.mu.Lock()
+		addr := p.get(name)
+		f.dirty = true
+		f.mu.Unlock()
+		if addr.Is4() {
+			r.Answer = append(r.Answer, &dns.A{Hdr: hdr, A: addr.AsSlice()})
+		} else {
+			r.Answer = append(r.Answer, &dns.AAAA{Hdr: hdr, AAAA: addr.AsSlice()})
+		}
+		return r
+	case dns.TypePTR:
+		addr, err := dnsutils.ParsePTRQName(name)
+		if err != nil {
+			return nil
+		}
+		target, ok := f.Lookup(addr)
+		if !ok {
+			return nil
+		}
+		r := new(dns.Msg)
+		r.SetReply(q)
+		r.RecursionAvailable = true
+		r.Answer = append(r.Answer, &dns.PTR{
+			Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: uint32(f.args.TTL)},
+			Ptr: target,
+		})
+		return r
+	}
+	return nil
+}
+
+func (f *FakeIP) Exec(_ context.Context, qCtx *query_context.Context) error {
+	if r := f.Response(qCtx.Q()); r != nil {
+		qCtx.SetResponse(r)
+	}
+	return nil
+}
+
+// Api returns a handler that serves GET /{ip}. It replies the domain that
+// ip was allocated to, or 404 if ip is not allocated.
+func (f *FakeIP) Api() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{ip}", func(w http.ResponseWriter, req *http.Request) {
+		addr, err := netip.ParseAddr(req.PathValue("ip"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid ip, %s", err), http.StatusBadRequest)
+			return
+		}
+		name, ok := f.Lookup(addr)
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		_, _ = io.WriteString(w, name)
+	})
+	return mux
+}
+
+// Close saves mappings to the file.
+func (f *FakeIP) Close() error {
+	f.closeOnce.Do(func() { close(f.closeNotify) })
+	<-f.closeDone
+	return nil
+}
@@ -0,0 +1,212 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fakeip
+
+import (
+	"net/netip"
+	"path/filepath"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/domain"
+	"github.com/miekg/dns"
+)
+
+func query(t *testing.T, f *FakeIP, name string, qt uint16) *dns.Msg {
+	t.Helper()
+	q := new(dns.Msg)
+	q.SetQuestion(name, qt)
+	return f.Response(q)
+}
+
+func answerAddr(t *testing.T, r *dns.Msg) netip.Addr {
+	t.Helper()
+	if r == nil || len(r.Answer) != 1 {
+		t.Fatalf("want one answer, got %v", r)
+	}
+	switch rr := r.Answer[0].(type) {
+	case *dns.A:
+		addr, _ := netip.AddrFromSlice(rr.A)
+		return addr.Unmap()
+	case *dns.AAAA:
+		addr, _ := netip.AddrFromSlice(rr.AAAA)
+		return addr
+	}
+	t.Fatalf("unexpected answer %s", r.Answer[0])
+	return netip.Addr{}
+}
+
+func TestFakeIP(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "fakeip.txt")
+	exclude := domain.NewDomainMixMatcher()
+	if err := exclude.Add("domain:direct.example", struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	args := &Args{Inet4: "198.18.0.0/30", Inet6: "fc00::/64", File: file}
+	f, err := NewFakeIP(args, []domain.Matcher[struct{}]{exclude}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := answerAddr(t, query(t, f, "a.example.", dns.TypeA))
+	if a != netip.MustParseAddr("198.18.0.1") {
+		t.Fatalf("unexpected addr %s", a)
+	}
+	if got := answerAddr(t, query(t, f, "A.Example.", dns.TypeA)); got != a {
+		t.Fatalf("same name should get the same addr, want %s, got %s", a, got)
+	}
+	b := answerAddr(t, query(t, f, "b.example.", dns.TypeA))
+	if b != netip.MustParseAddr("198.18.0.2") {
+		t.Fatalf("unexpected addr %s", b)
+	}
+	if got := answerAddr(t, query(t, f, "a.example.", dns.TypeAAAA)); got != netip.MustParseAddr("fc00::1") {
+		t.Fatalf("unexpected addr %s", got)
+	}
+
+	// The pool is full. b is the least recently used.
+	query(t, f, "a.example.", dns.TypeA)
+	if got := answerAddr(t, query(t, f, "c.example.", dns.TypeA)); got != b {
+		t.Fatalf("want recycled addr %s, got %s", b, got)
+	}
+	if name, _ := f.Lookup(b); name != "c.example." {
+		t.Fatalf("want c.example., got %s", name)
+	}
+
+	if r := query(t, f, "www.direct.example.", dns.TypeA); r != nil {
+		t.Fatal("excluded name should be skipped")
+	}
+	if r := query(t, f, "a.example.", dns.TypeMX); r != nil {
+		t.Fatal("other types should be skipped")
+	}
+
+	r := query(t, f, "1.0.18.198.in-addr.arpa.", dns.TypePTR)
+	if r == nil || len(r.Answer) != 1 || r.Answer[0].(*dns.PTR).Ptr != "a.example." {
+		t.Fatalf("unexpected ptr response %v", r)
+	}
+	if r := query(t, f, "3.0.18.198.in-addr.arpa.", dns.TypePTR); r != nil {
+		t.Fatal("unallocated addr should be skipped")
+	}
+
+	// Persistence
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err = NewFakeIP(args, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if name, _ := f.Lookup(a); name != "a.example." {
+		t.Fatalf("want a.example. after reload, got %s", name)
+	}
+	if got := answerAddr(t, query(t, f, "c.example.", dns.TypeA)); got != b {
+		t.Fatalf("want %s after reload, got %s", b, got)
+	}
+	// a is the least recently used now.
+	if got := answerAddr(t, query(t, f, "d.example.", dns.TypeA)); got != a {
+		t.Fatalf("want recycled addr %s, got %s", a, got)
+	}
+}
+
+func TestFakeIP_Reload(t *testing.T) {
+	plugins := make(map[string]any)
+	m := coremain.NewTestMosdnsWithPlugins(plugins)
+	p, err := Init(coremain.NewBP("fakeip", m), &Args{Inet4: "198.18.0.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugins["fakeip"] = p
+	prev := p.(*FakeIP)
+	a := answerAddr(t, query(t, prev, "a.example.", dns.TypeA))
+
+	g, commit := coremain.NewTestReloadGraph(m, map[string]any{})
+	p, err = Init(coremain.NewBP("fakeip", g), &Args{Inet4: "198.18.0.0/24", TTL: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit()
+	f := p.(*FakeIP)
+	defer f.Close()
+
+	// Allocations of the old plugin before it is closed must be kept.
+	b := answerAddr(t, query(t, prev, "b.example.", dns.TypeA))
+	if err := prev.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := f.Lookup(a); name != "a.example." {
+		t.Fatalf("want a.example. after reload, got %s", name)
+	}
+	if got := answerAddr(t, query(t, f, "b.example.", dns.TypeA)); got != b {
+		t.Fatalf("want %s after reload, got %s", b, got)
+	}
+
+	// Different pools start over.
+	g, commit = coremain.NewTestReloadGraph(coremain.NewTestMosdnsWithPlugins(map[string]any{"fakeip": f}), map[string]any{})
+	p, err = Init(coremain.NewBP("fakeip", g), &Args{Inet4: "198.19.0.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit()
+	f = p.(*FakeIP)
+	defer f.Close()
+	if f.mappings == prev.mappings {
+		t.Fatal("mappings of another pool should not be kept")
+	}
+	if got := answerAddr(t, query(t, f, "b.example.", dns.TypeA)); got != netip.MustParseAddr("198.19.0.1") {
+		t.Fatalf("unexpected addr %s", got)
+	}
+}
+
+func TestNewPool(t *testing.T) {
+	p, err := newPool("10.0.0.0/8", 1<<30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.size != 1<<24-2 {
+		t.Fatalf("unexpected size %d", p.size)
+	}
+	if got := p.offsetAddr(p.size - 1); got != netip.MustParseAddr("10.255.255.254") {
+		t.Fatalf("unexpected last addr %s", got)
+	}
+	if _, ok := p.addrOffset(netip.MustParseAddr("10.255.255.255")); ok {
+		t.Fatal("broadcast addr should not be in the pool")
+	}
+	if _, err := newPool("10.0.0.0/31", 10); err == nil {
+		t.Fatal("want error for a small prefix")
+	}
+
+	// Restored mappings leave gaps that are allocated first.
+	p, _ = newPool("fc00::/120", 10)
+	p.set("x.", netip.MustParseAddr("fc00::3"))
+	if got := p.get("y."); got != netip.MustParseAddr("fc00::1") && got != netip.MustParseAddr("fc00::2") {
+		t.Fatalf("want a gap addr, got %s", got)
+	}
+
+	// The old address of a re-mapped name is freed.
+	p, _ = newPool("fc00::/120", 10)
+	p.set("x.", netip.MustParseAddr("fc00::1"))
+	p.set("x.", netip.MustParseAddr("fc00::2"))
+	if got := p.get("y."); got != netip.MustParseAddr("fc00::1") {
+		t.Fatalf("want the freed addr, got %s", got)
+	}
+	if _, ok := p.lookup(netip.MustParseAddr("fc00::2")); !ok {
+		t.Fatal("re-mapped addr was lost")
+	}
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fakeip
+
+import (
+	"fmt"
+	"math/big"
+	"net/netip"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/lru"
+)
+
+// pool allocates addresses from a prefix. Once all addresses are allocated,
+// the least recently used one is recycled. pool is not concurrent safe.
+type pool struct {
+	prefix netip.Prefix
+	size   uint64                  // number of allocatable addresses
+	next   uint64                  // offset of the next never allocated address
+	free   map[netip.Addr]struct{} // unallocated addresses below next
+
+	names *lru.LRU[string, netip.Addr]
+	addrs map[netip.Addr]string
+}
+
+func newPool(s string, maxSize int) (*pool, error) {
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return nil, err
+	}
+	prefix = prefix.Masked()
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	// Skip the network address, and the broadcast address of ipv4.
+	size := uint64(maxSize)
+	if hostBits < 64 {
+		n := uint64(1)<<hostBits - 1
+		if prefix.Addr().Is4() {
+			n--
+		}
+		if n < size {
+			size = n
+		}
+	}
+	if size == 0 {
+		return nil, fmt.Errorf("prefix %s is too small", prefix)
+	}
+	return &pool{
+		prefix: prefix,
+		size:   size,
+		free:   make(map[netip.Addr]struct{}),
+		names:  lru.NewLRU[string, netip.Addr](int(size)+1, nil),
+		addrs:  make(map[netip.Addr]string),
+	}, nil
+}
+
+func (p *pool) offsetAddr(off uint64) netip.Addr {
+	b := p.prefix.Addr().AsSlice()
+	n := new(big.Int).SetBytes(b)
+	n.Add(n, new(big.Int).SetUint64(off+1))
+	n.FillBytes(b)
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+func (p *pool) addrOffset(addr netip.Addr) (uint64, bool) {
+	if !p.prefix.Contains(addr) {
+		return 0, false
+	}
+	n := new(big.Int).SetBytes(addr.AsSlice())
+	n.Sub(n, new(big.Int).SetBytes(p.prefix.Addr().AsSlice()))
+	if n.Sign() <= 0 || !n.IsUint64() || n.Uint64() > p.size {
+		return 0, false
+	}
+	return n.Uint64() - 1, true
+}
+
+// get returns the address of name. It allocates one if name has no address.
+func (p *pool) get(name string) netip.Addr {
+	if addr, ok := p.names.Get(name); ok {
+		return addr
+	}
+	var addr netip.Addr
+	switch {
+	case len(p.free) > 0:
+		for addr = range p.free {
+			break
+		}
+		delete(p.free, addr)
+	case p.next < p.size:
+		addr = p.offsetAddr(p.next)
+		p.next++
+	default:
+		_, addr, _ = p.names.PopOldest()
+		delete(p.addrs, addr)
+	}
+	p.names.Add(name, addr)
+	p.addrs[addr] = name
+	return addr
+}
+
+// set restores a mapping. It returns false if addr is not in this pool.
+func (p *pool) set(name string, addr netip.Addr) bool {
+	off, ok := p.addrOffset(addr)
+	if !ok {
+		return false
+	}
+	if oldName, ok := p.addrs[addr]; ok {
+		p.names.Del(oldName)
+	}
+	if oldAddr, ok := p.names.Get(name); ok && oldAddr != addr {
+		delete(p.addrs, oldAddr)
+		p.free[oldAddr] = struct{}{}
+	}
+	for ; p.next <= off; p.next++ {
+		p.free[p.offsetAddr(p.next)] = struct{}{}
+	}
+	delete(p.free, addr)
+	p.names.Add(name, addr)
+	p.addrs[addr] = name
+	return true
+}
+
+// lookup returns the name of addr.
+func (p *pool) lookup(addr netip.Addr) (string, bool) {
+	name, ok := p.addrs[addr]
+	return name, ok
+}
+
+// rangeOldest calls f for all mappings, from the least recently used.
+func (p *pool) rangeOldest(f func(name string, addr netip.Addr)) {
+	p.names.Range(f)
+}
@@ -21,10 +21,13 @@ package fastforward
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -34,6 +37,7 @@ import (
 	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
 	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
 	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
@@ -93,6 +97,11 @@ func Init(bp *coremain.BP, args any) (any, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := f.RegMetricsTo(bp.MetricsReg()); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to register metrics, %w", err)
+	}
+	bp.RegAPI(f.Api())
 	return f, nil
 }
 
@@ -151,6 +160,7 @@ func NewForward(args *Args, opt Opts) (*Forward, error) {
 			MaxConns:       c.MaxConns,
 			EnablePipeline: c.EnablePipeline,
 			Logger:         opt.Logger,
+			EventObserver:  uw,
 		}
 
 		u, err := upstream.NewUpstream(c.Addr, uOpt)
@@ -173,6 +183,49 @@ func NewForward(args *Args, opt Opts) (*Forward, error) {
 	return f, nil
 }
 
+// RegMetricsTo registers metrics of all upstreams to r.
+func (f *Forward) RegMetricsTo(r prometheus.Registerer) error {
+	for uw := range f.us {
+		for _, c := range uw.collectors() {
+			if err := r.Register(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type upstreamHealth struct {
+	Name              string `json:"name"`
+	Addr              string `json:"addr"`
+	ConsecutiveErrors uint32 `json:"consecutive_errors"`
+	LastError         string `json:"last_error,omitempty"`
+}
+
+// Api returns the api handler of this forward.
+// "GET /upstreams" returns the health of upstreams.
+func (f *Forward) Api() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /upstreams", func(w http.ResponseWriter, _ *http.Request) {
+		hs := make([]upstreamHealth, 0, len(f.us))
+		for uw := range f.us {
+			h := upstreamHealth{
+				Name:              uw.name(),
+				Addr:              uw.cfg.Addr,
+				ConsecutiveErrors: uw.consecutiveErrs.Load(),
+			}
+			if e := uw.lastErr.Load(); e != nil {
+				h.LastError = *e
+			}
+			hs = append(hs, h)
+		}
+		slices.SortFunc(hs, func(a, b upstreamHealth) int { return strings.Compare(a.Name, b.Name) })
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(hs)
+	})
+	return mux
+}
+
 func (f *Forward) Exec(ctx context.Context, qCtx *query_context.Context) (err error) {
 	r, err := f.exchange(ctx, qCtx, f.us)
 	if err != nil {
@@ -253,6 +306,7 @@ func (f *Forward) exchange(ctx context.Context, qCtx *query_context.Context, us
 
 	qc := qCtx.Q().Copy()
 	uqid := qCtx.Id()
+	observer, _ := query_context.GetUpstreamObserver(qCtx)
 	sent := 0
 	for u := range us {
 		if sent > mcq {
@@ -265,7 +319,11 @@ func (f *Forward) exchange(ctx context.Context, qCtx *query_context.Context, us
 			// Give each upstream a fixed timeout to finsh the query.
 			upstreamCtx, cancel := context.WithTimeout(context.Background(), queryTimeout)
 			defer cancel()
+			queryTime := time.Now()
 			r, err := u.ExchangeContext(upstreamCtx, qc)
+			if observer != nil {
+				observer(u.cfg.Addr, qc, r, queryTime, time.Now())
+			}
 			if err != nil {
 				f.logger.Warn(
 					"upstream error",
@@ -304,6 +362,7 @@ func (f *Forward) exchange(ctx context.Context, qCtx *query_context.Context, us
 						}
 					}
 				}
+				query_context.SetUpstream(qCtx, u.name())
 				return r, nil
 			}
 		case <-ctx.Done():
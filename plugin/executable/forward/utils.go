@@ -22,23 +22,100 @@ package fastforward
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
+	"time"
 
 	"github.com/IrineSistiana/mosdns/v5/pkg/upstream"
 	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap/zapcore"
 )
 
 type upstreamWrapper struct {
 	u   upstream.Upstream
 	cfg UpstreamConfig
+
+	queryTotal      prometheus.Counter
+	errTotal        prometheus.Counter
+	thread          prometheus.Gauge
+	responseLatency prometheus.Histogram
+	connOpened      prometheus.Counter
+	connClosed      prometheus.Counter
+	connCurrent     prometheus.Gauge
+
+	// for health api
+	consecutiveErrs atomic.Uint32
+	lastErr         atomic.Pointer[string]
 }
 
 // newWrapper inits all metrics.
 // Note: upstreamWrapper.u still needs to be set.
 func newWrapper(cfg UpstreamConfig, pluginTag string) *upstreamWrapper {
-	return &upstreamWrapper{
+	uw := &upstreamWrapper{
 		cfg: cfg,
 	}
+	lb := prometheus.Labels{"upstream": uw.name()}
+	uw.queryTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        "forward_query_total",
+		Help:        "The total number of queries processed by this upstream",
+		ConstLabels: lb,
+	})
+	uw.errTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        "forward_err_total",
+		Help:        "The total number of queries failed",
+		ConstLabels: lb,
+	})
+	uw.thread = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "forward_thread",
+		Help:        "The number of threads (queries) that are currently being processed",
+		ConstLabels: lb,
+	})
+	uw.responseLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:        "forward_response_latency_millisecond",
+		Help:        "The response latency in millisecond",
+		Buckets:     []float64{1, 5, 10, 20, 50, 100, 200, 500, 1000},
+		ConstLabels: lb,
+	})
+	uw.connOpened = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        "forward_conn_opened_total",
+		Help:        "The total number of connections that are opened",
+		ConstLabels: lb,
+	})
+	uw.connClosed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        "forward_conn_closed_total",
+		Help:        "The total number of connections that are closed",
+		ConstLabels: lb,
+	})
+	uw.connCurrent = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "forward_conn_current",
+		Help:        "The number of connections that are currently open",
+		ConstLabels: lb,
+	})
+	return uw
+}
+
+func (uw *upstreamWrapper) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		uw.queryTotal,
+		uw.errTotal,
+		uw.thread,
+		uw.responseLatency,
+		uw.connOpened,
+		uw.connClosed,
+		uw.connCurrent,
+	}
+}
+
+// OnEvent implements upstream.EventObserver.
+func (uw *upstreamWrapper) OnEvent(typ upstream.Event) {
+	switch typ {
+	case upstream.EventConnOpen:
+		uw.connOpened.Inc()
+		uw.connCurrent.Inc()
+	case upstream.EventConnClose:
+		uw.connClosed.Inc()
+		uw.connCurrent.Dec()
+	}
 }
 
 // name returns upstream tag if it was set in the config.
@@ -51,7 +128,21 @@ func (uw *upstreamWrapper) name() string {
 }
 
 func (uw *upstreamWrapper) ExchangeContext(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	uw.queryTotal.Inc()
+	uw.thread.Inc()
+	defer uw.thread.Dec()
+
+	start := time.Now()
 	r, err := uw.u.ExchangeContext(ctx, m)
+	if err != nil {
+		uw.errTotal.Inc()
+		uw.consecutiveErrs.Add(1)
+		s := err.Error()
+		uw.lastErr.Store(&s)
+	} else {
+		uw.responseLatency.Observe(float64(time.Since(start).Milliseconds()))
+		uw.consecutiveErrs.Store(0)
+	}
 	return r, err
 }
 
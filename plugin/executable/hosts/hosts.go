@@ -23,13 +23,17 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"os"
+	"sync/atomic"
+
 	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/pkg/file_watcher"
 	"github.com/IrineSistiana/mosdns/v5/pkg/hosts"
-	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/domain"
 	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
 	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
 	"github.com/miekg/dns"
-	"os"
+	"go.uber.org/zap"
 )
 
 const PluginType = "hosts"
@@ -40,50 +44,93 @@ func init() {
 
 var _ sequence.Executable = (*Hosts)(nil)
 
+// Args: Entries and Files accept both the "domain ip..." format and the
+// /etc/hosts format "ip name [aliases...]".
 type Args struct {
 	Entries []string `yaml:"entries"`
 	Files   []string `yaml:"files"`
+	// FallThrough falls through, instead of replying NODATA, for names
+	// that only have addresses of the other family.
+	FallThrough bool `yaml:"fall_through"`
+	// DisableReload disables reloading hosts when files are changed.
+	DisableReload bool `yaml:"disable_reload"`
 }
 
 type Hosts struct {
-	h *hosts.Hosts
+	args    *Args
+	logger  *zap.Logger
+	h       atomic.Pointer[hosts.Hosts]
+	watcher *file_watcher.Watcher
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return NewHosts(args.(*Args), bp.L())
 }
 
-func Init(_ *coremain.BP, args any) (any, error) {
-	return NewHosts(args.(*Args))
+func NewHosts(args *Args, logger *zap.Logger) (*Hosts, error) {
+	if logger == nil {
+		logger = mlog.Nop()
+	}
+	h := &Hosts{args: args, logger: logger}
+	if err := h.load(); err != nil {
+		return nil, err
+	}
+	if !args.DisableReload && len(args.Files) > 0 {
+		w, err := file_watcher.New(args.Files, file_watcher.Opts{OnChange: h.reload, Logger: logger})
+		if err != nil {
+			return nil, fmt.Errorf("failed to watch files, %w", err)
+		}
+		h.watcher = w
+	}
+	return h, nil
 }
 
-func NewHosts(args *Args) (*Hosts, error) {
-	m := domain.NewMixMatcher[*hosts.IPs]()
-	m.SetDefaultMatcher(domain.MatcherFull)
-	for i, entry := range args.Entries {
-		if err := domain.Load[*hosts.IPs](m, entry, hosts.ParseIPs); err != nil {
-			return nil, fmt.Errorf("failed to load entry #%d %s, %w", i, entry, err)
+func (h *Hosts) load() error {
+	b := hosts.NewBuilder()
+	for i, entry := range h.args.Entries {
+		if err := b.AddLine(entry); err != nil {
+			return fmt.Errorf("failed to load entry #%d %s, %w", i, entry, err)
 		}
 	}
-	for i, file := range args.Files {
-		b, err := os.ReadFile(file)
+	for i, file := range h.args.Files {
+		data, err := os.ReadFile(file)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read file #%d %s, %w", i, file, err)
+			return fmt.Errorf("failed to read file #%d %s, %w", i, file, err)
 		}
-		if err := domain.LoadFromTextReader[*hosts.IPs](m, bytes.NewReader(b), hosts.ParseIPs); err != nil {
-			return nil, fmt.Errorf("failed to load file #%d %s, %w", i, file, err)
+		if err := b.LoadFromReader(bytes.NewReader(data)); err != nil {
+			return fmt.Errorf("failed to load file #%d %s, %w", i, file, err)
 		}
 	}
+	h.h.Store(b.Build(hosts.Opts{FallThrough: h.args.FallThrough}))
+	return nil
+}
 
-	return &Hosts{
-		h: hosts.NewHosts(m),
-	}, nil
+func (h *Hosts) reload() {
+	if err := h.load(); err != nil {
+		h.logger.Error("failed to reload hosts, old hosts are kept", zap.Error(err))
+		return
+	}
+	h.logger.Info("hosts reloaded")
 }
 
 func (h *Hosts) Response(q *dns.Msg) *dns.Msg {
-	return h.h.LookupMsg(q)
+	return h.h.Load().LookupMsg(q)
 }
 
 func (h *Hosts) Exec(_ context.Context, qCtx *query_context.Context) error {
-	r := h.h.LookupMsg(qCtx.Q())
+	r := h.Response(qCtx.Q())
 	if r != nil {
 		qCtx.SetResponse(r)
+		if len(r.Answer) > 0 {
+			qCtx.AddInfo(dns.ExtendedErrorCodeForgedAnswer, "usehosts", 301, "USE_HOSTS")
+		}
+	}
+	return nil
+}
+
+func (h *Hosts) Close() error {
+	if h.watcher != nil {
+		return h.watcher.Close()
 	}
 	return nil
 }
@@ -3,11 +3,10 @@ package ip_hosts
 import (
 	"context"
 	"fmt"
-	"net/netip"
 	"os"
-	"strings"
-	"time"
 
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/addr_source"
 	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
 	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
 	"github.com/miekg/dns"
@@ -15,57 +14,63 @@ import (
 
 const PluginType = "ip_hosts"
 
-var addInfoEnabled bool
-
 func init() {
-	addInfoEnabled = os.Getenv("ADDINFO") == "yes"
-
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
 	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
 }
 
 var _ sequence.RecursiveExecutable = (*IPHosts)(nil)
 
+// Args: addresses are loaded from Env, IPs and Files, and reloaded when
+// Files are changed.
+type Args struct {
+	Env           string   `yaml:"env"`
+	IPs           []string `yaml:"ips"`
+	Files         []string `yaml:"files"`
+	DisableReload bool     `yaml:"disable_reload"`
+}
+
 type IPHosts struct {
-	envVarName string
-	ipv4       []netip.Addr
-	ipv6       []netip.Addr
+	name string // shown in diagnostic info
+	src  *addr_source.Source
 }
 
+func Init(bp *coremain.BP, args any) (any, error) {
+	a := args.(*Args)
+	src, err := addr_source.New(addr_source.Opts{
+		Env:           a.Env,
+		IPs:           a.IPs,
+		Files:         a.Files,
+		DisableReload: a.DisableReload,
+		Logger:        bp.L(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &IPHosts{name: bp.Tag(), src: src}, nil
+}
+
+// QuickSetup format: env_key
+// env_key is the name of an environment variable that contains addresses.
 func QuickSetup(_ sequence.BQ, s string) (any, error) {
 	return NewIPHosts(s)
 }
 
+// NewIPHosts creates an IPHosts that loads addresses from the environment
+// variable envVarName.
 func NewIPHosts(envVarName string) (*IPHosts, error) {
-	b := &IPHosts{
-		envVarName: envVarName,
-	}
-
-	addresses := os.Getenv(envVarName)
-	if addresses == "" {
-		fmt.Println("[PaoPaoDNS ERROR] env_key is not set: ", envVarName+"=?")
-		return nil, nil
-	}
-
-	ips := strings.Fields(addresses)
-	for _, s := range ips {
-		addr, err := netip.ParseAddr(s)
-		if err != nil {
-			fmt.Println("[PaoPaoDNS ERROR] invalid address: ", s, ", debug:", err)
-			return nil, nil
-		}
-		if addr.Is4() {
-			b.ipv4 = append(b.ipv4, addr)
-		} else {
-			b.ipv6 = append(b.ipv6, addr)
-		}
+	src, err := addr_source.New(addr_source.Opts{Env: envVarName})
+	if err != nil {
+		return nil, err
 	}
 	fmt.Println("[PaoPaoDNS HOST] load:", envVarName, "=", os.Getenv(envVarName))
-	return b, nil
+	return &IPHosts{name: "env_key -> " + envVarName, src: src}, nil
 }
 
 func (b *IPHosts) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
 	if r := b.Response(qCtx.Q()); r != nil {
 		qCtx.SetResponse(r)
+		qCtx.AddInfo(dns.ExtendedErrorCodeForgedAnswer, "host", r.Answer[0].Header().Ttl+1, "Host: "+b.name)
 	}
 	// End the processing flow like ActionAccept.
 	return nil
@@ -78,12 +83,13 @@ func (b *IPHosts) Response(q *dns.Msg) *dns.Msg {
 
 	qName := q.Question[0].Name
 	qtype := q.Question[0].Qtype
+	addrs := b.src.Addrs()
 
 	switch {
-	case qtype == dns.TypeA && len(b.ipv4) > 0:
+	case qtype == dns.TypeA && len(addrs.V4) > 0:
 		r := new(dns.Msg)
 		r.SetReply(q)
-		for _, addr := range b.ipv4 {
+		for _, addr := range addrs.V4 {
 			rr := &dns.A{
 				Hdr: dns.RR_Header{
 					Name:   qName,
@@ -95,12 +101,12 @@ func (b *IPHosts) Response(q *dns.Msg) *dns.Msg {
 			}
 			r.Answer = append(r.Answer, rr)
 		}
-		return b.addinfo(r)
+		return r
 
-	case qtype == dns.TypeAAAA && len(b.ipv6) > 0:
+	case qtype == dns.TypeAAAA && len(addrs.V6) > 0:
 		r := new(dns.Msg)
 		r.SetReply(q)
-		for _, addr := range b.ipv6 {
+		for _, addr := range addrs.V6 {
 			rr := &dns.AAAA{
 				Hdr: dns.RR_Header{
 					Name:   qName,
@@ -112,22 +118,11 @@ func (b *IPHosts) Response(q *dns.Msg) *dns.Msg {
 			}
 			r.Answer = append(r.Answer, rr)
 		}
-		return b.addinfo(r)
+		return r
 	}
 	return nil
 }
 
-func (b *IPHosts) addinfo(r *dns.Msg) *dns.Msg {
-	if addInfoEnabled && r != nil {
-		txtRecord := new(dns.TXT)
-		txtRecord.Hdr = dns.RR_Header{
-			Name:   time.Now().Format("20060102150405.0000000") + ".host.paopaodns.",
-			Rrtype: dns.TypeTXT,
-			Class:  dns.ClassINET,
-			Ttl:    1 + r.Answer[0].Header().Ttl,
-		}
-		txtRecord.Txt = []string{"Host: env_key -> " + b.envVarName}
-		r.Extra = append(r.Extra, txtRecord)
-	}
-	return r
+func (b *IPHosts) Close() error {
+	return b.src.Close()
 }
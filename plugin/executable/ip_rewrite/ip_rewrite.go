@@ -1,13 +1,15 @@
 package ip_rewrite
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"net/netip"
 	"os"
-	"strings"
-	"time"
 
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/addr_source"
 	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
 	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
 	"github.com/miekg/dns"
@@ -15,66 +17,125 @@ import (
 
 const PluginType = "ip_rewrite"
 
-var addInfoEnabled bool
-
 func init() {
-	addInfoEnabled = os.Getenv("ADDINFO") == "yes"
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
 	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
 }
 
 var _ sequence.Executable = (*IPRewrite)(nil)
 
-type IPRewrite struct {
-	envVarName string
-	ipv4       []netip.Addr
-	ipv6       []netip.Addr
+// Args configures either fixed addresses or prefix translation.
+//
+// Fixed addresses replace all A/AAAA answers. They are loaded from Env, IPs
+// and IPFiles, and reloaded when IPFiles are changed.
+//
+// Prefix translation translates A/AAAA answers. Rules and lines of Files
+// are "from_cidr -> to_cidr".
+type Args struct {
+	Env           string   `yaml:"env"`
+	IPs           []string `yaml:"ips"`
+	IPFiles       []string `yaml:"ip_files"`
+	DisableReload bool     `yaml:"disable_reload"`
+
+	Rules []string `yaml:"rules"`
+	Files []string `yaml:"files"`
+	// KeepUnmatched keeps answers that match no rule. By default, they are
+	// removed.
+	KeepUnmatched bool `yaml:"keep_unmatched"`
 }
 
-func QuickSetup(_ sequence.BQ, s string) (any, error) {
-	return NewIPRewrite(s)
+type IPRewrite struct {
+	name string // shown in diagnostic info
+	src  *addr_source.Source
+
+	translator    *Translator // nil if translation is disabled
+	keepUnmatched bool
 }
 
-func NewIPRewrite(envVarName string) (*IPRewrite, error) {
-	b := &IPRewrite{
-		envVarName: envVarName,
+func Init(bp *coremain.BP, args any) (any, error) {
+	a := args.(*Args)
+	hasAddrs := len(a.Env)+len(a.IPs)+len(a.IPFiles) > 0
+	hasRules := len(a.Rules)+len(a.Files) > 0
+	switch {
+	case hasAddrs && hasRules:
+		return nil, errors.New("fixed addresses and translation rules cannot be used together")
+	case hasRules:
+		return NewTranslateIPRewrite(a)
 	}
-
-	addresses := os.Getenv(envVarName)
-	if addresses == "" {
-		fmt.Println("[PaoPaoDNS ERROR] env_key is not set: ", envVarName+"=?")
-		return nil, nil
+	src, err := addr_source.New(addr_source.Opts{
+		Env:           a.Env,
+		IPs:           a.IPs,
+		Files:         a.IPFiles,
+		DisableReload: a.DisableReload,
+		Logger:        bp.L(),
+	})
+	if err != nil {
+		return nil, err
 	}
+	return &IPRewrite{name: bp.Tag(), src: src}, nil
+}
 
-	ips := strings.Fields(addresses)
-	for _, s := range ips {
-		addr, err := netip.ParseAddr(s)
+// NewTranslateIPRewrite creates an IPRewrite that translates answers
+// by prefix rules.
+func NewTranslateIPRewrite(args *Args) (*IPRewrite, error) {
+	t := NewTranslator()
+	for i, s := range args.Rules {
+		if err := t.LoadFromText(s); err != nil {
+			return nil, fmt.Errorf("invalid rule #%d, %w", i, err)
+		}
+	}
+	for i, file := range args.Files {
+		b, err := os.ReadFile(file)
 		if err != nil {
-			fmt.Println("[PaoPaoDNS ERROR] invalid address: ", s, ", debug:", err)
-			return nil, nil
+			return nil, fmt.Errorf("failed to read file #%d %s, %w", i, file, err)
 		}
-		if addr.Is4() {
-			b.ipv4 = append(b.ipv4, addr)
-		} else {
-			b.ipv6 = append(b.ipv6, addr)
+		if err := t.LoadFromReader(bytes.NewReader(b)); err != nil {
+			return nil, fmt.Errorf("failed to load file #%d %s, %w", i, file, err)
 		}
 	}
+	if t.Len() == 0 {
+		return nil, fmt.Errorf("no rule")
+	}
+	return &IPRewrite{translator: t, keepUnmatched: args.KeepUnmatched}, nil
+}
+
+// QuickSetup format: env_key
+// env_key is the name of an environment variable that contains addresses.
+func QuickSetup(_ sequence.BQ, s string) (any, error) {
+	return NewIPRewrite(s)
+}
+
+// NewIPRewrite creates an IPRewrite that loads addresses from the
+// environment variable envVarName.
+func NewIPRewrite(envVarName string) (*IPRewrite, error) {
+	src, err := addr_source.New(addr_source.Opts{Env: envVarName})
+	if err != nil {
+		return nil, err
+	}
 	fmt.Println("[PaoPaoDNS SWAP] load:", envVarName, "=", os.Getenv(envVarName))
-	return b, nil
+	return &IPRewrite{name: "env_key -> " + envVarName, src: src}, nil
 }
 
 func (b *IPRewrite) Exec(_ context.Context, qCtx *query_context.Context) error {
-	if r := b.Response(qCtx.Q(), qCtx.R().Answer); r != nil {
+	if b.translator != nil {
+		if r := qCtx.R(); r != nil {
+			b.translate(r)
+		}
+		return nil
+	}
+	var a []dns.RR
+	if r := qCtx.R(); r != nil {
+		a = r.Answer
+	}
+	if r := b.Response(qCtx.Q(), a); r != nil {
 		qCtx.SetResponse(r)
+		qCtx.AddInfo(dns.ExtendedErrorCodeForgedAnswer, "swap", r.Answer[0].Header().Ttl+1, "Swap: "+b.name)
 	}
 	return nil
 }
 
 func (b *IPRewrite) Response(q *dns.Msg, a []dns.RR) *dns.Msg {
-	if b == nil {
-		return nil
-	}
-
-	if len(q.Question) != 1 {
+	if len(q.Question) != 1 || b.src == nil {
 		return nil
 	}
 	var ttl uint32 = 300
@@ -83,12 +144,13 @@ func (b *IPRewrite) Response(q *dns.Msg, a []dns.RR) *dns.Msg {
 	}
 	qName := q.Question[0].Name
 	qtype := q.Question[0].Qtype
+	addrs := b.src.Addrs()
 
 	switch {
-	case qtype == dns.TypeA && len(b.ipv4) > 0:
+	case qtype == dns.TypeA && len(addrs.V4) > 0:
 		r := new(dns.Msg)
 		r.SetReply(q)
-		for _, addr := range b.ipv4 {
+		for _, addr := range addrs.V4 {
 			rr := &dns.A{
 				Hdr: dns.RR_Header{
 					Name:   qName,
@@ -100,12 +162,12 @@ func (b *IPRewrite) Response(q *dns.Msg, a []dns.RR) *dns.Msg {
 			}
 			r.Answer = append(r.Answer, rr)
 		}
-		return b.addinfo(r)
+		return r
 
-	case qtype == dns.TypeAAAA && len(b.ipv6) > 0:
+	case qtype == dns.TypeAAAA && len(addrs.V6) > 0:
 		r := new(dns.Msg)
 		r.SetReply(q)
-		for _, addr := range b.ipv6 {
+		for _, addr := range addrs.V6 {
 			rr := &dns.AAAA{
 				Hdr: dns.RR_Header{
 					Name:   qName,
@@ -117,21 +179,46 @@ func (b *IPRewrite) Response(q *dns.Msg, a []dns.RR) *dns.Msg {
 			}
 			r.Answer = append(r.Answer, rr)
 		}
-		return b.addinfo(r)
+		return r
 	}
 	return nil
 }
-func (b *IPRewrite) addinfo(r *dns.Msg) *dns.Msg {
-	if addInfoEnabled && r != nil {
-		txtRecord := new(dns.TXT)
-		txtRecord.Hdr = dns.RR_Header{
-			Name:   time.Now().Format("20060102150405.0000000") + ".swap.paopaodns.",
-			Rrtype: dns.TypeTXT,
-			Class:  dns.ClassINET,
-			Ttl:    1 + r.Answer[0].Header().Ttl,
+
+// translate translates A/AAAA answers of r. Records of r may be shared
+// with others, e.g. the cache, so translated records are copies.
+func (b *IPRewrite) translate(r *dns.Msg) {
+	answer := make([]dns.RR, 0, len(r.Answer))
+	for _, rr := range r.Answer {
+		var addr netip.Addr
+		switch rr := rr.(type) {
+		case *dns.A:
+			addr, _ = netip.AddrFromSlice(rr.A)
+		case *dns.AAAA:
+			addr, _ = netip.AddrFromSlice(rr.AAAA)
+		default:
+			answer = append(answer, rr)
+			continue
+		}
+		translated, ok := b.translator.Translate(addr)
+		if ok {
+			rr = dns.Copy(rr)
+			switch rr := rr.(type) {
+			case *dns.A:
+				rr.A = translated.AsSlice()
+			case *dns.AAAA:
+				rr.AAAA = translated.AsSlice()
+			}
+		}
+		if ok || b.keepUnmatched {
+			answer = append(answer, rr)
 		}
-		txtRecord.Txt = []string{"Swap: env_key -> " + b.envVarName}
-		r.Extra = append(r.Extra, txtRecord)
 	}
-	return r
+	r.Answer = answer
+}
+
+func (b *IPRewrite) Close() error {
+	if b.src != nil {
+		return b.src.Close()
+	}
+	return nil
 }
@@ -0,0 +1,114 @@
+package ip_rewrite
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+)
+
+// rule maps addresses in from to the same host in to.
+type rule struct {
+	from netip.Prefix
+	to   netip.Prefix
+}
+
+// Translator translates addresses between prefixes. The prefix part of an
+// address is replaced and the host part is kept. IPv6 prefixes are simply
+// swapped, like NPTv6 without the checksum-neutral adjustment.
+type Translator struct {
+	rules []rule // longest from first
+}
+
+// NewTranslator creates an empty Translator.
+func NewTranslator() *Translator {
+	return new(Translator)
+}
+
+// LoadFromReader loads rules from a reader, one rule per line.
+// See LoadFromText for the rule format.
+func (t *Translator) LoadFromReader(reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+
+	lineCounter := 0
+	for scanner.Scan() {
+		lineCounter++
+		s := strings.TrimSpace(utils.RemoveComment(scanner.Text(), "#"))
+		if len(s) == 0 {
+			continue
+		}
+		if err := t.LoadFromText(s); err != nil {
+			return fmt.Errorf("invalid data at line #%d: %w", lineCounter, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// LoadFromText loads a rule from s.
+// Format: "from_cidr -> to_cidr" or "from_cidr to_cidr".
+// Both prefixes must be of the same family and have the same length.
+func (t *Translator) LoadFromText(s string) error {
+	fields := strings.Fields(strings.Replace(s, "->", " ", 1))
+	if len(fields) != 2 {
+		return fmt.Errorf("invalid rule %q, want \"from_cidr -> to_cidr\"", s)
+	}
+	from, err := netip.ParsePrefix(fields[0])
+	if err != nil {
+		return err
+	}
+	to, err := netip.ParsePrefix(fields[1])
+	if err != nil {
+		return err
+	}
+	if from.Addr().Is4() != to.Addr().Is4() {
+		return fmt.Errorf("%s and %s are not of the same family", from, to)
+	}
+	if from.Bits() != to.Bits() {
+		return fmt.Errorf("%s and %s have different lengths", from, to)
+	}
+	t.rules = append(t.rules, rule{from: from.Masked(), to: to.Masked()})
+	sort.SliceStable(t.rules, func(i, j int) bool {
+		return t.rules[i].from.Bits() > t.rules[j].from.Bits()
+	})
+	return nil
+}
+
+// Len returns the number of rules.
+func (t *Translator) Len() int {
+	return len(t.rules)
+}
+
+// Translate returns the translated addr. ok is false if addr matches
+// no rule.
+func (t *Translator) Translate(addr netip.Addr) (_ netip.Addr, ok bool) {
+	addr = addr.Unmap()
+	for _, r := range t.rules {
+		if r.from.Contains(addr) {
+			return replacePrefix(addr, r.to), true
+		}
+	}
+	return addr, false
+}
+
+func replacePrefix(addr netip.Addr, p netip.Prefix) netip.Addr {
+	a := addr.AsSlice()
+	b := p.Addr().AsSlice()
+	bits := p.Bits()
+	for i := range a {
+		switch {
+		case bits >= 8:
+			a[i] = b[i]
+			bits -= 8
+		case bits > 0:
+			mask := byte(0xff) << (8 - bits)
+			a[i] = b[i]&mask | a[i]&^mask
+			bits = 0
+		}
+	}
+	out, _ := netip.AddrFromSlice(a)
+	return out
+}
@@ -0,0 +1,96 @@
+package ip_rewrite
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+)
+
+func TestTranslator(t *testing.T) {
+	tr := NewTranslator()
+	rules := `
+# lan over vpn
+192.168.100.0/24 -> 10.8.100.0/24
+192.168.0.0/16   -> 10.9.0.0/16
+10.1.2.0/23 10.3.4.0/23
+fd00:1::/48 -> 2001:db8:5::/48
+`
+	if err := tr.LoadFromReader(strings.NewReader(rules)); err != nil {
+		t.Fatal(err)
+	}
+	for in, want := range map[string]string{
+		"192.168.100.7":      "10.8.100.7",
+		"192.168.1.7":        "10.9.1.7",
+		"10.1.3.9":           "10.3.5.9",
+		"fd00:1::1234:1":     "2001:db8:5::1234:1",
+		"fd00:1:0:ab::1":     "2001:db8:5:ab::1",
+		"::ffff:192.168.1.1": "10.9.1.1",
+	} {
+		got, ok := tr.Translate(netip.MustParseAddr(in))
+		if !ok || got != netip.MustParseAddr(want) {
+			t.Fatalf("%s: want %s, got %s", in, want, got)
+		}
+	}
+	if _, ok := tr.Translate(netip.MustParseAddr("172.16.0.1")); ok {
+		t.Fatal("unexpected match")
+	}
+
+	for _, s := range []string{
+		"192.168.0.0/24 -> 10.0.0.0/16",
+		"192.168.0.0/24 -> fd00::/24",
+		"192.168.0.0/24",
+	} {
+		if err := NewTranslator().LoadFromText(s); err == nil {
+			t.Fatalf("want error for %q", s)
+		}
+	}
+}
+
+func TestIPRewrite_translate(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		b, err := NewTranslateIPRewrite(&Args{
+			Rules:         []string{"192.168.100.0/24 -> 10.8.100.0/24"},
+			KeepUnmatched: keep,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := new(dns.Msg)
+		q.SetQuestion("nas.lan.", dns.TypeA)
+		r := new(dns.Msg)
+		r.SetReply(q)
+		var orig []dns.RR
+		for _, s := range []string{
+			"nas.lan. 60 IN CNAME nas.example.",
+			"nas.example. 60 IN A 192.168.100.5",
+			"nas.example. 60 IN A 203.0.113.5",
+		} {
+			rr, _ := dns.NewRR(s)
+			r.Answer = append(r.Answer, rr)
+			orig = append(orig, rr)
+		}
+		qCtx := query_context.NewContext(q)
+		qCtx.SetResponse(r)
+		if err := b.Exec(context.Background(), qCtx); err != nil {
+			t.Fatal(err)
+		}
+
+		wantLen := 2
+		if keep {
+			wantLen = 3
+		}
+		if len(r.Answer) != wantLen {
+			t.Fatalf("keep %v: want %d answers, got %v", keep, wantLen, r.Answer)
+		}
+		if got := r.Answer[1].(*dns.A).A.String(); got != "10.8.100.5" {
+			t.Fatalf("unexpected translated addr %s", got)
+		}
+		if got := orig[1].(*dns.A).A.String(); got != "192.168.100.5" {
+			t.Fatalf("original record was modified to %s", got)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package metrics_collector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const PluginType = "metrics_collector"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(struct{}) })
+}
+
+var _ sequence.RecursiveExecutable = (*Collector)(nil)
+
+// Collector collects metrics of queries that pass through it. Put it at
+// the beginning of a sequence to collect metrics of the whole sequence.
+type Collector struct {
+	queryTotal prometheus.Counter
+	errTotal   prometheus.Counter
+	thread     prometheus.Gauge
+	latency    prometheus.Histogram
+}
+
+func Init(bp *coremain.BP, _ any) (any, error) {
+	c := NewCollector()
+	if err := c.RegMetricsTo(bp.MetricsReg()); err != nil {
+		return nil, fmt.Errorf("failed to register metrics, %w", err)
+	}
+	return c, nil
+}
+
+func NewCollector() *Collector {
+	return &Collector{
+		queryTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "query_total",
+			Help: "The total number of queries pass through",
+		}),
+		errTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "err_total",
+			Help: "The total number of queries failed",
+		}),
+		thread: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "thread",
+			Help: "The number of threads (queries) that are currently being processed",
+		}),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "response_latency_millisecond",
+			Help:    "The response latency in millisecond",
+			Buckets: []float64{1, 5, 10, 20, 50, 100, 200, 500, 1000},
+		}),
+	}
+}
+
+// RegMetricsTo registers metrics of this collector to r.
+func (c *Collector) RegMetricsTo(r prometheus.Registerer) error {
+	for _, collector := range [...]prometheus.Collector{c.queryTotal, c.errTotal, c.thread, c.latency} {
+		if err := r.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Collector) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	c.thread.Inc()
+	defer c.thread.Dec()
+
+	c.queryTotal.Inc()
+	err := next.ExecNext(ctx, qCtx)
+	if err != nil {
+		c.errTotal.Inc()
+	}
+	if qCtx.R() != nil {
+		c.latency.Observe(float64(time.Since(qCtx.StartTime()).Milliseconds()))
+	}
+	return err
+}
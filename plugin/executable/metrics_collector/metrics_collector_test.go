@@ -0,0 +1,68 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package metrics_collector
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	c := NewCollector()
+	if err := c.RegMetricsTo(prometheus.NewRegistry()); err != nil {
+		t.Fatal(err)
+	}
+
+	var next sequence.ExecutableFunc = func(_ context.Context, qCtx *query_context.Context) error {
+		if qCtx.Q().Question[0].Name == "err." {
+			return errors.New("test err")
+		}
+		r := new(dns.Msg)
+		r.SetReply(qCtx.Q())
+		qCtx.SetResponse(r)
+		return nil
+	}
+	cw := sequence.NewChainWalker([]*sequence.ChainNode{{E: next}}, nil)
+	for _, name := range []string{"example.com.", "example.org.", "err."} {
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeA)
+		_ = c.Exec(context.Background(), query_context.NewContext(q), cw)
+	}
+
+	if v := testutil.ToFloat64(c.queryTotal); v != 3 {
+		t.Fatalf("want query_total 3, got %v", v)
+	}
+	if v := testutil.ToFloat64(c.errTotal); v != 1 {
+		t.Fatalf("want err_total 1, got %v", v)
+	}
+	if v := testutil.ToFloat64(c.thread); v != 0 {
+		t.Fatalf("want thread 0, got %v", v)
+	}
+	if n := testutil.CollectAndCount(c.latency); n != 1 {
+		t.Fatalf("want 1 histogram, got %d", n)
+	}
+}
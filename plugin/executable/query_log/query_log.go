@@ -0,0 +1,479 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package query_log
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const PluginType = "query_log"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+const (
+	flushInterval      = time.Second
+	writeBufferSize    = 64 * 1024
+	dropReportInterval = time.Minute
+)
+
+var _ sequence.RecursiveExecutable = (*QueryLog)(nil)
+
+type Args struct {
+	// File is the path of the log file. Required.
+	File string `yaml:"file"`
+	// Fields selects fields of each entry. Default is all fields.
+	// Available fields: time, uqid, client, qname, qtype, rcode, answers,
+	// latency, upstream, marks, error.
+	Fields []string `yaml:"fields"`
+
+	// MaxSize rotates the file when it grows larger than MaxSize MB.
+	// Default is 100. Negative value disables size based rotation.
+	MaxSize int `yaml:"max_size"`
+	// RotateInterval rotates the file every RotateInterval seconds.
+	// Default is 0, which disables time based rotation.
+	RotateInterval int `yaml:"rotate_interval"`
+	// MaxBackups is the maximum number of rotated files to keep.
+	// Default is 0, which keeps all files.
+	MaxBackups int  `yaml:"max_backups"`
+	Compress   bool `yaml:"compress"`
+
+	// SampleRate is the fraction of queries that are logged, in (0, 1].
+	// Default is 1.
+	SampleRate float64 `yaml:"sample_rate"`
+	// BufferSize is the number of entries that can be queued for writing.
+	// Entries are dropped when the queue is full. Default is 4096.
+	BufferSize int `yaml:"buffer_size"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultNum(&a.MaxSize, 100)
+	utils.SetDefaultNum(&a.SampleRate, 1)
+	utils.SetDefaultNum(&a.BufferSize, 4096)
+}
+
+type field uint16
+
+const (
+	fieldTime field = 1 << iota
+	fieldUqid
+	fieldClient
+	fieldQName
+	fieldQType
+	fieldRcode
+	fieldAnswers
+	fieldLatency
+	fieldUpstream
+	fieldMarks
+	fieldError
+
+	fieldAll = fieldError<<1 - 1
+)
+
+var fieldNames = map[string]field{
+	"time":     fieldTime,
+	"uqid":     fieldUqid,
+	"client":   fieldClient,
+	"qname":    fieldQName,
+	"qtype":    fieldQType,
+	"rcode":    fieldRcode,
+	"answers":  fieldAnswers,
+	"latency":  fieldLatency,
+	"upstream": fieldUpstream,
+	"marks":    fieldMarks,
+	"error":    fieldError,
+}
+
+type QueryLog struct {
+	fields     field
+	sampleRate float64
+	logger     *zap.Logger
+
+	w       io.WriteCloser
+	queue   chan []byte
+	dropped atomic.Uint64
+
+	closeOnce   sync.Once
+	closeNotify chan struct{}
+	closeDone   chan struct{}
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	a := args.(*Args)
+	if len(a.File) == 0 {
+		return nil, errors.New("missing log file")
+	}
+	a.init()
+	if bp.CheckOnly() {
+		// Don't open the log file.
+		return NewQueryLog(nopCloser{io.Discard}, a, bp.L())
+	}
+	var maxSize int64
+	if a.MaxSize > 0 {
+		maxSize = int64(a.MaxSize) << 20
+	}
+	w := &rotateWriter{
+		path:       a.File,
+		maxSize:    maxSize,
+		interval:   time.Duration(a.RotateInterval) * time.Second,
+		maxBackups: a.MaxBackups,
+		compress:   a.Compress,
+		logger:     bp.L(),
+	}
+
+	// Share the file of the running plugin, so it will not be written
+	// and rotated by two writers.
+	var sw *sharedWriter
+	if prev, ok := bp.PrevPlugin().(*QueryLog); ok {
+		if ref, ok := prev.w.(*writerRef); ok && ref.s.w.path == a.File {
+			sw = ref.s
+			bp.OnCommit(func() { sw.setRotation(w) })
+		}
+	}
+	if sw == nil {
+		if err := w.open(); err != nil {
+			return nil, fmt.Errorf("failed to open log file, %w", err)
+		}
+		sw = &sharedWriter{w: w}
+	}
+	ref := sw.ref()
+	l, err := NewQueryLog(ref, a, bp.L())
+	if err != nil {
+		_ = ref.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// sharedWriter serializes writes to a rotateWriter. It is shared by the
+// query logs of the running and the new graph while mosdns is reloading.
+type sharedWriter struct {
+	mu   sync.Mutex
+	w    *rotateWriter
+	refs int
+}
+
+// ref returns a new reference to s. The file is closed after all
+// references were closed.
+func (s *sharedWriter) ref() *writerRef {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refs++
+	return &writerRef{s: s}
+}
+
+// setRotation applies rotation args of w to s.
+func (s *sharedWriter) setRotation(w *rotateWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w.maxSize = w.maxSize
+	s.w.interval = w.interval
+	s.w.maxBackups = w.maxBackups
+	s.w.compress = w.compress
+}
+
+type writerRef struct {
+	s      *sharedWriter
+	closed bool // protected by s.mu
+}
+
+func (r *writerRef) Write(p []byte) (int, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.w.Write(p)
+}
+
+func (r *writerRef) Close() error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.s.refs--
+	if r.s.refs > 0 {
+		return nil
+	}
+	return r.s.w.Close()
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// NewQueryLog creates a QueryLog that writes entries to w. Only Fields,
+// SampleRate and BufferSize of args are used. w will be closed by
+// QueryLog.Close.
+func NewQueryLog(w io.WriteCloser, args *Args, logger *zap.Logger) (*QueryLog, error) {
+	args.init()
+	if args.SampleRate <= 0 || args.SampleRate > 1 {
+		return nil, fmt.Errorf("invalid sample rate %v", args.SampleRate)
+	}
+	fields := fieldAll
+	if len(args.Fields) > 0 {
+		fields = 0
+		for _, s := range args.Fields {
+			f, ok := fieldNames[s]
+			if !ok {
+				return nil, fmt.Errorf("unknown field %s", s)
+			}
+			fields |= f
+		}
+	}
+	if logger == nil {
+		logger = mlog.Nop()
+	}
+	l := &QueryLog{
+		fields:      fields,
+		sampleRate:  args.SampleRate,
+		logger:      logger,
+		w:           w,
+		queue:       make(chan []byte, args.BufferSize),
+		closeNotify: make(chan struct{}),
+		closeDone:   make(chan struct{}),
+	}
+	go l.writeLoop()
+	return l, nil
+}
+
+func (l *QueryLog) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	err := next.ExecNext(ctx, qCtx)
+	if l.sampleRate < 1 && rand.Float64() >= l.sampleRate {
+		return err
+	}
+	b := l.appendEntry(make([]byte, 0, 256), qCtx, err)
+	select {
+	case l.queue <- b:
+	case <-l.closeNotify:
+	default:
+		l.dropped.Add(1)
+	}
+	return err
+}
+
+// Dropped returns the number of entries dropped because the queue was full.
+func (l *QueryLog) Dropped() uint64 {
+	return l.dropped.Load()
+}
+
+// appendEntry appends a json object and a newline to b.
+func (l *QueryLog) appendEntry(b []byte, qCtx *query_context.Context, err error) []byte {
+	n := 0
+	key := func(k string) {
+		if n > 0 {
+			b = append(b, ',')
+		}
+		n++
+		b = append(b, '"')
+		b = append(b, k...)
+		b = append(b, '"', ':')
+	}
+
+	b = append(b, '{')
+	if l.fields&fieldTime != 0 {
+		key("time")
+		b = appendString(b, qCtx.StartTime().Format(time.RFC3339Nano))
+	}
+	if l.fields&fieldUqid != 0 {
+		key("uqid")
+		b = strconv.AppendUint(b, uint64(qCtx.Id()), 10)
+	}
+	if l.fields&fieldClient != 0 {
+		key("client")
+		if addr, ok := query_context.GetClientAddr(qCtx); ok && addr.IsValid() {
+			b = appendString(b, addr.String())
+		} else {
+			b = append(b, "null"...)
+		}
+	}
+	var question dns.Question
+	if q := qCtx.Q(); len(q.Question) > 0 {
+		question = q.Question[0]
+	}
+	if l.fields&fieldQName != 0 {
+		key("qname")
+		b = appendString(b, question.Name)
+	}
+	if l.fields&fieldQType != 0 {
+		key("qtype")
+		b = appendString(b, dnsType(question.Qtype))
+	}
+	r := qCtx.R()
+	if l.fields&fieldRcode != 0 {
+		key("rcode")
+		if r != nil {
+			b = appendString(b, dnsRcode(r.Rcode))
+		} else {
+			b = append(b, "null"...)
+		}
+	}
+	if l.fields&fieldAnswers != 0 {
+		key("answers")
+		b = append(b, '[')
+		i := 0
+		if r != nil {
+			for _, rr := range r.Answer {
+				var s string
+				switch rr := rr.(type) {
+				case *dns.A:
+					s = rr.A.String()
+				case *dns.AAAA:
+					s = rr.AAAA.String()
+				default:
+					continue
+				}
+				if i > 0 {
+					b = append(b, ',')
+				}
+				i++
+				b = appendString(b, s)
+			}
+		}
+		b = append(b, ']')
+	}
+	if l.fields&fieldLatency != 0 {
+		key("latency_ms")
+		b = strconv.AppendFloat(b, float64(time.Since(qCtx.StartTime()).Microseconds())/1000, 'f', -1, 64)
+	}
+	if l.fields&fieldUpstream != 0 {
+		key("upstream")
+		if u, ok := query_context.GetUpstream(qCtx); ok {
+			b = appendString(b, u)
+		} else {
+			b = append(b, "null"...)
+		}
+	}
+	if l.fields&fieldMarks != 0 {
+		key("marks")
+		b = append(b, '[')
+		for i, m := range qCtx.Marks() {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = strconv.AppendUint(b, uint64(m), 10)
+		}
+		b = append(b, ']')
+	}
+	if l.fields&fieldError != 0 && err != nil {
+		key("error")
+		b = appendString(b, err.Error())
+	}
+	return append(b, '}', '\n')
+}
+
+func appendString(b []byte, s string) []byte {
+	js, _ := json.Marshal(s) // never fails for a string
+	return append(b, js...)
+}
+
+func dnsType(t uint16) string {
+	if s, ok := dns.TypeToString[t]; ok {
+		return s
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+func dnsRcode(c int) string {
+	if s, ok := dns.RcodeToString[c]; ok {
+		return s
+	}
+	return "RCODE" + strconv.Itoa(c)
+}
+
+func (l *QueryLog) writeLoop() {
+	defer close(l.closeDone)
+	dropTicker := time.NewTicker(dropReportInterval)
+	defer dropTicker.Stop()
+	flushTicker := time.NewTicker(flushInterval)
+	defer flushTicker.Stop()
+
+	// Entries are buffered and written as a whole, so a rotation never
+	// splits an entry.
+	buf := make([]byte, 0, writeBufferSize)
+	flush := func() {
+		if len(buf) == 0 {
+			return
+		}
+		if _, err := l.w.Write(buf); err != nil {
+			l.logger.Error("failed to write query log", zap.Error(err))
+		}
+		buf = buf[:0]
+	}
+	write := func(b []byte) {
+		buf = append(buf, b...)
+		if len(buf) >= writeBufferSize {
+			flush()
+		}
+	}
+	var reported uint64
+	for {
+		select {
+		case b := <-l.queue:
+			write(b)
+		case <-flushTicker.C:
+			flush()
+		case <-dropTicker.C:
+			if d := l.dropped.Load(); d > reported {
+				l.logger.Warn("query log entries dropped, writer is too slow", zap.Uint64("dropped", d-reported))
+				reported = d
+			}
+		case <-l.closeNotify:
+			for {
+				select {
+				case b := <-l.queue:
+					write(b)
+				default:
+					flush()
+					if err := l.w.Close(); err != nil {
+						l.logger.Error("failed to close query log", zap.Error(err))
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// Close flushes queued entries and closes the writer.
+func (l *QueryLog) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closeNotify)
+	})
+	<-l.closeDone
+	return nil
+}
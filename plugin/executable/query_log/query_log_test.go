@@ -0,0 +1,177 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package query_log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+)
+
+func TestQueryLog(t *testing.T) {
+	var next sequence.ExecutableFunc = func(_ context.Context, qCtx *query_context.Context) error {
+		r := new(dns.Msg)
+		r.SetReply(qCtx.Q())
+		r.Answer = append(r.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   netip.MustParseAddr("192.0.2.1").AsSlice(),
+		})
+		qCtx.SetResponse(r)
+		qCtx.SetMark(2)
+		qCtx.SetMark(1)
+		query_context.SetUpstream(qCtx, "up")
+		return errors.New("test err")
+	}
+	cw := sequence.NewChainWalker([]*sequence.ChainNode{{E: next}}, nil)
+
+	buf := new(bytes.Buffer)
+	l, err := NewQueryLog(nopCloser{buf}, &Args{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	qCtx := query_context.NewContext(q)
+	client := netip.MustParseAddr("127.0.0.1")
+	query_context.SetClientAddr(qCtx, &client)
+	if err := l.Exec(context.Background(), qCtx, cw); err == nil {
+		t.Fatal("error of next should be returned")
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var e map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+		t.Fatalf("invalid entry %q, %v", buf.String(), err)
+	}
+	want := map[string]any{
+		"client":   "127.0.0.1",
+		"qname":    "example.com.",
+		"qtype":    "A",
+		"rcode":    "NOERROR",
+		"upstream": "up",
+		"error":    "test err",
+	}
+	for k, v := range want {
+		if e[k] != v {
+			t.Fatalf("%s: want %v, got %v", k, v, e[k])
+		}
+	}
+	if a, _ := e["answers"].([]any); len(a) != 1 || a[0] != "192.0.2.1" {
+		t.Fatalf("unexpected answers %v", e["answers"])
+	}
+	if m, _ := e["marks"].([]any); len(m) != 2 || m[0] != 1.0 || m[1] != 2.0 {
+		t.Fatalf("unexpected marks %v", e["marks"])
+	}
+	for _, k := range []string{"time", "uqid", "latency_ms"} {
+		if _, ok := e[k]; !ok {
+			t.Fatalf("missing %s", k)
+		}
+	}
+}
+
+func TestQueryLog_Fields(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l, err := NewQueryLog(nopCloser{buf}, &Args{Fields: []string{"qname", "rcode"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	if err := l.Exec(context.Background(), query_context.NewContext(q), sequence.ChainWalker{}); err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Close()
+	if got := strings.TrimSpace(buf.String()); got != `{"qname":"example.com.","rcode":null}` {
+		t.Fatalf("unexpected entry %s", got)
+	}
+
+	if _, err := NewQueryLog(nopCloser{buf}, &Args{Fields: []string{"unknown"}}, nil); err == nil {
+		t.Fatal("unknown field should be rejected")
+	}
+	if _, err := NewQueryLog(nopCloser{buf}, &Args{SampleRate: 2}, nil); err == nil {
+		t.Fatal("invalid sample rate should be rejected")
+	}
+}
+
+func TestQueryLog_Reload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "query.log")
+	plugins := make(map[string]any)
+	m := coremain.NewTestMosdnsWithPlugins(plugins)
+	p, err := Init(coremain.NewBP("log", m), &Args{File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugins["log"] = p
+	prev := p.(*QueryLog)
+
+	g, commit := coremain.NewTestReloadGraph(m, map[string]any{})
+	p, err = Init(coremain.NewBP("log", g), &Args{File: file, Fields: []string{"qname"}, MaxSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit()
+	l := p.(*QueryLog)
+	sw := l.w.(*writerRef).s
+	if sw != prev.w.(*writerRef).s {
+		t.Fatal("log file was not shared")
+	}
+	if sw.w.maxSize != 1<<20 {
+		t.Fatal("rotation args were not updated")
+	}
+
+	// The old plugin is closed after the reload, the file must be kept.
+	exec := func(l *QueryLog) {
+		q := new(dns.Msg)
+		q.SetQuestion("example.com.", dns.TypeA)
+		if err := l.Exec(context.Background(), query_context.NewContext(q), sequence.NewChainWalker(nil, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exec(prev)
+	if err := prev.Close(); err != nil {
+		t.Fatal(err)
+	}
+	exec(l)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 || lines[1] != `{"qname":"example.com."}` {
+		t.Fatalf("unexpected log %q", b)
+	}
+}
@@ -0,0 +1,220 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package query_log
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const backupTimeFormat = "20060102T150405.000"
+
+// rotateWriter is a file writer that rotates the file when it grows
+// larger than maxSize or was opened longer than interval. Rotated files
+// are renamed to "<name>-<time><ext>" and optionally gzipped in the
+// background. It is not safe for concurrent use.
+type rotateWriter struct {
+	path       string
+	maxSize    int64         // 0 disables size based rotation
+	interval   time.Duration // 0 disables time based rotation
+	maxBackups int           // 0 keeps all rotated files
+	compress   bool
+	logger     *zap.Logger
+
+	f        *os.File
+	size     int64
+	openedAt time.Time
+
+	bgMu sync.Mutex // serializes background compressing and pruning
+	bgWg sync.WaitGroup
+}
+
+func (w *rotateWriter) Write(p []byte) (int, error) {
+	if w.f == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+	if w.shouldRotate(len(p)) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *rotateWriter) shouldRotate(n int) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.maxSize > 0 && w.size+int64(n) > w.maxSize {
+		return true
+	}
+	return w.interval > 0 && time.Since(w.openedAt) >= w.interval
+}
+
+func (w *rotateWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	w.f = f
+	w.size = fi.Size()
+	w.openedAt = time.Now()
+	return nil
+}
+
+func (w *rotateWriter) rotate() error {
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	w.f = nil
+	backup := w.backupName(time.Now())
+	if err := os.Rename(w.path, backup); err != nil {
+		return err
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	// Compressing a large file takes a while, don't block writes.
+	compress, maxBackups := w.compress, w.maxBackups
+	w.bgWg.Add(1)
+	go func() {
+		defer w.bgWg.Done()
+		w.bgMu.Lock()
+		defer w.bgMu.Unlock()
+		if compress {
+			if err := gzipFile(backup); err != nil {
+				w.logger.Error("failed to compress rotated file", zap.String("file", backup), zap.Error(err))
+			}
+		}
+		if err := w.prune(maxBackups); err != nil {
+			w.logger.Error("failed to remove old rotated files", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+func (w *rotateWriter) backupName(t time.Time) string {
+	ext := filepath.Ext(w.path)
+	return strings.TrimSuffix(w.path, ext) + "-" + t.Format(backupTimeFormat) + ext
+}
+
+// backups returns rotated files, oldest first.
+func (w *rotateWriter) backups() ([]string, error) {
+	ext := filepath.Ext(w.path)
+	prefix := strings.TrimSuffix(filepath.Base(w.path), ext) + "-"
+	entries, err := os.ReadDir(filepath.Dir(w.path))
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		ts, ok := strings.CutSuffix(strings.TrimSuffix(name, ".gz"), ext)
+		if !ok {
+			continue
+		}
+		if _, err := time.Parse(backupTimeFormat, strings.TrimPrefix(ts, prefix)); err != nil {
+			continue
+		}
+		files = append(files, filepath.Join(filepath.Dir(w.path), name))
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+// prune removes the oldest rotated files, keeping at most maxBackups.
+func (w *rotateWriter) prune(maxBackups int) error {
+	if maxBackups <= 0 {
+		return nil
+	}
+	files, err := w.backups()
+	if err != nil {
+		return err
+	}
+	for len(files) > maxBackups {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// Close closes the file and waits for background compressing and pruning.
+func (w *rotateWriter) Close() error {
+	defer w.bgWg.Wait()
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+// gzipFile compresses file to file.gz and removes file.
+func gzipFile(file string) error {
+	src, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(file+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	_ = src.Close()
+	return os.Remove(file)
+}
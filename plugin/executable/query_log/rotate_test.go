@@ -0,0 +1,72 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package query_log
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+)
+
+func TestRotateWriter(t *testing.T) {
+	dir := t.TempDir()
+	w := &rotateWriter{
+		path:       filepath.Join(dir, "query.jsonl"),
+		maxSize:    10,
+		maxBackups: 2,
+		compress:   true,
+		logger:     mlog.Nop(),
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err := w.Write([]byte("0123456789\n")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond * 2) // backup names have millisecond precision
+	}
+	// Wait for background compressing and pruning.
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := w.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("want 2 backups, got %v", backups)
+	}
+	for _, b := range backups {
+		if !strings.HasSuffix(b, ".jsonl.gz") {
+			t.Fatalf("backup %s is not compressed", b)
+		}
+	}
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "0123456789\n" {
+		t.Fatalf("unexpected current file %q", data)
+	}
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package recursive
+
+import (
+	"hash/maphash"
+	"net/netip"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/cache"
+	"github.com/miekg/dns"
+)
+
+const (
+	minInfraTTL = 5
+	maxInfraTTL = 86400
+)
+
+type key string
+
+var seed = maphash.MakeSeed()
+
+func (k key) Sum() uint64 {
+	return maphash.String(seed, string(k))
+}
+
+// delegation is a zone cut and the addresses of its name servers.
+type delegation struct {
+	zone    string
+	servers []netip.AddrPort
+}
+
+// infraCache caches delegations and addresses of name servers.
+// All names are in canonical form.
+type infraCache struct {
+	zones *cache.Cache[key, *delegation]
+	addrs *cache.Cache[key, []netip.Addr]
+}
+
+func newInfraCache(size int) *infraCache {
+	return &infraCache{
+		zones: cache.New[key, *delegation](cache.Opts{Size: size}),
+		addrs: cache.New[key, []netip.Addr](cache.Opts{Size: size}),
+	}
+}
+
+func (c *infraCache) getDelegation(zone string) *delegation {
+	d, _, _ := c.zones.Get(key(zone))
+	return d
+}
+
+func (c *infraCache) storeDelegation(d *delegation, ttl uint32) {
+	c.zones.Store(key(d.zone), d, expirationTime(ttl))
+}
+
+func (c *infraCache) getAddrs(name string) []netip.Addr {
+	addrs, _, _ := c.addrs.Get(key(name))
+	return addrs
+}
+
+func (c *infraCache) storeAddrs(name string, addrs []netip.Addr, ttl uint32) {
+	c.addrs.Store(key(name), addrs, expirationTime(ttl))
+}
+
+// flush deletes delegations of name and its parents, except the root.
+func (c *infraCache) flush(name string) {
+	c.addrs.Delete(key(name))
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		c.zones.Delete(key(name[off:]))
+	}
+}
+
+func (c *infraCache) close() {
+	_ = c.zones.Close()
+	_ = c.addrs.Close()
+}
+
+func expirationTime(ttl uint32) time.Time {
+	if ttl < minInfraTTL {
+		ttl = minInfraTTL
+	}
+	if ttl > maxInfraTTL {
+		ttl = maxInfraTTL
+	}
+	return time.Now().Add(time.Duration(ttl) * time.Second)
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package recursive
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"go.uber.org/zap"
+)
+
+const PluginType = "recursive"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
+}
+
+var _ sequence.Executable = (*Recursive)(nil)
+
+type Args struct {
+	// RootHints is a list of root server addresses, "ip" or "ip:port".
+	// Default is the IANA root servers.
+	RootHints []string `yaml:"root_hints"`
+	// Port is used to query name servers learned from referrals. Default is 53.
+	Port int `yaml:"port"`
+	// IPv6 allows querying name servers via ipv6.
+	IPv6                     bool `yaml:"ipv6"`
+	DisableQnameMinimization bool `yaml:"disable_qname_minimization"`
+	// Timeout for each exchange with a name server, in milliseconds.
+	Timeout   int `yaml:"timeout"`
+	CacheSize int `yaml:"cache_size"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultNum(&a.Port, 53)
+	utils.SetDefaultNum(&a.Timeout, 1000)
+	utils.SetDefaultNum(&a.CacheSize, 4096)
+}
+
+type Recursive struct {
+	r *Resolver
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return NewRecursive(args.(*Args), bp.L())
+}
+
+// QuickSetup format: [root_hint]...
+func QuickSetup(bq sequence.BQ, s string) (any, error) {
+	return NewRecursive(&Args{RootHints: strings.Fields(s)}, bq.L())
+}
+
+func NewRecursive(args *Args, logger *zap.Logger) (*Recursive, error) {
+	args.init()
+	if !utils.CheckNumRange(args.Port, 1, 65535) {
+		return nil, fmt.Errorf("invalid port %d", args.Port)
+	}
+
+	var roots []netip.AddrPort
+	for _, s := range args.RootHints {
+		ap, err := parseAddrPort(s, uint16(args.Port))
+		if err != nil {
+			return nil, fmt.Errorf("invalid root hint %s, %w", s, err)
+		}
+		roots = append(roots, ap)
+	}
+	if len(roots) == 0 {
+		roots = defaultRootHints(uint16(args.Port), args.IPv6)
+	}
+
+	r := NewResolver(ResolverOpts{
+		Roots:             roots,
+		Port:              uint16(args.Port),
+		IPv6:              args.IPv6,
+		QnameMinimization: !args.DisableQnameMinimization,
+		Timeout:           time.Duration(args.Timeout) * time.Millisecond,
+		CacheSize:         args.CacheSize,
+		Logger:            logger,
+	})
+	return &Recursive{r: r}, nil
+}
+
+func parseAddrPort(s string, defaultPort uint16) (netip.AddrPort, error) {
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap, nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	return netip.AddrPortFrom(addr, defaultPort), nil
+}
+
+// Exec implements sequence.Executable. It resolves the query iteratively
+// and sets the response.
+func (r *Recursive) Exec(ctx context.Context, qCtx *query_context.Context) error {
+	resp, err := r.r.Resolve(ctx, qCtx.Q())
+	if err != nil {
+		return err
+	}
+	qCtx.SetResponse(resp)
+	return nil
+}
+
+// Flush removes cached delegations of domain and its parent zones,
+// except the root. Following queries will re-discover them.
+func (r *Recursive) Flush(domain string) {
+	r.r.Flush(domain)
+}
+
+func (r *Recursive) Close() error {
+	return r.r.Close()
+}
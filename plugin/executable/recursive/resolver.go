@@ -0,0 +1,500 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package recursive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/pkg/dnsutils"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxDepth limits nested resolutions, e.g. name servers without glue.
+	maxDepth       = 6
+	maxReferrals   = 32
+	maxCNAMEs      = 12
+	maxNSResolves  = 3
+	maxServerTries = 4
+	ednsUDPSize    = 1232
+)
+
+var (
+	errMaxDepth         = errors.New("maximum recursion depth reached")
+	errTooManyReferrals = errors.New("too many referrals")
+	errTooManyCNAMEs    = errors.New("cname chain is too long")
+	errNoServer         = errors.New("no usable name server")
+)
+
+type ResolverOpts struct {
+	// Roots are the root name servers. Required.
+	Roots []netip.AddrPort
+	// Port is used for name servers learned from referrals. Default is 53.
+	Port uint16
+	// IPv6 allows name servers to be queried via ipv6.
+	IPv6              bool
+	QnameMinimization bool
+	// Timeout of each exchange with a name server. Default is 1s.
+	Timeout   time.Duration
+	CacheSize int
+	Logger    *zap.Logger
+}
+
+func (opts *ResolverOpts) init() {
+	utils.SetDefaultNum(&opts.Port, 53)
+	utils.SetDefaultNum(&opts.Timeout, time.Second)
+	if opts.Logger == nil {
+		opts.Logger = mlog.Nop()
+	}
+}
+
+// Resolver is an iterative resolver. It caches delegations but not answers.
+type Resolver struct {
+	opts  ResolverOpts
+	infra *infraCache
+}
+
+func NewResolver(opts ResolverOpts) *Resolver {
+	opts.init()
+	return &Resolver{
+		opts:  opts,
+		infra: newInfraCache(opts.CacheSize),
+	}
+}
+
+// Resolve resolves q from the roots and returns a response for q.
+// Name servers are always queried with the DO bit set. Signature and denial
+// records are kept in the response if q has the DO bit set. The resolver
+// does not validate them, so the AD bit is never set and the CD bit of q
+// is copied to the response. Use dnssec_validate for validation.
+func (r *Resolver) Resolve(ctx context.Context, q *dns.Msg) (*dns.Msg, error) {
+	resp := new(dns.Msg)
+	resp.SetReply(q) // also copies the CD bit
+	resp.RecursionAvailable = true
+	clientOpt := q.IsEdns0()
+	clientDO := clientOpt != nil && clientOpt.Do()
+	if clientOpt != nil {
+		resp.SetEdns0(ednsUDPSize, clientDO)
+	}
+	if len(q.Question) != 1 {
+		resp.Rcode = dns.RcodeFormatError
+		return resp, nil
+	}
+	question := q.Question[0]
+	if question.Qclass != dns.ClassINET {
+		resp.Rcode = dns.RcodeNotImplemented
+		return resp, nil
+	}
+
+	m, err := r.resolve(ctx, dns.CanonicalName(question.Name), question.Qtype, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp.Rcode = m.Rcode
+	resp.Answer = m.Answer
+	resp.Ns = m.Ns
+	if !clientDO {
+		dnsutils.StripDNSSEC(resp, question.Qtype)
+	}
+	return resp, nil
+}
+
+// Flush deletes cached delegations of name and its parents.
+func (r *Resolver) Flush(name string) {
+	r.infra.flush(dns.CanonicalName(name))
+}
+
+func (r *Resolver) Close() error {
+	r.infra.close()
+	return nil
+}
+
+// resolve resolves name and follows the cname chain across zones.
+func (r *Resolver) resolve(ctx context.Context, name string, qtype uint16, depth int) (*dns.Msg, error) {
+	if depth > maxDepth {
+		return nil, errMaxDepth
+	}
+
+	var answer []dns.RR
+	target := name
+	for i := 0; i <= maxCNAMEs; i++ {
+		m, err := r.iterate(ctx, target, qtype, depth)
+		if err != nil {
+			return nil, err
+		}
+		answer = append(answer, m.Answer...)
+		next, done := followCNAME(m.Answer, target, qtype)
+		if done || m.Rcode != dns.RcodeSuccess {
+			m.Answer = answer
+			return m, nil
+		}
+		target = next
+	}
+	return nil, errTooManyCNAMEs
+}
+
+// iterate resolves name from the closest known delegation. It follows
+// referrals but not cnames.
+func (r *Resolver) iterate(ctx context.Context, name string, qtype uint16, depth int) (*dns.Msg, error) {
+	d := r.closestDelegation(name)
+	known := d.zone // The longest ancestor of name that is known to exist.
+	qmin := r.opts.QnameMinimization
+	for i := 0; i < maxReferrals; i++ {
+		qname, qt := name, qtype
+		if qmin {
+			if n := nextName(known, name); n != name {
+				qname, qt = n, dns.TypeA
+			}
+		}
+
+		m, err := r.exchange(ctx, d.servers, qname, qt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query %s %s in zone %s, %w", qname, dns.TypeToString[qt], d.zone, err)
+		}
+		sanitize(m, d.zone, qname)
+
+		child, isReferral, err := referral(m, d.zone, qname)
+		if err != nil {
+			return nil, fmt.Errorf("invalid response for %s from zone %s, %w", qname, d.zone, err)
+		}
+		if isReferral {
+			nd, err := r.newDelegation(ctx, m, child, depth)
+			if err != nil {
+				return nil, err
+			}
+			r.opts.Logger.Debug("referral", zap.String("qname", qname), zap.String("from", d.zone), zap.String("to", child))
+			d, known = nd, child
+			continue
+		}
+
+		if qname != name {
+			if m.Rcode == dns.RcodeNameError {
+				// Some servers do not handle empty non-terminals properly.
+				// Retry with the full qname.
+				qmin = false
+			} else {
+				known = qname
+			}
+			continue
+		}
+		return m, nil
+	}
+	return nil, errTooManyReferrals
+}
+
+func (r *Resolver) closestDelegation(name string) *delegation {
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		if d := r.infra.getDelegation(name[off:]); d != nil {
+			return d
+		}
+	}
+	return &delegation{zone: ".", servers: r.opts.Roots}
+}
+
+// newDelegation builds a delegation of zone from the referral m and caches it.
+// m must have been sanitized.
+func (r *Resolver) newDelegation(ctx context.Context, m *dns.Msg, zone string, depth int) (*delegation, error) {
+	var nsNames []string
+	ttl := uint32(maxInfraTTL)
+	for _, rr := range m.Ns {
+		if ns, ok := rr.(*dns.NS); ok && equalName(ns.Hdr.Name, zone) {
+			nsNames = append(nsNames, dns.CanonicalName(ns.Ns))
+			ttl = min(ttl, ns.Hdr.Ttl)
+		}
+	}
+
+	glue := make(map[string][]netip.Addr)
+	for _, rr := range m.Extra {
+		var addr netip.Addr
+		switch rr := rr.(type) {
+		case *dns.A:
+			addr, _ = netip.AddrFromSlice(rr.A.To4())
+		case *dns.AAAA:
+			addr, _ = netip.AddrFromSlice(rr.AAAA)
+		default:
+			continue
+		}
+		name := dns.CanonicalName(rr.Header().Name)
+		glue[name] = append(glue[name], addr)
+	}
+
+	d := &delegation{zone: zone}
+	for _, ns := range nsNames {
+		addrs := glue[ns]
+		if len(addrs) > 0 {
+			r.infra.storeAddrs(ns, addrs, ttl)
+		}
+		d.servers = append(d.servers, r.toServers(addrs)...)
+	}
+
+	if len(d.servers) == 0 { // glueless delegation
+		for i, ns := range nsNames {
+			if i >= maxNSResolves {
+				break
+			}
+			addrs, err := r.lookupAddrs(ctx, ns, depth+1)
+			if err != nil {
+				r.opts.Logger.Debug("failed to resolve name server", zap.String("ns", ns), zap.Error(err))
+				continue
+			}
+			if servers := r.toServers(addrs); len(servers) > 0 {
+				d.servers = servers
+				break
+			}
+		}
+	}
+	if len(d.servers) == 0 {
+		return nil, fmt.Errorf("no address for name servers of %s", zone)
+	}
+	r.infra.storeDelegation(d, ttl)
+	return d, nil
+}
+
+// lookupAddrs returns the addresses of the name server ns.
+func (r *Resolver) lookupAddrs(ctx context.Context, ns string, depth int) ([]netip.Addr, error) {
+	if addrs := r.infra.getAddrs(ns); len(addrs) > 0 {
+		return addrs, nil
+	}
+
+	qtypes := []uint16{dns.TypeA}
+	if r.opts.IPv6 {
+		qtypes = append(qtypes, dns.TypeAAAA)
+	}
+	var addrs []netip.Addr
+	ttl := uint32(maxInfraTTL)
+	for _, qt := range qtypes {
+		m, err := r.resolve(ctx, ns, qt, depth)
+		if err != nil {
+			return nil, err
+		}
+		for _, rr := range m.Answer {
+			var addr netip.Addr
+			switch rr := rr.(type) {
+			case *dns.A:
+				addr, _ = netip.AddrFromSlice(rr.A.To4())
+			case *dns.AAAA:
+				addr, _ = netip.AddrFromSlice(rr.AAAA)
+			default:
+				continue
+			}
+			addrs = append(addrs, addr)
+			ttl = min(ttl, rr.Header().Ttl)
+		}
+	}
+	if len(addrs) > 0 {
+		r.infra.storeAddrs(ns, addrs, ttl)
+	}
+	return addrs, nil
+}
+
+func (r *Resolver) toServers(addrs []netip.Addr) []netip.AddrPort {
+	var s []netip.AddrPort
+	for _, addr := range addrs {
+		if !r.opts.IPv6 && !addr.Unmap().Is4() {
+			continue
+		}
+		s = append(s, netip.AddrPortFrom(addr, r.opts.Port))
+	}
+	return s
+}
+
+// exchange sends the query to servers in random order until one of them
+// returns a NOERROR or NXDOMAIN response.
+func (r *Resolver) exchange(ctx context.Context, servers []netip.AddrPort, qname string, qtype uint16) (*dns.Msg, error) {
+	q := new(dns.Msg)
+	q.SetQuestion(qname, qtype)
+	q.RecursionDesired = false
+	q.SetEdns0(ednsUDPSize, true) // DO, so signatures can be validated downstream
+
+	order := rand.Perm(len(servers))
+	es := new(utils.Errors)
+	for i, idx := range order {
+		if i >= maxServerTries {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		server := servers[idx]
+		m, err := r.exchangeServer(ctx, server, q)
+		if err != nil {
+			es.Append(fmt.Errorf("%s: %w", server, err))
+			continue
+		}
+		if m.Rcode != dns.RcodeSuccess && m.Rcode != dns.RcodeNameError {
+			es.Append(fmt.Errorf("%s: rcode %s", server, dns.RcodeToString[m.Rcode]))
+			continue
+		}
+		return m, nil
+	}
+	if es.Len() == 0 {
+		return nil, errNoServer
+	}
+	return nil, es
+}
+
+func (r *Resolver) exchangeServer(ctx context.Context, server netip.AddrPort, q *dns.Msg) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.opts.Timeout)
+	defer cancel()
+
+	c := &dns.Client{Net: "udp", UDPSize: ednsUDPSize}
+	m, _, err := c.ExchangeContext(ctx, q, server.String())
+	if err == nil && m.Truncated {
+		c = &dns.Client{Net: "tcp"}
+		m, _, err = c.ExchangeContext(ctx, q, server.String())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Question) != 1 || !equalName(m.Question[0].Name, q.Question[0].Name) || m.Question[0].Qtype != q.Question[0].Qtype {
+		return nil, errors.New("question mismatched")
+	}
+	return m, nil
+}
+
+// sanitize applies the bailiwick rule to m. Records that are not in zone are
+// removed. Answer records that are not in the cname chain of qname are
+// removed.
+func sanitize(m *dns.Msg, zone, qname string) {
+	inZone := func(rr dns.RR) bool {
+		return rr.Header().Rrtype != dns.TypeOPT && dns.IsSubDomain(zone, rr.Header().Name)
+	}
+	m.Answer = filterRR(m.Answer, inZone)
+	m.Ns = filterRR(m.Ns, inZone)
+	m.Extra = filterRR(m.Extra, inZone)
+
+	chain := map[string]struct{}{dns.CanonicalName(qname): {}}
+	for changed := true; changed; {
+		changed = false
+		for _, rr := range m.Answer {
+			if cname, ok := rr.(*dns.CNAME); ok {
+				if _, ok := chain[dns.CanonicalName(cname.Hdr.Name)]; !ok {
+					continue
+				}
+				t := dns.CanonicalName(cname.Target)
+				if _, ok := chain[t]; !ok {
+					chain[t] = struct{}{}
+					changed = true
+				}
+			}
+		}
+	}
+	m.Answer = filterRR(m.Answer, func(rr dns.RR) bool {
+		_, ok := chain[dns.CanonicalName(rr.Header().Name)]
+		return ok
+	})
+}
+
+// referral reports whether m is a referral to a child zone of zone.
+func referral(m *dns.Msg, zone, qname string) (string, bool, error) {
+	if m.Rcode != dns.RcodeSuccess || len(m.Answer) > 0 {
+		return "", false, nil
+	}
+	var child string
+	for _, rr := range m.Ns {
+		switch rr.Header().Rrtype {
+		case dns.TypeSOA:
+			return "", false, nil // negative response
+		case dns.TypeNS:
+			if len(child) == 0 {
+				child = dns.CanonicalName(rr.Header().Name)
+			}
+		}
+	}
+	if len(child) == 0 {
+		return "", false, nil
+	}
+	if equalName(child, zone) {
+		if m.Authoritative {
+			return "", false, nil // NODATA with apex NS
+		}
+		return "", false, errors.New("lame delegation")
+	}
+	if !dns.IsSubDomain(child, qname) {
+		return "", false, fmt.Errorf("out of bailiwick referral to %s", child)
+	}
+	return child, true, nil
+}
+
+// followCNAME follows the cname chain of name in answer. If the chain ends
+// with a cname target that has no record in answer, it returns the target.
+func followCNAME(answer []dns.RR, name string, qtype uint16) (string, bool) {
+	if qtype == dns.TypeCNAME || qtype == dns.TypeANY {
+		return "", true
+	}
+	cur := name
+	for i := 0; i <= len(answer); i++ {
+		var next string
+		for _, rr := range answer {
+			h := rr.Header()
+			if !equalName(h.Name, cur) {
+				continue
+			}
+			if h.Rrtype == qtype {
+				return "", true
+			}
+			if cname, ok := rr.(*dns.CNAME); ok {
+				next = dns.CanonicalName(cname.Target)
+			}
+		}
+		if len(next) == 0 {
+			break
+		}
+		cur = next
+	}
+	if cur == name {
+		return "", true
+	}
+	return cur, false
+}
+
+// nextName returns the name that has one more label than known, which
+// must be an ancestor of name.
+func nextName(known, name string) string {
+	labels := dns.CountLabel(known)
+	idx := dns.Split(name)
+	if len(idx) <= labels+1 {
+		return name
+	}
+	return name[idx[len(idx)-labels-1]:]
+}
+
+func filterRR(rrs []dns.RR, keep func(rr dns.RR) bool) []dns.RR {
+	n := 0
+	for _, rr := range rrs {
+		if keep(rr) {
+			rrs[n] = rr
+			n++
+		}
+	}
+	return rrs[:n]
+}
+
+func equalName(a, b string) bool {
+	return dns.CanonicalName(a) == dns.CanonicalName(b)
+}
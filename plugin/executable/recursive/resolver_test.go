@@ -0,0 +1,367 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package recursive
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// fakeAuth is a minimal authoritative server for the zones it loads.
+type fakeAuth struct {
+	zones map[string][]dns.RR // zone -> records
+
+	mu      sync.Mutex
+	queries []dns.Question
+	noDO    bool // a query without the DO bit was received
+}
+
+func newFakeAuth(t *testing.T, zones map[string][]string) *fakeAuth {
+	a := &fakeAuth{zones: make(map[string][]dns.RR)}
+	for zone, records := range zones {
+		for _, s := range records {
+			rr, err := dns.NewRR(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a.zones[zone] = append(a.zones[zone], rr)
+		}
+	}
+	return a
+}
+
+func (a *fakeAuth) ServeDNS(w dns.ResponseWriter, q *dns.Msg) {
+	question := q.Question[0]
+	opt := q.IsEdns0()
+	do := opt != nil && opt.Do()
+	a.mu.Lock()
+	a.queries = append(a.queries, question)
+	a.noDO = a.noDO || !do
+	a.mu.Unlock()
+
+	r := new(dns.Msg)
+	r.SetReply(q)
+	qname := dns.CanonicalName(question.Name)
+
+	// find the closest zone
+	var zone string
+	for z := range a.zones {
+		if dns.IsSubDomain(z, qname) && len(z) > len(zone) {
+			zone = z
+		}
+	}
+	if len(zone) == 0 {
+		r.Rcode = dns.RcodeRefused
+		_ = w.WriteMsg(r)
+		return
+	}
+	records := a.zones[zone]
+
+	// delegations
+	for _, rr := range records {
+		h := rr.Header()
+		if h.Rrtype == dns.TypeNS && !equalName(h.Name, zone) && dns.IsSubDomain(h.Name, qname) {
+			for _, rr := range records {
+				if rr.Header().Rrtype == dns.TypeNS && equalName(rr.Header().Name, h.Name) {
+					r.Ns = append(r.Ns, rr)
+					for _, glue := range records {
+						t := glue.Header().Rrtype
+						if (t == dns.TypeA || t == dns.TypeAAAA) && equalName(glue.Header().Name, rr.(*dns.NS).Ns) {
+							r.Extra = append(r.Extra, glue)
+						}
+					}
+				}
+			}
+			_ = w.WriteMsg(r)
+			return
+		}
+	}
+
+	r.Authoritative = true
+	exist := false
+	for _, rr := range records {
+		h := rr.Header()
+		if dns.IsSubDomain(qname, h.Name) {
+			exist = true // name or empty non-terminal
+		}
+		if !equalName(h.Name, qname) {
+			continue
+		}
+		if h.Rrtype == question.Qtype || h.Rrtype == dns.TypeCNAME {
+			r.Answer = append(r.Answer, rr)
+		}
+		if sig, ok := rr.(*dns.RRSIG); ok && do && sig.TypeCovered == question.Qtype {
+			r.Answer = append(r.Answer, rr)
+		}
+	}
+	if len(r.Answer) == 0 {
+		if !exist {
+			r.Rcode = dns.RcodeNameError
+		}
+		for _, rr := range records {
+			if rr.Header().Rrtype == dns.TypeSOA {
+				r.Ns = append(r.Ns, rr)
+			}
+		}
+	}
+	_ = w.WriteMsg(r)
+}
+
+func (a *fakeAuth) seen(name string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, q := range a.queries {
+		if equalName(q.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// startFakeServers starts handlers on 127.0.0.1, 127.0.0.2 ... with the same port.
+func startFakeServers(t *testing.T, handlers ...dns.Handler) uint16 {
+	for try := 0; try < 10; try++ {
+		l, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := l.LocalAddr().(*net.UDPAddr).Port
+		conns := []net.PacketConn{l}
+		for i := 1; i < len(handlers); i++ {
+			c, err := net.ListenPacket("udp", net.JoinHostPort("127.0.0."+strconv.Itoa(i+1), strconv.Itoa(port)))
+			if err != nil {
+				break
+			}
+			conns = append(conns, c)
+		}
+		if len(conns) != len(handlers) {
+			for _, c := range conns {
+				c.Close()
+			}
+			continue
+		}
+		for i, c := range conns {
+			s := &dns.Server{PacketConn: c, Handler: handlers[i]}
+			go s.ActivateAndServe()
+			t.Cleanup(func() { _ = s.Shutdown() })
+		}
+		return uint16(port)
+	}
+	t.Fatal("failed to find a free port")
+	return 0
+}
+
+func TestResolver(t *testing.T) {
+	root := newFakeAuth(t, map[string][]string{
+		".": {
+			". 86400 IN SOA a.root. nstld. 1 1800 900 604800 86400",
+			"test. 86400 IN NS ns.test.",
+			"ns.test. 86400 IN A 127.0.0.2",
+		},
+	})
+	tld := newFakeAuth(t, map[string][]string{
+		"test.": {
+			"test. 3600 IN SOA ns.test. admin.test. 1 1800 900 604800 300",
+			"example.test. 3600 IN NS ns.example.test.",
+			"ns.example.test. 3600 IN A 127.0.0.3",
+			"other.test. 3600 IN NS ns.example.test.",
+			"glueless.test. 3600 IN NS ns.other.test.",
+			"bad.test. 3600 IN NS ns.evil.",
+			"ns.evil. 3600 IN A 127.0.0.3", // out of bailiwick glue
+		},
+	})
+	auth := newFakeAuth(t, map[string][]string{
+		"example.test.": {
+			"example.test. 3600 IN SOA ns.example.test. admin.example.test. 1 1800 900 604800 300",
+			"www.example.test. 300 IN A 192.0.2.1",
+			"www.example.test. 300 IN RRSIG A 8 3 300 20300101000000 20200101000000 12345 example.test. AAAA",
+			"a.b.c.example.test. 300 IN A 192.0.2.2",
+			"alias.example.test. 300 IN CNAME www.other.test.",
+		},
+		"other.test.": {
+			"other.test. 3600 IN SOA ns.example.test. admin.example.test. 1 1800 900 604800 300",
+			"www.other.test. 300 IN A 192.0.2.3",
+			"ns.other.test. 300 IN A 127.0.0.3",
+		},
+		"bad.test.": {
+			"bad.test. 3600 IN SOA ns.evil. admin.example.test. 1 1800 900 604800 300",
+			"www.bad.test. 300 IN A 192.0.2.5",
+		},
+		"glueless.test.": {
+			"glueless.test. 3600 IN SOA ns.other.test. admin.example.test. 1 1800 900 604800 300",
+			"www.glueless.test. 300 IN A 192.0.2.4",
+		},
+	})
+	port := startFakeServers(t, root, tld, auth)
+
+	r := NewResolver(ResolverOpts{
+		Roots:             []netip.AddrPort{netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), port)},
+		Port:              port,
+		QnameMinimization: true,
+		Timeout:           time.Second,
+	})
+	defer r.Close()
+
+	tests := []struct {
+		name      string
+		qname     string
+		qtype     uint16
+		wantRcode int
+		wantAddr  string
+	}{
+		{"answer", "www.example.test.", dns.TypeA, dns.RcodeSuccess, "192.0.2.1"},
+		{"empty_non_terminal", "a.b.c.example.test.", dns.TypeA, dns.RcodeSuccess, "192.0.2.2"},
+		{"cname_cross_zone", "alias.example.test.", dns.TypeA, dns.RcodeSuccess, "192.0.2.3"},
+		{"glueless", "www.glueless.test.", dns.TypeA, dns.RcodeSuccess, "192.0.2.4"},
+		{"nxdomain", "nx.example.test.", dns.TypeA, dns.RcodeNameError, ""},
+		{"nodata", "www.example.test.", dns.TypeAAAA, dns.RcodeSuccess, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := new(dns.Msg)
+			q.SetQuestion(tt.qname, tt.qtype)
+			resp, err := r.Resolve(context.Background(), q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Rcode != tt.wantRcode {
+				t.Fatalf("want rcode %d, got %d", tt.wantRcode, resp.Rcode)
+			}
+			var got string
+			for _, rr := range resp.Answer {
+				if a, ok := rr.(*dns.A); ok {
+					got = a.A.String()
+				}
+			}
+			if got != tt.wantAddr {
+				t.Fatalf("want addr %q, got %q, resp: %s", tt.wantAddr, got, resp)
+			}
+			if len(tt.wantAddr) == 0 && !hasSOA(resp) {
+				t.Fatalf("negative response has no soa, resp: %s", resp)
+			}
+		})
+	}
+
+	if root.seen("www.example.test.") || tld.seen("www.example.test.") {
+		t.Fatal("full qname was leaked to parent zones")
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("www.bad.test.", dns.TypeA)
+	if _, err := r.Resolve(context.Background(), q); err == nil {
+		t.Fatal("out of bailiwick glue was used")
+	}
+	if addrs := r.infra.getAddrs("ns.evil."); len(addrs) > 0 {
+		t.Fatal("out of bailiwick glue was cached")
+	}
+
+	if root.noDO || tld.noDO || auth.noDO {
+		t.Fatal("name servers were queried without the DO bit")
+	}
+
+	r.Flush("www.example.test.")
+	if d := r.infra.getDelegation("example.test."); d != nil {
+		t.Fatal("delegation was not flushed")
+	}
+}
+
+func TestResolver_dnssec(t *testing.T) {
+	root := newFakeAuth(t, map[string][]string{
+		".": {
+			". 86400 IN SOA a.root. nstld. 1 1800 900 604800 86400",
+			"www.test. 300 IN A 192.0.2.1",
+			"www.test. 300 IN RRSIG A 8 2 300 20300101000000 20200101000000 12345 . AAAA",
+		},
+	})
+	port := startFakeServers(t, root)
+	r := NewResolver(ResolverOpts{
+		Roots:   []netip.AddrPort{netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), port)},
+		Timeout: time.Second,
+	})
+	defer r.Close()
+
+	hasSig := func(m *dns.Msg) bool {
+		for _, rr := range m.Answer {
+			if rr.Header().Rrtype == dns.TypeRRSIG {
+				return true
+			}
+		}
+		return false
+	}
+
+	q := new(dns.Msg)
+	q.SetQuestion("www.test.", dns.TypeA)
+	q.CheckingDisabled = true
+	q.SetEdns0(1232, true)
+	resp, err := r.Resolve(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasSig(resp) {
+		t.Fatalf("signature was removed, resp: %s", resp)
+	}
+	if opt := resp.IsEdns0(); opt == nil || !opt.Do() {
+		t.Fatal("response has no DO bit")
+	}
+	if !resp.CheckingDisabled || resp.AuthenticatedData {
+		t.Fatal("CD bit was not copied or AD bit was set")
+	}
+
+	q = new(dns.Msg)
+	q.SetQuestion("www.test.", dns.TypeA)
+	resp, err = r.Resolve(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasSig(resp) || resp.IsEdns0() != nil {
+		t.Fatalf("client without DO got dnssec records, resp: %s", resp)
+	}
+}
+
+func hasSOA(m *dns.Msg) bool {
+	for _, rr := range m.Ns {
+		if rr.Header().Rrtype == dns.TypeSOA {
+			return true
+		}
+	}
+	return false
+}
+
+func Test_nextName(t *testing.T) {
+	tests := []struct {
+		known, name, want string
+	}{
+		{".", "www.example.test.", "test."},
+		{"test.", "www.example.test.", "example.test."},
+		{"example.test.", "www.example.test.", "www.example.test."},
+		{"www.example.test.", "www.example.test.", "www.example.test."},
+	}
+	for _, tt := range tests {
+		if got := nextName(tt.known, tt.name); got != tt.want {
+			t.Errorf("nextName(%s, %s) = %s, want %s", tt.known, tt.name, got, tt.want)
+		}
+	}
+}
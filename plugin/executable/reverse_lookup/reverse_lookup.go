@@ -0,0 +1,196 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package reverse_lookup
+
+import (
+	"context"
+	"fmt"
+	"hash/maphash"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/cache"
+	"github.com/IrineSistiana/mosdns/v5/pkg/dnsutils"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+)
+
+const PluginType = "reverse_lookup"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+var _ sequence.RecursiveExecutable = (*ReverseLookup)(nil)
+
+type Args struct {
+	// Size is the maximum number of recorded addresses. Default is 65536.
+	Size int `yaml:"size"`
+	// HandlePTR answers PTR queries of recorded addresses.
+	HandlePTR bool `yaml:"handle_ptr"`
+	// TTL is the maximum lifetime of a record in seconds. Records also
+	// expire with the TTL of their answers. Default is 7200.
+	TTL int `yaml:"ttl"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultNum(&a.Size, 64*1024)
+	utils.SetDefaultNum(&a.TTL, 7200)
+}
+
+type key netip.Addr
+
+var seed = maphash.MakeSeed()
+
+func (k key) Sum() uint64 {
+	b := netip.Addr(k).As16()
+	return maphash.Bytes(seed, b[:])
+}
+
+type ReverseLookup struct {
+	args *Args
+	c    *cache.Cache[key, string]
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	p := NewReverseLookup(args.(*Args))
+	bp.RegAPI(p.Api())
+	return p, nil
+}
+
+func NewReverseLookup(args *Args) *ReverseLookup {
+	args.init()
+	return &ReverseLookup{
+		args: args,
+		c:    cache.New[key, string](cache.Opts{Size: args.Size}),
+	}
+}
+
+func (p *ReverseLookup) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	if p.args.HandlePTR {
+		if r := p.ResponsePTR(qCtx.Q()); r != nil {
+			qCtx.SetResponse(r)
+			return nil
+		}
+	}
+	if err := next.ExecNext(ctx, qCtx); err != nil {
+		return err
+	}
+	p.saveIPs(qCtx.R())
+	return nil
+}
+
+// Lookup returns the domain that addr was resolved from, or an empty
+// string if addr is unknown.
+func (p *ReverseLookup) Lookup(addr netip.Addr) string {
+	d, _, _ := p.c.Get(key(addr.Unmap()))
+	return d
+}
+
+// ResponsePTR returns a PTR response if q is a PTR query of a recorded
+// address. Otherwise, it returns nil.
+func (p *ReverseLookup) ResponsePTR(q *dns.Msg) *dns.Msg {
+	if len(q.Question) != 1 || q.Question[0].Qtype != dns.TypePTR {
+		return nil
+	}
+	question := q.Question[0]
+	addr, err := dnsutils.ParsePTRQName(strings.ToLower(question.Name))
+	if err != nil {
+		return nil
+	}
+	d, expire, ok := p.c.Get(key(addr.Unmap()))
+	if !ok {
+		return nil
+	}
+	ttl := uint32(1)
+	if s := time.Until(expire) / time.Second; s > 1 {
+		ttl = uint32(s)
+	}
+	r := new(dns.Msg)
+	r.SetReply(q)
+	r.Answer = append(r.Answer, &dns.PTR{
+		Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypePTR, Class: question.Qclass, Ttl: ttl},
+		Ptr: d,
+	})
+	return r
+}
+
+func (p *ReverseLookup) saveIPs(r *dns.Msg) {
+	if r == nil || r.Rcode != dns.RcodeSuccess || len(r.Question) != 1 {
+		return
+	}
+	now := time.Now()
+	maxTTL := uint32(p.args.TTL)
+	for _, rr := range r.Answer {
+		var ip []byte
+		switch rr := rr.(type) {
+		case *dns.A:
+			ip = rr.A
+		case *dns.AAAA:
+			ip = rr.AAAA
+		default:
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(ip)
+		if !ok {
+			continue
+		}
+		ttl := rr.Header().Ttl
+		if ttl > maxTTL {
+			ttl = maxTTL
+		}
+		if ttl == 0 {
+			continue
+		}
+		// Record the queried name rather than the owner of the record, which
+		// may be the target of a CNAME chain.
+		p.c.Store(key(addr.Unmap()), dns.Fqdn(r.Question[0].Name), now.Add(time.Duration(ttl)*time.Second))
+	}
+}
+
+// Api returns a handler that serves GET /{ip}. It replies the domain that
+// ip was resolved from, or 404 if ip is unknown.
+func (p *ReverseLookup) Api() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{ip}", func(w http.ResponseWriter, req *http.Request) {
+		addr, err := netip.ParseAddr(req.PathValue("ip"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid ip, %s", err), http.StatusBadRequest)
+			return
+		}
+		d := p.Lookup(addr)
+		if len(d) == 0 {
+			http.NotFound(w, req)
+			return
+		}
+		_, _ = io.WriteString(w, d)
+	})
+	return mux
+}
+
+func (p *ReverseLookup) Close() error {
+	return p.c.Close()
+}
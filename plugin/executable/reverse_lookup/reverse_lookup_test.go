@@ -0,0 +1,114 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package reverse_lookup
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+)
+
+type answerNext struct {
+	rrs []string
+}
+
+func (a *answerNext) Exec(_ context.Context, qCtx *query_context.Context) error {
+	r := new(dns.Msg)
+	r.SetReply(qCtx.Q())
+	for _, s := range a.rrs {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			return err
+		}
+		r.Answer = append(r.Answer, rr)
+	}
+	qCtx.SetResponse(r)
+	return nil
+}
+
+func TestReverseLookup(t *testing.T) {
+	p := NewReverseLookup(&Args{HandlePTR: true, TTL: 60})
+	defer p.Close()
+
+	next := &answerNext{rrs: []string{
+		"www.example. 300 IN CNAME cdn.example.",
+		"cdn.example. 300 IN A 192.0.2.1",
+		"cdn.example. 0 IN A 192.0.2.2",
+	}}
+	q := new(dns.Msg)
+	q.SetQuestion("www.example.", dns.TypeA)
+	cw := sequence.NewChainWalker([]*sequence.ChainNode{{E: next}}, nil)
+	if err := p.Exec(context.Background(), query_context.NewContext(q), cw); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := p.Lookup(netip.MustParseAddr("192.0.2.1")); got != "www.example." {
+		t.Fatalf("want www.example., got %q", got)
+	}
+	if got := p.Lookup(netip.MustParseAddr("192.0.2.2")); got != "" {
+		t.Fatalf("zero ttl answer should not be recorded, got %q", got)
+	}
+
+	// PTR
+	q = new(dns.Msg)
+	q.SetQuestion("1.2.0.192.IN-ADDR.ARPA.", dns.TypePTR)
+	cw = sequence.NewChainWalker([]*sequence.ChainNode{{E: &answerNext{}}}, nil)
+	qCtx := query_context.NewContext(q)
+	if err := p.Exec(context.Background(), qCtx, cw); err != nil {
+		t.Fatal(err)
+	}
+	r := qCtx.R()
+	if r == nil || len(r.Answer) != 1 {
+		t.Fatalf("want a ptr answer, got %v", r)
+	}
+	ptr := r.Answer[0].(*dns.PTR)
+	if ptr.Ptr != "www.example." || ptr.Hdr.Ttl > 60 {
+		t.Fatalf("unexpected ptr %s", ptr)
+	}
+
+	// Api
+	srv := httptest.NewServer(p.Api())
+	defer srv.Close()
+	for ip, want := range map[string]int{
+		"192.0.2.1": http.StatusOK,
+		"192.0.2.3": http.StatusNotFound,
+		"invalid":   http.StatusBadRequest,
+	} {
+		resp, err := http.Get(srv.URL + "/" + ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("%s: want status %d, got %d", ip, want, resp.StatusCode)
+		}
+		if want == http.StatusOK && string(b) != "www.example." {
+			t.Fatalf("%s: unexpected body %q", ip, b)
+		}
+	}
+}
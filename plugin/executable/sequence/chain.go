@@ -100,15 +100,21 @@ func (w *ChainWalker) nop() bool {
 	return w.p >= len(w.chain)
 }
 
+// buildChain builds the chain from rs. It reports errors of all rules.
 func (s *Sequence) buildChain(bq BQ, rs []RuleConfig) error {
 	c := make([]*ChainNode, 0, len(rs))
+	var errs []error
 	for ri, r := range rs {
 		n, err := s.newNode(bq, r, ri)
 		if err != nil {
-			return fmt.Errorf("failed to init rule #%d, %w", ri, err)
+			errs = append(errs, fmt.Errorf("failed to init rule #%d, %w", ri, err))
+			continue
 		}
 		c = append(c, n)
 	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
 	s.chain = c
 	return nil
 }
@@ -26,6 +26,40 @@ type RuleArgs struct {
 	Exec    string   `yaml:"exec"`
 }
 
+// Deps implements coremain.ArgsWithDeps. It returns plugins referenced
+// by "$tag" and targets of jump and goto.
+func (a *Args) Deps() []string {
+	var deps []string
+	addQuickSetup := func(typ, args string) {
+		switch typ {
+		case "jump", "goto":
+			deps = append(deps, args)
+			return
+		}
+		for _, f := range strings.Fields(args) {
+			if tag, ok := strings.CutPrefix(f, "$"); ok && len(tag) > 0 {
+				deps = append(deps, tag)
+			}
+		}
+	}
+	for _, ra := range *a {
+		rc := parseArgs(ra)
+		for _, mc := range rc.Matches {
+			if len(mc.Tag) > 0 {
+				deps = append(deps, mc.Tag)
+			} else {
+				addQuickSetup(mc.Type, mc.Args)
+			}
+		}
+		if len(rc.Tag) > 0 {
+			deps = append(deps, rc.Tag)
+		} else {
+			addQuickSetup(rc.Type, rc.Args)
+		}
+	}
+	return deps
+}
+
 func parseArgs(ra RuleArgs) RuleConfig {
 	var rc RuleConfig
 	for _, s := range ra.Matches {
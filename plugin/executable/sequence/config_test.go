@@ -79,3 +79,16 @@ func Test_parseMatch(t *testing.T) {
 		})
 	}
 }
+
+func TestArgs_Deps(t *testing.T) {
+	args := Args{
+		{Matches: []string{"$m1", "!qname $set1 $set2 example.com"}, Exec: "$e1"},
+		{Matches: []string{"resp_ip_mmdb $mmdb CN"}, Exec: "jump seq1"},
+		{Exec: "goto seq2"},
+		{Exec: "ttl 300"},
+	}
+	want := []string{"m1", "set1", "set2", "e1", "mmdb", "seq1", "seq2"}
+	if got := args.Deps(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Deps() = %v, want %v", got, want)
+	}
+}
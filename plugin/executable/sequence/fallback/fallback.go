@@ -64,6 +64,11 @@ type Args struct {
 	AlwaysStandby bool `yaml:"always_standby"`
 }
 
+// Deps implements coremain.ArgsWithDeps.
+func (a *Args) Deps() []string {
+	return []string{a.Primary, a.Secondary}
+}
+
 func Init(bp *coremain.BP, args any) (any, error) {
 	return newFallbackPlugin(bp, args.(*Args))
 }
@@ -54,7 +54,7 @@ func (s *Sequence) Close() error {
 	return nil
 }
 
-type Args = []RuleArgs
+type Args []RuleArgs
 
 func Init(bp *coremain.BP, args any) (any, error) {
 	return NewSequence(bp, *args.(*Args))
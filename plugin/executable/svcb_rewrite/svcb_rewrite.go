@@ -0,0 +1,310 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package svcb_rewrite
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const PluginType = "svcb_rewrite"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
+}
+
+var _ sequence.RecursiveExecutable = (*Rewriter)(nil)
+
+// Args configures how SvcParams of HTTPS/SVCB answers are rewritten.
+// A record is dropped if a key in its mandatory list was removed.
+type Args struct {
+	// NoIPv4Hint and NoIPv6Hint remove ipv4hint and ipv6hint.
+	NoIPv4Hint bool `yaml:"no_ipv4hint"`
+	NoIPv6Hint bool `yaml:"no_ipv6hint"`
+	// NoECH removes ech.
+	NoECH bool `yaml:"no_ech"`
+	// StripALPN are protocol ids, e.g. "h3", that are removed from alpn.
+	StripALPN []string `yaml:"strip_alpn"`
+	// SyncHints replaces hints with the A/AAAA answers of the target name.
+	// The A/AAAA queries are sent through the rest of the sequence, so
+	// hints are consistent with what ip_rewrite, black_hole, prefer_ipv4
+	// etc. do to A/AAAA queries. A hint is removed if there is no answer.
+	SyncHints bool `yaml:"sync_hints"`
+}
+
+type Rewriter struct {
+	args      *Args
+	stripALPN map[string]struct{}
+	logger    *zap.Logger
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return NewRewriter(args.(*Args), bp.L()), nil
+}
+
+// QuickSetup format: [no_ipv4hint|no_ipv6hint|no_ech|sync_hints|strip_alpn=id[,id...]]...
+func QuickSetup(bq sequence.BQ, s string) (any, error) {
+	args := new(Args)
+	for _, opt := range strings.Fields(s) {
+		k, v, _ := strings.Cut(opt, "=")
+		switch k {
+		case "no_ipv4hint":
+			args.NoIPv4Hint = true
+		case "no_ipv6hint":
+			args.NoIPv6Hint = true
+		case "no_ech":
+			args.NoECH = true
+		case "sync_hints":
+			args.SyncHints = true
+		case "strip_alpn":
+			args.StripALPN = append(args.StripALPN, strings.Split(v, ",")...)
+		default:
+			return nil, fmt.Errorf("invalid option %s", opt)
+		}
+	}
+	return NewRewriter(args, bq.L()), nil
+}
+
+func NewRewriter(args *Args, logger *zap.Logger) *Rewriter {
+	r := &Rewriter{args: args, logger: logger}
+	if len(args.StripALPN) > 0 {
+		r.stripALPN = make(map[string]struct{})
+		for _, id := range args.StripALPN {
+			r.stripALPN[id] = struct{}{}
+		}
+	}
+	return r
+}
+
+func (w *Rewriter) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	if err := next.ExecNext(ctx, qCtx); err != nil {
+		return err
+	}
+	q := qCtx.Q()
+	r := qCtx.R()
+	if len(q.Question) != 1 || r == nil {
+		return nil
+	}
+	if qt := q.Question[0].Qtype; qt != dns.TypeHTTPS && qt != dns.TypeSVCB {
+		return nil
+	}
+
+	// Records of r may be shared with others, e.g. the cache, so
+	// records are copied before rewriting.
+	var records []*dns.SVCB
+	answer := make([]dns.RR, 0, len(r.Answer))
+	for _, rr := range r.Answer {
+		switch rr := rr.(type) {
+		case *dns.SVCB:
+			c := dns.Copy(rr).(*dns.SVCB)
+			records = append(records, c)
+			answer = append(answer, c)
+		case *dns.HTTPS:
+			c := dns.Copy(rr).(*dns.HTTPS)
+			records = append(records, &c.SVCB)
+			answer = append(answer, c)
+		default:
+			answer = append(answer, rr)
+		}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	r.Answer = answer
+
+	var hints map[string]*addrHints
+	if w.args.SyncHints {
+		hints = w.lookupHints(ctx, qCtx, next, records)
+	}
+	dropped := make(map[*dns.SVCB]struct{})
+	for _, rec := range records {
+		if rec.Priority == 0 { // AliasMode has no SvcParams.
+			continue
+		}
+		v, ok := w.rewrite(rec.Value, hints[hintName(rec)])
+		if !ok {
+			dropped[rec] = struct{}{}
+			continue
+		}
+		rec.Value = v
+	}
+	if len(dropped) > 0 {
+		answer = answer[:0]
+		for _, rr := range r.Answer {
+			var rec *dns.SVCB
+			switch rr := rr.(type) {
+			case *dns.SVCB:
+				rec = rr
+			case *dns.HTTPS:
+				rec = &rr.SVCB
+			}
+			if _, drop := dropped[rec]; rec == nil || !drop {
+				answer = append(answer, rr)
+			}
+		}
+		r.Answer = answer
+	}
+	return nil
+}
+
+// rewrite returns the rewritten params. h is nil if hints are kept.
+// It returns false if a key in mandatory was removed, the record must be
+// dropped then. See RFC 9460 section 8.
+func (w *Rewriter) rewrite(params []dns.SVCBKeyValue, h *addrHints) ([]dns.SVCBKeyValue, bool) {
+	out := params[:0]
+	alpnRemoved := false
+	for _, kv := range params {
+		switch kv := kv.(type) {
+		case *dns.SVCBIPv4Hint:
+			if w.args.NoIPv4Hint || h != nil {
+				continue
+			}
+		case *dns.SVCBIPv6Hint:
+			if w.args.NoIPv6Hint || h != nil {
+				continue
+			}
+		case *dns.SVCBECHConfig:
+			if w.args.NoECH {
+				continue
+			}
+		case *dns.SVCBAlpn:
+			if w.stripALPN != nil {
+				ids := kv.Alpn[:0]
+				for _, id := range kv.Alpn {
+					if _, strip := w.stripALPN[id]; !strip {
+						ids = append(ids, id)
+					}
+				}
+				kv.Alpn = ids
+				if len(ids) == 0 {
+					alpnRemoved = true
+					continue
+				}
+			}
+		}
+		out = append(out, kv)
+	}
+	if alpnRemoved {
+		// no-default-alpn requires alpn. See RFC 9460 section 7.1.1.
+		params, out = out, out[:0]
+		for _, kv := range params {
+			if kv.Key() != dns.SVCB_NO_DEFAULT_ALPN {
+				out = append(out, kv)
+			}
+		}
+	}
+	if h != nil {
+		if len(h.v4) > 0 && !w.args.NoIPv4Hint {
+			out = append(out, &dns.SVCBIPv4Hint{Hint: h.v4})
+		}
+		if len(h.v6) > 0 && !w.args.NoIPv6Hint {
+			out = append(out, &dns.SVCBIPv6Hint{Hint: h.v6})
+		}
+	}
+	for _, kv := range out {
+		if m, ok := kv.(*dns.SVCBMandatory); ok {
+			for _, key := range m.Code {
+				if !slices.ContainsFunc(out, func(kv dns.SVCBKeyValue) bool { return kv.Key() == key }) {
+					return nil, false
+				}
+			}
+		}
+	}
+	return out, true
+}
+
+type addrHints struct {
+	v4 []net.IP
+	v6 []net.IP
+}
+
+// hintName returns the name whose addresses are hinted by rec.
+func hintName(rec *dns.SVCB) string {
+	if rec.Target == "." {
+		return dns.CanonicalName(rec.Hdr.Name)
+	}
+	return dns.CanonicalName(rec.Target)
+}
+
+// lookupHints queries A and AAAA of the hinted names through next. Names
+// that failed are not in the returned map.
+func (w *Rewriter) lookupHints(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker, records []*dns.SVCB) map[string]*addrHints {
+	names := make(map[string]struct{})
+	for _, rec := range records {
+		if rec.Priority != 0 {
+			names[hintName(rec)] = struct{}{}
+		}
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		hints  = make(map[string]*addrHints)
+		failed = make(map[string]struct{})
+	)
+	for name := range names {
+		hints[name] = new(addrHints)
+		for _, qt := range [...]uint16{dns.TypeA, dns.TypeAAAA} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				subCtx := qCtx.Copy()
+				subCtx.Q().Question[0] = dns.Question{Name: name, Qtype: qt, Qclass: dns.ClassINET}
+				subCtx.SetResponse(nil)
+				err := next.ExecNext(ctx, subCtx)
+				mu.Lock()
+				defer mu.Unlock()
+				r := subCtx.R()
+				if err != nil || r == nil || (r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError) {
+					if err != nil {
+						w.logger.Warn("failed to query hints", qCtx.InfoField(), zap.String("name", name), zap.Error(err))
+					}
+					failed[name] = struct{}{}
+					return
+				}
+				h := hints[name]
+				for _, rr := range r.Answer {
+					switch rr := rr.(type) {
+					case *dns.A:
+						h.v4 = append(h.v4, rr.A)
+					case *dns.AAAA:
+						h.v6 = append(h.v6, rr.AAAA)
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	for name := range failed {
+		delete(hints, name)
+	}
+	return hints
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package svcb_rewrite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+)
+
+// zoneNext answers queries from records.
+type zoneNext struct {
+	rrs []string
+}
+
+func (z *zoneNext) Exec(_ context.Context, qCtx *query_context.Context) error {
+	q := qCtx.Q()
+	r := new(dns.Msg)
+	r.SetReply(q)
+	for _, s := range z.rrs {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			return err
+		}
+		if rr.Header().Name == q.Question[0].Name && rr.Header().Rrtype == q.Question[0].Qtype {
+			r.Answer = append(r.Answer, rr)
+		}
+	}
+	qCtx.SetResponse(r)
+	return nil
+}
+
+func TestRewriter(t *testing.T) {
+	next := &zoneNext{rrs: []string{
+		`example. 300 IN HTTPS 1 . alpn="h3,h2" no-default-alpn ipv4hint="192.0.2.1" ech="AEX+DQBB" ipv6hint="2001:db8::1"`,
+		`h3only.example. 300 IN HTTPS 1 . alpn="h3" no-default-alpn port=443`,
+		`alias.example. 300 IN HTTPS 0 example.`,
+		`mandatory.example. 300 IN HTTPS 1 . mandatory=ech alpn="h2" ech="AEX+DQBB"`,
+		`mandatory.example. 300 IN HTTPS 2 . alpn="h2" ech="AEX+DQBB"`,
+		"example. 300 IN A 10.0.0.1",
+	}}
+
+	tests := []struct {
+		name  string
+		args  *Args
+		qname string
+		want  string
+	}{
+		{"keep", &Args{}, "example.",
+			`example.	300	IN	HTTPS	1 . alpn="h3,h2" no-default-alpn="" ipv4hint="192.0.2.1" ech="AEX+DQBB" ipv6hint="2001:db8::1"`},
+		{"no ipv6hint and ech", &Args{NoIPv6Hint: true, NoECH: true}, "example.",
+			`example.	300	IN	HTTPS	1 . alpn="h3,h2" no-default-alpn="" ipv4hint="192.0.2.1"`},
+		{"strip alpn", &Args{StripALPN: []string{"h3"}}, "example.",
+			`example.	300	IN	HTTPS	1 . alpn="h2" no-default-alpn="" ipv4hint="192.0.2.1" ech="AEX+DQBB" ipv6hint="2001:db8::1"`},
+		{"strip all alpn", &Args{StripALPN: []string{"h3"}}, "h3only.example.",
+			`h3only.example.	300	IN	HTTPS	1 . port="443"`},
+		{"sync hints", &Args{SyncHints: true}, "example.",
+			`example.	300	IN	HTTPS	1 . alpn="h3,h2" no-default-alpn="" ech="AEX+DQBB" ipv4hint="10.0.0.1"`},
+		{"alias mode", &Args{NoECH: true, SyncHints: true}, "alias.example.",
+			`alias.example.	300	IN	HTTPS	0 example.`},
+		// The record that requires ech is dropped.
+		{"mandatory key removed", &Args{NoECH: true}, "mandatory.example.",
+			`mandatory.example.	300	IN	HTTPS	2 . alpn="h2"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewRewriter(tt.args, mlog.Nop())
+			q := new(dns.Msg)
+			q.SetQuestion(tt.qname, dns.TypeHTTPS)
+			qCtx := query_context.NewContext(q)
+			cw := sequence.NewChainWalker([]*sequence.ChainNode{{E: next}}, nil)
+			if err := w.Exec(context.Background(), qCtx, cw); err != nil {
+				t.Fatal(err)
+			}
+			r := qCtx.R()
+			if len(r.Answer) != 1 {
+				t.Fatalf("want one answer, got %v", r)
+			}
+			if got := r.Answer[0].String(); got != tt.want {
+				t.Fatalf("want %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRewriter_copy(t *testing.T) {
+	shared, err := dns.NewRR(`example. 300 IN HTTPS 1 . alpn="h3,h2" ech="AEX+DQBB"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := shared.String()
+	var next sequence.ExecutableFunc = func(_ context.Context, qCtx *query_context.Context) error {
+		r := new(dns.Msg)
+		r.SetReply(qCtx.Q())
+		r.Answer = []dns.RR{shared}
+		qCtx.SetResponse(r)
+		return nil
+	}
+
+	w := NewRewriter(&Args{NoECH: true, StripALPN: []string{"h3"}}, mlog.Nop())
+	q := new(dns.Msg)
+	q.SetQuestion("example.", dns.TypeHTTPS)
+	qCtx := query_context.NewContext(q)
+	cw := sequence.NewChainWalker([]*sequence.ChainNode{{E: next}}, nil)
+	if err := w.Exec(context.Background(), qCtx, cw); err != nil {
+		t.Fatal(err)
+	}
+	if got := qCtx.R().Answer[0].String(); got == want {
+		t.Fatal("record was not rewritten")
+	}
+	if got := shared.String(); got != want {
+		t.Fatalf("shared record was modified to %s", got)
+	}
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package zone
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/netlist"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+)
+
+type acl struct {
+	keys    map[string]struct{} // canonical key names
+	clients *netlist.List
+}
+
+func newACL(args *ACLArgs) (*acl, error) {
+	if len(args.TSIGKeys) == 0 && len(args.Clients) == 0 {
+		return nil, errors.New("neither tsig_keys nor clients is configured")
+	}
+	a := new(acl)
+	if len(args.TSIGKeys) > 0 {
+		a.keys = make(map[string]struct{})
+		for _, k := range args.TSIGKeys {
+			a.keys[dns.CanonicalName(k)] = struct{}{}
+		}
+	}
+	if len(args.Clients) > 0 {
+		a.clients = netlist.NewList()
+		for i, s := range args.Clients {
+			if err := netlist.LoadFromText(a.clients, s); err != nil {
+				return nil, fmt.Errorf("invalid client #%d %s, %w", i, s, err)
+			}
+		}
+		a.clients.Sort()
+	}
+	return a, nil
+}
+
+func (a *acl) allowed(qCtx *query_context.Context) bool {
+	if a.clients != nil {
+		addr, ok := query_context.GetClientAddr(qCtx)
+		if !ok || !a.clients.Contains(addr.Unmap()) {
+			return false
+		}
+	}
+	if a.keys != nil {
+		key, ok := query_context.GetTSIGKey(qCtx)
+		if !ok {
+			return false
+		}
+		if _, ok := a.keys[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
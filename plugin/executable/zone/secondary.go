@@ -0,0 +1,300 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package zone
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/zone_file"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultRetryInterval = time.Second * 30
+	minRefreshInterval   = time.Second * 5
+	transferTimeout      = time.Second * 10
+)
+
+type SecondaryArgs struct {
+	// Zone is the origin of the zone. Required.
+	Zone string `yaml:"zone"`
+	// Primaries are addresses of primary servers, "ip" or "ip:port".
+	// NOTIFY messages are only accepted from them. Required.
+	Primaries []string `yaml:"primaries"`
+	// TSIGKey and TSIGSecret are used to sign transfer requests. The
+	// algorithm is hmac-sha256. Optional.
+	TSIGKey    string `yaml:"tsig_key"`
+	TSIGSecret string `yaml:"tsig_secret"`
+	// File is where the transferred zone is saved. It is loaded at startup,
+	// so the zone can be served before the first transfer. Optional.
+	File string `yaml:"file"`
+}
+
+// secondary is a zone transferred from primaries. It is refreshed on the
+// SOA refresh and retry timers, and on NOTIFY.
+type secondary struct {
+	origin     string
+	primaries  []netip.AddrPort
+	tsigKey    string
+	tsigSecret string
+	file       string
+	onChange   func()
+	logger     *zap.Logger
+
+	zone   atomic.Pointer[zone_file.Zone] // nil if the zone is unavailable
+	lastOK time.Time                      // the last time the zone was confirmed by a primary
+
+	notify      chan struct{}
+	closeOnce   sync.Once
+	closeNotify chan struct{}
+}
+
+func newSecondary(args *SecondaryArgs, onChange func(), logger *zap.Logger) (*secondary, error) {
+	if len(args.Zone) == 0 {
+		return nil, errors.New("missing zone")
+	}
+	if len(args.Primaries) == 0 {
+		return nil, errors.New("missing primaries")
+	}
+	s := &secondary{
+		origin:      dns.CanonicalName(args.Zone),
+		tsigSecret:  args.TSIGSecret,
+		file:        args.File,
+		onChange:    onChange,
+		logger:      logger.With(zap.String("zone", dns.CanonicalName(args.Zone))),
+		notify:      make(chan struct{}, 1),
+		closeNotify: make(chan struct{}),
+	}
+	if len(args.TSIGKey) > 0 {
+		s.tsigKey = dns.CanonicalName(args.TSIGKey)
+	}
+	for i, p := range args.Primaries {
+		ap, err := parseAddrPort(p, 53)
+		if err != nil {
+			return nil, fmt.Errorf("invalid primary #%d %s, %w", i, p, err)
+		}
+		s.primaries = append(s.primaries, ap)
+	}
+
+	if len(s.file) > 0 {
+		zone, err := zone_file.LoadZoneFile(s.file, s.origin)
+		switch {
+		case err == nil:
+			s.zone.Store(zone)
+			s.lastOK = time.Now()
+		case !errors.Is(err, os.ErrNotExist):
+			return nil, fmt.Errorf("failed to load zone file %s, %w", s.file, err)
+		}
+	}
+	return s, nil
+}
+
+func parseAddrPort(s string, defaultPort uint16) (netip.AddrPort, error) {
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap, nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	return netip.AddrPortFrom(addr, defaultPort), nil
+}
+
+func (s *secondary) run() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-s.notify:
+			timer.Stop()
+		case <-s.closeNotify:
+			return
+		}
+		timer.Reset(s.refresh())
+	}
+}
+
+// refresh transfers the zone if it is outdated, and returns the interval
+// until the next refresh.
+func (s *secondary) refresh() time.Duration {
+	err := s.transfer()
+	zone := s.zone.Load()
+	if err == nil {
+		s.lastOK = time.Now()
+		return max(time.Duration(zone.SOA().Refresh)*time.Second, minRefreshInterval)
+	}
+
+	s.logger.Warn("failed to refresh zone", zap.Error(err))
+	if zone == nil {
+		return defaultRetryInterval
+	}
+	soa := zone.SOA()
+	if time.Since(s.lastOK) > time.Duration(soa.Expire)*time.Second {
+		s.logger.Error("zone expired")
+		s.zone.Store(nil)
+		s.onChange()
+		return defaultRetryInterval
+	}
+	return max(time.Duration(soa.Retry)*time.Second, minRefreshInterval)
+}
+
+// transfer tries primaries in order until the zone is confirmed up to date
+// or transferred.
+func (s *secondary) transfer() error {
+	var errs []error
+	for _, p := range s.primaries {
+		err := s.transferFrom(p)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("primary %s, %w", p, err))
+	}
+	return errors.Join(errs...)
+}
+
+func (s *secondary) transferFrom(primary netip.AddrPort) error {
+	cur := s.zone.Load()
+	var rrs []dns.RR
+	var err error
+	if cur != nil {
+		rrs, err = s.exchange(primary, dns.TypeIXFR, cur.SOA().Serial)
+	}
+	if cur == nil || err != nil { // IXFR may not be supported.
+		rrs, err = s.exchange(primary, dns.TypeAXFR, 0)
+	}
+	if err != nil {
+		return err
+	}
+
+	base := cur
+	if base == nil {
+		base = zone_file.NewZone(s.origin)
+	}
+	zone, err := base.ApplyTransfer(rrs)
+	if err != nil {
+		return err
+	}
+	if zone == cur {
+		return nil
+	}
+	if zone.SOA() == nil {
+		return errors.New("transferred zone has no SOA")
+	}
+	s.zone.Store(zone)
+	s.onChange()
+	s.logger.Info("zone transferred", zap.Stringer("primary", primary), zap.Uint32("serial", zone.SOA().Serial))
+	if len(s.file) > 0 {
+		if err := writeZoneFile(s.file, zone); err != nil {
+			s.logger.Error("failed to save zone", zap.String("file", s.file), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func (s *secondary) exchange(primary netip.AddrPort, qtype uint16, serial uint32) ([]dns.RR, error) {
+	q := new(dns.Msg)
+	if qtype == dns.TypeIXFR {
+		q.SetIxfr(s.origin, serial, "", "")
+	} else {
+		q.SetAxfr(s.origin)
+	}
+	t := &dns.Transfer{DialTimeout: transferTimeout, ReadTimeout: transferTimeout, WriteTimeout: transferTimeout}
+	if len(s.tsigKey) > 0 {
+		q.SetTsig(s.tsigKey, dns.HmacSHA256, 300, time.Now().Unix())
+		t.TsigSecret = map[string]string{s.tsigKey: s.tsigSecret}
+	}
+	env, err := t.In(q, primary.String())
+	if err != nil {
+		return nil, err
+	}
+	var rrs []dns.RR
+	for e := range env {
+		if e.Error != nil {
+			err = e.Error
+			continue // drain the channel
+		}
+		rrs = append(rrs, e.RR...)
+	}
+	return rrs, err
+}
+
+// fromPrimary reports whether qCtx is from one of the primaries.
+func (s *secondary) fromPrimary(qCtx *query_context.Context) bool {
+	addr, ok := query_context.GetClientAddr(qCtx)
+	if !ok {
+		return false
+	}
+	for _, p := range s.primaries {
+		if p.Addr() == addr.Unmap() {
+			return true
+		}
+	}
+	return false
+}
+
+// triggerRefresh refreshes the zone as soon as possible.
+func (s *secondary) triggerRefresh() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *secondary) close() {
+	s.closeOnce.Do(func() { close(s.closeNotify) })
+}
+
+// handleNotify replies NOTIFY messages (RFC 1996) and refreshes the
+// secondary zone.
+func (z *Zone) handleNotify(qCtx *query_context.Context) *dns.Msg {
+	q := qCtx.Q()
+	r := new(dns.Msg)
+	r.SetReply(q)
+	if len(q.Question) != 1 {
+		r.Rcode = dns.RcodeFormatError
+		return r
+	}
+
+	origin := dns.CanonicalName(q.Question[0].Name)
+	for _, s := range z.secondaries {
+		if s.origin != origin {
+			continue
+		}
+		if !s.fromPrimary(qCtx) {
+			z.logger.Warn("notify refused", qCtx.InfoField())
+			r.Rcode = dns.RcodeRefused
+			return r
+		}
+		r.Authoritative = true
+		s.triggerRefresh()
+		return r
+	}
+	r.Rcode = dns.RcodeNotAuth
+	return r
+}
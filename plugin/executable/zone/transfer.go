@@ -0,0 +1,76 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package zone
+
+import (
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+// handleTransfer replies AXFR and IXFR queries.
+func (z *Zone) handleTransfer(qCtx *query_context.Context) *dns.Msg {
+	q := qCtx.Q()
+	r := new(dns.Msg)
+	r.SetReply(q)
+
+	zone := z.findZone(q.Question[0].Name)
+	if zone == nil {
+		r.Rcode = dns.RcodeNotAuth
+		return r
+	}
+	if z.transfer == nil || !z.transfer.allowed(qCtx) {
+		z.logger.Warn("zone transfer refused", qCtx.InfoField())
+		r.Rcode = dns.RcodeRefused
+		return r
+	}
+
+	w, ok := query_context.GetStreamWriter(qCtx)
+	if !ok {
+		// Not a stream transport. Reply IXFR with the SOA only, which tells
+		// the client to retry over TCP (RFC 1995 2). AXFR is TCP only.
+		if q.Question[0].Qtype == dns.TypeIXFR {
+			r.Authoritative = true
+			r.Answer = []dns.RR{zone.SOA()}
+		} else {
+			r.Rcode = dns.RcodeRefused
+		}
+		return r
+	}
+
+	// The last message is the response.
+	var pending *dns.Msg
+	err := zone.Transfer(q, func(m *dns.Msg) error {
+		if pending != nil {
+			if err := w(pending); err != nil {
+				return err
+			}
+		}
+		pending = m
+		return nil
+	})
+	if err != nil {
+		z.logger.Warn("zone transfer failed", qCtx.InfoField(), zap.Error(err))
+		r.Rcode = dns.RcodeServerFailure
+		return r
+	}
+	z.logger.Info("zone transferred", qCtx.InfoField(), zap.Uint32("serial", zone.SOA().Serial))
+	return pending
+}
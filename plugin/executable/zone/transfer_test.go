@@ -0,0 +1,174 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package zone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/server"
+	"github.com/IrineSistiana/mosdns/v5/pkg/server/dns_handler"
+	"github.com/miekg/dns"
+)
+
+const testSecret = "c2VjcmV0LXNlY3JldC1zZWNyZXQ="
+
+func TestZone_Secondary(t *testing.T) {
+	dir := t.TempDir()
+	home := filepath.Join(dir, "home.zone")
+	if err := os.WriteFile(home, []byte(homeZone), 0644); err != nil {
+		t.Fatal(err)
+	}
+	primary, err := NewZone(&Args{
+		Files:         []string{home},
+		DisableReload: true,
+		Transfer:      &ACLArgs{TSIGKeys: []string{"xfr."}, Clients: []string{"127.0.0.1"}},
+		Update:        &UpdateArgs{Clients: []string{"127.0.0.1"}, JournalDir: filepath.Join(dir, "journal")},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	h := dns_handler.NewEntryHandler(dns_handler.EntryHandlerOpts{Entry: primary, Update: primary})
+	s := server.NewTCPServer(server.TCPServerOpts{DNSHandler: h, TSIGKeys: server.TSIGKeys{"xfr.": testSecret}})
+	go s.ServeTCP(l)
+
+	// Large enough for a multi-message transfer.
+	for i := 0; i < 1000; i++ {
+		rr, _ := dns.NewRR(fmt.Sprintf("host%d.home.arpa. 300 IN A 192.168.%d.%d", i, i/256, i%256))
+		primary.Zones()[0].Insert(rr)
+	}
+
+	savedZone := filepath.Join(dir, "secondary.zone")
+	secondary, err := NewZone(&Args{
+		Secondaries: []SecondaryArgs{{
+			Zone:       "home.arpa",
+			Primaries:  []string{l.Addr().String()},
+			TSIGKey:    "xfr.",
+			TSIGSecret: testSecret,
+			File:       savedZone,
+		}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer secondary.Close()
+
+	waitA := func(name string) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second * 5)
+		for {
+			if r := queryA(secondary, name); r != nil && len(r.Answer) == 1 {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s was not transferred", name)
+			}
+			time.Sleep(time.Millisecond * 50)
+		}
+	}
+	waitA("nas.home.arpa.")
+	waitA("host999.home.arpa.")
+	if _, err := os.Stat(savedZone); err != nil {
+		t.Fatalf("transferred zone was not saved, %v", err)
+	}
+
+	// Update the primary, then notify the secondary.
+	m := new(dns.Msg)
+	m.SetUpdate("home.arpa.")
+	rr, _ := dns.NewRR("tv.home.arpa. 300 IN A 192.168.1.3")
+	m.Insert([]dns.RR{rr})
+	c := &dns.Client{Net: "tcp"}
+	if r, _, err := c.Exchange(m, l.Addr().String()); err != nil || r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("update failed, %v, %v", r, err)
+	}
+
+	notify := new(dns.Msg)
+	notify.SetNotify("home.arpa.")
+	qCtx := query_context.NewContext(notify)
+	addr := netip.MustParseAddr("127.0.0.1")
+	query_context.SetClientAddr(qCtx, &addr)
+	if err := secondary.Exec(context.Background(), qCtx); err != nil {
+		t.Fatal(err)
+	}
+	if r := qCtx.R(); r.Rcode != dns.RcodeSuccess || !r.Authoritative {
+		t.Fatalf("unexpected notify response %v", r)
+	}
+	waitA("tv.home.arpa.")
+
+	// Transfers without the key are refused.
+	q := new(dns.Msg)
+	q.SetAxfr("home.arpa.")
+	if r, _, err := c.Exchange(q, l.Addr().String()); err != nil || r.Rcode != dns.RcodeRefused {
+		t.Fatalf("want REFUSED, got %v, %v", r, err)
+	}
+}
+
+func TestZone_SecondaryCheckOnly(t *testing.T) {
+	dir := t.TempDir()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan struct{}, 1)
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			c.Close()
+			accepted <- struct{}{}
+		}
+	}()
+
+	savedZone := filepath.Join(dir, "secondary.zone")
+	journalDir := filepath.Join(dir, "journal")
+	z, err := newZone(&Args{
+		Secondaries: []SecondaryArgs{{Zone: "home.arpa", Primaries: []string{l.Addr().String()}, File: savedZone}},
+		Update:      &UpdateArgs{Clients: []string{"127.0.0.1"}, JournalDir: journalDir},
+	}, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	select {
+	case <-accepted:
+		t.Fatal("secondary was started in check mode")
+	case <-time.After(time.Millisecond * 200):
+	}
+	for _, p := range []string{savedZone, journalDir} {
+		if _, err := os.Stat(p); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("%s was created in check mode", p)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package zone
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/zone_file"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+type updater struct {
+	acl        *acl
+	journalDir string
+}
+
+// newUpdater creates an updater. The journal dir is not created if
+// checkOnly is set.
+func newUpdater(args *UpdateArgs, checkOnly bool) (*updater, error) {
+	if len(args.JournalDir) == 0 {
+		return nil, errors.New("missing journal_dir")
+	}
+	a, err := newACL(&ACLArgs{TSIGKeys: args.TSIGKeys, Clients: args.Clients})
+	if err != nil {
+		return nil, err
+	}
+	if checkOnly {
+		return &updater{acl: a, journalDir: args.JournalDir}, nil
+	}
+	if err := os.MkdirAll(args.JournalDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create journal dir, %w", err)
+	}
+	return &updater{acl: a, journalDir: args.JournalDir}, nil
+}
+
+func (u *updater) journalPath(origin string) string {
+	name := strings.TrimSuffix(origin, ".")
+	if len(name) == 0 {
+		name = "root"
+	}
+	return filepath.Join(u.journalDir, name+".jnl")
+}
+
+// loadJournal returns the journal of zone if it is not older than zone.
+func (u *updater) loadJournal(zone *zone_file.Zone) (*zone_file.Zone, error) {
+	p := u.journalPath(zone.Origin())
+	j, err := zone_file.LoadZoneFile(p, zone.Origin())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return zone, nil
+		}
+		return nil, fmt.Errorf("failed to load journal %s, %w", p, err)
+	}
+	if zone_file.SerialGreater(zone.SOA().Serial, j.SOA().Serial) {
+		return zone, nil
+	}
+	return j, nil
+}
+
+// writeJournal atomically replaces the journal of zone.
+func (u *updater) writeJournal(zone *zone_file.Zone) error {
+	return writeZoneFile(u.journalPath(zone.Origin()), zone)
+}
+
+// writeZoneFile atomically replaces file p with zone.
+func writeZoneFile(p string, zone *zone_file.Zone) error {
+	f, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := zone.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+func (z *Zone) handleUpdate(qCtx *query_context.Context) *dns.Msg {
+	q := qCtx.Q()
+	r := new(dns.Msg)
+	r.SetReply(q)
+
+	if z.update == nil || !z.update.acl.allowed(qCtx) {
+		z.logger.Warn("update refused", qCtx.InfoField())
+		r.Rcode = dns.RcodeRefused
+		return r
+	}
+	if len(q.Question) != 1 {
+		r.Rcode = dns.RcodeFormatError
+		return r
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	origin := dns.CanonicalName(q.Question[0].Name)
+	var zone *zone_file.Zone
+	for _, zz := range z.fileZones {
+		if zz.Origin() == origin {
+			zone = zz
+			break
+		}
+	}
+	if zone == nil {
+		r.Rcode = dns.RcodeNotAuth
+		return r
+	}
+
+	rcode, changed := zone.Update(q)
+	r.Rcode = rcode
+	if changed {
+		z.logger.Info("zone updated", qCtx.InfoField(), zap.String("zone", origin), zap.Uint32("serial", zone.SOA().Serial))
+		if err := z.update.writeJournal(zone); err != nil {
+			z.logger.Error("failed to write journal", zap.String("zone", origin), zap.Error(err))
+		}
+	}
+	return r
+}
@@ -0,0 +1,288 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package zone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/pkg/file_watcher"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/zone_file"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const PluginType = "zone"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+var _ sequence.Executable = (*Zone)(nil)
+
+type Args struct {
+	// Files are RFC 1035 zone files. The origin of each zone is the owner
+	// of its first record, which must be a SOA.
+	Files []string `yaml:"files"`
+	// DisableReload disables reloading zones when files are changed.
+	DisableReload bool `yaml:"disable_reload"`
+	// Update enables RFC 2136 dynamic updates.
+	Update *UpdateArgs `yaml:"update"`
+	// Transfer enables AXFR and IXFR. Transfers are only served over TCP.
+	Transfer *ACLArgs `yaml:"transfer"`
+	// Secondaries are zones that are transferred from primary servers.
+	Secondaries []SecondaryArgs `yaml:"secondaries"`
+}
+
+// ACLArgs configures an access control list. A request is allowed only if
+// it passes all configured checks. At least one check must be configured.
+type ACLArgs struct {
+	// TSIGKeys are the names of allowed TSIG keys. Keys are verified by
+	// servers. See their tsig_keys args.
+	TSIGKeys []string `yaml:"tsig_keys"`
+	// Clients are IPs or CIDRs of allowed clients.
+	Clients []string `yaml:"clients"`
+}
+
+// UpdateArgs configures dynamic updates. Secondary zones cannot be updated.
+// See ACLArgs for TSIGKeys and Clients.
+type UpdateArgs struct {
+	TSIGKeys []string `yaml:"tsig_keys"`
+	Clients  []string `yaml:"clients"`
+	// JournalDir is where updated zones are stored, one zone file per zone.
+	// A journal is loaded instead of its zone file if its SOA serial is not
+	// less than the file's. Required.
+	JournalDir string `yaml:"journal_dir"`
+}
+
+type Zone struct {
+	args     *Args
+	files    []string
+	logger   *zap.Logger
+	zones    atomic.Pointer[[]*zone_file.Zone] // sorted, longest origin first
+	watcher  *file_watcher.Watcher
+	update   *updater // nil if update is disabled
+	transfer *acl     // nil if transfer is disabled
+
+	secondaries []*secondary
+
+	mu        sync.Mutex // protects fileZones, zone updates and journals.
+	fileZones []*zone_file.Zone
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	a := args.(*Args)
+	// Keep the running zone on reload if the args are unchanged. Otherwise,
+	// secondaries would have no zone until the next transfer, and updates
+	// applied by the old plugin before the commit would be lost.
+	if prev, ok := bp.PrevPlugin().(*Zone); ok && reflect.DeepEqual(prev.args, a) {
+		// Files may be changed, reload them as a new plugin would do.
+		if len(prev.files) > 0 {
+			bp.OnCommit(prev.reload)
+		}
+		return prev, nil
+	}
+	return newZone(a, bp.L(), bp.CheckOnly())
+}
+
+func NewZone(args *Args, logger *zap.Logger) (*Zone, error) {
+	return newZone(args, logger, false)
+}
+
+// newZone creates a Zone. If checkOnly is set, zones are loaded, but
+// secondaries and file watchers are not started and nothing is written.
+func newZone(args *Args, logger *zap.Logger, checkOnly bool) (*Zone, error) {
+	if len(args.Files) == 0 && len(args.Secondaries) == 0 {
+		return nil, errors.New("no zone file or secondary zone")
+	}
+	if logger == nil {
+		logger = mlog.Nop()
+	}
+	z := &Zone{
+		args:   args,
+		files:  args.Files,
+		logger: logger,
+	}
+	if args.Update != nil {
+		u, err := newUpdater(args.Update, checkOnly)
+		if err != nil {
+			return nil, fmt.Errorf("invalid update args, %w", err)
+		}
+		z.update = u
+	}
+	if args.Transfer != nil {
+		a, err := newACL(args.Transfer)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transfer args, %w", err)
+		}
+		z.transfer = a
+	}
+	for i := range args.Secondaries {
+		s, err := newSecondary(&args.Secondaries[i], z.publish, logger)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secondary zone #%d, %w", i, err)
+		}
+		z.secondaries = append(z.secondaries, s)
+	}
+	if err := z.load(); err != nil {
+		return nil, err
+	}
+	if checkOnly {
+		return z, nil
+	}
+	for _, s := range z.secondaries {
+		go s.run()
+	}
+	if !args.DisableReload && len(args.Files) > 0 {
+		w, err := file_watcher.New(args.Files, file_watcher.Opts{OnChange: z.reload, Logger: logger})
+		if err != nil {
+			return nil, fmt.Errorf("failed to watch zone files, %w", err)
+		}
+		z.watcher = w
+	}
+	return z, nil
+}
+
+func (z *Zone) load() error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	zones := make([]*zone_file.Zone, 0, len(z.files))
+	origins := make(map[string]string)
+	for _, s := range z.secondaries {
+		origins[s.origin] = "secondaries"
+	}
+	for i, file := range z.files {
+		zone, err := zone_file.LoadZoneFile(file, "")
+		if err != nil {
+			return fmt.Errorf("failed to load zone file #%d %s, %w", i, file, err)
+		}
+		if prev, dup := origins[zone.Origin()]; dup {
+			return fmt.Errorf("zone %s is defined in both %s and %s", zone.Origin(), prev, file)
+		}
+		origins[zone.Origin()] = file
+		if z.update != nil {
+			if zone, err = z.update.loadJournal(zone); err != nil {
+				return err
+			}
+		}
+		zones = append(zones, zone)
+	}
+	z.fileZones = zones
+	z.publishLocked()
+	return nil
+}
+
+// publish updates zones that are used to answer queries.
+func (z *Zone) publish() {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.publishLocked()
+}
+
+func (z *Zone) publishLocked() {
+	zones := make([]*zone_file.Zone, 0, len(z.fileZones)+len(z.secondaries))
+	zones = append(zones, z.fileZones...)
+	for _, s := range z.secondaries {
+		if zone := s.zone.Load(); zone != nil {
+			zones = append(zones, zone)
+		}
+	}
+	sort.Slice(zones, func(i, j int) bool {
+		return dns.CountLabel(zones[i].Origin()) > dns.CountLabel(zones[j].Origin())
+	})
+	z.zones.Store(&zones)
+}
+
+// findZone returns the zone whose origin is name.
+func (z *Zone) findZone(name string) *zone_file.Zone {
+	name = dns.CanonicalName(name)
+	for _, zone := range z.Zones() {
+		if zone.Origin() == name {
+			return zone
+		}
+	}
+	return nil
+}
+
+func (z *Zone) reload() {
+	if err := z.load(); err != nil {
+		z.logger.Error("failed to reload zones, old zones are kept", zap.Error(err))
+		return
+	}
+	z.logger.Info("zones reloaded")
+}
+
+// Zones returns the currently loaded zones, longest origin first.
+func (z *Zone) Zones() []*zone_file.Zone {
+	return *z.zones.Load()
+}
+
+// Response returns the authoritative response of q, or nil if q does not
+// belong to any zone.
+func (z *Zone) Response(q *dns.Msg) *dns.Msg {
+	for _, zone := range z.Zones() {
+		if r := zone.Reply(q); r != nil {
+			return r
+		}
+	}
+	return nil
+}
+
+func (z *Zone) Exec(_ context.Context, qCtx *query_context.Context) error {
+	q := qCtx.Q()
+	switch q.Opcode {
+	case dns.OpcodeUpdate:
+		qCtx.SetResponse(z.handleUpdate(qCtx))
+		return nil
+	case dns.OpcodeNotify:
+		qCtx.SetResponse(z.handleNotify(qCtx))
+		return nil
+	}
+	if len(q.Question) == 1 {
+		if qt := q.Question[0].Qtype; qt == dns.TypeAXFR || qt == dns.TypeIXFR {
+			qCtx.SetResponse(z.handleTransfer(qCtx))
+			return nil
+		}
+	}
+	if r := z.Response(qCtx.Q()); r != nil {
+		qCtx.SetResponse(r)
+	}
+	return nil
+}
+
+func (z *Zone) Close() error {
+	for _, s := range z.secondaries {
+		s.close()
+	}
+	if z.watcher != nil {
+		return z.watcher.Close()
+	}
+	return nil
+}
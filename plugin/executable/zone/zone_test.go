@@ -0,0 +1,213 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package zone
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+)
+
+const (
+	homeZone = `home.arpa. 3600 IN SOA ns.home.arpa. admin.home.arpa. 1 7200 3600 1209600 300
+nas.home.arpa. 300 IN A 192.168.1.2
+`
+	subZone = `lab.home.arpa. 3600 IN SOA ns.lab.home.arpa. admin.lab.home.arpa. 1 7200 3600 1209600 300
+pi.lab.home.arpa. 300 IN A 192.168.2.2
+`
+)
+
+func queryA(z *Zone, name string) *dns.Msg {
+	q := new(dns.Msg)
+	q.SetQuestion(name, dns.TypeA)
+	return z.Response(q)
+}
+
+func TestZone(t *testing.T) {
+	dir := t.TempDir()
+	home := filepath.Join(dir, "home.zone")
+	lab := filepath.Join(dir, "lab.zone")
+	if err := os.WriteFile(home, []byte(homeZone), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(lab, []byte(subZone), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := NewZone(&Args{Files: []string{home, lab}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	if r := queryA(z, "pi.lab.home.arpa."); r == nil || len(r.Answer) != 1 || !r.Authoritative {
+		t.Fatalf("unexpected response from the sub zone: %v", r)
+	}
+	if r := queryA(z, "nas.home.arpa."); r == nil || len(r.Answer) != 1 {
+		t.Fatalf("unexpected response from the parent zone: %v", r)
+	}
+	if r := queryA(z, "example.com."); r != nil {
+		t.Fatal("out of zone query should not be answered")
+	}
+
+	// Broken files must not replace loaded zones.
+	if err := os.WriteFile(lab, []byte("broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if r := queryA(z, "pi.lab.home.arpa."); r == nil || len(r.Answer) != 1 {
+		t.Fatalf("zone should be kept after a failed reload: %v", r)
+	}
+
+	if err := os.WriteFile(home, []byte(homeZone+"tv.home.arpa. 300 IN A 192.168.1.3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(lab, []byte(subZone), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		if r := queryA(z, "tv.home.arpa."); r != nil && len(r.Answer) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("zone was not reloaded")
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
+
+func TestZone_Reload(t *testing.T) {
+	home := filepath.Join(t.TempDir(), "home.zone")
+	if err := os.WriteFile(home, []byte(homeZone), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plugins := make(map[string]any)
+	m := coremain.NewTestMosdnsWithPlugins(plugins)
+	p, err := Init(coremain.NewBP("zone", m), &Args{Files: []string{home}, DisableReload: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugins["zone"] = p
+	prev := p.(*Zone)
+	defer prev.Close()
+
+	if err := os.WriteFile(home, []byte(homeZone+"tv.home.arpa. 300 IN A 192.168.1.3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	g, commit := coremain.NewTestReloadGraph(m, map[string]any{})
+	p, err = Init(coremain.NewBP("zone", g), &Args{Files: []string{home}, DisableReload: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != prev {
+		t.Fatal("zone was not reused")
+	}
+	commit()
+	if r := queryA(prev, "tv.home.arpa."); r == nil || len(r.Answer) != 1 {
+		t.Fatalf("zone files should be reloaded on commit: %v", r)
+	}
+
+	g, commit = coremain.NewTestReloadGraph(m, map[string]any{})
+	p, err = Init(coremain.NewBP("zone", g), &Args{Files: []string{home}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit()
+	defer p.(*Zone).Close()
+	if p == prev {
+		t.Fatal("zone should not be reused if args are changed")
+	}
+}
+
+func TestZone_Update(t *testing.T) {
+	dir := t.TempDir()
+	home := filepath.Join(dir, "home.zone")
+	if err := os.WriteFile(home, []byte(homeZone), 0644); err != nil {
+		t.Fatal(err)
+	}
+	args := &Args{
+		Files:         []string{home},
+		DisableReload: true,
+		Update: &UpdateArgs{
+			TSIGKeys:   []string{"dhcp."},
+			Clients:    []string{"192.168.1.0/24"},
+			JournalDir: filepath.Join(dir, "journal"),
+		},
+	}
+	z, err := NewZone(args, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newUpdate := func(client, key string) *query_context.Context {
+		m := new(dns.Msg)
+		m.SetUpdate("home.arpa.")
+		rr, _ := dns.NewRR("phone.home.arpa. 300 IN A 192.168.1.20")
+		m.Insert([]dns.RR{rr})
+		qCtx := query_context.NewContext(m)
+		addr := netip.MustParseAddr(client)
+		query_context.SetClientAddr(qCtx, &addr)
+		if len(key) > 0 {
+			query_context.SetTSIGKey(qCtx, key)
+		}
+		return qCtx
+	}
+
+	tests := []struct {
+		name   string
+		client string
+		key    string
+		rcode  int
+	}{
+		{"no key", "192.168.1.1", "", dns.RcodeRefused},
+		{"wrong key", "192.168.1.1", "other.", dns.RcodeRefused},
+		{"wrong client", "10.0.0.1", "dhcp.", dns.RcodeRefused},
+		{"allowed", "192.168.1.1", "dhcp.", dns.RcodeSuccess},
+	}
+	for _, tt := range tests {
+		qCtx := newUpdate(tt.client, tt.key)
+		if err := z.Exec(context.Background(), qCtx); err != nil {
+			t.Fatal(err)
+		}
+		if got := qCtx.R().Rcode; got != tt.rcode {
+			t.Fatalf("%s: rcode = %d, want %d", tt.name, got, tt.rcode)
+		}
+	}
+	if r := queryA(z, "phone.home.arpa."); r == nil || len(r.Answer) != 1 {
+		t.Fatalf("updated record not found: %v", r)
+	}
+
+	// Updates survive restarts.
+	z, err = NewZone(args, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := queryA(z, "phone.home.arpa."); r == nil || len(r.Answer) != 1 {
+		t.Fatalf("updated record was not loaded from the journal: %v", r)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/IrineSistiana/mosdns/v5/coremain"
@@ -29,12 +30,35 @@ type request struct {
 var (
 	requestQueue = make(chan request, 100)
 	wg           sync.WaitGroup
+
+	// flushers are in-process caches that are flushed with unbound.
+	flushers atomic.Pointer[[]Flusher]
 )
 
+// Flusher is a plugin that caches records of domains, e.g. recursive.
+type Flusher interface {
+	Flush(domain string)
+}
+
+type Args struct {
+	// Flush are tags of plugins that implement Flusher.
+	Flush []string `yaml:"flush"`
+}
+
+// Deps implements coremain.ArgsWithDeps.
+func (a *Args) Deps() []string {
+	return a.Flush
+}
+
 func handleRequest(r request, logger *zap.Logger) {
 	defer wg.Done()
 
 	domain := r.domain
+	if fs := flushers.Load(); fs != nil {
+		for _, f := range *fs {
+			f.Flush(domain)
+		}
+	}
 
 	m := new(dns.Msg)
 	m.SetQuestion(dns.Fqdn(domain), dns.TypeA)
@@ -113,7 +137,28 @@ func processQueue(logger *zap.Logger) {
 	}
 }
 
+type flushdServer struct {
+	listener net.Listener
+}
+
 func Init(bp *coremain.BP, args any) (any, error) {
+	var fs []Flusher
+	for _, tag := range args.(*Args).Flush {
+		f, _ := bp.M().GetPlugin(tag).(Flusher)
+		if f == nil {
+			return nil, fmt.Errorf("cannot find flusher %s", tag)
+		}
+		fs = append(fs, f)
+	}
+	if bp.CheckOnly() {
+		return &flushdServer{}, nil
+	}
+	// The socket and the queue are global. Keep the running server on reload.
+	if prev, ok := bp.PrevPlugin().(*flushdServer); ok {
+		bp.OnCommit(func() { flushers.Store(&fs) })
+		return prev, nil
+	}
+	flushers.Store(&fs)
 	logger := bp.L()
 
 	os.Remove(sockPath)
@@ -138,9 +183,9 @@ func Init(bp *coremain.BP, args any) (any, error) {
 	go processQueue(logger)
 	fmt.Println("flushd server start on path", sockPath, "...")
 
-	return nil, nil
+	return &flushdServer{listener: listener}, nil
 }
 
 func init() {
-	coremain.RegNewPluginFunc("flushd_server", Init, func() any { return new(struct{}) })
+	coremain.RegNewPluginFunc("flushd_server", Init, func() any { return new(Args) })
 }
@@ -26,6 +26,15 @@ type HTTPServer struct {
 }
 
 func Init(bp *coremain.BP, args any) (any, error) {
+	if bp.CheckOnly() {
+		return struct{}{}, nil
+	}
+	// The handler is registered to http.DefaultServeMux and can only be
+	// registered once. Keep the running server on reload.
+	if prev, ok := bp.PrevPlugin().(*HTTPServer); ok {
+		return prev, nil
+	}
+
 	httpServer := &HTTPServer{
 		server: &http.Server{
 			Addr:    ":7889",
@@ -20,22 +20,67 @@
 package server_utils
 
 import (
+	"context"
 	"fmt"
+	"sync/atomic"
+
 	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
 	"github.com/IrineSistiana/mosdns/v5/pkg/server/dns_handler"
 	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
 )
 
-func NewHandler(bp *coremain.BP, entry string) (dns_handler.Handler, error) {
-	p := bp.M().GetPlugin(entry)
-	exec := sequence.ToExecutable(p)
-	if exec == nil {
-		return nil, fmt.Errorf("cannot find executable entry by tag %s", entry)
+// NewHandler creates a dns handler. entry handles queries. update and notify
+// handle UPDATE and NOTIFY messages and are optional.
+func NewHandler(bp *coremain.BP, entry, update, notify string) (dns_handler.Handler, error) {
+	exec, err := getExecutable(bp, entry)
+	if err != nil {
+		return nil, err
 	}
 
 	handlerOpts := dns_handler.EntryHandlerOpts{
 		Logger: bp.L(),
 		Entry:  exec,
 	}
+	if len(update) > 0 {
+		if handlerOpts.Update, err = getExecutable(bp, update); err != nil {
+			return nil, err
+		}
+	}
+	if len(notify) > 0 {
+		if handlerOpts.Notify, err = getExecutable(bp, notify); err != nil {
+			return nil, err
+		}
+	}
 	return dns_handler.NewEntryHandler(handlerOpts), nil
 }
+
+func getExecutable(bp *coremain.BP, tag string) (sequence.Executable, error) {
+	exec := sequence.ToExecutable(bp.M().GetPlugin(tag))
+	if exec == nil {
+		return nil, fmt.Errorf("cannot find executable entry by tag %s", tag)
+	}
+	return exec, nil
+}
+
+// AtomicHandler is a dns_handler.Handler that passes queries to a handler
+// that can be replaced at runtime. Servers use it to switch to the entries
+// of a new plugin graph without closing the listener.
+type AtomicHandler struct {
+	h atomic.Pointer[dns_handler.Handler]
+}
+
+func NewAtomicHandler(h dns_handler.Handler) *AtomicHandler {
+	ah := new(AtomicHandler)
+	ah.Store(h)
+	return ah
+}
+
+// Store replaces the handler. Queries that are being served are not affected.
+func (ah *AtomicHandler) Store(h dns_handler.Handler) {
+	ah.h.Store(&h)
+}
+
+func (ah *AtomicHandler) ServeDNS(ctx context.Context, qCtx *query_context.Context) error {
+	return (*ah.h.Load()).ServeDNS(ctx, qCtx)
+}
@@ -21,7 +21,9 @@ package tcp_server
 
 import (
 	"fmt"
+	"maps"
 	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/IrineSistiana/mosdns/v5/coremain"
@@ -37,11 +39,22 @@ func init() {
 }
 
 type Args struct {
-	Entry       string `yaml:"entry"`
+	Entry string `yaml:"entry"`
+	// Update is the tag of the executable that handles UPDATE messages.
+	Update string `yaml:"update"`
+	// Notify is the tag of the executable that handles NOTIFY messages.
+	Notify      string `yaml:"notify"`
 	Listen      string `yaml:"listen"`
 	Cert        string `yaml:"cert"`
 	Key         string `yaml:"key"`
 	IdleTimeout int    `yaml:"idle_timeout"`
+	// TSIGKeys maps TSIG key names to their base64 encoded secrets.
+	TSIGKeys map[string]string `yaml:"tsig_keys"`
+}
+
+// Deps implements coremain.ArgsWithDeps.
+func (a *Args) Deps() []string {
+	return []string{a.Entry, a.Update, a.Notify}
 }
 
 func (a *Args) init() {
@@ -52,38 +65,108 @@ func (a *Args) init() {
 type TcpServer struct {
 	args *Args
 
-	l net.Listener
+	l       net.Listener
+	h       *server_utils.AtomicHandler
+	owner   atomic.Bool   // l is served and closed by this server
+	stopped chan struct{} // closed when the serve loop exits
+	closed  atomic.Bool
 }
 
 func (s *TcpServer) Close() error {
+	s.closed.Store(true)
+	if !s.owner.Load() { // l was handed over or was never served
+		return nil
+	}
 	return s.l.Close()
 }
 
 func Init(bp *coremain.BP, args any) (any, error) {
-	return StartServer(bp, args.(*Args))
+	a := args.(*Args)
+	if bp.CheckOnly() {
+		// Only check the entries.
+		if _, err := server_utils.NewHandler(bp, a.Entry, a.Update, a.Notify); err != nil {
+			return nil, fmt.Errorf("failed to init dns handler, %w", err)
+		}
+		return struct{}{}, nil
+	}
+	return StartServer(bp, a)
 }
 
+// StartServer starts a TcpServer. If mosdns is reloading and the running
+// server listens on the same address, the listener is not recreated. The
+// running server is kept and switches to the new entries if other args are
+// also unchanged. Otherwise, the listener is handed over to a new server.
 func StartServer(bp *coremain.BP, args *Args) (*TcpServer, error) {
-	dh, err := server_utils.NewHandler(bp, args.Entry)
+	dh, err := server_utils.NewHandler(bp, args.Entry, args.Update, args.Notify)
 	if err != nil {
 		return nil, fmt.Errorf("failed to init dns handler, %w", err)
 	}
 
-	serverOpts := server.TCPServerOpts{Logger: bp.L(), DNSHandler: dh, IdleTimeout: time.Duration(args.IdleTimeout) * time.Second}
-	s := server.NewTCPServer(serverOpts)
+	prev, _ := bp.PrevPlugin().(*TcpServer)
+	if prev != nil && prev.sameArgs(args) {
+		bp.OnCommit(func() {
+			prev.args = args
+			prev.h.Store(dh)
+		})
+		return prev, nil
+	}
+
+	ts := &TcpServer{
+		args: args,
+		h:    server_utils.NewAtomicHandler(dh),
+	}
+	if prev != nil && prev.args.Listen == args.Listen {
+		ts.l = prev.l
+		bp.OnCommit(func() {
+			prev.handOver()
+			ts.serve(bp)
+		})
+		return ts, nil
+	}
 
 	l, err := net.Listen("tcp", args.Listen)
 	if err != nil {
 		return nil, fmt.Errorf("failed to listen socket, %w", err)
 	}
+	ts.l = l
+	ts.serve(bp)
+	return ts, nil
+}
 
+func (s *TcpServer) serve(bp *coremain.BP) {
+	serverOpts := server.TCPServerOpts{Logger: bp.L(), DNSHandler: s.h, IdleTimeout: time.Duration(s.args.IdleTimeout) * time.Second, TSIGKeys: s.args.TSIGKeys}
+	srv := server.NewTCPServer(serverOpts)
+	s.owner.Store(true)
+	s.stopped = make(chan struct{})
 	go func() {
-		defer l.Close()
-		err := s.ServeTCP(l)
-		bp.M().GetSafeClose().SendCloseSignal(err)
+		defer close(s.stopped)
+		err := srv.ServeTCP(s.l)
+		if s.owner.Load() {
+			s.l.Close()
+			if !s.closed.Load() {
+				bp.M().GetSafeClose().SendCloseSignal(err)
+			}
+		}
 	}()
-	return &TcpServer{
-		args: args,
-		l:    l,
-	}, nil
+}
+
+// handOver stops the serve loop of s without closing the listener.
+// Connections that are being served are not affected.
+func (s *TcpServer) handOver() {
+	s.owner.Store(false)
+	l := s.l.(interface{ SetDeadline(time.Time) error })
+	_ = l.SetDeadline(time.Now())
+	<-s.stopped
+	_ = l.SetDeadline(time.Time{})
+}
+
+// sameArgs reports whether args can be served by s without changes
+// other than entries.
+func (s *TcpServer) sameArgs(args *Args) bool {
+	a := s.args
+	return a.Listen == args.Listen &&
+		a.Cert == args.Cert &&
+		a.Key == args.Key &&
+		a.IdleTimeout == args.IdleTimeout &&
+		maps.Equal(a.TSIGKeys, args.TSIGKeys)
 }
@@ -0,0 +1,95 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tcp_server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+)
+
+// replyRcode returns an executable that replies rcode.
+func replyRcode(rcode int) sequence.ExecutableFunc {
+	return func(_ context.Context, qCtx *query_context.Context) error {
+		r := new(dns.Msg)
+		r.SetRcode(qCtx.Q(), rcode)
+		qCtx.SetResponse(r)
+		return nil
+	}
+}
+
+func TestStartServer_Reload(t *testing.T) {
+	plugins := map[string]any{"entry": replyRcode(dns.RcodeSuccess)}
+	m := coremain.NewTestMosdnsWithPlugins(plugins)
+	prev, err := StartServer(coremain.NewBP("server", m), &Args{Entry: "entry", Listen: "127.0.0.1:0", IdleTimeout: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugins["server"] = prev
+	addr := prev.l.Addr().String()
+
+	query := func(wantRcode int) {
+		t.Helper()
+		q := new(dns.Msg)
+		q.SetQuestion("example.com.", dns.TypeA)
+		r, _, err := (&dns.Client{Net: "tcp"}).Exchange(q, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Rcode != wantRcode {
+			t.Fatalf("want rcode %d, got %d", wantRcode, r.Rcode)
+		}
+	}
+	query(dns.RcodeSuccess)
+
+	// Only the entry changed, the server is kept.
+	g, commit := coremain.NewTestReloadGraph(m, map[string]any{"entry": replyRcode(dns.RcodeRefused)})
+	s, err := StartServer(coremain.NewBP("server", g), &Args{Entry: "entry", Listen: "127.0.0.1:0", IdleTimeout: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit()
+	if s != prev {
+		t.Fatal("server was not kept")
+	}
+	query(dns.RcodeRefused)
+
+	// The idle timeout changed, the listener is handed over.
+	g, commit = coremain.NewTestReloadGraph(m, map[string]any{"entry": replyRcode(dns.RcodeNameError)})
+	s, err = StartServer(coremain.NewBP("server", g), &Args{Entry: "entry", Listen: "127.0.0.1:0", IdleTimeout: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit()
+	if s == prev || s.l != prev.l {
+		t.Fatal("listener was not handed over")
+	}
+	if err := prev.Close(); err != nil { // closed with the old graph
+		t.Fatal(err)
+	}
+	query(dns.RcodeNameError)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
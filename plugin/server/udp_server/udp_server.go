@@ -25,7 +25,10 @@ import (
 	"github.com/IrineSistiana/mosdns/v5/pkg/server"
 	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
 	"github.com/IrineSistiana/mosdns/v5/plugin/server/server_utils"
+	"maps"
 	"net"
+	"sync/atomic"
+	"time"
 )
 
 const PluginType = "udp_server"
@@ -35,8 +38,19 @@ func init() {
 }
 
 type Args struct {
-	Entry  string `yaml:"entry"`
+	Entry string `yaml:"entry"`
+	// Update is the tag of the executable that handles UPDATE messages.
+	Update string `yaml:"update"`
+	// Notify is the tag of the executable that handles NOTIFY messages.
+	Notify string `yaml:"notify"`
 	Listen string `yaml:"listen"`
+	// TSIGKeys maps TSIG key names to their base64 encoded secrets.
+	TSIGKeys map[string]string `yaml:"tsig_keys"`
+}
+
+// Deps implements coremain.ArgsWithDeps.
+func (a *Args) Deps() []string {
+	return []string{a.Entry, a.Update, a.Notify}
 }
 
 func (a *Args) init() {
@@ -46,36 +60,102 @@ func (a *Args) init() {
 type UdpServer struct {
 	args *Args
 
-	c net.PacketConn
+	c       net.PacketConn
+	h       *server_utils.AtomicHandler
+	owner   atomic.Bool   // c is served and closed by this server
+	stopped chan struct{} // closed when the serve loop exits
+	closed  atomic.Bool
 }
 
 func (s *UdpServer) Close() error {
+	s.closed.Store(true)
+	if !s.owner.Load() { // c was handed over or was never served
+		return nil
+	}
 	return s.c.Close()
 }
 
 func Init(bp *coremain.BP, args any) (any, error) {
-	return StartServer(bp, args.(*Args))
+	a := args.(*Args)
+	if bp.CheckOnly() {
+		// Only check the entries.
+		if _, err := server_utils.NewHandler(bp, a.Entry, a.Update, a.Notify); err != nil {
+			return nil, fmt.Errorf("failed to init dns handler, %w", err)
+		}
+		return struct{}{}, nil
+	}
+	return StartServer(bp, a)
 }
 
+// StartServer starts a UdpServer. If mosdns is reloading and the running
+// server listens on the same address, the socket is not recreated. The
+// running server is kept and switches to the new entries if other args are
+// also unchanged. Otherwise, the socket is handed over to a new server.
 func StartServer(bp *coremain.BP, args *Args) (*UdpServer, error) {
-	dh, err := server_utils.NewHandler(bp, args.Entry)
+	dh, err := server_utils.NewHandler(bp, args.Entry, args.Update, args.Notify)
 	if err != nil {
 		return nil, fmt.Errorf("failed to init dns handler, %w", err)
 	}
 
-	serverOpts := server.UDPServerOpts{Logger: bp.L(), DNSHandler: dh}
-	s := server.NewUDPServer(serverOpts)
+	prev, _ := bp.PrevPlugin().(*UdpServer)
+	if prev != nil && prev.sameArgs(args) {
+		bp.OnCommit(func() {
+			prev.args = args
+			prev.h.Store(dh)
+		})
+		return prev, nil
+	}
+
+	us := &UdpServer{
+		args: args,
+		h:    server_utils.NewAtomicHandler(dh),
+	}
+	if prev != nil && prev.args.Listen == args.Listen {
+		us.c = prev.c
+		bp.OnCommit(func() {
+			prev.handOver()
+			us.serve(bp)
+		})
+		return us, nil
+	}
+
 	c, err := net.ListenPacket("udp", args.Listen)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create socket, %w", err)
 	}
+	us.c = c
+	us.serve(bp)
+	return us, nil
+}
+
+func (s *UdpServer) serve(bp *coremain.BP) {
+	serverOpts := server.UDPServerOpts{Logger: bp.L(), DNSHandler: s.h, TSIGKeys: s.args.TSIGKeys}
+	srv := server.NewUDPServer(serverOpts)
+	s.owner.Store(true)
+	s.stopped = make(chan struct{})
 	go func() {
-		defer c.Close()
-		err := s.ServeUDP(c)
-		bp.M().GetSafeClose().SendCloseSignal(err)
+		defer close(s.stopped)
+		err := srv.ServeUDP(s.c)
+		if s.owner.Load() {
+			s.c.Close()
+			if !s.closed.Load() {
+				bp.M().GetSafeClose().SendCloseSignal(err)
+			}
+		}
 	}()
-	return &UdpServer{
-		args: args,
-		c:    c,
-	}, nil
+}
+
+// handOver stops the serve loop of s without closing the socket.
+// Queries that are being served are not affected.
+func (s *UdpServer) handOver() {
+	s.owner.Store(false)
+	_ = s.c.SetReadDeadline(time.Now())
+	<-s.stopped
+	_ = s.c.SetReadDeadline(time.Time{})
+}
+
+// sameArgs reports whether args can be served by s without changes
+// other than entries.
+func (s *UdpServer) sameArgs(args *Args) bool {
+	return s.args.Listen == args.Listen && maps.Equal(s.args.TSIGKeys, args.TSIGKeys)
 }